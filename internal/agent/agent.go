@@ -5,24 +5,25 @@ import (
 "fmt"
 "log/slog"
 "strings"
+"sync"
+"time"
 
 "github.com/LeeroyDing/hyperagent/internal/editor"
 "github.com/LeeroyDing/hyperagent/internal/executor"
-"github.com/LeeroyDing/hyperagent/internal/gemini"
 "github.com/LeeroyDing/hyperagent/internal/history"
+"github.com/LeeroyDing/hyperagent/internal/llm"
 "github.com/LeeroyDing/hyperagent/internal/mcp"
 "github.com/LeeroyDing/hyperagent/internal/memory"
 "github.com/LeeroyDing/hyperagent/internal/orchestrator"
+"github.com/LeeroyDing/hyperagent/internal/output"
+"github.com/LeeroyDing/hyperagent/internal/prompt"
 "github.com/LeeroyDing/hyperagent/internal/token"
-"github.com/google/generative-ai-go/genai"
 )
 
-// GeminiClient defines the interface for interacting with Gemini
-
 type Agent struct {
 InteractiveMode bool
 DryRun          bool
-Gemini          gemini.GeminiClient
+LLM             llm.Client
 Executor        executor.Executor
 Memory          memory.Memory
 MCP             *mcp.MCPManager
@@ -30,11 +31,36 @@ History         history.History
 TokenMgr        *token.TokenManager
 Editor          *editor.FileEditor
 Orchestrator    *orchestrator.Orchestrator
+Limits          Limits
+// ContextLimits sizes the model window that history is pruned to.
+ContextLimits token.Limits
+// Prompts renders the system prompt from the session's profile.
+Prompts *prompt.Library
+// CommandAllowlist is shown to the model in the system prompt. It stays
+// empty when a command policy decides what may run, since the allowlist
+// would then be wrong.
+CommandAllowlist []string
+// OutputLimits caps the size of tool results sent to the model.
+OutputLimits output.Limits
+// Artifacts keeps the full text of truncated tool results. When nil,
+// truncated output is not kept.
+Artifacts *output.Store
+// Workspace returns the workspace a session's file tools are confined
+// to. When nil, file tools may access any path.
+Workspace func(sessionID string) *editor.Workspace
+// Edits journals file edits so that they can be undone. When nil, edits
+// are not recorded.
+Edits *editor.Journal
+
+mu           sync.Mutex
+summaries    map[string]contextSummary
+sessionLocks map[string]*sync.Mutex
+confirmMu    sync.Mutex
 }
 
-func NewAgent(gemini gemini.GeminiClient, executor executor.Executor, memory memory.Memory, mcpMgr *mcp.MCPManager, historyMgr history.History, interactiveMode bool) *Agent {
+func NewAgent(client llm.Client, executor executor.Executor, memory memory.Memory, mcpMgr *mcp.MCPManager, historyMgr history.History, interactiveMode bool) *Agent {
 return &Agent{
-Gemini:          gemini,
+LLM:             client,
 Executor:        executor,
 Memory:          memory,
 MCP:             mcpMgr,
@@ -42,20 +68,31 @@ History:         historyMgr,
 InteractiveMode: interactiveMode,
 Editor:          editor.NewFileEditor(),
 Orchestrator:    orchestrator.NewOrchestrator(),
+TokenMgr:        &token.TokenManager{},
+ContextLimits:   token.DefaultLimits(""),
+summaries:       make(map[string]contextSummary),
+Prompts:         defaultPrompts(),
 }
 }
 
-func (a *Agent) getTools() []*genai.Tool {
-return []*genai.Tool{
-{
-FunctionDeclarations: []*genai.FunctionDeclaration{
+func defaultPrompts() *prompt.Library {
+// The built-in profile always parses
+l, _ := prompt.NewLibrary(nil, "")
+return l
+}
+
+func (a *Agent) getTools() []llm.Tool {
+tools := []llm.Tool{
 {
 Name:        "execute_command",
-Description: "Execute a shell command on the host system",
-Parameters: &genai.Schema{
-Type: genai.TypeObject,
-Properties: map[string]*genai.Schema{
-"command": {Type: genai.TypeString, Description: "The shell command to execute"},
+Description: "Execute a shell command on the host system. The response reports the exit code, the duration and the output. A command that prompts for input keeps running; answer it with send_input.",
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"command": {Type: llm.TypeString, Description: "The shell command to execute"},
+"timeout": {Type: llm.TypeInteger, Description: "Timeout in seconds for long-running commands such as builds (optional, capped by configuration)"},
+"separate_stderr": {Type: llm.TypeBoolean, Description: "Capture stdout and stderr separately by running the command without a terminal, in the shell's current directory (optional)"},
+"background": {Type: llm.TypeBoolean, Description: "Return shortly after starting the command and let it keep running without a timeout, for servers, watchers and interactive programs; follow it with read_output (optional)"},
 },
 Required: []string{"command"},
 },
@@ -63,12 +100,12 @@ Required: []string{"command"},
 {
 Name:        "read_file",
 Description: "Read lines from a file",
-Parameters: &genai.Schema{
-Type: genai.TypeObject,
-Properties: map[string]*genai.Schema{
-"path":  {Type: genai.TypeString, Description: "Path to the file"},
-"start": {Type: genai.TypeInteger, Description: "Start line (1-indexed)"},
-"end":   {Type: genai.TypeInteger, Description: "End line (optional)"},
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"path":  {Type: llm.TypeString, Description: "Path to the file"},
+"start": {Type: llm.TypeInteger, Description: "Start line (1-indexed)"},
+"end":   {Type: llm.TypeInteger, Description: "End line (optional)"},
 },
 Required: []string{"path"},
 },
@@ -76,12 +113,13 @@ Required: []string{"path"},
 {
 Name:        "replace_text",
 Description: "Replace text in a file",
-Parameters: &genai.Schema{
-Type: genai.TypeObject,
-Properties: map[string]*genai.Schema{
-"path":     {Type: genai.TypeString, Description: "Path to the file"},
-"old_text": {Type: genai.TypeString, Description: "Text to find"},
-"new_text": {Type: genai.TypeString, Description: "Replacement text"},
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"path":     {Type: llm.TypeString, Description: "Path to the file"},
+"old_text": {Type: llm.TypeString, Description: "Text to find"},
+"new_text": {Type: llm.TypeString, Description: "Replacement text"},
+"dry_run":  dryRunSchema,
 },
 Required: []string{"path", "old_text", "new_text"},
 },
@@ -89,11 +127,11 @@ Required: []string{"path", "old_text", "new_text"},
 {
 Name:        "memory_save",
 Description: "Save information to long-term memory",
-Parameters: &genai.Schema{
-Type: genai.TypeObject,
-Properties: map[string]*genai.Schema{
-"id":      {Type: genai.TypeString, Description: "Unique ID for the memory"},
-"content": {Type: genai.TypeString, Description: "Content to memorize"},
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"id":      {Type: llm.TypeString, Description: "Unique ID for the memory"},
+"content": {Type: llm.TypeString, Description: "Content to memorize"},
 },
 Required: []string{"id", "content"},
 },
@@ -101,11 +139,11 @@ Required: []string{"id", "content"},
 {
 Name:        "memory_load",
 Description: "Search long-term memory",
-Parameters: &genai.Schema{
-Type: genai.TypeObject,
-Properties: map[string]*genai.Schema{
-"query": {Type: genai.TypeString, Description: "Search query"},
-"limit": {Type: genai.TypeInteger, Description: "Max results (default 5)"},
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"query": {Type: llm.TypeString, Description: "Search query"},
+"limit": {Type: llm.TypeInteger, Description: "Max results (default 5)"},
 },
 Required: []string{"query"},
 },
@@ -113,20 +151,38 @@ Required: []string{"query"},
 {
 Name:        "memory_forget",
 Description: "Delete information from long-term memory",
-Parameters: &genai.Schema{
-Type: genai.TypeObject,
-Properties: map[string]*genai.Schema{
-"id": {Type: genai.TypeString, Description: "ID of the memory to delete"},
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"id": {Type: llm.TypeString, Description: "ID of the memory to delete"},
 },
 Required: []string{"id"},
 },
 },
-},
-},
 }
+tools = append(tools, editTools...)
+tools = append(tools, searchTools...)
+
+if _, ok := a.Executor.(executor.Interactive); ok {
+tools = append(tools, sendInputTool, readOutputTool)
+}
+if a.Artifacts != nil {
+tools = append(tools, readArtifactTool)
+}
+if a.Edits != nil {
+tools = append(tools, undoEditTool)
+}
+return append(tools, a.mcpTools()...)
 }
 
 func (a *Agent) Run(ctx context.Context, sessionID, prompt string) (string, error) {
+return a.RunStream(ctx, sessionID, prompt, nil)
+}
+
+// RunStream is Run with progress reporting: emit, when not nil, receives text
+// deltas, tool calls and their results as they happen, and a final event
+// with the complete reply.
+func (a *Agent) RunStream(ctx context.Context, sessionID, prompt string, emit func(Event)) (string, error) {
 slog.Info("Starting agentic loop", "session", sessionID, "prompt", prompt)
 
 // 1. RAG Step: Recall relevant memories
@@ -147,101 +203,209 @@ if err != nil {
 return "", fmt.Errorf("failed to load history: %w", err)
 }
 
-var messages []gemini.Message
-for _, m := range hist {
-messages = append(messages, gemini.Message{Role: m.Role, Content: m.Content})
-}
-
 // Inject RAG context into the current prompt if available
 finalPrompt := prompt
 if ragContext != "" {
 finalPrompt = fmt.Sprintf("%s\n\nUser Prompt: %s", ragContext, prompt)
 }
-messages = append(messages, gemini.Message{Role: "user", Content: finalPrompt})
+promptMsg := llm.NewTextMessage(llm.RoleUser, finalPrompt)
+tools := a.getTools()
+
+var messages []llm.Message
+sys, err := a.systemPrompt(sessionID, tools)
+if err != nil {
+return "", err
+}
+if sys != "" {
+messages = append(messages, llm.NewTextMessage(llm.RoleSystem, sys))
+}
+
+// Fit history into what is left of the window after the system prompt, the prompt and tools
+var reserved int
+if a.TokenMgr != nil {
+reserved = a.TokenMgr.CountTokens(sys) + a.TokenMgr.CountMessage(promptMsg) + a.TokenMgr.CountTools(tools)
+}
+messages = append(messages, a.fitContext(ctx, sessionID, historyToMessages(hist), reserved)...)
+messages = append(messages, promptMsg)
 
 // Save user message to history (original prompt)
 a.History.AddMessage(sessionID, "user", prompt)
 
-tools := a.getTools()
-textResp, toolCalls, err := a.Gemini.GenerateContent(ctx, messages, tools)
+// The time limit also cancels the model call or tool running when it ends
+b := newBudget(a.Limits)
+runCtx, cancel := context.WithTimeout(ctx, b.limits.MaxDuration)
+defer cancel()
+var stopReason string
+resp, err := a.generate(runCtx, messages, tools, emit)
 if err != nil {
-return "", fmt.Errorf("gemini error: %w", err)
+if !b.expired(ctx, runCtx) {
+return "", fmt.Errorf("model error: %w", err)
 }
+slog.Warn("Tool budget exhausted", "session", sessionID, "reason", StopMaxDuration)
+stopReason = StopMaxDuration
+resp = stopped(b.timeLimit())
+}
+for toolCalls := resp.FunctionCalls(); len(toolCalls) > 0; toolCalls = resp.FunctionCalls() {
+a.History.AppendMessage(sessionID, toolCallMessage(resp))
 
-for len(toolCalls) > 0 {
-var toolResponses []gemini.ToolResponse
-for _, tc := range toolCalls {
-slog.Info("Handling tool call", "name", tc.Name, "args", tc.Arguments)
-result, err := a.handleToolCall(ctx, sessionID, tc)
-if err != nil {
-result = fmt.Sprintf("Error: %v", err)
+if reason, why := b.spend(toolCalls); reason != "" {
+slog.Warn("Tool budget exhausted", "session", sessionID, "reason", reason, "detail", why)
+stopReason = reason
+resp = a.finish(ctx, sessionID, messages, resp, why, emit)
+break
 }
-toolResponses = append(toolResponses, gemini.ToolResponse{
-Name:    tc.Name,
-Content: result,
-})
+
+outcomes := a.runToolCalls(runCtx, sessionID, toolCalls, emit)
+results := make([]llm.FunctionResult, len(outcomes))
+for i, o := range outcomes {
+results[i] = o.functionResult()
 }
+a.History.AppendMessage(sessionID, toolResultMessage(outcomes))
 
-textResp, toolCalls, err = a.Gemini.SendToolResponse(ctx, messages, tools, toolResponses)
+// Keep the model's calls and our results in the conversation so that
+// later turns can refer back to them.
+messages = append(messages, resp.Message, llm.NewFunctionResultMessage(results))
+if err = runCtx.Err(); err == nil {
+resp, err = a.generate(runCtx, messages, tools, emit)
+}
+if b.expired(ctx, runCtx) {
+slog.Warn("Tool budget exhausted", "session", sessionID, "reason", StopMaxDuration)
+stopReason = StopMaxDuration
+resp = a.finalSummary(ctx, messages, b.timeLimit(), emit)
+break
+}
 if err != nil {
-return "", fmt.Errorf("gemini tool response error: %w", err)
+return "", fmt.Errorf("model tool response error: %w", err)
 }
 }
+textResp := resp.Text()
 
 // Save assistant response to history
-if textResp != "" {
+if stopReason != "" {
+a.History.AppendMessage(sessionID, history.Message{Role: "model", Content: textResp, StopReason: stopReason})
+} else if textResp != "" {
 a.History.AddMessage(sessionID, "model", textResp)
 }
+if emit != nil {
+emit(Event{Type: EventFinal, Text: textResp, StopReason: stopReason})
+}
 
 return textResp, nil
 }
 
-func (a *Agent) handleToolCall(ctx context.Context, sessionID string, tc gemini.ToolCall) (string, error) {
+// systemPrompt renders the profile selected for the session.
+func (a *Agent) systemPrompt(sessionID string, tools []llm.Tool) (string, error) {
+if a.Prompts == nil {
+return "", nil
+}
+data := prompt.NewData()
+for _, t := range tools {
+data.Tools = append(data.Tools, prompt.Tool{Name: t.Name, Description: t.Description})
+}
+data.Allowlist = a.CommandAllowlist
+// Commands run in bash whatever the daemon's $SHELL is
+data.Shell = executor.ShellProgram
+// Show where the session's commands run rather than the daemon's directory
+if d, ok := a.Executor.(interface{ SessionDir(string) string }); ok {
+data.Cwd = d.SessionDir(sessionID)
+}
+
+// Pin the profile so that the session keeps it if the configured default changes
+profile := a.History.GetSessionProfile(sessionID)
+if !a.Prompts.Has(profile) {
+profile = a.Prompts.Default()
+a.History.SetSessionProfile(sessionID, profile)
+}
+
+sys, err := a.Prompts.Render(profile, data)
+if err != nil {
+return "", fmt.Errorf("failed to build system prompt: %w", err)
+}
+return sys, nil
+}
+
+// finish ends a run whose budget is exhausted. The pending calls are answered
+// with an error and the model is asked, without tools, for a final summary.
+func (a *Agent) finish(ctx context.Context, sessionID string, messages []llm.Message, resp *llm.Response, why string, emit func(Event)) *llm.Response {
+var outcomes []toolOutcome
+var results []llm.FunctionResult
+for _, tc := range resp.FunctionCalls() {
+o := toolOutcome{Call: tc, Err: fmt.Errorf("not executed: %s", why)}
+outcomes = append(outcomes, o)
+results = append(results, o.functionResult())
+}
+a.History.AppendMessage(sessionID, toolResultMessage(outcomes))
+return a.finalSummary(ctx, append(messages, resp.Message, llm.NewFunctionResultMessage(results)), why, emit)
+}
+
+// finalSummary asks the model, without tools, for a final summary of a run
+// whose budget is exhausted. The request gets summaryTimeout, since the
+// run's own time may be up.
+func (a *Agent) finalSummary(ctx context.Context, messages []llm.Message, why string, emit func(Event)) *llm.Response {
+ctx, cancel := context.WithTimeout(ctx, summaryTimeout)
+defer cancel()
+messages = append(messages, llm.NewTextMessage(llm.RoleUser, fmt.Sprintf("The tool budget for this request is exhausted: %s. Do not call any more tools. Summarize what you have done so far and what is left to do.", why)))
+summary, err := a.generate(ctx, messages, nil, emit)
+if err != nil || summary.Text() == "" {
+slog.Warn("Failed to get final summary", "error", err)
+return stopped(why)
+}
+return summary
+}
+
+// stopped is the reply of a run that ended without a summary.
+func stopped(why string) *llm.Response {
+return &llm.Response{Message: llm.NewTextMessage(llm.RoleModel, fmt.Sprintf("Stopped: %s.", why))}
+}
+
+func (a *Agent) handleToolCall(ctx context.Context, sessionID string, tc llm.FunctionCall) (string, error) {
 switch tc.Name {
 case "execute_command":
-cmd := tc.Arguments["command"].(string)
-if !a.confirmAction(fmt.Sprintf("Execute command: %s", cmd)) {
-return "Action cancelled by user", nil
+line, _ := tc.Arguments["command"].(string)
+if strings.TrimSpace(line) == "" {
+return "", fmt.Errorf("command is required")
 }
-return a.Executor.Execute(sessionID, cmd)
-case "read_file":
-path := tc.Arguments["path"].(string)
-start := int(tc.Arguments["start"].(float64))
-end := 0
-if e, ok := tc.Arguments["end"]; ok {
-end = int(e.(float64))
+cmd := executor.Command{Line: line}
+if t, ok := tc.Arguments["timeout"].(float64); ok {
+cmd.Timeout = time.Duration(t * float64(time.Second))
+}
+cmd.SeparateStderr, _ = tc.Arguments["separate_stderr"].(bool)
+cmd.Background, _ = tc.Arguments["background"].(bool)
+if !a.confirmAction(fmt.Sprintf("Execute command: %s", cmd.Line)) {
+return "Action cancelled by user", nil
 }
-lines, err := a.Editor.ReadLines(path, start, end)
+res, err := a.Executor.Execute(ctx, sessionID, cmd)
 if err != nil {
-return "", err
+if res.Output != "" || res.Stderr != "" {
+// Show what the command printed before it was interrupted
+return "", fmt.Errorf("%v\n%s", err, res.Format())
 }
-return strings.Join(lines, "\n"), nil
-case "replace_text":
-path := tc.Arguments["path"].(string)
-old := tc.Arguments["old_text"].(string)
-new := tc.Arguments["new_text"].(string)
-if !a.confirmAction(fmt.Sprintf("Replace text in %s", path)) {
-return "Action cancelled by user", nil
+return "", err
 }
-err := a.Editor.Replace(path, old, new)
+return res.Format(), nil
+case "read_file":
+path, _ := tc.Arguments["path"].(string)
+lines, err := a.editor(sessionID).ReadLines(path, intArg(tc.Arguments, "start", 1), intArg(tc.Arguments, "end", 0))
 if err != nil {
 return "", err
 }
-return "Text replaced successfully", nil
+return strings.Join(lines, "\n"), nil
+case "replace_text":
+return a.replaceText(sessionID, tc.Arguments)
 case "memory_save":
-id := tc.Arguments["id"].(string)
-content := tc.Arguments["content"].(string)
+id, _ := tc.Arguments["id"].(string)
+content, _ := tc.Arguments["content"].(string)
+if id == "" {
+return "", fmt.Errorf("id is required")
+}
 err := a.Memory.Memorize(ctx, id, content, nil)
 if err != nil {
 return "", err
 }
 return "Information memorized", nil
 case "memory_load":
-query := tc.Arguments["query"].(string)
-limit := 5
-if l, ok := tc.Arguments["limit"]; ok {
-limit = int(l.(float64))
-}
+query, _ := tc.Arguments["query"].(string)
+limit := intArg(tc.Arguments, "limit", 5)
 results, err := a.Memory.Recall(ctx, query, limit)
 if err != nil {
 return "", err
@@ -251,14 +415,42 @@ for _, r := range results {
 sb.WriteString(fmt.Sprintf("ID: %s\nContent: %s\n\n", r.ID, r.Content))
 }
 return sb.String(), nil
+case "write_file":
+return a.writeFile(sessionID, tc.Arguments)
+case "insert_lines":
+return a.insertLines(sessionID, tc.Arguments)
+case "delete_lines":
+return a.deleteLines(sessionID, tc.Arguments)
+case "apply_patch":
+return a.applyPatch(sessionID, tc.Arguments)
+case "list_dir":
+return a.listDir(sessionID, tc.Arguments)
+case "glob":
+return a.glob(sessionID, tc.Arguments)
+case "search_files":
+return a.searchFiles(sessionID, tc.Arguments)
+case "undo_edit":
+return a.undoEdit(sessionID, tc.Arguments)
+case "read_artifact":
+return a.readArtifact(sessionID, tc.Arguments)
+case "send_input":
+return a.sendInput(ctx, sessionID, tc.Arguments)
+case "read_output":
+return a.readOutput(ctx, sessionID, tc.Arguments)
 case "memory_forget":
-id := tc.Arguments["id"].(string)
+id, _ := tc.Arguments["id"].(string)
+if id == "" {
+return "", fmt.Errorf("id is required")
+}
 err := a.Memory.Forget(ctx, id)
 if err != nil {
 return "", err
 }
 return "Memory forgotten", nil
 default:
+if rt, ok := a.MCP.Lookup(tc.Name); ok {
+return a.handleMCPToolCall(ctx, rt, tc)
+}
 return "", fmt.Errorf("unknown tool: %s", tc.Name)
 }
 }
@@ -267,6 +459,9 @@ func (a *Agent) confirmAction(action string) bool {
 if !a.InteractiveMode {
 return true
 }
+// Parallel tool calls must not prompt at the same time
+a.confirmMu.Lock()
+defer a.confirmMu.Unlock()
 fmt.Printf("\n[INTERACTIVE MODE] Confirm action: %s (y/n): ", action)
 var response string
 fmt.Scanln(&response)
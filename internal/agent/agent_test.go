@@ -5,22 +5,27 @@ import (
 "errors"
 "fmt"
 "os"
+"strings"
 "testing"
+"time"
 
-"github.com/LeeroyDing/hyperagent/internal/gemini"
+"github.com/LeeroyDing/hyperagent/internal/executor"
+"github.com/LeeroyDing/hyperagent/internal/history"
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/LeeroyDing/hyperagent/internal/prompt"
 "github.com/philippgille/chromem-go"
 "github.com/stretchr/testify/assert"
 )
 
 func TestNewAgent(t *testing.T) {
-g := &MockGeminiClient{}
+g := &MockLLMClient{}
 e := &MockExecutor{}
 m := &MockMemory{}
 h := &MockHistory{}
 a := NewAgent(g, e, m, nil, h, false)
 
 assert.NotNil(t, a)
-assert.Equal(t, g, a.Gemini)
+assert.Equal(t, g, a.LLM)
 assert.Equal(t, e, a.Executor)
 assert.Equal(t, m, a.Memory)
 assert.Equal(t, h, a.History)
@@ -32,9 +37,9 @@ func TestAgent_Run(t *testing.T) {
 ctx := context.Background()
 
 t.Run("Success with RAG and Tool Call", func(t *testing.T) {
-g := &MockGeminiClient{
+g := &MockLLMClient{
 Responses: []string{"", "Final answer"},
-ToolCalls: [][]gemini.ToolCall{
+ToolCalls: [][]llm.FunctionCall{
 {{Name: "execute_command", Arguments: map[string]interface{}{"command": "ls"}}},
 nil,
 },
@@ -52,6 +57,99 @@ assert.Equal(t, "Final answer", resp)
 assert.Contains(t, e.ExecutedCommands, "ls")
 })
 
+t.Run("Tool Calls Kept In Conversation", func(t *testing.T) {
+g := &MockLLMClient{
+Responses: []string{"", "", "Final answer"},
+ToolCalls: [][]llm.FunctionCall{
+{{ID: "c1", Name: "execute_command", Arguments: map[string]interface{}{"command": "ls"}}},
+{{ID: "c2", Name: "execute_command", Arguments: map[string]interface{}{"command": "pwd"}}},
+nil,
+},
+}
+a := NewAgent(g, &MockExecutor{}, &MockMemory{}, nil, &MockHistory{}, false)
+
+resp, err := a.Run(ctx, "s1", "hello")
+assert.NoError(t, err)
+assert.Equal(t, "Final answer", resp)
+assert.Len(t, g.Requests, 3)
+
+last := g.Requests[2][1:]
+assert.Len(t, last, 5)
+assert.Equal(t, llm.RoleUser, last[0].Role)
+assert.Equal(t, "c1", last[1].FunctionCalls()[0].ID)
+assert.Equal(t, llm.RoleTool, last[2].Role)
+assert.Equal(t, "c1", last[2].FunctionResults()[0].ID)
+assert.Contains(t, last[2].FunctionResults()[0].Content, "Mock output for: ls")
+assert.Equal(t, "c2", last[3].FunctionCalls()[0].ID)
+assert.Equal(t, "c2", last[4].FunctionResults()[0].ID)
+})
+
+t.Run("Tool Calls Persisted And Replayed", func(t *testing.T) {
+g := &MockLLMClient{
+Responses: []string{"", "Final answer", "Again"},
+ToolCalls: [][]llm.FunctionCall{
+{{ID: "c1", Name: "execute_command", Arguments: map[string]interface{}{"command": "ls"}}},
+},
+}
+h := &MockHistory{}
+a := NewAgent(g, &MockExecutor{}, &MockMemory{}, nil, h, false)
+
+_, err := a.Run(ctx, "s1", "hello")
+assert.NoError(t, err)
+
+stored := h.Sessions["s1"]
+assert.Len(t, stored, 4)
+assert.Equal(t, "c1", stored[1].ToolCalls[0].ID)
+assert.Equal(t, "ls", stored[1].ToolCalls[0].Arguments["command"])
+assert.Equal(t, "c1", stored[2].ToolResults[0].CallID)
+assert.Contains(t, stored[2].ToolResults[0].Output, "Mock output for: ls")
+assert.Equal(t, "Final answer", stored[3].Content)
+
+_, err = a.Run(ctx, "s1", "and now?")
+assert.NoError(t, err)
+replayed := g.Requests[2][1:]
+assert.Len(t, replayed, 5)
+assert.Equal(t, "c1", replayed[1].FunctionCalls()[0].ID)
+assert.Contains(t, replayed[2].FunctionResults()[0].Content, "Mock output for: ls")
+})
+
+t.Run("System Prompt From Session Profile", func(t *testing.T) {
+g := &MockLLMClient{Responses: []string{"ok", "ok"}}
+h := &MockHistory{}
+a := NewAgent(g, &MockExecutor{}, &MockMemory{}, nil, h, false)
+a.CommandAllowlist = []string{"ls"}
+lib, err := prompt.NewLibrary([]prompt.Profile{{Name: "terse", Template: "Be terse. Tools: {{range .Tools}}{{.Name}} {{end}}"}}, "")
+assert.NoError(t, err)
+a.Prompts = lib
+
+_, err = a.Run(ctx, "s1", "hello")
+assert.NoError(t, err)
+sys := g.Requests[0][0]
+assert.Equal(t, llm.RoleSystem, sys.Role)
+assert.Contains(t, sys.Text(), "You are Hyperagent")
+assert.Contains(t, sys.Text(), "execute_command: ls.")
+assert.Equal(t, "default", h.Profiles["s1"])
+
+h.SetSessionProfile("s1", "terse")
+_, err = a.Run(ctx, "s1", "again")
+assert.NoError(t, err)
+assert.True(t, strings.HasPrefix(g.Requests[1][0].Text(), "Be terse. Tools: execute_command read_file"))
+})
+
+t.Run("System Prompt Working Directory", func(t *testing.T) {
+g := &MockLLMClient{Responses: []string{"ok"}}
+a := NewAgent(g, &dirExecutor{dir: "/srv/project"}, &MockMemory{}, nil, &MockHistory{}, false)
+lib, err := prompt.NewLibrary([]prompt.Profile{{Name: "cwd", Template: "cwd={{.Cwd}} shell={{.Shell}}"}}, "cwd")
+assert.NoError(t, err)
+a.Prompts = lib
+// Commands run in bash, not the daemon's $SHELL
+t.Setenv("SHELL", "/bin/zsh")
+
+_, err = a.Run(ctx, "s1", "hello")
+assert.NoError(t, err)
+assert.Equal(t, "cwd=/srv/project shell=bash", g.Requests[0][0].Text())
+})
+
 t.Run("History Load Error", func(t *testing.T) {
 h := &MockHistory{LoadError: errors.New("history error")}
 a := NewAgent(nil, nil, &MockMemory{}, nil, h, false)
@@ -61,7 +159,7 @@ assert.Contains(t, err.Error(), "history error")
 })
 
 t.Run("Gemini Generate Error", func(t *testing.T) {
-g := &MockGeminiClient{GenerateError: errors.New("gemini error")}
+g := &MockLLMClient{GenerateError: errors.New("gemini error")}
 h := &MockHistory{}
 a := NewAgent(g, nil, &MockMemory{}, nil, h, false)
 _, err := a.Run(ctx, "s1", "hello")
@@ -70,9 +168,9 @@ assert.Contains(t, err.Error(), "gemini error")
 })
 
 t.Run("Gemini Tool Response Error", func(t *testing.T) {
-g := &MockGeminiClient{
+g := &MockLLMClient{
 Responses: []string{""},
-ToolCalls: [][]gemini.ToolCall{
+ToolCalls: [][]llm.FunctionCall{
 {{Name: "execute_command", Arguments: map[string]interface{}{"command": "ls"}}},
 },
 SendToolResponseError: errors.New("tool response error"),
@@ -98,12 +196,49 @@ w.Close()
 defer func() { os.Stdin = oldStdin }()
 
 a := &Agent{InteractiveMode: true}
-tc := gemini.ToolCall{Name: "execute_command", Arguments: map[string]interface{}{"command": "ls"}}
+tc := llm.FunctionCall{Name: "execute_command", Arguments: map[string]interface{}{"command": "ls"}}
 resp, err := a.handleToolCall(ctx, "s1", tc)
 assert.NoError(t, err)
 assert.Equal(t, "Action cancelled by user", resp)
 })
 
+t.Run("execute_command timeout", func(t *testing.T) {
+shell := &deadlineShell{}
+exec := executor.NewShellExecutor(nil)
+exec.Timeouts = executor.Timeouts{Default: time.Minute, Max: 10 * time.Minute}
+exec.Manager.Creator = func(id string) (executor.Shell, error) { return shell, nil }
+a := NewAgent(nil, exec, nil, nil, nil, false)
+
+start := time.Now()
+tc := llm.FunctionCall{Name: "execute_command", Arguments: map[string]interface{}{"command": "make", "timeout": 300.0}}
+_, err := a.handleToolCall(ctx, "s1", tc)
+assert.NoError(t, err)
+assert.WithinDuration(t, start.Add(5*time.Minute), shell.deadline, time.Second)
+
+tc.Arguments = map[string]interface{}{"command": "make"}
+_, err = a.handleToolCall(ctx, "s1", tc)
+assert.NoError(t, err)
+assert.WithinDuration(t, start.Add(time.Minute), shell.deadline, time.Second)
+})
+
+t.Run("execute_command result", func(t *testing.T) {
+shell := &deadlineShell{result: executor.Result{Output: "FAIL: TestX", ExitCode: 1, Duration: 1500 * time.Millisecond}}
+exec := executor.NewShellExecutor(nil)
+exec.Manager.Creator = func(id string) (executor.Shell, error) { return shell, nil }
+a := NewAgent(nil, exec, nil, nil, nil, false)
+
+tc := llm.FunctionCall{Name: "execute_command", Arguments: map[string]interface{}{"command": "make test"}}
+resp, err := a.handleToolCall(ctx, "s1", tc)
+assert.NoError(t, err)
+assert.Equal(t, "Exit code: 1\nDuration: 1.5s\nOutput:\nFAIL: TestX", resp)
+
+// Partial output of an interrupted command is part of the error
+shell.result = executor.Result{Output: "building", ExitCode: -1}
+shell.err = context.DeadlineExceeded
+_, err = a.handleToolCall(ctx, "s1", tc)
+assert.EqualError(t, err, "command timed out after 2m0s and was interrupted\nExit code: none (interrupted)\nDuration: 0s\nOutput:\nbuilding")
+})
+
 t.Run("read_file success with end", func(t *testing.T) {
 a := NewAgent(nil, nil, nil, nil, nil, false)
 f, _ := os.CreateTemp("", "testfile")
@@ -111,7 +246,7 @@ defer os.Remove(f.Name())
 f.WriteString("line1\nline2\nline3")
 f.Close()
 
-tc := gemini.ToolCall{Name: "read_file", Arguments: map[string]interface{}{"path": f.Name(), "start": 1.0, "end": 2.0}}
+tc := llm.FunctionCall{Name: "read_file", Arguments: map[string]interface{}{"path": f.Name(), "start": 1.0, "end": 2.0}}
 resp, err := a.handleToolCall(ctx, "s1", tc)
 assert.NoError(t, err)
 assert.Equal(t, "line1\nline2", resp)
@@ -119,7 +254,7 @@ assert.Equal(t, "line1\nline2", resp)
 
 t.Run("read_file error", func(t *testing.T) {
 a := NewAgent(nil, nil, nil, nil, nil, false)
-tc := gemini.ToolCall{Name: "read_file", Arguments: map[string]interface{}{"path": "nonexistent", "start": 1.0, "end": 2.0}}
+tc := llm.FunctionCall{Name: "read_file", Arguments: map[string]interface{}{"path": "nonexistent", "start": 1.0, "end": 2.0}}
 resp, err := a.handleToolCall(ctx, "s1", tc)
 assert.Error(t, err)
 assert.Empty(t, resp)
@@ -132,10 +267,10 @@ defer os.Remove(f.Name())
 f.WriteString("old")
 f.Close()
 
-tc := gemini.ToolCall{Name: "replace_text", Arguments: map[string]interface{}{"path": f.Name(), "old_text": "old", "new_text": "new"}}
+tc := llm.FunctionCall{Name: "replace_text", Arguments: map[string]interface{}{"path": f.Name(), "old_text": "old", "new_text": "new"}}
 resp, err := a.handleToolCall(ctx, "s1", tc)
 assert.NoError(t, err)
-assert.Equal(t, "Text replaced successfully", resp)
+assert.Equal(t, "Changed "+f.Name()+" (+1 -1)", resp)
 })
 
 t.Run("replace_text cancelled", func(t *testing.T) {
@@ -148,7 +283,7 @@ w.Close()
 defer func() { os.Stdin = oldStdin }()
 
 a := &Agent{InteractiveMode: true}
-tc := gemini.ToolCall{Name: "replace_text", Arguments: map[string]interface{}{"path": "p", "old_text": "o", "new_text": "n"}}
+tc := llm.FunctionCall{Name: "replace_text", Arguments: map[string]interface{}{"path": "p", "old_text": "o", "new_text": "n"}}
 resp, err := a.handleToolCall(ctx, "s1", tc)
 assert.NoError(t, err)
 assert.Equal(t, "Action cancelled by user", resp)
@@ -157,7 +292,7 @@ assert.Equal(t, "Action cancelled by user", resp)
 t.Run("memory_save success", func(t *testing.T) {
 m := &MockMemory{}
 a := NewAgent(nil, nil, m, nil, nil, false)
-tc := gemini.ToolCall{Name: "memory_save", Arguments: map[string]interface{}{"id": "id", "content": "c"}}
+tc := llm.FunctionCall{Name: "memory_save", Arguments: map[string]interface{}{"id": "id", "content": "c"}}
 resp, err := a.handleToolCall(ctx, "s1", tc)
 assert.NoError(t, err)
 assert.Equal(t, "Information memorized", resp)
@@ -166,7 +301,7 @@ assert.Equal(t, "Information memorized", resp)
 t.Run("memory_load success with limit", func(t *testing.T) {
 m := &MockMemory{RecallResults: []chromem.Result{{ID: "id", Content: "c"}}}
 a := NewAgent(nil, nil, m, nil, nil, false)
-tc := gemini.ToolCall{Name: "memory_load", Arguments: map[string]interface{}{"query": "q", "limit": 10.0}}
+tc := llm.FunctionCall{Name: "memory_load", Arguments: map[string]interface{}{"query": "q", "limit": 10.0}}
 resp, err := a.handleToolCall(ctx, "s1", tc)
 assert.NoError(t, err)
 assert.Contains(t, resp, "c")
@@ -175,7 +310,7 @@ assert.Contains(t, resp, "c")
 t.Run("memory_forget success", func(t *testing.T) {
 m := &MockMemory{}
 a := NewAgent(nil, nil, m, nil, nil, false)
-tc := gemini.ToolCall{Name: "memory_forget", Arguments: map[string]interface{}{"id": "id"}}
+tc := llm.FunctionCall{Name: "memory_forget", Arguments: map[string]interface{}{"id": "id"}}
 resp, err := a.handleToolCall(ctx, "s1", tc)
 assert.NoError(t, err)
 assert.Equal(t, "Memory forgotten", resp)
@@ -183,13 +318,27 @@ assert.Equal(t, "Memory forgotten", resp)
 
 t.Run("unknown tool", func(t *testing.T) {
 a := NewAgent(nil, nil, nil, nil, nil, false)
-tc := gemini.ToolCall{Name: "unknown"}
+tc := llm.FunctionCall{Name: "unknown"}
 _, err := a.handleToolCall(ctx, "s1", tc)
 assert.Error(t, err)
 assert.Contains(t, err.Error(), "unknown tool")
 })
 }
 
+// deadlineShell records the deadline of the last command and returns a fixed result.
+type deadlineShell struct {
+deadline time.Time
+result   executor.Result
+err      error
+}
+
+func (s *deadlineShell) Execute(ctx context.Context, command string) (executor.Result, error) {
+s.deadline, _ = ctx.Deadline()
+return s.result, s.err
+}
+
+func (s *deadlineShell) Close() error { return nil }
+
 func TestAgent_ConfirmAction(t *testing.T) {
 t.Run("Non-Interactive", func(t *testing.T) {
 a := &Agent{InteractiveMode: false}
@@ -222,3 +371,23 @@ a := &Agent{InteractiveMode: true}
 assert.False(t, a.confirmAction("test"))
 })
 }
+
+func TestHistoryToMessages(t *testing.T) {
+hist := []history.Message{
+{Role: "user", Content: "hello"},
+{Role: "model", Content: "checking", ToolCalls: []history.ToolCall{{ID: "c1", Name: "read_file"}}},
+{Role: "tool", ToolResults: []history.ToolResult{{CallID: "c1", Name: "read_file", Error: "not found"}}},
+{Role: "model", ToolCalls: []history.ToolCall{{ID: "c2", Name: "execute_command"}}},
+{Role: "user", Content: "interrupted"},
+}
+
+msgs := historyToMessages(hist)
+assert.Len(t, msgs, 4)
+assert.Equal(t, "checking", msgs[1].Text())
+assert.Equal(t, "c1", msgs[1].FunctionCalls()[0].ID)
+result := msgs[2].FunctionResults()[0]
+assert.True(t, result.IsError)
+assert.Equal(t, "Error: not found", result.Content)
+// The unanswered call is dropped entirely
+assert.Equal(t, llm.RoleUser, msgs[3].Role)
+}
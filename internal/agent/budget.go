@@ -0,0 +1,125 @@
+package agent
+
+import (
+"context"
+"encoding/json"
+"fmt"
+"time"
+
+"github.com/LeeroyDing/hyperagent/internal/llm"
+)
+
+// Reasons for ending a run before the model produced a final answer.
+const (
+StopMaxIterations = "max_iterations"
+StopMaxToolCalls  = "max_tool_calls"
+StopMaxDuration   = "max_duration"
+StopRepeatedCall  = "repeated_call"
+)
+
+// Limits bounds a single Run of the agentic loop. Zero fields fall back to
+// DefaultLimits.
+type Limits struct {
+// MaxIterations caps the number of model turns that request tool calls.
+MaxIterations int `yaml:"max_iterations"`
+// MaxToolCalls caps the total number of tool calls.
+MaxToolCalls int `yaml:"max_tool_calls"`
+// MaxDuration caps the wall-clock time spent in the loop. Model calls and
+// tools still running when it is reached are cancelled.
+MaxDuration time.Duration `yaml:"max_duration"`
+// MaxRepeatedCalls is how many times in a row the model may issue the
+// same call with the same arguments before it is considered stuck.
+MaxRepeatedCalls int `yaml:"max_repeated_calls"`
+}
+
+// DefaultLimits returns the limits used when none are configured.
+func DefaultLimits() Limits {
+return Limits{
+MaxIterations:    25,
+MaxToolCalls:     100,
+MaxDuration:      10 * time.Minute,
+MaxRepeatedCalls: 3,
+}
+}
+
+func (l Limits) withDefaults() Limits {
+def := DefaultLimits()
+if l.MaxIterations <= 0 {
+l.MaxIterations = def.MaxIterations
+}
+if l.MaxToolCalls <= 0 {
+l.MaxToolCalls = def.MaxToolCalls
+}
+if l.MaxDuration <= 0 {
+l.MaxDuration = def.MaxDuration
+}
+if l.MaxRepeatedCalls <= 0 {
+l.MaxRepeatedCalls = def.MaxRepeatedCalls
+}
+return l
+}
+
+// summaryTimeout bounds the request for a final summary once the budget
+// is exhausted.
+const summaryTimeout = time.Minute
+
+// budget tracks the consumption of Limits during one run.
+type budget struct {
+limits     Limits
+start      time.Time
+iterations int
+toolCalls  int
+lastCall   string
+repeats    int
+}
+
+func newBudget(l Limits) *budget {
+return &budget{limits: l.withDefaults(), start: time.Now()}
+}
+
+// spend accounts for a model turn requesting calls. It returns a stop reason
+// and a human readable explanation when the turn must not be executed.
+func (b *budget) spend(calls []llm.FunctionCall) (string, string) {
+b.iterations++
+if b.iterations > b.limits.MaxIterations {
+return StopMaxIterations, fmt.Sprintf("reached the limit of %d tool iterations", b.limits.MaxIterations)
+}
+b.toolCalls += len(calls)
+if b.toolCalls > b.limits.MaxToolCalls {
+return StopMaxToolCalls, fmt.Sprintf("reached the limit of %d tool calls", b.limits.MaxToolCalls)
+}
+if elapsed := time.Since(b.start); elapsed > b.limits.MaxDuration {
+return StopMaxDuration, b.timeLimit()
+}
+for _, c := range calls {
+key := callKey(c)
+if key == b.lastCall {
+b.repeats++
+} else {
+b.lastCall, b.repeats = key, 1
+}
+if b.repeats > b.limits.MaxRepeatedCalls {
+return StopRepeatedCall, fmt.Sprintf("%s was called %d times in a row with the same arguments", c.Name, b.repeats)
+}
+}
+return "", ""
+}
+
+// timeLimit explains a run that took longer than MaxDuration.
+func (b *budget) timeLimit() string {
+return fmt.Sprintf("exceeded the time limit of %s", b.limits.MaxDuration)
+}
+
+// expired reports whether runCtx, the run's context bounded by
+// MaxDuration, ended because time ran out rather than because ctx, the
+// caller's, was cancelled.
+func (b *budget) expired(ctx, runCtx context.Context) bool {
+return runCtx.Err() != nil && ctx.Err() == nil
+}
+
+// callKey identifies a call by name and arguments. Map keys are marshalled
+// in sorted order, so equal arguments produce equal keys.
+func callKey(c llm.FunctionCall) string {
+args, _ := json.Marshal(c.Arguments)
+return c.Name + string(args)
+}
@@ -0,0 +1,146 @@
+package agent
+
+import (
+"context"
+"testing"
+"time"
+
+"github.com/LeeroyDing/hyperagent/internal/executor"
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/stretchr/testify/assert"
+)
+
+func ls(id string) []llm.FunctionCall {
+return []llm.FunctionCall{{ID: id, Name: "execute_command", Arguments: map[string]interface{}{"command": "ls"}}}
+}
+
+func TestBudget_Spend(t *testing.T) {
+t.Run("defaults", func(t *testing.T) {
+assert.Equal(t, DefaultLimits(), Limits{}.withDefaults())
+assert.Equal(t, 7, Limits{MaxIterations: 7}.withDefaults().MaxIterations)
+})
+
+t.Run("iterations", func(t *testing.T) {
+b := newBudget(Limits{MaxIterations: 2})
+reason, _ := b.spend(ls("1"))
+assert.Empty(t, reason)
+reason, _ = b.spend([]llm.FunctionCall{{Name: "read_file"}})
+assert.Empty(t, reason)
+reason, why := b.spend(ls("3"))
+assert.Equal(t, StopMaxIterations, reason)
+assert.Contains(t, why, "2 tool iterations")
+})
+
+t.Run("tool calls", func(t *testing.T) {
+b := newBudget(Limits{MaxToolCalls: 2})
+reason, _ := b.spend([]llm.FunctionCall{{Name: "a"}, {Name: "b"}, {Name: "c"}})
+assert.Equal(t, StopMaxToolCalls, reason)
+})
+
+t.Run("duration", func(t *testing.T) {
+b := newBudget(Limits{MaxDuration: time.Millisecond})
+b.start = time.Now().Add(-time.Second)
+reason, _ := b.spend(ls("1"))
+assert.Equal(t, StopMaxDuration, reason)
+})
+
+t.Run("repeated call", func(t *testing.T) {
+b := newBudget(Limits{MaxRepeatedCalls: 2})
+reason, _ := b.spend(ls("1"))
+assert.Empty(t, reason)
+reason, _ = b.spend(ls("2"))
+assert.Empty(t, reason)
+reason, why := b.spend(ls("3"))
+assert.Equal(t, StopRepeatedCall, reason)
+assert.Contains(t, why, "execute_command was called 3 times")
+})
+
+t.Run("different arguments reset the count", func(t *testing.T) {
+b := newBudget(Limits{MaxRepeatedCalls: 1})
+b.spend(ls("1"))
+reason, _ := b.spend([]llm.FunctionCall{{Name: "execute_command", Arguments: map[string]interface{}{"command": "pwd"}}})
+assert.Empty(t, reason)
+reason, _ = b.spend(ls("3"))
+assert.Empty(t, reason)
+})
+}
+
+func TestAgent_RunStopsOnRepeatedCalls(t *testing.T) {
+g := &MockLLMClient{
+Responses: []string{"", "", "", "I kept listing files."},
+ToolCalls: [][]llm.FunctionCall{ls("1"), ls("2"), ls("3")},
+}
+e := &MockExecutor{}
+h := &MockHistory{}
+a := NewAgent(g, e, &MockMemory{}, nil, h, false)
+a.Limits = Limits{MaxRepeatedCalls: 2}
+
+resp, err := a.Run(context.Background(), "s1", "list")
+assert.NoError(t, err)
+assert.Equal(t, "I kept listing files.", resp)
+assert.Len(t, e.ExecutedCommands, 2)
+
+// The summary request offers no tools and answers the pending call
+last := g.Requests[len(g.Requests)-1]
+assert.Equal(t, llm.RoleUser, last[len(last)-1].Role)
+assert.Contains(t, last[len(last)-1].Text(), "Do not call any more tools")
+assert.True(t, last[len(last)-2].FunctionResults()[0].IsError)
+
+stored := h.Sessions["s1"]
+final := stored[len(stored)-1]
+assert.Equal(t, StopRepeatedCall, final.StopReason)
+assert.Equal(t, "I kept listing files.", final.Content)
+assert.Contains(t, stored[len(stored)-2].ToolResults[0].Error, "not executed")
+}
+
+func TestAgent_RunSummaryFallback(t *testing.T) {
+g := &MockLLMClient{
+Responses: []string{"", "", ""},
+ToolCalls: [][]llm.FunctionCall{ls("1"), ls("2")},
+}
+a := NewAgent(g, &MockExecutor{}, &MockMemory{}, nil, &MockHistory{}, false)
+a.Limits = Limits{MaxIterations: 1}
+
+resp, err := a.Run(context.Background(), "s1", "list")
+assert.NoError(t, err)
+assert.Contains(t, resp, "Stopped: reached the limit of 1 tool iterations")
+}
+
+// blockingExecutor runs commands until they are cancelled.
+type blockingExecutor struct{}
+
+func (blockingExecutor) Execute(ctx context.Context, sessionID string, cmd executor.Command) (executor.Result, error) {
+<-ctx.Done()
+return executor.Result{ExitCode: -1}, ctx.Err()
+}
+
+func TestAgent_RunStopsOnTimeLimit(t *testing.T) {
+g := &MockLLMClient{
+Responses: []string{"", "I ran out of time."},
+ToolCalls: [][]llm.FunctionCall{ls("1")},
+}
+h := &MockHistory{}
+a := NewAgent(g, blockingExecutor{}, &MockMemory{}, nil, h, false)
+a.Limits = Limits{MaxDuration: 50 * time.Millisecond}
+
+// The running command is cancelled when the time is up
+start := time.Now()
+resp, err := a.Run(context.Background(), "s1", "list")
+assert.NoError(t, err)
+assert.Less(t, time.Since(start), 5*time.Second)
+assert.Equal(t, "I ran out of time.", resp)
+
+last := g.Requests[len(g.Requests)-1]
+assert.Contains(t, last[len(last)-1].Text(), "exceeded the time limit of 50ms")
+assert.True(t, last[len(last)-2].FunctionResults()[0].IsError)
+stored := h.Sessions["s1"]
+assert.Equal(t, StopMaxDuration, stored[len(stored)-1].StopReason)
+
+// A cancelled caller is not reported as the time limit
+g = &MockLLMClient{Responses: []string{""}, ToolCalls: [][]llm.FunctionCall{ls("1")}}
+a = NewAgent(g, blockingExecutor{}, &MockMemory{}, nil, &MockHistory{}, false)
+ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+defer cancel()
+_, err = a.Run(ctx, "s1", "list")
+assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
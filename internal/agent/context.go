@@ -0,0 +1,133 @@
+package agent
+
+import (
+"context"
+"encoding/json"
+"fmt"
+"log/slog"
+"strings"
+
+"github.com/LeeroyDing/hyperagent/internal/llm"
+)
+
+// contextSummary is a cached summary of the first upTo history messages of a session.
+type contextSummary struct {
+upTo int
+text string
+}
+
+// fitContext trims history so that it fits the model window together with
+// reserved tokens (prompt, RAG block, tool schemas). The oldest turns are
+// replaced by a summary that is cached per session and only extended when
+// more history has to go.
+func (a *Agent) fitContext(ctx context.Context, sessionID string, messages []llm.Message, reserved int) []llm.Message {
+if a.TokenMgr == nil || len(messages) == 0 {
+return messages
+}
+budget := a.ContextLimits.InputBudget() - reserved
+
+// suffix[i] is the token count of messages[i:]
+suffix := make([]int, len(messages)+1)
+for i := len(messages) - 1; i >= 0; i-- {
+suffix[i] = suffix[i+1] + a.TokenMgr.CountMessage(messages[i])
+}
+if suffix[0] <= budget {
+return messages
+}
+
+a.mu.Lock()
+cached := a.summaries[sessionID]
+a.mu.Unlock()
+if cached.upTo > len(messages) {
+cached = contextSummary{}
+}
+if cached.text != "" && a.TokenMgr.CountTokens(cached.text)+suffix[cached.upTo] <= budget {
+return withSummary(cached.text, messages[cached.upTo:])
+}
+
+// Cut deeper than strictly needed so that the summary can be reused for
+// the next few turns instead of being regenerated every time.
+cut := len(messages)
+for i := cached.upTo; i < len(messages); i++ {
+if isTurnStart(messages[i]) && suffix[i] <= budget/2 {
+cut = i
+break
+}
+}
+
+slog.Info("Summarizing old history", "session", sessionID, "messages", cut-cached.upTo, "tokens", suffix[0], "budget", budget)
+summary, err := a.summarize(ctx, cached.text, messages[cached.upTo:cut])
+if err != nil {
+slog.Warn("Failed to summarize history, dropping old turns", "session", sessionID, "error", err)
+summary = cached.text
+} else {
+a.mu.Lock()
+if a.summaries == nil {
+a.summaries = make(map[string]contextSummary)
+}
+a.summaries[sessionID] = contextSummary{upTo: cut, text: summary}
+a.mu.Unlock()
+}
+
+if a.TokenMgr.CountTokens(summary)+suffix[cut] > budget {
+summary = ""
+}
+return withSummary(summary, messages[cut:])
+}
+
+// summarize condenses messages, extending a previous summary if there is one.
+func (a *Agent) summarize(ctx context.Context, previous string, messages []llm.Message) (string, error) {
+var sb strings.Builder
+if previous != "" {
+sb.WriteString("Summary so far:\n")
+sb.WriteString(previous)
+sb.WriteString("\n\nLater conversation:\n")
+}
+sb.WriteString(transcript(messages))
+
+prompt := fmt.Sprintf("Summarize the following conversation between a user and an assistant that can run tools. Keep the user's goals, decisions, commands that were run, files that were changed and any open tasks. Be concise.\n\n%s", sb.String())
+resp, err := a.LLM.GenerateContent(ctx, []llm.Message{llm.NewTextMessage(llm.RoleUser, prompt)}, nil)
+if err != nil {
+return "", err
+}
+if resp.Text() == "" {
+return "", fmt.Errorf("empty summary")
+}
+return resp.Text(), nil
+}
+
+// transcript renders messages as plain text for summarization.
+func transcript(messages []llm.Message) string {
+var sb strings.Builder
+for _, m := range messages {
+if text := m.Text(); text != "" {
+sb.WriteString(fmt.Sprintf("%s: %s\n", m.Role, text))
+}
+for _, fc := range m.FunctionCalls() {
+args, _ := json.Marshal(fc.Arguments)
+sb.WriteString(fmt.Sprintf("%s called %s(%s)\n", m.Role, fc.Name, args))
+}
+for _, r := range m.FunctionResults() {
+sb.WriteString(fmt.Sprintf("%s %s returned: %s\n", m.Role, r.Name, r.Content))
+}
+}
+return sb.String()
+}
+
+// isTurnStart reports whether a conversation may start at m without
+// separating function calls from their results.
+func isTurnStart(m llm.Message) bool {
+return m.Role == llm.RoleUser && len(m.FunctionResults()) == 0
+}
+
+// withSummary prefixes the first kept message with the summary of the dropped ones.
+func withSummary(summary string, rest []llm.Message) []llm.Message {
+if summary == "" {
+return rest
+}
+text := "[SUMMARY OF EARLIER CONVERSATION]\n" + summary
+if len(rest) == 0 {
+return []llm.Message{llm.NewTextMessage(llm.RoleUser, text)}
+}
+return append([]llm.Message{llm.NewTextMessage(llm.RoleUser, text+"\n\n"+rest[0].Text())}, rest[1:]...)
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+"context"
+"errors"
+"fmt"
+"strings"
+"testing"
+
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/LeeroyDing/hyperagent/internal/token"
+"github.com/stretchr/testify/assert"
+)
+
+// turns builds n user/model exchanges of roughly 30 estimated tokens per message.
+func turns(from, n int) []llm.Message {
+var msgs []llm.Message
+for i := from; i < from+n; i++ {
+msgs = append(msgs,
+llm.NewTextMessage(llm.RoleUser, fmt.Sprintf("question %02d %s", i, strings.Repeat("q", 90))),
+llm.NewTextMessage(llm.RoleModel, fmt.Sprintf("answer %02d %s", i, strings.Repeat("a", 92))),
+)
+}
+return msgs
+}
+
+func TestAgent_FitContext(t *testing.T) {
+ctx := context.Background()
+
+t.Run("fits unchanged", func(t *testing.T) {
+a := NewAgent(&MockLLMClient{}, nil, nil, nil, nil, false)
+msgs := turns(0, 3)
+assert.Equal(t, msgs, a.fitContext(ctx, "s1", msgs, 100))
+})
+
+t.Run("summarizes and reuses the summary", func(t *testing.T) {
+g := &MockLLMClient{Responses: []string{"first summary", "second summary"}}
+a := NewAgent(g, nil, nil, nil, nil, false)
+a.ContextLimits = token.Limits{ContextWindow: 250, ReserveOutput: 50}
+
+history := turns(0, 10)
+got := a.fitContext(ctx, "s1", history, 0)
+assert.Len(t, g.Requests, 1)
+assert.Len(t, got, 2)
+assert.True(t, strings.HasPrefix(got[0].Text(), "[SUMMARY OF EARLIER CONVERSATION]\nfirst summary\n\nquestion 09"))
+assert.Equal(t, history[19], got[1])
+assert.Contains(t, g.Requests[0][0].Text(), "question 00")
+
+// One more turn still fits next to the cached summary
+history = append(history, turns(10, 1)...)
+got = a.fitContext(ctx, "s1", history, 0)
+assert.Len(t, g.Requests, 1)
+assert.Len(t, got, 4)
+assert.Contains(t, got[0].Text(), "first summary")
+
+// Eventually the summary is extended rather than rebuilt
+history = append(history, turns(11, 2)...)
+got = a.fitContext(ctx, "s1", history, 0)
+assert.Len(t, g.Requests, 2)
+prompt := g.Requests[1][0].Text()
+assert.Contains(t, prompt, "Summary so far:\nfirst summary")
+assert.NotContains(t, prompt, "question 00")
+assert.Contains(t, got[0].Text(), "second summary")
+})
+
+t.Run("drops old turns when summarizing fails", func(t *testing.T) {
+g := &MockLLMClient{GenerateError: errors.New("unavailable")}
+a := NewAgent(g, nil, nil, nil, nil, false)
+a.ContextLimits = token.Limits{ContextWindow: 250, ReserveOutput: 50}
+
+history := turns(0, 10)
+got := a.fitContext(ctx, "s1", history, 0)
+assert.Equal(t, history[18:], got)
+})
+
+t.Run("keeps tool results with their calls", func(t *testing.T) {
+g := &MockLLMClient{Responses: []string{"summary"}}
+a := NewAgent(g, nil, nil, nil, nil, false)
+a.ContextLimits = token.Limits{ContextWindow: 250, ReserveOutput: 50}
+
+history := append(turns(0, 5), llm.NewTextMessage(llm.RoleUser, "run it"),
+llm.Message{Role: llm.RoleModel, Parts: []llm.Part{{FunctionCall: &llm.FunctionCall{ID: "c1", Name: "execute_command"}}}},
+llm.NewFunctionResultMessage([]llm.FunctionResult{{ID: "c1", Name: "execute_command", Content: strings.Repeat("x", 200)}}),
+)
+got := a.fitContext(ctx, "s1", history, 0)
+assert.Len(t, got, 3)
+assert.Contains(t, got[0].Text(), "run it")
+assert.Equal(t, llm.RoleTool, got[2].Role)
+})
+}
+
+func TestAgent_RunPrunesHistory(t *testing.T) {
+g := &MockLLMClient{Responses: []string{"summary", "done"}}
+h := &MockHistory{}
+for _, m := range turns(0, 10) {
+h.AddMessage("s1", string(m.Role), m.Text())
+}
+a := NewAgent(g, &MockExecutor{}, &MockMemory{}, nil, h, false)
+sys, _ := a.systemPrompt("s1", a.getTools())
+a.ContextLimits = token.Limits{ContextWindow: 250 + a.TokenMgr.CountTokens(sys) + a.TokenMgr.CountTools(a.getTools()), ReserveOutput: 50}
+
+resp, err := a.Run(context.Background(), "s1", "hi")
+assert.NoError(t, err)
+assert.Equal(t, "done", resp)
+sent := g.Requests[1]
+assert.Less(t, len(sent), 22)
+assert.Equal(t, llm.RoleSystem, sent[0].Role)
+assert.Contains(t, sent[1].Text(), "summary")
+assert.Equal(t, "hi", sent[len(sent)-1].Text())
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+"fmt"
+"time"
+
+"github.com/LeeroyDing/hyperagent/internal/history"
+"github.com/LeeroyDing/hyperagent/internal/llm"
+)
+
+// toolOutcome is the result of a single tool call as seen by the agent loop.
+type toolOutcome struct {
+Call     llm.FunctionCall
+Output   string
+Err      error
+Duration time.Duration
+}
+
+// functionResult converts the outcome into what is sent back to the model.
+func (o toolOutcome) functionResult() llm.FunctionResult {
+r := llm.FunctionResult{ID: o.Call.ID, Name: o.Call.Name, Content: o.Output}
+if o.Err != nil {
+r.Content = fmt.Sprintf("Error: %v", o.Err)
+r.IsError = true
+}
+return r
+}
+
+// record converts the outcome into a history entry.
+func (o toolOutcome) record() history.ToolResult {
+r := history.ToolResult{
+CallID:     o.Call.ID,
+Name:       o.Call.Name,
+Output:     o.Output,
+DurationMs: o.Duration.Milliseconds(),
+}
+if o.Err != nil {
+r.Error = o.Err.Error()
+}
+return r
+}
+
+// toolCallMessage builds the history entry for a model turn that requested tool calls.
+func toolCallMessage(resp *llm.Response) history.Message {
+msg := history.Message{Role: string(llm.RoleModel), Content: resp.Text()}
+for _, fc := range resp.FunctionCalls() {
+msg.ToolCalls = append(msg.ToolCalls, history.ToolCall{ID: fc.ID, Name: fc.Name, Arguments: fc.Arguments})
+}
+return msg
+}
+
+// toolResultMessage builds the history entry holding the results of one round of tool calls.
+func toolResultMessage(outcomes []toolOutcome) history.Message {
+msg := history.Message{Role: string(llm.RoleTool)}
+for _, o := range outcomes {
+msg.ToolResults = append(msg.ToolResults, o.record())
+}
+return msg
+}
+
+// historyToMessages replays stored history, including tool calls and their
+// results, as model context. Tool calls without recorded results (for
+// example after a crash mid-turn) are dropped because providers reject
+// unanswered calls.
+func historyToMessages(hist []history.Message) []llm.Message {
+var messages []llm.Message
+for i, m := range hist {
+switch {
+case len(m.ToolCalls) > 0:
+msg := llm.Message{Role: llm.RoleModel}
+if m.Content != "" {
+msg.Parts = append(msg.Parts, llm.Part{Text: m.Content})
+}
+if i+1 < len(hist) && len(hist[i+1].ToolResults) > 0 {
+for _, tc := range m.ToolCalls {
+msg.Parts = append(msg.Parts, llm.Part{FunctionCall: &llm.FunctionCall{ID: tc.ID, Name: tc.Name, Arguments: tc.Arguments}})
+}
+}
+if len(msg.Parts) > 0 {
+messages = append(messages, msg)
+}
+case len(m.ToolResults) > 0:
+if i == 0 || len(hist[i-1].ToolCalls) == 0 {
+continue
+}
+var results []llm.FunctionResult
+for _, r := range m.ToolResults {
+fr := llm.FunctionResult{ID: r.CallID, Name: r.Name, Content: r.Output}
+if r.Error != "" {
+fr.Content = "Error: " + r.Error
+fr.IsError = true
+}
+results = append(results, fr)
+}
+messages = append(messages, llm.NewFunctionResultMessage(results))
+default:
+messages = append(messages, llm.NewTextMessage(llm.NormalizeRole(m.Role), m.Content))
+}
+}
+return messages
+}
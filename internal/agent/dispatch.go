@@ -0,0 +1,133 @@
+package agent
+
+import (
+"context"
+"fmt"
+"log/slog"
+"sync"
+"time"
+
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/LeeroyDing/hyperagent/internal/orchestrator"
+)
+
+// readOnlyTools are the built-in tools that do not change anything and may
+// run concurrently with each other.
+var readOnlyTools = map[string]bool{
+"read_file":     true,
+"memory_load":   true,
+"read_artifact": true,
+"list_dir":      true,
+"glob":          true,
+"search_files":  true,
+}
+
+// isReadOnly reports whether a tool may run in parallel. MCP tools qualify
+// when their server marks them with the read-only hint.
+func (a *Agent) isReadOnly(name string) bool {
+if readOnlyTools[name] {
+return true
+}
+if rt, ok := a.MCP.Lookup(name); ok {
+hint := rt.Tool.Annotations.ReadOnlyHint
+return hint != nil && *hint
+}
+return false
+}
+
+// sessionLock returns the mutex that serializes mutating tools of a session,
+// including across concurrent runs.
+func (a *Agent) sessionLock(sessionID string) *sync.Mutex {
+a.mu.Lock()
+defer a.mu.Unlock()
+if a.sessionLocks == nil {
+a.sessionLocks = make(map[string]*sync.Mutex)
+}
+l, ok := a.sessionLocks[sessionID]
+if !ok {
+l = &sync.Mutex{}
+a.sessionLocks[sessionID] = l
+}
+return l
+}
+
+// runToolCalls executes the calls of one model turn. Consecutive read-only
+// calls run concurrently through the orchestrator; a mutating call waits for
+// everything before it and runs alone, so the model sees the effects in the
+// order it asked for them. Outcomes are returned in call order.
+func (a *Agent) runToolCalls(ctx context.Context, sessionID string, calls []llm.FunctionCall, emit func(Event)) []toolOutcome {
+outcomes := make([]toolOutcome, 0, len(calls))
+for i := 0; i < len(calls); {
+j := i
+for j < len(calls) && a.isReadOnly(calls[j].Name) {
+j++
+}
+if j == i {
+j = i + 1
+}
+batch := calls[i:j]
+
+for k := range batch {
+slog.Info("Handling tool call", "name", batch[k].Name, "args", batch[k].Arguments)
+if emit != nil {
+emit(Event{Type: EventToolCall, ToolCall: &batch[k]})
+}
+}
+
+var done []toolOutcome
+if len(batch) == 1 && !a.isReadOnly(batch[0].Name) {
+done = []toolOutcome{a.runMutating(ctx, sessionID, batch[0])}
+} else {
+done = a.runParallel(ctx, sessionID, batch)
+}
+
+for _, o := range done {
+if emit != nil {
+r := o.record()
+emit(Event{Type: EventToolResult, ToolResult: &r})
+}
+}
+outcomes = append(outcomes, done...)
+i = j
+}
+return outcomes
+}
+
+// runMutating runs a call that may change state while holding the session
+// lock. A panicking tool fails the call, as it does in the orchestrator.
+func (a *Agent) runMutating(ctx context.Context, sessionID string, tc llm.FunctionCall) (o toolOutcome) {
+lock := a.sessionLock(sessionID)
+lock.Lock()
+defer lock.Unlock()
+
+start := time.Now()
+defer func() {
+if r := recover(); r != nil {
+o = toolOutcome{Call: tc, Err: fmt.Errorf("tool %s panicked: %v", tc.Name, r), Duration: time.Since(start)}
+}
+}()
+output, err := a.callTool(ctx, sessionID, tc)
+return toolOutcome{Call: tc, Output: output, Err: err, Duration: time.Since(start)}
+}
+
+// runParallel runs read-only calls concurrently.
+func (a *Agent) runParallel(ctx context.Context, sessionID string, calls []llm.FunctionCall) []toolOutcome {
+o := a.Orchestrator
+if o == nil {
+o = orchestrator.NewOrchestrator()
+}
+
+tasks := make([]orchestrator.Task, len(calls))
+for i, tc := range calls {
+tasks[i] = orchestrator.Task{ID: tc.ID, ToolName: tc.Name, ToolArgs: tc.Arguments}
+}
+results := o.RunParallel(ctx, tasks, func(ctx context.Context, t orchestrator.Task) (string, error) {
+return a.callTool(ctx, sessionID, llm.FunctionCall{ID: t.ID, Name: t.ToolName, Arguments: t.ToolArgs})
+})
+
+outcomes := make([]toolOutcome, len(calls))
+for i, r := range results {
+outcomes[i] = toolOutcome{Call: calls[i], Output: r.Output, Err: r.Error, Duration: r.Duration}
+}
+return outcomes
+}
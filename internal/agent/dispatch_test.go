@@ -0,0 +1,165 @@
+package agent
+
+import (
+"context"
+"sync"
+"testing"
+"time"
+
+"github.com/LeeroyDing/hyperagent/internal/executor"
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/LeeroyDing/hyperagent/internal/orchestrator"
+"github.com/philippgille/chromem-go"
+"github.com/stretchr/testify/assert"
+)
+
+// gatedMemory blocks every Recall until release is closed and counts how
+// many calls are waiting at the same time.
+type gatedMemory struct {
+MockMemory
+release chan struct{}
+
+mu      sync.Mutex
+waiting int
+peak    int
+}
+
+func (m *gatedMemory) Recall(ctx context.Context, query string, limit int) ([]chromem.Result, error) {
+m.mu.Lock()
+m.waiting++
+if m.waiting > m.peak {
+m.peak = m.waiting
+}
+m.mu.Unlock()
+defer func() {
+m.mu.Lock()
+m.waiting--
+m.mu.Unlock()
+}()
+
+select {
+case <-m.release:
+return []chromem.Result{{ID: query, Content: query}}, nil
+case <-ctx.Done():
+return nil, ctx.Err()
+}
+}
+
+func (m *gatedMemory) peakWaiting() int {
+m.mu.Lock()
+defer m.mu.Unlock()
+return m.peak
+}
+
+// panicExecutor panics on every command.
+type panicExecutor struct {
+MockExecutor
+}
+
+func (e *panicExecutor) Execute(ctx context.Context, sessionID string, cmd executor.Command) (executor.Result, error) {
+panic("boom")
+}
+
+func recallCall(id, query string) llm.FunctionCall {
+return llm.FunctionCall{ID: id, Name: "memory_load", Arguments: map[string]interface{}{"query": query}}
+}
+
+func TestAgent_RunToolCalls(t *testing.T) {
+ctx := context.Background()
+
+t.Run("Read-only calls run concurrently", func(t *testing.T) {
+mem := &gatedMemory{release: make(chan struct{})}
+a := NewAgent(nil, &MockExecutor{}, mem, nil, &MockHistory{}, false)
+
+go func() {
+// Release once all three are in flight; a sequential dispatch never gets there
+for mem.peakWaiting() < 3 {
+time.Sleep(time.Millisecond)
+}
+close(mem.release)
+}()
+
+calls := []llm.FunctionCall{recallCall("1", "a"), recallCall("2", "b"), recallCall("3", "c")}
+outcomes := a.runToolCalls(ctx, "s1", calls, nil)
+
+assert.Len(t, outcomes, 3)
+for i, o := range outcomes {
+assert.NoError(t, o.Err)
+assert.Equal(t, calls[i], o.Call)
+assert.Contains(t, o.Output, "ID: "+calls[i].Arguments["query"].(string))
+}
+})
+
+t.Run("Mutating calls keep their place", func(t *testing.T) {
+mem := &gatedMemory{release: make(chan struct{})}
+close(mem.release)
+exec := &MockExecutor{}
+a := NewAgent(nil, exec, mem, nil, &MockHistory{}, false)
+
+var events []Event
+calls := []llm.FunctionCall{
+recallCall("1", "a"),
+{ID: "2", Name: "execute_command", Arguments: map[string]interface{}{"command": "ls"}},
+recallCall("3", "b"),
+{ID: "4", Name: "memory_save", Arguments: map[string]interface{}{"id": "k", "content": "v"}},
+}
+outcomes := a.runToolCalls(ctx, "s1", calls, func(e Event) { events = append(events, e) })
+
+assert.Len(t, outcomes, 4)
+for i, o := range outcomes {
+assert.Equal(t, calls[i].ID, o.Call.ID)
+}
+assert.Contains(t, outcomes[1].Output, "Mock output for: ls")
+assert.Equal(t, "Information memorized", outcomes[3].Output)
+
+var order []string
+for _, e := range events {
+if e.Type == EventToolResult {
+order = append(order, e.ToolResult.CallID)
+}
+}
+assert.Equal(t, []string{"1", "2", "3", "4"}, order)
+})
+
+t.Run("Malformed calls fail", func(t *testing.T) {
+a := NewAgent(nil, &panicExecutor{}, &MockMemory{}, nil, &MockHistory{}, false)
+calls := []llm.FunctionCall{
+{ID: "1", Name: "execute_command", Arguments: map[string]interface{}{"command": 42}},
+{ID: "2", Name: "memory_save", Arguments: map[string]interface{}{}},
+{ID: "3", Name: "execute_command", Arguments: map[string]interface{}{"command": "ls"}},
+}
+outcomes := a.runToolCalls(ctx, "s1", calls, nil)
+assert.EqualError(t, outcomes[0].Err, "command is required")
+assert.EqualError(t, outcomes[1].Err, "id is required")
+assert.EqualError(t, outcomes[2].Err, "tool execute_command panicked: boom")
+})
+
+t.Run("Task timeout", func(t *testing.T) {
+mem := &gatedMemory{release: make(chan struct{})}
+a := NewAgent(nil, &MockExecutor{}, mem, nil, &MockHistory{}, false)
+a.Orchestrator = orchestrator.NewOrchestratorWithOptions(orchestrator.Options{TaskTimeout: 10 * time.Millisecond})
+
+outcomes := a.runToolCalls(ctx, "s1", []llm.FunctionCall{recallCall("1", "a"), recallCall("2", "b")}, nil)
+for _, o := range outcomes {
+assert.EqualError(t, o.Err, "memory_load timed out after 10ms")
+assert.True(t, o.functionResult().IsError)
+}
+})
+}
+
+func TestAgent_IsReadOnly(t *testing.T) {
+a := NewAgent(nil, nil, nil, newTestMCPManager(t), nil, false)
+assert.True(t, a.isReadOnly("read_file"))
+assert.True(t, a.isReadOnly("memory_load"))
+assert.True(t, a.isReadOnly("files__echo"))
+assert.False(t, a.isReadOnly("files__fail"))
+assert.False(t, a.isReadOnly("execute_command"))
+assert.False(t, a.isReadOnly("replace_text"))
+assert.False(t, a.isReadOnly("unknown"))
+}
+
+func TestAgent_SessionLock(t *testing.T) {
+a := NewAgent(nil, nil, nil, nil, nil, false)
+assert.Same(t, a.sessionLock("s1"), a.sessionLock("s1"))
+assert.NotSame(t, a.sessionLock("s1"), a.sessionLock("s2"))
+}
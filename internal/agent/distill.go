@@ -5,7 +5,7 @@ import (
 "fmt"
 "log/slog"
 
-"github.com/LeeroyDing/hyperagent/internal/gemini"
+"github.com/LeeroyDing/hyperagent/internal/llm"
 )
 
 func (a *Agent) Distill(ctx context.Context, sessionID string) error {
@@ -28,15 +28,15 @@ historyText += fmt.Sprintf("%s: %s\n", m.Role, m.Content)
 
 prompt := fmt.Sprintf("Summarize the following conversation into a concise set of key facts, decisions, and context for long-term memory. Focus on information that will be useful for future interactions. Conversation:\n%s", historyText)
 
-summary, _, err := a.Gemini.GenerateContent(ctx, []gemini.Message{
-{Role: "user", Content: prompt},
+resp, err := a.LLM.GenerateContent(ctx, []llm.Message{
+llm.NewTextMessage(llm.RoleUser, prompt),
 }, nil)
 if err != nil {
 return fmt.Errorf("failed to generate distillation summary: %w", err)
 }
 
 // Save to vector memory
-err = a.Memory.Memorize(ctx, fmt.Sprintf("distill-%s-%d", sessionID, len(hist)), summary, map[string]string{
+err = a.Memory.Memorize(ctx, fmt.Sprintf("distill-%s-%d", sessionID, len(hist)), resp.Text(), map[string]string{
 "session_id": sessionID,
 "type":       "distillation",
 })
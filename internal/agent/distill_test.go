@@ -18,7 +18,7 @@ Sessions: map[string][]history.Message{
 "s1": make([]history.Message, 6),
 },
 }
-g := &MockGeminiClient{Responses: []string{"summary"}}
+g := &MockLLMClient{Responses: []string{"summary"}}
 m := &MockMemory{}
 a := NewAgent(g, nil, m, nil, h, false)
 
@@ -52,7 +52,7 @@ Sessions: map[string][]history.Message{
 "s1": make([]history.Message, 6),
 },
 }
-g := &MockGeminiClient{GenerateError: errors.New("gemini error")}
+g := &MockLLMClient{GenerateError: errors.New("gemini error")}
 a := NewAgent(g, nil, nil, nil, h, false)
 err := a.Distill(ctx, "s1")
 assert.Error(t, err)
@@ -65,7 +65,7 @@ Sessions: map[string][]history.Message{
 "s1": make([]history.Message, 6),
 },
 }
-g := &MockGeminiClient{Responses: []string{"summary"}}
+g := &MockLLMClient{Responses: []string{"summary"}}
 m := &MockMemory{MemorizeError: errors.New("mem error")}
 a := NewAgent(g, nil, m, nil, h, false)
 err := a.Distill(ctx, "s1")
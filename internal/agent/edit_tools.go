@@ -0,0 +1,246 @@
+package agent
+
+import (
+"fmt"
+"log/slog"
+"strings"
+
+"github.com/LeeroyDing/hyperagent/internal/editor"
+"github.com/LeeroyDing/hyperagent/internal/llm"
+)
+
+// dryRunSchema declares the dry_run parameter of the editing tools.
+var dryRunSchema = &llm.Schema{Type: llm.TypeBoolean, Description: "Only show the change as a diff without writing it (optional)"}
+
+// editTools declares the tools that write files.
+var editTools = []llm.Tool{
+{
+Name:        "write_file",
+Description: "Write a file, creating it and its parent directories if needed",
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"path":    {Type: llm.TypeString, Description: "Path to the file"},
+"content": {Type: llm.TypeString, Description: "Content to write"},
+"mode":    {Type: llm.TypeString, Description: "overwrite (default) replaces the file, create fails if it exists, append adds to its end"},
+"dry_run": dryRunSchema,
+},
+Required: []string{"path", "content"},
+},
+},
+{
+Name:        "insert_lines",
+Description: "Insert lines into a file before the given line",
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"path":    {Type: llm.TypeString, Description: "Path to the file"},
+"line":    {Type: llm.TypeInteger, Description: "Line to insert before (1-indexed, one past the last line appends)"},
+"text":    {Type: llm.TypeString, Description: "Lines to insert"},
+"dry_run": dryRunSchema,
+},
+Required: []string{"path", "line", "text"},
+},
+},
+{
+Name:        "delete_lines",
+Description: "Delete a range of lines from a file",
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"path":    {Type: llm.TypeString, Description: "Path to the file"},
+"start":   {Type: llm.TypeInteger, Description: "First line to delete (1-indexed)"},
+"end":     {Type: llm.TypeInteger, Description: "Last line to delete (optional, default start)"},
+"dry_run": dryRunSchema,
+},
+Required: []string{"path", "start"},
+},
+},
+{
+Name:        "apply_patch",
+Description: "Apply a unified diff that may change, create and delete several files. Hunks are found even if the line numbers are off; either every hunk applies or nothing is written, and the response reports each hunk.",
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"patch":   {Type: llm.TypeString, Description: "The unified diff, with --- and +++ file headers and @@ hunks"},
+"dry_run": dryRunSchema,
+},
+Required: []string{"patch"},
+},
+},
+}
+
+// undoEditTool declares the tool that reverts recorded edits.
+var undoEditTool = llm.Tool{
+Name:        "undo_edit",
+Description: "Undo an edit made with the file editing tools in this session, restoring the files as they were before it. Edits must be undone newest first when they changed the same file.",
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"id": {Type: llm.TypeString, Description: "ID of the edit (optional, default the latest edit not undone yet)"},
+},
+},
+}
+
+// editor returns the file editor confined to the session's workspace.
+func (a *Agent) editor(sessionID string) *editor.FileEditor {
+if a.Workspace == nil {
+return a.Editor
+}
+return a.Editor.Confined(a.Workspace(sessionID))
+}
+
+// dryRun reports whether a tool call only previews its change.
+func (a *Agent) dryRun(args map[string]interface{}) bool {
+d, _ := args["dry_run"].(bool)
+return a.DryRun || d
+}
+
+// intArg returns an integer argument of a tool call, or def without one.
+func intArg(args map[string]interface{}, name string, def int) int {
+if v, ok := args[name].(float64); ok {
+return int(v)
+}
+return def
+}
+
+// writeFile handles write_file.
+func (a *Agent) writeFile(sessionID string, args map[string]interface{}) (string, error) {
+path, _ := args["path"].(string)
+content, _ := args["content"].(string)
+mode, _ := args["mode"].(string)
+dryRun := a.dryRun(args)
+if !dryRun && !a.confirmAction(fmt.Sprintf("Write file %s", path)) {
+return "Action cancelled by user", nil
+}
+c, err := a.editor(sessionID).WriteFile(path, content, editor.WriteMode(mode), dryRun)
+if err != nil {
+return "", err
+}
+return a.recordEdit(sessionID, "write_file", dryRun, c), nil
+}
+
+// insertLines handles insert_lines.
+func (a *Agent) insertLines(sessionID string, args map[string]interface{}) (string, error) {
+path, _ := args["path"].(string)
+text, _ := args["text"].(string)
+line := intArg(args, "line", 0)
+dryRun := a.dryRun(args)
+if !dryRun && !a.confirmAction(fmt.Sprintf("Insert lines into %s at line %d", path, line)) {
+return "Action cancelled by user", nil
+}
+c, err := a.editor(sessionID).InsertLines(path, line, text, dryRun)
+if err != nil {
+return "", err
+}
+return a.recordEdit(sessionID, "insert_lines", dryRun, c), nil
+}
+
+// deleteLines handles delete_lines.
+func (a *Agent) deleteLines(sessionID string, args map[string]interface{}) (string, error) {
+path, _ := args["path"].(string)
+start := intArg(args, "start", 0)
+end := intArg(args, "end", start)
+dryRun := a.dryRun(args)
+if !dryRun && !a.confirmAction(fmt.Sprintf("Delete lines %d-%d of %s", start, end, path)) {
+return "Action cancelled by user", nil
+}
+c, err := a.editor(sessionID).DeleteLines(path, start, end, dryRun)
+if err != nil {
+return "", err
+}
+return a.recordEdit(sessionID, "delete_lines", dryRun, c), nil
+}
+
+// applyPatch handles apply_patch.
+func (a *Agent) applyPatch(sessionID string, args map[string]interface{}) (string, error) {
+patch, _ := args["patch"].(string)
+dryRun := a.dryRun(args)
+if !dryRun && !a.confirmAction("Apply patch:\n"+patch) {
+return "Action cancelled by user", nil
+}
+res, err := a.editor(sessionID).ApplyPatch(patch, dryRun)
+if err != nil {
+return "", err
+}
+return a.recordEdit(sessionID, "apply_patch", dryRun, res.Changes...) + "\n" + res.Report(), nil
+}
+
+// replaceText handles replace_text.
+func (a *Agent) replaceText(sessionID string, args map[string]interface{}) (string, error) {
+path, _ := args["path"].(string)
+old, _ := args["old_text"].(string)
+new, _ := args["new_text"].(string)
+dryRun := a.dryRun(args)
+if !dryRun && !a.confirmAction(fmt.Sprintf("Replace text in %s", path)) {
+return "Action cancelled by user", nil
+}
+c, err := a.editor(sessionID).Replace(path, old, new, dryRun)
+if err != nil {
+return "", err
+}
+out := a.recordEdit(sessionID, "replace_text", dryRun, c)
+if c.Note != "" {
+out += fmt.Sprintf("\nNote: %s.", c.Note)
+}
+return out, nil
+}
+
+// undoEdit handles undo_edit.
+func (a *Agent) undoEdit(sessionID string, args map[string]interface{}) (string, error) {
+if a.Edits == nil {
+return "", fmt.Errorf("edits are not recorded")
+}
+id, _ := args["id"].(string)
+if !a.confirmAction(fmt.Sprintf("Undo edit %s", id)) {
+return "Action cancelled by user", nil
+}
+e, err := a.Edits.Revert(sessionID, id)
+if err != nil {
+return "", err
+}
+var sb strings.Builder
+fmt.Fprintf(&sb, "Undid edit %s (%s):", e.ID, e.Tool)
+for _, f := range e.Files {
+if f.Created {
+fmt.Fprintf(&sb, "\nRemoved %s", f.Path)
+} else {
+fmt.Fprintf(&sb, "\nRestored %s", f.Path)
+}
+}
+return sb.String(), nil
+}
+
+// recordEdit journals changes that were written and describes them.
+func (a *Agent) recordEdit(sessionID, tool string, dryRun bool, changes ...editor.Change) string {
+out := formatChanges(dryRun, changes...)
+if dryRun || a.Edits == nil {
+return out
+}
+e, err := a.Edits.Record(sessionID, tool, changes...)
+if err != nil {
+slog.Warn("Failed to record edit", "session", sessionID, "tool", tool, "error", err)
+return out
+}
+return out + fmt.Sprintf("\nRecorded as edit %s, undo_edit reverts it.", e.ID)
+}
+
+// formatChanges describes edits: their diffs for a dry run, else a line
+// for each file.
+func formatChanges(dryRun bool, changes ...editor.Change) string {
+var sb strings.Builder
+if dryRun {
+sb.WriteString("Dry run, nothing was written.\n")
+for _, c := range changes {
+sb.WriteString(c.Diff)
+}
+return strings.TrimSuffix(sb.String(), "\n")
+}
+for i, c := range changes {
+if i > 0 {
+sb.WriteByte('\n')
+}
+sb.WriteString(c.Summary())
+}
+return sb.String()
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+"context"
+"os"
+"path/filepath"
+"strings"
+"testing"
+
+"github.com/LeeroyDing/hyperagent/internal/editor"
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/stretchr/testify/assert"
+)
+
+func TestAgent_EditTools(t *testing.T) {
+ctx := context.Background()
+a := NewAgent(nil, &MockExecutor{}, nil, nil, nil, false)
+path := filepath.Join(t.TempDir(), "notes.txt")
+call := func(name string, args map[string]interface{}) (string, error) {
+return a.handleToolCall(ctx, "s1", llm.FunctionCall{Name: name, Arguments: args})
+}
+read := func() string {
+content, _ := os.ReadFile(path)
+return string(content)
+}
+
+out, err := call("write_file", map[string]interface{}{"path": path, "content": "a\nb\n", "dry_run": true})
+assert.NoError(t, err)
+assert.Contains(t, out, "Dry run, nothing was written.\n--- /dev/null\n")
+assert.NoFileExists(t, path)
+
+out, err = call("write_file", map[string]interface{}{"path": path, "content": "a\nb\n"})
+assert.NoError(t, err)
+assert.Equal(t, "Created "+path+" (2 lines)", out)
+
+out, err = call("insert_lines", map[string]interface{}{"path": path, "line": 2.0, "text": "x\n"})
+assert.NoError(t, err)
+assert.Equal(t, "Changed "+path+" (+1 -0)", out)
+assert.Equal(t, "a\nx\nb\n", read())
+
+out, err = call("delete_lines", map[string]interface{}{"path": path, "start": 1.0})
+assert.NoError(t, err)
+assert.Equal(t, "Changed "+path+" (+0 -1)", out)
+assert.Equal(t, "x\nb\n", read())
+
+patch := "--- " + path + "\n+++ " + path + "\n@@ -1,2 +1,2 @@\n-x\n+y\n b\n"
+out, err = call("apply_patch", map[string]interface{}{"patch": patch})
+assert.NoError(t, err)
+assert.Equal(t, "Changed "+path+" (+1 -1)\n"+path+": hunk 1 applied at line 1", out)
+assert.Equal(t, "y\nb\n", read())
+
+out, err = call("replace_text", map[string]interface{}{"path": path, "old_text": "  b", "new_text": "b"})
+assert.NoError(t, err)
+assert.Equal(t, "Changed "+path+" (+0 -0)\nNote: old text matched line 2 ignoring indentation.", out)
+
+out, err = call("replace_text", map[string]interface{}{"path": path, "old_text": "y", "new_text": "z", "dry_run": true})
+assert.NoError(t, err)
+assert.Contains(t, out, "Dry run, nothing was written.\n--- "+path+"\n")
+assert.Equal(t, "y\nb\n", read())
+
+// The agent's dry run setting previews every edit
+a.DryRun = true
+_, err = call("delete_lines", map[string]interface{}{"path": path, "start": 1.0, "end": 2.0})
+assert.NoError(t, err)
+assert.Equal(t, "y\nb\n", read())
+
+out, err = call("replace_text", map[string]interface{}{"path": path, "old_text": "  b", "new_text": "c"})
+assert.NoError(t, err)
+assert.Contains(t, out, "-b\n+c")
+assert.True(t, strings.HasSuffix(out, "\nNote: old text matched line 2 ignoring indentation."))
+assert.Equal(t, "y\nb\n", read())
+
+var names []string
+for _, tool := range a.getTools() {
+names = append(names, tool.Name)
+}
+assert.Subset(t, names, []string{"write_file", "insert_lines", "delete_lines", "apply_patch", "replace_text"})
+assert.NotContains(t, names, "undo_edit")
+}
+
+func TestAgent_UndoEdit(t *testing.T) {
+ctx := context.Background()
+a := NewAgent(nil, &MockExecutor{}, nil, nil, nil, false)
+a.Edits = editor.NewJournal(t.TempDir())
+path := filepath.Join(t.TempDir(), "notes.txt")
+call := func(name string, args map[string]interface{}) (string, error) {
+return a.handleToolCall(ctx, "s1", llm.FunctionCall{Name: name, Arguments: args})
+}
+assert.NoError(t, os.WriteFile(path, []byte("a\n"), 0644))
+
+out, err := call("replace_text", map[string]interface{}{"path": path, "old_text": "a", "new_text": "b"})
+assert.NoError(t, err)
+assert.Equal(t, "Changed "+path+" (+1 -1)\nRecorded as edit 1, undo_edit reverts it.", out)
+
+out, err = call("write_file", map[string]interface{}{"path": path, "content": "c\n", "mode": "append"})
+assert.NoError(t, err)
+assert.Equal(t, "Changed "+path+" (+1 -0)\nRecorded as edit 2, undo_edit reverts it.", out)
+
+out, err = call("undo_edit", map[string]interface{}{})
+assert.NoError(t, err)
+assert.Equal(t, "Undid edit 2 (write_file):\nRestored "+path, out)
+
+out, err = call("undo_edit", map[string]interface{}{"id": "1"})
+assert.NoError(t, err)
+assert.Equal(t, "Undid edit 1 (replace_text):\nRestored "+path, out)
+content, _ := os.ReadFile(path)
+assert.Equal(t, "a\n", string(content))
+
+_, err = call("undo_edit", map[string]interface{}{})
+assert.ErrorContains(t, err, "there are no edits to undo")
+
+var names []string
+for _, tool := range a.getTools() {
+names = append(names, tool.Name)
+}
+assert.Contains(t, names, "undo_edit")
+}
+
+func TestAgent_Workspace(t *testing.T) {
+ctx := context.Background()
+a := NewAgent(nil, &MockExecutor{}, nil, nil, nil, false)
+roots := map[string]string{"s1": t.TempDir(), "s2": t.TempDir()}
+a.Workspace = func(sessionID string) *editor.Workspace {
+return &editor.Workspace{Roots: []string{roots[sessionID]}}
+}
+
+out, err := a.handleToolCall(ctx, "s1", llm.FunctionCall{Name: "write_file", Arguments: map[string]interface{}{"path": "a.txt", "content": "x\n"}})
+assert.NoError(t, err)
+assert.Equal(t, "Created "+filepath.Join(roots["s1"], "a.txt")+" (1 lines)", out)
+
+_, err = a.handleToolCall(ctx, "s2", llm.FunctionCall{Name: "read_file", Arguments: map[string]interface{}{"path": filepath.Join(roots["s1"], "a.txt"), "start": 1.0}})
+assert.ErrorContains(t, err, "access denied: "+filepath.Join(roots["s1"], "a.txt")+" is outside the workspace "+roots["s2"])
+
+_, err = a.handleToolCall(ctx, "s1", llm.FunctionCall{Name: "replace_text", Arguments: map[string]interface{}{"path": ".env", "old_text": "a", "new_text": "b"}})
+assert.ErrorContains(t, err, `access denied: .env matches the deny pattern ".env"`)
+}
@@ -0,0 +1,50 @@
+package agent
+
+import (
+"context"
+
+"github.com/LeeroyDing/hyperagent/internal/history"
+"github.com/LeeroyDing/hyperagent/internal/llm"
+)
+
+// EventType identifies a step of a run reported by RunStream.
+type EventType string
+
+const (
+// EventTextDelta carries a piece of the model's reply.
+EventTextDelta EventType = "text_delta"
+// EventToolCall is sent before a tool starts running.
+EventToolCall EventType = "tool_call"
+// EventToolResult is sent when a tool has finished.
+EventToolResult EventType = "tool_result"
+// EventFinal carries the complete reply and ends the stream.
+EventFinal EventType = "final"
+)
+
+// Event is a single progress update of a run.
+type Event struct {
+Type       EventType           `json:"type"`
+Text       string              `json:"text,omitempty"`
+ToolCall   *llm.FunctionCall   `json:"tool_call,omitempty"`
+ToolResult *history.ToolResult `json:"tool_result,omitempty"`
+StopReason string              `json:"stop_reason,omitempty"`
+}
+
+// generate asks the model for its next turn. With a non-nil emit, text is
+// reported as it arrives when the backend supports streaming, and in one
+// piece otherwise.
+func (a *Agent) generate(ctx context.Context, messages []llm.Message, tools []llm.Tool, emit func(Event)) (*llm.Response, error) {
+if emit == nil {
+return a.LLM.GenerateContent(ctx, messages, tools)
+}
+if sc, ok := a.LLM.(llm.StreamClient); ok {
+return sc.GenerateContentStream(ctx, messages, tools, func(text string) {
+emit(Event{Type: EventTextDelta, Text: text})
+})
+}
+resp, err := a.LLM.GenerateContent(ctx, messages, tools)
+if err == nil && resp.Text() != "" {
+emit(Event{Type: EventTextDelta, Text: resp.Text()})
+}
+return resp, err
+}
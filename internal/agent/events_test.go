@@ -0,0 +1,65 @@
+package agent
+
+import (
+"context"
+"testing"
+
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/stretchr/testify/assert"
+)
+
+// streamingLLM adds GenerateContentStream to MockLLMClient, delivering replies in small chunks.
+type streamingLLM struct {
+MockLLMClient
+}
+
+func (m *streamingLLM) GenerateContentStream(ctx context.Context, messages []llm.Message, tools []llm.Tool, onText func(string)) (*llm.Response, error) {
+resp, err := m.GenerateContent(ctx, messages, tools)
+if err != nil {
+return nil, err
+}
+text := resp.Text()
+for i := 0; i < len(text); i += 4 {
+onText(text[i:min(i+4, len(text))])
+}
+return resp, nil
+}
+
+func TestAgent_RunStream(t *testing.T) {
+ctx := context.Background()
+calls := [][]llm.FunctionCall{{{ID: "c1", Name: "execute_command", Arguments: map[string]interface{}{"command": "ls"}}}}
+
+t.Run("streaming backend", func(t *testing.T) {
+g := &streamingLLM{MockLLMClient{Responses: []string{"", "All files listed"}, ToolCalls: calls}}
+a := NewAgent(g, &MockExecutor{}, &MockMemory{}, nil, &MockHistory{}, false)
+
+var events []Event
+resp, err := a.RunStream(ctx, "s1", "list", func(ev Event) { events = append(events, ev) })
+assert.NoError(t, err)
+assert.Equal(t, "All files listed", resp)
+
+assert.Equal(t, EventToolCall, events[0].Type)
+assert.Equal(t, "c1", events[0].ToolCall.ID)
+assert.Equal(t, EventToolResult, events[1].Type)
+assert.Contains(t, events[1].ToolResult.Output, "Mock output for: ls")
+
+var text string
+for _, ev := range events[2 : len(events)-1] {
+assert.Equal(t, EventTextDelta, ev.Type)
+text += ev.Text
+}
+assert.Greater(t, len(events), 4)
+assert.Equal(t, "All files listed", text)
+assert.Equal(t, Event{Type: EventFinal, Text: "All files listed"}, events[len(events)-1])
+})
+
+t.Run("non-streaming backend", func(t *testing.T) {
+g := &MockLLMClient{Responses: []string{"hi"}}
+a := NewAgent(g, &MockExecutor{}, &MockMemory{}, nil, &MockHistory{}, false)
+
+var events []Event
+_, err := a.RunStream(ctx, "s1", "hello", func(ev Event) { events = append(events, ev) })
+assert.NoError(t, err)
+assert.Equal(t, []Event{{Type: EventTextDelta, Text: "hi"}, {Type: EventFinal, Text: "hi"}}, events)
+})
+}
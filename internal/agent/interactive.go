@@ -0,0 +1,75 @@
+package agent
+
+import (
+"context"
+"fmt"
+"strings"
+"time"
+
+"github.com/LeeroyDing/hyperagent/internal/executor"
+"github.com/LeeroyDing/hyperagent/internal/llm"
+)
+
+// sendInputTool declares the tool that types into a running command.
+var sendInputTool = llm.Tool{
+Name:        "send_input",
+Description: "Type into the command running in the shell, for example to answer a prompt or to stop it with ctrl-c. The response reports the output since the last call and whether the command still runs.",
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"input": {Type: llm.TypeString, Description: "Text to type (optional)"},
+"key":   {Type: llm.TypeString, Description: "Key to press after the text: " + strings.Join(executor.KeyNames(), ", ") + " (default enter)"},
+"wait":  {Type: llm.TypeInteger, Description: "Seconds to wait for the command to finish or prompt again (optional)"},
+},
+},
+}
+
+// readOutputTool declares the tool that follows a running command.
+var readOutputTool = llm.Tool{
+Name:        "read_output",
+Description: "Read the output of the command running in the shell since the last call, waiting up to the given time for it to finish or prompt for input",
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"wait": {Type: llm.TypeInteger, Description: "Seconds to wait (optional, default 0)"},
+},
+},
+}
+
+// waitArg returns the wait argument of a tool call.
+func waitArg(args map[string]interface{}) time.Duration {
+w, _ := args["wait"].(float64)
+return time.Duration(w * float64(time.Second))
+}
+
+// sendInput handles send_input.
+func (a *Agent) sendInput(ctx context.Context, sessionID string, args map[string]interface{}) (string, error) {
+ie, ok := a.Executor.(executor.Interactive)
+if !ok {
+return "", fmt.Errorf("the executor does not support input to running commands")
+}
+in := executor.Input{Wait: waitArg(args)}
+in.Text, _ = args["input"].(string)
+in.Key, _ = args["key"].(string)
+if !a.confirmAction(fmt.Sprintf("Send input to command: %q", in.Text)) {
+return "Action cancelled by user", nil
+}
+res, err := ie.SendInput(ctx, sessionID, in)
+if err != nil {
+return "", err
+}
+return res.Format(), nil
+}
+
+// readOutput handles read_output.
+func (a *Agent) readOutput(ctx context.Context, sessionID string, args map[string]interface{}) (string, error) {
+ie, ok := a.Executor.(executor.Interactive)
+if !ok {
+return "", fmt.Errorf("the executor does not support running commands in the background")
+}
+res, err := ie.ReadOutput(ctx, sessionID, waitArg(args))
+if err != nil {
+return "", err
+}
+return res.Format(), nil
+}
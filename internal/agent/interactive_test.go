@@ -0,0 +1,60 @@
+package agent
+
+import (
+"context"
+"testing"
+"time"
+
+"github.com/LeeroyDing/hyperagent/internal/executor"
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/stretchr/testify/assert"
+)
+
+// interactiveExecutor records the input and reads of running commands.
+type interactiveExecutor struct {
+MockExecutor
+Inputs []executor.Input
+Waits  []time.Duration
+}
+
+func (e *interactiveExecutor) SendInput(ctx context.Context, sessionID string, in executor.Input) (executor.Result, error) {
+e.Inputs = append(e.Inputs, in)
+return executor.Result{Output: "answer=" + in.Text, ExitCode: 0}, nil
+}
+
+func (e *interactiveExecutor) ReadOutput(ctx context.Context, sessionID string, wait time.Duration) (executor.Result, error) {
+e.Waits = append(e.Waits, wait)
+return executor.Result{Output: "tick", ExitCode: -1, Running: true}, nil
+}
+
+func TestAgent_InteractiveTools(t *testing.T) {
+ctx := context.Background()
+
+names := func(a *Agent) []string {
+var names []string
+for _, tool := range a.getTools() {
+names = append(names, tool.Name)
+}
+return names
+}
+assert.NotContains(t, names(NewAgent(nil, &MockExecutor{}, nil, nil, nil, false)), "send_input")
+
+e := &interactiveExecutor{}
+a := NewAgent(nil, e, nil, nil, nil, false)
+assert.Contains(t, names(a), "send_input")
+assert.Contains(t, names(a), "read_output")
+
+out, err := a.handleToolCall(ctx, "s1", llm.FunctionCall{Name: "send_input", Arguments: map[string]interface{}{"input": "y", "key": "enter", "wait": 2.0}})
+assert.NoError(t, err)
+assert.Equal(t, []executor.Input{{Text: "y", Key: "enter", Wait: 2 * time.Second}}, e.Inputs)
+assert.Equal(t, "Exit code: 0\nDuration: 0s\nOutput:\nanswer=y", out)
+
+out, err = a.handleToolCall(ctx, "s1", llm.FunctionCall{Name: "read_output", Arguments: map[string]interface{}{}})
+assert.NoError(t, err)
+assert.Equal(t, []time.Duration{0}, e.Waits)
+assert.Contains(t, out, "Exit code: none (still running)")
+assert.Contains(t, out, "Follow it with read_output")
+
+_, err = NewAgent(nil, &MockExecutor{}, nil, nil, nil, false).handleToolCall(ctx, "s1", llm.FunctionCall{Name: "read_output", Arguments: map[string]interface{}{}})
+assert.Error(t, err)
+}
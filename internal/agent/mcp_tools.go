@@ -0,0 +1,81 @@
+package agent
+
+import (
+"context"
+"encoding/json"
+"fmt"
+"sort"
+
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/LeeroyDing/hyperagent/internal/mcp"
+)
+
+// mcpTools translates every discovered MCP tool into a tool declaration.
+func (a *Agent) mcpTools() []llm.Tool {
+tools := a.MCP.ListTools()
+names := make([]string, 0, len(tools))
+for name := range tools {
+names = append(names, name)
+}
+sort.Strings(names)
+
+var decls []llm.Tool
+for _, name := range names {
+rt := tools[name]
+params := llm.SchemaFromJSON(map[string]interface{}{
+"type":       rt.Tool.InputSchema.Type,
+"properties": rt.Tool.InputSchema.Properties,
+"required":   toInterfaceSlice(rt.Tool.InputSchema.Required),
+})
+if len(rt.Tool.RawInputSchema) > 0 {
+var raw map[string]interface{}
+if err := json.Unmarshal(rt.Tool.RawInputSchema, &raw); err == nil {
+params = llm.SchemaFromJSON(raw)
+}
+}
+if params.Type != llm.TypeObject {
+params = &llm.Schema{Type: llm.TypeObject}
+}
+
+description := rt.Tool.Description
+if description == "" {
+description = fmt.Sprintf("Tool %s provided by MCP server %s", rt.Tool.Name, rt.Server)
+}
+
+decls = append(decls, llm.Tool{
+Name:        name,
+Description: description,
+Parameters:  params,
+})
+}
+return decls
+}
+
+func toInterfaceSlice(in []string) []interface{} {
+out := make([]interface{}, len(in))
+for i, s := range in {
+out[i] = s
+}
+return out
+}
+
+// handleMCPToolCall dispatches a namespaced tool call to the MCP server that owns it.
+func (a *Agent) handleMCPToolCall(ctx context.Context, rt mcp.RemoteTool, tc llm.FunctionCall) (string, error) {
+if !a.confirmAction(fmt.Sprintf("Call MCP tool %s on server %s", rt.Tool.Name, rt.Server)) {
+return "Action cancelled by user", nil
+}
+
+res, err := a.MCP.CallTool(ctx, rt.Server, rt.Tool.Name, tc.Arguments)
+if err != nil {
+return "", fmt.Errorf("MCP tool %s failed: %w", tc.Name, err)
+}
+
+output := mcp.FormatResult(res)
+if res.IsError {
+if output == "" {
+output = "tool reported an error"
+}
+return "", fmt.Errorf("MCP tool %s returned an error: %s", tc.Name, output)
+}
+return output, nil
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+"context"
+"testing"
+
+"github.com/LeeroyDing/hyperagent/internal/llm"
+hmcp "github.com/LeeroyDing/hyperagent/internal/mcp"
+"github.com/mark3labs/mcp-go/client"
+"github.com/mark3labs/mcp-go/mcp"
+"github.com/mark3labs/mcp-go/server"
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+func newTestMCPManager(t *testing.T) *hmcp.MCPManager {
+srv := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false))
+srv.AddTool(mcp.NewTool("echo",
+mcp.WithDescription("Echo a message"),
+mcp.WithString("message", mcp.Required(), mcp.Description("Message to echo")),
+mcp.WithReadOnlyHintAnnotation(true),
+), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+return mcp.NewToolResultText("echo: " + req.GetString("message", "")), nil
+})
+srv.AddTool(mcp.NewTool("fail"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+return mcp.NewToolResultError("boom"), nil
+})
+srv.AddTool(mcp.NewTool("screenshot"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+return mcp.NewToolResultImage("captured", "aGVsbG8=", "image/png"), nil
+})
+
+c, err := client.NewInProcessClient(srv)
+require.NoError(t, err)
+ctx := context.Background()
+require.NoError(t, c.Start(ctx))
+
+mgr := hmcp.NewMCPManager()
+require.NoError(t, mgr.AddClient(ctx, "files", c))
+return mgr
+}
+
+func TestAgent_MCPTools(t *testing.T) {
+ctx := context.Background()
+mgr := newTestMCPManager(t)
+a := NewAgent(nil, nil, nil, mgr, nil, false)
+
+t.Run("declared with namespaced names", func(t *testing.T) {
+var echo *llm.Tool
+for _, d := range a.getTools() {
+if d.Name == "files__echo" {
+echo = &d
+}
+}
+require.NotNil(t, echo)
+assert.Equal(t, "Echo a message", echo.Description)
+assert.Equal(t, llm.TypeObject, echo.Parameters.Type)
+assert.Equal(t, llm.TypeString, echo.Parameters.Properties["message"].Type)
+assert.Equal(t, []string{"message"}, echo.Parameters.Required)
+})
+
+t.Run("text result", func(t *testing.T) {
+tc := llm.FunctionCall{Name: "files__echo", Arguments: map[string]interface{}{"message": "hi"}}
+resp, err := a.handleToolCall(ctx, "s1", tc)
+assert.NoError(t, err)
+assert.Equal(t, "echo: hi", resp)
+})
+
+t.Run("image result", func(t *testing.T) {
+tc := llm.FunctionCall{Name: "files__screenshot"}
+resp, err := a.handleToolCall(ctx, "s1", tc)
+assert.NoError(t, err)
+assert.Contains(t, resp, "captured")
+assert.Contains(t, resp, "[image: image/png")
+})
+
+t.Run("error result", func(t *testing.T) {
+tc := llm.FunctionCall{Name: "files__fail"}
+_, err := a.handleToolCall(ctx, "s1", tc)
+assert.Error(t, err)
+assert.Contains(t, err.Error(), "boom")
+})
+}
@@ -3,49 +3,65 @@ package agent
 import (
 "context"
 
-"github.com/LeeroyDing/hyperagent/internal/gemini"
+"github.com/LeeroyDing/hyperagent/internal/executor"
 "github.com/LeeroyDing/hyperagent/internal/history"
-"github.com/google/generative-ai-go/genai"
+"github.com/LeeroyDing/hyperagent/internal/llm"
 "github.com/philippgille/chromem-go"
 )
 
-type MockGeminiClient struct {
+type MockLLMClient struct {
 Responses             []string
-ToolCalls             [][]gemini.ToolCall
+ToolCalls             [][]llm.FunctionCall
 ResponseIndex         int
 GenerateError         error
 SendToolResponseError error
+// Requests records the conversation passed to each GenerateContent call.
+Requests [][]llm.Message
 }
 
-func (m *MockGeminiClient) GenerateContent(ctx context.Context, messages []gemini.Message, tools []*genai.Tool) (string, []gemini.ToolCall, error) {
-if m.GenerateError != nil { return "", nil, m.GenerateError }
-if m.ResponseIndex >= len(m.Responses) { return "Mock response", nil, nil }
+func (m *MockLLMClient) next(fallback string) *llm.Response {
+if m.ResponseIndex >= len(m.Responses) { return mockResponse(fallback, nil) }
 response := m.Responses[m.ResponseIndex]
-var toolCalls []gemini.ToolCall
+var toolCalls []llm.FunctionCall
 if m.ResponseIndex < len(m.ToolCalls) { toolCalls = m.ToolCalls[m.ResponseIndex] }
 m.ResponseIndex++
-return response, toolCalls, nil
+return mockResponse(response, toolCalls)
 }
 
-func (m *MockGeminiClient) SendToolResponse(ctx context.Context, messages []gemini.Message, tools []*genai.Tool, toolResponses []gemini.ToolResponse) (string, []gemini.ToolCall, error) {
-if m.SendToolResponseError != nil { return "", nil, m.SendToolResponseError }
-if m.ResponseIndex >= len(m.Responses) { return "Mock tool response", nil, nil }
-response := m.Responses[m.ResponseIndex]
-var toolCalls []gemini.ToolCall
-if m.ResponseIndex < len(m.ToolCalls) { toolCalls = m.ToolCalls[m.ResponseIndex] }
-m.ResponseIndex++
-return response, toolCalls, nil
+func mockResponse(text string, toolCalls []llm.FunctionCall) *llm.Response {
+msg := llm.Message{Role: llm.RoleModel}
+if text != "" { msg.Parts = append(msg.Parts, llm.Part{Text: text}) }
+for i := range toolCalls { msg.Parts = append(msg.Parts, llm.Part{FunctionCall: &toolCalls[i]}) }
+return &llm.Response{Message: msg}
+}
+
+func (m *MockLLMClient) GenerateContent(ctx context.Context, messages []llm.Message, tools []llm.Tool) (*llm.Response, error) {
+m.Requests = append(m.Requests, append([]llm.Message(nil), messages...))
+if len(messages) > 0 && messages[len(messages)-1].Role == llm.RoleTool {
+if m.SendToolResponseError != nil { return nil, m.SendToolResponseError }
+return m.next("Mock tool response"), nil
+}
+if m.GenerateError != nil { return nil, m.GenerateError }
+return m.next("Mock response"), nil
 }
 
-func (m *MockGeminiClient) EmbedContent(ctx context.Context, text string) ([]float32, error) { return []float32{0.1}, nil }
-func (m *MockGeminiClient) Close() error { return nil }
+func (m *MockLLMClient) EmbedContent(ctx context.Context, text string) ([]float32, error) { return []float32{0.1}, nil }
+func (m *MockLLMClient) Close() error { return nil }
 
 type MockExecutor struct { ExecutedCommands []string }
-func (m *MockExecutor) Execute(sessionID, command string) (string, error) {
-m.ExecutedCommands = append(m.ExecutedCommands, command)
-return "Mock output for: " + command, nil
+func (m *MockExecutor) Execute(ctx context.Context, sessionID string, cmd executor.Command) (executor.Result, error) {
+m.ExecutedCommands = append(m.ExecutedCommands, cmd.Line)
+return executor.Result{Output: "Mock output for: " + cmd.Line}, nil
 }
 
+// dirExecutor reports a fixed working directory for every session.
+type dirExecutor struct {
+MockExecutor
+dir string
+}
+
+func (d *dirExecutor) SessionDir(sessionID string) string { return d.dir }
+
 type MockMemory struct {
 Memorized     map[string]string
 RecallResults []chromem.Result
@@ -77,6 +93,7 @@ func (m *MockMemory) List(ctx context.Context) ([]chromem.Document, error) { ret
 
 type MockHistory struct {
 Sessions  map[string][]history.Message
+Profiles  map[string]string
 LoadError error
 }
 
@@ -93,6 +110,12 @@ h.Sessions[sessionID] = append(h.Sessions[sessionID], history.Message{Role: role
 return nil
 }
 
+func (h *MockHistory) AppendMessage(sessionID string, msg history.Message) error {
+if h.Sessions == nil { h.Sessions = make(map[string][]history.Message) }
+h.Sessions[sessionID] = append(h.Sessions[sessionID], msg)
+return nil
+}
+
 func (h *MockHistory) LoadHistory(sessionID string) ([]history.Message, error) {
 if h.LoadError != nil { return nil, h.LoadError }
 if h.Sessions == nil { return []history.Message{}, nil }
@@ -102,3 +125,19 @@ return h.Sessions[sessionID], nil
 func (h *MockHistory) ListSessions() ([]history.Session, error) { return []history.Session{}, nil }
 func (h *MockHistory) SetSessionName(sessionID, name string) error { return nil }
 func (h *MockHistory) GetSessionName(sessionID string) string { return "Mock Session" }
+
+func (h *MockHistory) SetSessionProfile(sessionID, profile string) error {
+if h.Profiles == nil { h.Profiles = make(map[string]string) }
+h.Profiles[sessionID] = profile
+return nil
+}
+
+func (h *MockHistory) GetSessionProfile(sessionID string) string { return h.Profiles[sessionID] }
+
+func (h *MockHistory) SetSessionSandbox(sessionID, sandbox string) error { return nil }
+
+func (h *MockHistory) GetSessionSandbox(sessionID string) string { return "" }
+
+func (h *MockHistory) SetSessionWorkdir(sessionID, dir string) error { return nil }
+
+func (h *MockHistory) GetSessionWorkdir(sessionID string) string { return "" }
@@ -0,0 +1,96 @@
+package agent
+
+import (
+"context"
+"errors"
+"fmt"
+"log/slog"
+"strings"
+
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/LeeroyDing/hyperagent/internal/output"
+)
+
+// readArtifactTool declares the tool that pages through spilled tool results.
+var readArtifactTool = llm.Tool{
+Name:        "read_artifact",
+Description: "Read lines of the full output of an earlier tool call that was truncated",
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"id":    {Type: llm.TypeString, Description: "Artifact ID from the truncation notice"},
+"start": {Type: llm.TypeInteger, Description: "Start line (1-indexed, default 1)"},
+"lines": {Type: llm.TypeInteger, Description: "Number of lines to read (optional)"},
+},
+Required: []string{"id"},
+},
+}
+
+// callTool runs a tool call and keeps its result, or its error, within the
+// output limits.
+func (a *Agent) callTool(ctx context.Context, sessionID string, tc llm.FunctionCall) (string, error) {
+out, err := a.handleToolCall(ctx, sessionID, tc)
+if tc.Name == readArtifactTool.Name {
+// Pages are already sized to the limits
+return out, err
+}
+if err != nil {
+if msg := a.limitOutput(sessionID, tc.Name, err.Error()); msg != err.Error() {
+return "", errors.New(msg)
+}
+return "", err
+}
+return a.limitOutput(sessionID, tc.Name, out), nil
+}
+
+// limitOutput truncates text that exceeds the output limits and saves the
+// full text as an artifact the model can read with read_artifact.
+func (a *Agent) limitOutput(sessionID, tool, text string) string {
+short, truncated := output.Truncate(text, a.OutputLimits, a.countTokens())
+if !truncated {
+return text
+}
+
+notice := fmt.Sprintf("[output truncated: %d lines, %d bytes in total]", strings.Count(text, "\n")+1, len(text))
+if a.Artifacts != nil {
+id, err := a.Artifacts.Save(sessionID, tool, text)
+if err != nil {
+slog.Warn("Failed to save truncated output", "session", sessionID, "tool", tool, "error", err)
+} else {
+notice = fmt.Sprintf("[output truncated: %d lines, %d bytes in total. The full output is saved as artifact %s; call read_artifact to page through it.]", strings.Count(text, "\n")+1, len(text), id)
+}
+}
+return short + "\n" + notice
+}
+
+// readArtifact handles read_artifact.
+func (a *Agent) readArtifact(sessionID string, args map[string]interface{}) (string, error) {
+if a.Artifacts == nil {
+return "", fmt.Errorf("no artifact store is configured")
+}
+id, _ := args["id"].(string)
+start := 1
+if s, ok := args["start"].(float64); ok {
+start = int(s)
+}
+count := a.OutputLimits.WithDefaults().MaxLines
+if n, ok := args["lines"].(float64); ok && int(n) > 0 && int(n) < count {
+count = int(n)
+}
+
+page, err := a.Artifacts.ReadLines(sessionID, id, start, count)
+if err != nil {
+return "", err
+}
+text, _ := output.Truncate(strings.Join(page.Lines, "\n"), a.OutputLimits, a.countTokens())
+return fmt.Sprintf("Lines %d-%d of %d of artifact %s:\n%s", page.Start, page.Start+len(page.Lines)-1, page.Total, id, text), nil
+}
+
+// countTokens returns the token counter used for the output limits, if any.
+// It counts locally, as truncating a result may count it several times.
+func (a *Agent) countTokens() output.CountFunc {
+if a.TokenMgr == nil {
+return nil
+}
+return a.TokenMgr.EstimateTokens
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+"context"
+"fmt"
+"os"
+"path/filepath"
+"regexp"
+"strings"
+"testing"
+
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/LeeroyDing/hyperagent/internal/output"
+"github.com/LeeroyDing/hyperagent/internal/token"
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+func TestAgent_OutputLimits(t *testing.T) {
+ctx := context.Background()
+
+var lines []string
+for i := 1; i <= 500; i++ {
+lines = append(lines, fmt.Sprintf("log line %d", i))
+}
+path := filepath.Join(t.TempDir(), "big.log")
+require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644))
+readBig := llm.FunctionCall{Name: "read_file", Arguments: map[string]interface{}{"path": path, "start": 1.0}}
+
+t.Run("Truncated and spilled", func(t *testing.T) {
+a := NewAgent(nil, nil, nil, nil, nil, false)
+a.OutputLimits = output.Limits{MaxLines: 21}
+a.Artifacts = output.NewStore(t.TempDir())
+
+out, err := a.callTool(ctx, "s1", readBig)
+require.NoError(t, err)
+assert.True(t, strings.HasPrefix(out, "log line 1\n"))
+assert.Contains(t, out, "\n[... 480 lines omitted ...]\n")
+assert.Contains(t, out, "log line 500\n[output truncated: 500 lines")
+
+id := regexp.MustCompile(`artifact (\S+);`).FindStringSubmatch(out)
+require.Len(t, id, 2)
+
+page, err := a.callTool(ctx, "s1", llm.FunctionCall{Name: "read_artifact", Arguments: map[string]interface{}{"id": id[1], "start": 250.0, "lines": 2.0}})
+require.NoError(t, err)
+assert.Equal(t, fmt.Sprintf("Lines 250-251 of 500 of artifact %s:\nlog line 250\nlog line 251", id[1]), page)
+
+// Pages are capped by the same limits
+page, err = a.callTool(ctx, "s1", llm.FunctionCall{Name: "read_artifact", Arguments: map[string]interface{}{"id": id[1]}})
+require.NoError(t, err)
+assert.True(t, strings.HasPrefix(page, "Lines 1-21 of 500"))
+})
+
+t.Run("Without store", func(t *testing.T) {
+a := NewAgent(nil, nil, nil, nil, nil, false)
+a.OutputLimits = output.Limits{MaxLines: 21}
+
+out, err := a.callTool(ctx, "s1", readBig)
+require.NoError(t, err)
+assert.True(t, strings.HasSuffix(out, fmt.Sprintf("[output truncated: 500 lines, %d bytes in total]", len(strings.Join(lines, "\n")))))
+for _, tool := range a.getTools() {
+assert.NotEqual(t, "read_artifact", tool.Name)
+}
+
+_, err = a.handleToolCall(ctx, "s1", llm.FunctionCall{Name: "read_artifact", Arguments: map[string]interface{}{"id": "x"}})
+assert.EqualError(t, err, "no artifact store is configured")
+})
+
+t.Run("Token limit counted locally", func(t *testing.T) {
+remote := &remoteCounter{}
+a := NewAgent(nil, nil, nil, nil, nil, false)
+a.TokenMgr = token.NewTokenManagerWithFallback(remote, token.EstimateCounter{})
+a.OutputLimits = output.Limits{MaxTokens: 100}
+
+out, err := a.callTool(ctx, "s1", readBig)
+require.NoError(t, err)
+assert.Contains(t, out, "[output truncated: 500 lines")
+assert.Less(t, len(out), 4*150)
+assert.Zero(t, remote.calls)
+})
+
+t.Run("Small output untouched", func(t *testing.T) {
+a := NewAgent(nil, &MockExecutor{}, nil, nil, nil, false)
+a.Artifacts = output.NewStore(t.TempDir())
+out, err := a.callTool(ctx, "s1", llm.FunctionCall{Name: "execute_command", Arguments: map[string]interface{}{"command": "ls"}})
+require.NoError(t, err)
+assert.Equal(t, "Exit code: 0\nDuration: 0s\nOutput:\nMock output for: ls", out)
+})
+}
+
+// remoteCounter stands in for a token counting API.
+type remoteCounter struct {
+calls int
+}
+
+func (c *remoteCounter) CountTokens(ctx context.Context, text string) (int, error) {
+c.calls++
+return len(text), nil
+}
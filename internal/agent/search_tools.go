@@ -0,0 +1,169 @@
+package agent
+
+import (
+"fmt"
+"strconv"
+"strings"
+
+"github.com/LeeroyDing/hyperagent/internal/editor"
+"github.com/LeeroyDing/hyperagent/internal/llm"
+)
+
+// searchTools declares the tools that explore files without a shell.
+var searchTools = []llm.Tool{
+{
+Name:        "list_dir",
+Description: "List the files and directories in a directory, skipping what .gitignore excludes",
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"path":  {Type: llm.TypeString, Description: "Directory to list (default the working directory)"},
+"depth": {Type: llm.TypeInteger, Description: "Levels of subdirectories to list (default 1)"},
+"limit": {Type: llm.TypeInteger, Description: "Maximum number of entries (default 500)"},
+},
+},
+},
+{
+Name:        "glob",
+Description: "Find files and directories whose path matches a glob pattern, skipping what .gitignore excludes",
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"pattern": {Type: llm.TypeString, Description: "Glob relative to path, where ** matches any number of directories, e.g. **/*_test.go"},
+"path":    {Type: llm.TypeString, Description: "Directory to search (default the working directory)"},
+"limit":   {Type: llm.TypeInteger, Description: "Maximum number of results (default 500)"},
+},
+Required: []string{"pattern"},
+},
+},
+{
+Name:        "search_files",
+Description: "Search the lines of files for a regular expression, skipping binary files and what .gitignore excludes. Results are printed as path:line:text, with context lines as path-line-text.",
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"pattern":     {Type: llm.TypeString, Description: "Regular expression (RE2 syntax)"},
+"path":        {Type: llm.TypeString, Description: "File or directory to search (default the working directory)"},
+"include":     {Type: llm.TypeArray, Items: &llm.Schema{Type: llm.TypeString}, Description: "Globs of the files to search, e.g. *.go (optional)"},
+"exclude":     {Type: llm.TypeArray, Items: &llm.Schema{Type: llm.TypeString}, Description: "Globs of the files and directories to skip (optional)"},
+"ignore_case": {Type: llm.TypeBoolean, Description: "Match case-insensitively (optional)"},
+"context":     {Type: llm.TypeInteger, Description: "Lines to show before and after each match (optional, up to 10)"},
+"limit":       {Type: llm.TypeInteger, Description: "Maximum number of matches (default 100)"},
+},
+Required: []string{"pattern"},
+},
+},
+}
+
+// stringsArg returns a list argument of a tool call. A single string is
+// taken as a list of one.
+func stringsArg(args map[string]interface{}, name string) []string {
+switch v := args[name].(type) {
+case string:
+if v != "" {
+return []string{v}
+}
+case []interface{}:
+var res []string
+for _, s := range v {
+if s, ok := s.(string); ok {
+res = append(res, s)
+}
+}
+return res
+}
+return nil
+}
+
+// formatEntries lists entries one per line, directories with a slash.
+func formatEntries(entries []editor.Entry, withSize bool) string {
+var sb strings.Builder
+for _, en := range entries {
+sb.WriteString(en.Path)
+if en.Dir {
+sb.WriteString("/")
+} else if withSize {
+fmt.Fprintf(&sb, " (%d bytes)", en.Size)
+}
+sb.WriteByte('\n')
+}
+return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// listDir handles list_dir.
+func (a *Agent) listDir(sessionID string, args map[string]interface{}) (string, error) {
+path, _ := args["path"].(string)
+entries, truncated, err := a.editor(sessionID).ListDir(path, intArg(args, "depth", 1), intArg(args, "limit", 0))
+if err != nil {
+return "", err
+}
+if len(entries) == 0 {
+return "The directory is empty", nil
+}
+out := formatEntries(entries, true)
+if truncated {
+out += fmt.Sprintf("\n[listing stopped after %d entries; list a subdirectory or raise limit]", len(entries))
+}
+return out, nil
+}
+
+// glob handles glob.
+func (a *Agent) glob(sessionID string, args map[string]interface{}) (string, error) {
+pattern, _ := args["pattern"].(string)
+path, _ := args["path"].(string)
+entries, truncated, err := a.editor(sessionID).Glob(path, pattern, intArg(args, "limit", 0))
+if err != nil {
+return "", err
+}
+if len(entries) == 0 {
+return fmt.Sprintf("No files match %s", pattern), nil
+}
+out := formatEntries(entries, false)
+if truncated {
+out += fmt.Sprintf("\n[stopped after %d results; narrow the pattern or raise limit]", len(entries))
+}
+return out, nil
+}
+
+// searchFiles handles search_files.
+func (a *Agent) searchFiles(sessionID string, args map[string]interface{}) (string, error) {
+opts := editor.SearchOptions{
+Include: stringsArg(args, "include"),
+Exclude: stringsArg(args, "exclude"),
+Context: intArg(args, "context", 0),
+Limit:   intArg(args, "limit", 0),
+}
+opts.Pattern, _ = args["pattern"].(string)
+opts.Path, _ = args["path"].(string)
+opts.IgnoreCase, _ = args["ignore_case"].(bool)
+matches, truncated, err := a.editor(sessionID).Search(opts)
+if err != nil {
+return "", err
+}
+if len(matches) == 0 {
+return "No matches found", nil
+}
+
+var sb strings.Builder
+// end is the last line printed of the file printed last
+prevPath, end := "", 0
+for _, m := range matches {
+first := m.Line - len(m.Before)
+if sb.Len() > 0 && (m.Path != prevPath || first > end+1) {
+sb.WriteString("--\n")
+}
+for i, l := range m.Before {
+sb.WriteString(m.Path + "-" + strconv.Itoa(first+i) + "-" + l + "\n")
+}
+sb.WriteString(m.Path + ":" + strconv.Itoa(m.Line) + ":" + m.Text + "\n")
+for i, l := range m.After {
+sb.WriteString(m.Path + "-" + strconv.Itoa(m.Line+1+i) + "-" + l + "\n")
+}
+prevPath, end = m.Path, m.Line+len(m.After)
+}
+out := strings.TrimSuffix(sb.String(), "\n")
+if truncated {
+out += fmt.Sprintf("\n[stopped after %d matches; narrow the search or raise limit]", len(matches))
+}
+return out, nil
+}
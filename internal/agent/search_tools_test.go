@@ -0,0 +1,45 @@
+package agent
+
+import (
+"context"
+"os"
+"path/filepath"
+"testing"
+
+"github.com/LeeroyDing/hyperagent/internal/editor"
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+func TestAgent_SearchTools(t *testing.T) {
+ctx := context.Background()
+root := t.TempDir()
+require.NoError(t, os.MkdirAll(filepath.Join(root, "pkg"), 0755))
+require.NoError(t, os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644))
+require.NoError(t, os.WriteFile(filepath.Join(root, "pkg", "a.go"), []byte("package pkg\n// one\n// two\n// three\n// four\nfunc A() {}\n"), 0644))
+a := NewAgent(nil, &MockExecutor{}, nil, nil, nil, false)
+a.Workspace = func(string) *editor.Workspace { return &editor.Workspace{Roots: []string{root}} }
+call := func(name string, args map[string]interface{}) string {
+out, err := a.handleToolCall(ctx, "s1", llm.FunctionCall{Name: name, Arguments: args})
+require.NoError(t, err)
+return out
+}
+
+assert.Equal(t, "main.go (29 bytes)\npkg/", call("list_dir", map[string]interface{}{}))
+assert.Equal(t, "pkg/a.go (55 bytes)", call("list_dir", map[string]interface{}{"path": "pkg"}))
+assert.Equal(t, "main.go\npkg/a.go", call("glob", map[string]interface{}{"pattern": "**/*.go"}))
+assert.Equal(t, "No files match *.md", call("glob", map[string]interface{}{"pattern": "*.md"}))
+
+out := call("search_files", map[string]interface{}{"pattern": "^func", "include": []interface{}{"*.go"}, "context": 1.0})
+assert.Equal(t, "main.go-2-\nmain.go:3:func main() {}\n--\npkg/a.go-5-// four\npkg/a.go:6:func A() {}", out)
+
+out = call("search_files", map[string]interface{}{"pattern": "one|two", "path": "pkg", "context": 1.0})
+assert.Equal(t, "pkg/a.go-1-package pkg\npkg/a.go:2:// one\npkg/a.go:3:// two\npkg/a.go-4-// three", out)
+
+out = call("search_files", map[string]interface{}{"pattern": "//", "limit": 1.0})
+assert.Equal(t, "pkg/a.go:2:// one\n[stopped after 1 matches; narrow the search or raise limit]", out)
+assert.Equal(t, "No matches found", call("search_files", map[string]interface{}{"pattern": "missing"}))
+
+assert.True(t, a.isReadOnly("search_files"))
+}
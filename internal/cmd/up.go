@@ -9,16 +9,23 @@ import (
 "path/filepath"
 "syscall"
 
-"github.com/spf13/cobra"
 "github.com/LeeroyDing/hyperagent/internal/agent"
 "github.com/LeeroyDing/hyperagent/internal/config"
 "github.com/LeeroyDing/hyperagent/internal/daemon"
+"github.com/LeeroyDing/hyperagent/internal/editor"
 "github.com/LeeroyDing/hyperagent/internal/executor"
 "github.com/LeeroyDing/hyperagent/internal/gemini"
 "github.com/LeeroyDing/hyperagent/internal/history"
-"github.com/LeeroyDing/hyperagent/internal/memory"
+"github.com/LeeroyDing/hyperagent/internal/llm"
 "github.com/LeeroyDing/hyperagent/internal/mcp"
+"github.com/LeeroyDing/hyperagent/internal/memory"
+"github.com/LeeroyDing/hyperagent/internal/openai"
+"github.com/LeeroyDing/hyperagent/internal/orchestrator"
+"github.com/LeeroyDing/hyperagent/internal/output"
+"github.com/LeeroyDing/hyperagent/internal/prompt"
+"github.com/LeeroyDing/hyperagent/internal/token"
 "github.com/LeeroyDing/hyperagent/internal/web"
+"github.com/spf13/cobra"
 )
 
 var daemonize bool
@@ -93,13 +100,22 @@ os.Exit(1)
 }
 
 ctx := context.Background()
-gClient, err := gemini.NewClient(ctx, cfg.GeminiAPIKey, cfg.Model)
+gClient, err := newModelClient(ctx, cfg)
 if err != nil {
-slog.Error("Failed to initialize Gemini client", "error", err)
+slog.Error("Failed to initialize model client", "provider", cfg.Provider, "error", err)
 os.Exit(1)
 }
 
-executor := executor.NewShellExecutor(cfg.CommandAllowlist)
+shell := executor.NewShellExecutor(cfg.CommandAllowlist)
+shell.Timeouts = cfg.CommandTimeouts
+shell.Manager.Limits = cfg.ShellSessions
+if cfg.ShellPrompts != nil {
+// Prompts were validated by LoadConfig
+shell.Prompts, _ = executor.CompilePrompts(cfg.ShellPrompts)
+}
+if cfg.CommandPolicy != nil {
+shell.Policy = cfg.CommandPolicy
+}
 mem, err := memory.NewMemory(ctx, gClient, "")
 if err != nil {
 slog.Error("Failed to initialize memory", "error", err)
@@ -107,15 +123,54 @@ os.Exit(1)
 }
 
 mcpMgr := mcp.NewMCPManager()
+mcpMgr.Start(ctx, cfg.MCPServers)
 historyMgr, err := history.NewHistoryManager(history.GetDefaultHistoryDir())
 if err != nil {
 slog.Error("Failed to initialize history manager", "error", err)
 os.Exit(1)
 }
 
-a := agent.NewAgent(gClient, executor, mem, mcpMgr, historyMgr, cfg.InteractiveMode)
+shellEnv := cfg.ShellEnv.Environ(os.Environ())
+shell.Manager.Config = func(id string) executor.SessionConfig {
+dir := historyMgr.GetSessionWorkdir(id)
+if dir == "" {
+dir = cfg.ShellDir
+}
+return executor.SessionConfig{
+Dir:     dir,
+Env:     shellEnv,
+Sandbox: cfg.SandboxFor(historyMgr.GetSessionSandbox(id)),
+}
+}
+// The manager is configured before it starts reaping shells
+go shell.Manager.Run(ctx)
+
+a := agent.NewAgent(gClient, shell, mem, mcpMgr, historyMgr, cfg.InteractiveMode)
+a.Limits = cfg.Limits
+a.Orchestrator = orchestrator.NewOrchestratorWithOptions(cfg.Parallel)
+a.OutputLimits = cfg.OutputLimits
+a.Artifacts = output.NewStore(output.GetDefaultArtifactDir())
+a.Edits = editor.NewJournal(editor.GetDefaultJournalDir())
+a.Workspace = func(id string) *editor.Workspace {
+dir := shell.Manager.Config(id).Dir
+if dir == "" {
+dir, _ = os.Getwd()
+}
+return &editor.Workspace{Roots: append([]string{dir}, cfg.Workspace.Roots...), Deny: cfg.Workspace.Deny}
+}
+a.TokenMgr = newTokenManager(gClient, cfg.Model)
+a.ContextLimits = cfg.ContextLimitsFor(cfg.Model)
+if cfg.CommandPolicy == nil {
+// A policy replaces the allowlist, so the model is not told about it
+a.CommandAllowlist = cfg.CommandAllowlist
+}
+// Profiles were validated by LoadConfig
+a.Prompts, _ = prompt.NewLibrary(cfg.Profiles, cfg.DefaultProfile)
 
 srv := web.NewServer(a, historyMgr, mem, d)
+srv.Sandboxes = cfg.Sandboxes
+srv.Shells = shell.Manager
+srv.Edits = a.Edits
 
 // Handle cleanup on exit
 c := make(chan os.Signal, 1)
@@ -123,7 +178,10 @@ signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 go func() {
 <-c
 slog.Info("Shutting down...")
-executor.Cleanup()
+shell.Cleanup()
+if err := mcpMgr.Close(); err != nil {
+slog.Warn("Failed to close MCP servers", "error", err)
+}
 d.Unlock()
 os.Exit(0)
 }()
@@ -137,6 +195,29 @@ os.Exit(1)
 },
 }
 
+// newTokenManager counts with the provider's own API when it has one and
+// with the local tiktoken approximation otherwise.
+func newTokenManager(client llm.Client, model string) *token.TokenManager {
+if tc, ok := client.(token.TokenCounter); ok {
+var fallback token.TokenCounter = token.EstimateCounter{}
+if tk, err := token.NewTiktokenCounter(model); err == nil {
+fallback = tk
+}
+return token.NewTokenManagerWithFallback(tc, fallback)
+}
+return token.NewTokenManagerOrEstimate(model)
+}
+
+// newModelClient creates the LLM backend selected by cfg.Provider.
+func newModelClient(ctx context.Context, cfg *config.Config) (llm.Client, error) {
+switch cfg.Provider {
+case config.ProviderOpenAI:
+return openai.NewClient(cfg.BaseURL, cfg.APIKey, cfg.Model, cfg.EmbeddingModel), nil
+default:
+return gemini.NewClient(ctx, cfg.GeminiAPIKey, cfg.Model)
+}
+}
+
 func init() {
 upCmd.Flags().BoolVarP(&daemonize, "daemon", "d", false, "Run in background as a daemon")
 rootCmd.AddCommand(upCmd)
@@ -1,19 +1,99 @@
 package config
 
 import (
+"fmt"
 "os"
 "path/filepath"
 
+"github.com/LeeroyDing/hyperagent/internal/agent"
+"github.com/LeeroyDing/hyperagent/internal/editor"
+"github.com/LeeroyDing/hyperagent/internal/executor"
 "github.com/LeeroyDing/hyperagent/internal/mcp"
+"github.com/LeeroyDing/hyperagent/internal/orchestrator"
+"github.com/LeeroyDing/hyperagent/internal/output"
+"github.com/LeeroyDing/hyperagent/internal/policy"
+"github.com/LeeroyDing/hyperagent/internal/prompt"
+"github.com/LeeroyDing/hyperagent/internal/sandbox"
+"github.com/LeeroyDing/hyperagent/internal/token"
 "gopkg.in/yaml.v3"
 )
 
+// Supported values for Config.Provider.
+const (
+ProviderGemini = "gemini"
+ProviderOpenAI = "openai"
+)
+
 type Config struct {
+// Provider selects the model backend: "gemini" (default) or "openai" for any
+// OpenAI-compatible server such as llama.cpp, vLLM or Ollama.
+Provider         string             `yaml:"provider"`
 Model            string             `yaml:"model"`
 MCPServers       []mcp.ServerConfig `yaml:"mcp_servers"`
 InteractiveMode  bool               `yaml:"interactive_mode"`
 CommandAllowlist []string           `yaml:"command_allowlist"`
+// CommandPolicy replaces CommandAllowlist with allow and deny rules that
+// are checked against every program a command line would run.
+CommandPolicy    *policy.Policy     `yaml:"command_policy,omitempty"`
+// Sandbox isolates the shell sessions that did not choose a named sandbox.
+Sandbox          sandbox.Config     `yaml:"sandbox,omitempty"`
+// Sandboxes are named sandboxes that sessions can choose when created.
+Sandboxes        map[string]sandbox.Config `yaml:"sandboxes,omitempty"`
+// ShellDir is where shells start when their session did not choose a
+// working directory. When empty, the daemon's working directory is used.
+ShellDir         string             `yaml:"shell_dir,omitempty"`
+// ShellEnv filters and extends the environment shells inherit.
+ShellEnv         executor.Environment `yaml:"shell_env,omitempty"`
+// ShellPrompts are the patterns that recognize a command waiting for
+// input. When nil, executor.DefaultPrompts are used.
+ShellPrompts     []string           `yaml:"shell_prompts,omitempty"`
+// ShellSessions bounds how many shells are kept and for how long.
+ShellSessions    executor.SessionLimits `yaml:"shell_sessions,omitempty"`
+// Workspace adds directories the file tools may access besides the
+// session's working directory, and denies files with secrets in them.
+Workspace        editor.Workspace   `yaml:"workspace,omitempty"`
+// CommandTimeouts bounds how long a shell command may run.
+CommandTimeouts  executor.Timeouts  `yaml:"command_timeouts,omitempty"`
 GeminiAPIKey     string             `yaml:"gemini_api_key"`
+// BaseURL is the OpenAI-compatible API root including the /v1 prefix.
+BaseURL          string             `yaml:"base_url,omitempty"`
+APIKey           string             `yaml:"api_key,omitempty"`
+EmbeddingModel   string             `yaml:"embedding_model,omitempty"`
+// Limits bounds the tool loop of a single request.
+Limits           agent.Limits       `yaml:"limits,omitempty"`
+// OutputLimits caps the size of a tool result sent to the model.
+OutputLimits     output.Limits      `yaml:"output_limits,omitempty"`
+// Parallel bounds concurrent execution of read-only tool calls.
+Parallel         orchestrator.Options `yaml:"parallel,omitempty"`
+// ContextLimits overrides the context window per model name.
+ContextLimits    map[string]token.Limits `yaml:"context_limits,omitempty"`
+// Profiles are additional system prompt templates selectable per session.
+Profiles         []prompt.Profile   `yaml:"profiles,omitempty"`
+// DefaultProfile is used by sessions that did not choose a profile.
+DefaultProfile   string             `yaml:"default_profile,omitempty"`
+}
+
+// ContextLimitsFor returns the configured context limits of a model, with
+// unset fields taken from token.DefaultLimits.
+func (c *Config) ContextLimitsFor(model string) token.Limits {
+limits := token.DefaultLimits(model)
+if l, ok := c.ContextLimits[model]; ok {
+if l.ContextWindow > 0 {
+limits.ContextWindow = l.ContextWindow
+}
+if l.ReserveOutput > 0 {
+limits.ReserveOutput = l.ReserveOutput
+}
+}
+return limits
+}
+
+// SandboxFor returns the named sandbox, or Sandbox for an empty or unknown name.
+func (c *Config) SandboxFor(name string) sandbox.Config {
+if sb, ok := c.Sandboxes[name]; ok {
+return sb
+}
+return c.Sandbox
 }
 
 func GetDefaultConfigPath() string {
@@ -39,9 +119,57 @@ if err != nil {
 return nil, err
 }
 
-if cfg.Model == "" {
+if cfg.Provider == "" {
+cfg.Provider = ProviderGemini
+}
+if cfg.Model == "" && cfg.Provider == ProviderGemini {
 cfg.Model = "gemini-3-flash-preview"
 }
 
+switch cfg.Provider {
+case ProviderGemini:
+case ProviderOpenAI:
+if cfg.Model == "" {
+return nil, fmt.Errorf("model is required for provider %q", cfg.Provider)
+}
+// Memory embeds with this model; the server has no default for it
+if cfg.EmbeddingModel == "" {
+return nil, fmt.Errorf("embedding_model is required for provider %q", cfg.Provider)
+}
+default:
+return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+}
+
+if cfg.ShellDir != "" && !filepath.IsAbs(cfg.ShellDir) {
+return nil, fmt.Errorf("shell_dir %q is not absolute", cfg.ShellDir)
+}
+
+if _, err := executor.CompilePrompts(cfg.ShellPrompts); err != nil {
+return nil, fmt.Errorf("invalid shell_prompts: %w", err)
+}
+
+if err := cfg.Workspace.Validate(); err != nil {
+return nil, fmt.Errorf("invalid workspace: %w", err)
+}
+
+if err := cfg.Sandbox.Validate(); err != nil {
+return nil, fmt.Errorf("invalid sandbox: %w", err)
+}
+for name, sb := range cfg.Sandboxes {
+if err := sb.Validate(); err != nil {
+return nil, fmt.Errorf("invalid sandbox %s: %w", name, err)
+}
+}
+
+if cfg.CommandPolicy != nil {
+if err := cfg.CommandPolicy.Validate(); err != nil {
+return nil, fmt.Errorf("invalid command_policy: %w", err)
+}
+}
+
+if _, err := prompt.NewLibrary(cfg.Profiles, cfg.DefaultProfile); err != nil {
+return nil, err
+}
+
 return &cfg, nil
 }
@@ -3,7 +3,11 @@ package config
 import (
 "os"
 "testing"
+"time"
 
+"github.com/LeeroyDing/hyperagent/internal/editor"
+"github.com/LeeroyDing/hyperagent/internal/executor"
+"github.com/LeeroyDing/hyperagent/internal/token"
 "github.com/stretchr/testify/assert"
 )
 
@@ -48,6 +52,200 @@ assert.NoError(t, err)
 assert.Equal(t, "gemini-3-flash-preview", cfg.Model)
 })
 
+t.Run("OpenAIProvider", func(t *testing.T) {
+content := "provider: openai\nmodel: llama3\nbase_url: http://localhost:11434/v1\nembedding_model: nomic-embed-text"
+tmpfile, err := os.CreateTemp("", "config_openai.yaml")
+assert.NoError(t, err)
+defer os.Remove(tmpfile.Name())
+err = os.WriteFile(tmpfile.Name(), []byte(content), 0644)
+assert.NoError(t, err)
+
+cfg, err := LoadConfig(tmpfile.Name())
+assert.NoError(t, err)
+assert.Equal(t, ProviderOpenAI, cfg.Provider)
+assert.Equal(t, "llama3", cfg.Model)
+assert.Equal(t, "http://localhost:11434/v1", cfg.BaseURL)
+})
+
+t.Run("Limits", func(t *testing.T) {
+content := "limits:\n  max_iterations: 5\n  max_duration: 90s\n"
+tmpfile, err := os.CreateTemp("", "config_limits.yaml")
+assert.NoError(t, err)
+defer os.Remove(tmpfile.Name())
+err = os.WriteFile(tmpfile.Name(), []byte(content), 0644)
+assert.NoError(t, err)
+
+cfg, err := LoadConfig(tmpfile.Name())
+assert.NoError(t, err)
+assert.Equal(t, 5, cfg.Limits.MaxIterations)
+assert.Equal(t, 90*time.Second, cfg.Limits.MaxDuration)
+assert.Zero(t, cfg.Limits.MaxToolCalls)
+})
+
+t.Run("Parallel", func(t *testing.T) {
+content := "parallel:\n  max_concurrency: 8\n  task_timeout: 30s\n"
+tmpfile, err := os.CreateTemp("", "config_parallel.yaml")
+assert.NoError(t, err)
+defer os.Remove(tmpfile.Name())
+err = os.WriteFile(tmpfile.Name(), []byte(content), 0644)
+assert.NoError(t, err)
+
+cfg, err := LoadConfig(tmpfile.Name())
+assert.NoError(t, err)
+assert.Equal(t, 8, cfg.Parallel.MaxConcurrency)
+assert.Equal(t, 30*time.Second, cfg.Parallel.TaskTimeout)
+})
+
+t.Run("ContextLimits", func(t *testing.T) {
+content := "context_limits:\n  llama3:\n    context_window: 8192\n"
+tmpfile, err := os.CreateTemp("", "config_context.yaml")
+assert.NoError(t, err)
+defer os.Remove(tmpfile.Name())
+err = os.WriteFile(tmpfile.Name(), []byte(content), 0644)
+assert.NoError(t, err)
+
+cfg, err := LoadConfig(tmpfile.Name())
+assert.NoError(t, err)
+assert.Equal(t, token.Limits{ContextWindow: 8192, ReserveOutput: 4096}, cfg.ContextLimitsFor("llama3"))
+assert.Equal(t, token.DefaultLimits("gemini-3-flash-preview"), cfg.ContextLimitsFor("gemini-3-flash-preview"))
+})
+
+t.Run("Profiles", func(t *testing.T) {
+tmpfile, err := os.CreateTemp("", "config_profiles.yaml")
+assert.NoError(t, err)
+defer os.Remove(tmpfile.Name())
+
+content := "default_profile: reviewer\nprofiles:\n  - name: reviewer\n    description: Reviews code\n    template: Review on {{.OS}}\n"
+err = os.WriteFile(tmpfile.Name(), []byte(content), 0644)
+assert.NoError(t, err)
+cfg, err := LoadConfig(tmpfile.Name())
+assert.NoError(t, err)
+assert.Equal(t, "reviewer", cfg.DefaultProfile)
+assert.Len(t, cfg.Profiles, 1)
+assert.Equal(t, "Reviews code", cfg.Profiles[0].Description)
+
+err = os.WriteFile(tmpfile.Name(), []byte("profiles:\n  - name: bad\n    template: \"{{.OS\"\n"), 0644)
+assert.NoError(t, err)
+_, err = LoadConfig(tmpfile.Name())
+assert.Error(t, err)
+assert.Contains(t, err.Error(), "bad")
+})
+
+t.Run("Sandboxes", func(t *testing.T) {
+tmpfile, err := os.CreateTemp("", "config_sandbox.yaml")
+assert.NoError(t, err)
+defer os.Remove(tmpfile.Name())
+
+content := "sandbox:\n  enabled: true\n  writable_paths: [\"/work\"]\nsandboxes:\n  online:\n    enabled: true\n    network: true\n"
+err = os.WriteFile(tmpfile.Name(), []byte(content), 0644)
+assert.NoError(t, err)
+cfg, err := LoadConfig(tmpfile.Name())
+assert.NoError(t, err)
+assert.Equal(t, []string{"/work"}, cfg.SandboxFor("").WritablePaths)
+assert.False(t, cfg.SandboxFor("").Network)
+assert.True(t, cfg.SandboxFor("online").Network)
+assert.False(t, cfg.SandboxFor("unknown").Network)
+
+err = os.WriteFile(tmpfile.Name(), []byte("sandboxes:\n  bad:\n    writable_paths: [\"work\"]\n"), 0644)
+assert.NoError(t, err)
+_, err = LoadConfig(tmpfile.Name())
+assert.Error(t, err)
+assert.Contains(t, err.Error(), "invalid sandbox bad")
+})
+
+t.Run("ShellEnvironment", func(t *testing.T) {
+tmpfile, err := os.CreateTemp("", "config_shell.yaml")
+assert.NoError(t, err)
+defer os.Remove(tmpfile.Name())
+
+content := "shell_prompts: [\"^> $\"]\nshell_sessions:\n  idle_timeout: 15m\n  max_sessions: -1\nshell_dir: /srv/project\nshell_env:\n  deny: [\"AWS_*\"]\n  set:\n    CI: \"1\"\n"
+err = os.WriteFile(tmpfile.Name(), []byte(content), 0644)
+assert.NoError(t, err)
+cfg, err := LoadConfig(tmpfile.Name())
+assert.NoError(t, err)
+assert.Equal(t, "/srv/project", cfg.ShellDir)
+assert.Equal(t, []string{"^> $"}, cfg.ShellPrompts)
+assert.Equal(t, executor.SessionLimits{IdleTimeout: 15 * time.Minute, MaxSessions: -1}, cfg.ShellSessions)
+assert.Equal(t, []string{"CI=1", "HOME=/root"}, cfg.ShellEnv.Environ([]string{"HOME=/root", "AWS_REGION=eu"}))
+
+err = os.WriteFile(tmpfile.Name(), []byte("shell_dir: project\n"), 0644)
+assert.NoError(t, err)
+_, err = LoadConfig(tmpfile.Name())
+assert.Error(t, err)
+assert.Contains(t, err.Error(), "shell_dir")
+
+err = os.WriteFile(tmpfile.Name(), []byte("shell_prompts: [\"(\"]\n"), 0644)
+assert.NoError(t, err)
+_, err = LoadConfig(tmpfile.Name())
+assert.Error(t, err)
+assert.Contains(t, err.Error(), "invalid shell_prompts")
+})
+
+t.Run("Workspace", func(t *testing.T) {
+tmpfile, err := os.CreateTemp("", "config_workspace.yaml")
+assert.NoError(t, err)
+defer os.Remove(tmpfile.Name())
+
+err = os.WriteFile(tmpfile.Name(), []byte("workspace:\n  roots: [/srv/shared]\n  deny: [\"*.pem\", \"secrets/*\"]\n"), 0644)
+assert.NoError(t, err)
+cfg, err := LoadConfig(tmpfile.Name())
+assert.NoError(t, err)
+assert.Equal(t, editor.Workspace{Roots: []string{"/srv/shared"}, Deny: []string{"*.pem", "secrets/*"}}, cfg.Workspace)
+
+err = os.WriteFile(tmpfile.Name(), []byte("workspace:\n  roots: [shared]\n"), 0644)
+assert.NoError(t, err)
+_, err = LoadConfig(tmpfile.Name())
+assert.ErrorContains(t, err, `invalid workspace: root "shared" is not absolute`)
+
+err = os.WriteFile(tmpfile.Name(), []byte("workspace:\n  deny: [\"[\"]\n"), 0644)
+assert.NoError(t, err)
+_, err = LoadConfig(tmpfile.Name())
+assert.ErrorContains(t, err, `invalid deny pattern "["`)
+})
+
+t.Run("CommandPolicy", func(t *testing.T) {
+tmpfile, err := os.CreateTemp("", "config_policy.yaml")
+assert.NoError(t, err)
+defer os.Remove(tmpfile.Name())
+
+content := "command_policy:\n  rules:\n    - program: git\n      action: allow\n      args: [\"status*\"]\n  write_paths: [\".\"]\n"
+err = os.WriteFile(tmpfile.Name(), []byte(content), 0644)
+assert.NoError(t, err)
+cfg, err := LoadConfig(tmpfile.Name())
+assert.NoError(t, err)
+assert.NoError(t, cfg.CommandPolicy.Check("git status", "/"))
+assert.Error(t, cfg.CommandPolicy.Check("git push", "/"))
+
+err = os.WriteFile(tmpfile.Name(), []byte("command_policy:\n  rules:\n    - program: git\n      action: permit\n"), 0644)
+assert.NoError(t, err)
+_, err = LoadConfig(tmpfile.Name())
+assert.Error(t, err)
+assert.Contains(t, err.Error(), "invalid command_policy")
+})
+
+t.Run("InvalidProvider", func(t *testing.T) {
+tmpfile, err := os.CreateTemp("", "config_provider.yaml")
+assert.NoError(t, err)
+defer os.Remove(tmpfile.Name())
+
+err = os.WriteFile(tmpfile.Name(), []byte("provider: openai"), 0644)
+assert.NoError(t, err)
+_, err = LoadConfig(tmpfile.Name())
+assert.Error(t, err)
+assert.Contains(t, err.Error(), "model is required")
+
+err = os.WriteFile(tmpfile.Name(), []byte("provider: openai\nmodel: llama3"), 0644)
+assert.NoError(t, err)
+_, err = LoadConfig(tmpfile.Name())
+assert.EqualError(t, err, `embedding_model is required for provider "openai"`)
+
+err = os.WriteFile(tmpfile.Name(), []byte("provider: unknown"), 0644)
+assert.NoError(t, err)
+_, err = LoadConfig(tmpfile.Name())
+assert.Error(t, err)
+assert.Contains(t, err.Error(), "unknown provider")
+})
+
 t.Run("FileNotFound", func(t *testing.T) {
 _, err := LoadConfig("non_existent_file.yaml")
 assert.Error(t, err)
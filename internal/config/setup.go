@@ -41,6 +41,7 @@ interactive = false
 }
 
 cfg := &Config{
+Provider:        ProviderGemini,
 GeminiAPIKey:    apiKey,
 Model:           model,
 InteractiveMode: interactive,
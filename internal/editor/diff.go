@@ -0,0 +1,187 @@
+package editor
+
+import (
+"fmt"
+"path/filepath"
+"strings"
+)
+
+// diffContext is the number of unchanged lines around a change in a diff.
+const diffContext = 3
+
+// maxDiffCells bounds the table of the line diff. Larger changes are shown
+// as replacing every line between the common prefix and suffix.
+const maxDiffCells = 4 << 20
+
+// lineOp is one line of a diff: ' ' kept, '-' removed or '+' added. Text
+// includes the line ending, if any.
+type lineOp struct {
+kind byte
+text string
+}
+
+// splitLines splits s into lines that keep their line endings.
+func splitLines(s string) []string {
+lines := strings.SplitAfter(s, "\n")
+if lines[len(lines)-1] == "" {
+lines = lines[:len(lines)-1]
+}
+return lines
+}
+
+// trimEOL returns line without its line ending.
+func trimEOL(line string) string {
+return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+}
+
+// diffLines returns the edit script that turns a into b, with a longest
+// common subsequence of the lines between their common prefix and suffix.
+func diffLines(a, b []string) []lineOp {
+var ops []lineOp
+pre := 0
+for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+ops = append(ops, lineOp{' ', a[pre]})
+pre++
+}
+suf := 0
+for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+suf++
+}
+am, bm := a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+if len(am)*len(bm) > maxDiffCells {
+for _, l := range am {
+ops = append(ops, lineOp{'-', l})
+}
+for _, l := range bm {
+ops = append(ops, lineOp{'+', l})
+}
+} else {
+// lcs[i][j] is the length of the LCS of am[i:] and bm[j:]
+lcs := make([][]int, len(am)+1)
+for i := range lcs {
+lcs[i] = make([]int, len(bm)+1)
+}
+for i := len(am) - 1; i >= 0; i-- {
+for j := len(bm) - 1; j >= 0; j-- {
+if am[i] == bm[j] {
+lcs[i][j] = lcs[i+1][j+1] + 1
+} else {
+lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+}
+}
+}
+i, j := 0, 0
+for i < len(am) || j < len(bm) {
+switch {
+case i < len(am) && j < len(bm) && am[i] == bm[j]:
+ops = append(ops, lineOp{' ', am[i]})
+i++
+j++
+case i < len(am) && (j == len(bm) || lcs[i+1][j] >= lcs[i][j+1]):
+ops = append(ops, lineOp{'-', am[i]})
+i++
+default:
+ops = append(ops, lineOp{'+', bm[j]})
+j++
+}
+}
+}
+
+for _, l := range a[len(a)-suf:] {
+ops = append(ops, lineOp{' ', l})
+}
+return ops
+}
+
+// unifiedDiff renders the change from old to new of the file at path as a
+// unified diff. Created and deleted files are compared with /dev/null, and
+// relative paths get git's a/ and b/ prefixes.
+func unifiedDiff(path, old, new string, created, deleted bool) string {
+ops := diffLines(splitLines(old), splitLines(new))
+
+var sb strings.Builder
+from, to := "a/"+path, "b/"+path
+if filepath.IsAbs(path) {
+from, to = path, path
+}
+if created {
+from = "/dev/null"
+}
+if deleted {
+to = "/dev/null"
+}
+fmt.Fprintf(&sb, "--- %s\n+++ %s\n", from, to)
+
+// oldLine and newLine are the 1-indexed lines before ops[i]
+oldLine, newLine := 1, 1
+for i := 0; i < len(ops); {
+if ops[i].kind == ' ' {
+oldLine++
+newLine++
+i++
+continue
+}
+// A hunk starts diffContext lines before the change and ends once
+// more than twice that many unchanged lines follow
+start := max(0, i-diffContext)
+end := i
+for end < len(ops) {
+if ops[end].kind != ' ' {
+end++
+continue
+}
+run := end
+for run < len(ops) && ops[run].kind == ' ' {
+run++
+}
+if run == len(ops) || run-end > 2*diffContext {
+end = min(run, end+diffContext)
+break
+}
+end = run
+}
+
+hunkOld, hunkNew := oldLine-(i-start), newLine-(i-start)
+var oldCount, newCount int
+for _, op := range ops[start:end] {
+if op.kind != '+' {
+oldCount++
+}
+if op.kind != '-' {
+newCount++
+}
+}
+fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(hunkOld, oldCount), hunkRange(hunkNew, newCount))
+for _, op := range ops[start:end] {
+sb.WriteByte(op.kind)
+sb.WriteString(op.text)
+if !strings.HasSuffix(op.text, "\n") {
+sb.WriteString("\n\\ No newline at end of file\n")
+}
+}
+
+for _, op := range ops[i:end] {
+if op.kind != '+' {
+oldLine++
+}
+if op.kind != '-' {
+newLine++
+}
+}
+i = end
+}
+return sb.String()
+}
+
+// hunkRange formats the start and length of one side of a hunk. An empty
+// range starts at the line before it.
+func hunkRange(start, count int) string {
+if count == 0 {
+start--
+}
+if count == 1 {
+return fmt.Sprint(start)
+}
+return fmt.Sprintf("%d,%d", start, count)
+}
@@ -4,19 +4,44 @@ import (
 "bufio"
 "fmt"
 "os"
+"path/filepath"
 "strings"
 )
 
 // FileEditor provides methods for safe file manipulation.
-type FileEditor struct{}
+type FileEditor struct {
+// Workspace confines the files the editor accesses. When nil, any
+// path may be accessed.
+Workspace *Workspace
+}
 
 // NewFileEditor creates a new FileEditor.
 func NewFileEditor() *FileEditor {
 return &FileEditor{}
 }
 
+// Confined returns a copy of the editor that only accesses files in ws.
+func (e *FileEditor) Confined(ws *Workspace) *FileEditor {
+c := *e
+c.Workspace = ws
+return &c
+}
+
+// resolve checks that path is in the workspace and returns the path to
+// access.
+func (e *FileEditor) resolve(path string) (string, error) {
+if e.Workspace == nil {
+return path, nil
+}
+return e.Workspace.Resolve(path)
+}
+
 // ReadLines reads specific lines from a file (1-indexed).
 func (e *FileEditor) ReadLines(path string, start, end int) ([]string, error) {
+path, err := e.resolve(path)
+if err != nil {
+return nil, err
+}
 file, err := os.Open(path)
 if err != nil {
 return nil, err
@@ -45,21 +70,279 @@ return lines, nil
 
 // Replace replaces oldText with newText in the file.
 // It returns an error if oldText is not found or found multiple times (to be safe).
-func (e *FileEditor) Replace(path string, oldText, newText string) error {
+// When oldText does not occur as is, it is matched line by line ignoring
+// line endings and trailing whitespace, then ignoring indentation; the
+// change notes how it matched. When nothing matches, the error shows the
+// most similar lines, which are not replaced.
+// NewText takes the line endings of the file. With dryRun nothing is
+// written.
+func (e *FileEditor) Replace(path string, oldText, newText string, dryRun bool) (Change, error) {
+path, err := e.resolve(path)
+if err != nil {
+return Change{}, err
+}
 content, err := os.ReadFile(path)
 if err != nil {
-return err
+return Change{}, err
 }
 
 strContent := string(content)
-count := strings.Count(strContent, oldText)
-if count == 0 {
-return fmt.Errorf("old text not found in file")
+if lineEnding(strContent) == "\r\n" {
+newText = strings.ReplaceAll(strings.ReplaceAll(newText, "\r\n", "\n"), "\n", "\r\n")
+}
+count := 0
+if oldText != "" {
+count = strings.Count(strContent, oldText)
 }
 if count > 1 {
-return fmt.Errorf("old text found multiple times (%d), please be more specific", count)
+return Change{}, fmt.Errorf("old text found multiple times (%d), please be more specific", count)
+}
+if count == 1 {
+return e.commit(path, strContent, strings.Replace(strContent, oldText, newText, 1), dryRun)
+}
+
+newContent, note, err := replaceTiered(strContent, oldText, newText)
+if err != nil {
+return Change{}, err
+}
+c, err := e.commit(path, strContent, newContent, dryRun)
+c.Note = note
+return c, err
+}
+
+// Change describes an edit of one file.
+type Change struct {
+Path    string
+Created bool
+Deleted bool
+// Diff is the edit as a unified diff
+Diff    string
+Added   int
+Removed int
+// Note tells how the edit found the text it replaced, when it was not
+// found as is
+Note string
+
+// before and after are the content of the file around the edit, mode its
+// permissions before it
+before, after string
+mode          os.FileMode
+}
+
+// Summary describes the change in one line.
+func (c Change) Summary() string {
+switch {
+case c.Created:
+return fmt.Sprintf("Created %s (%d lines)", c.Path, c.Added)
+case c.Deleted:
+return fmt.Sprintf("Deleted %s", c.Path)
+default:
+return fmt.Sprintf("Changed %s (+%d -%d)", c.Path, c.Added, c.Removed)
+}
 }
 
-newContent := strings.Replace(strContent, oldText, newText, 1)
-return os.WriteFile(path, []byte(newContent), 0644)
+// newChange describes the edit from old to new.
+func newChange(path, old, new string, created, deleted bool) Change {
+c := Change{Path: path, Created: created, Deleted: deleted, Diff: unifiedDiff(path, old, new, created, deleted), before: old, after: new, mode: 0644}
+if info, err := os.Stat(path); err == nil {
+c.mode = info.Mode().Perm()
+}
+for _, op := range diffLines(splitLines(old), splitLines(new)) {
+switch op.kind {
+case '+':
+c.Added++
+case '-':
+c.Removed++
+}
+}
+return c
+}
+
+// WriteMode selects how WriteFile treats an existing file.
+type WriteMode string
+
+const (
+// WriteOverwrite creates the file or replaces its content.
+WriteOverwrite WriteMode = "overwrite"
+// WriteCreate creates the file and fails if it exists.
+WriteCreate WriteMode = "create"
+// WriteAppend adds to the end of the file, creating it if needed.
+WriteAppend WriteMode = "append"
+)
+
+// WriteFile writes content to the file at path, creating missing parent
+// directories. With dryRun nothing is written.
+func (e *FileEditor) WriteFile(path, content string, mode WriteMode, dryRun bool) (Change, error) {
+if mode == "" {
+mode = WriteOverwrite
+}
+path, err := e.resolve(path)
+if err != nil {
+return Change{}, err
+}
+old, err := os.ReadFile(path)
+exists := err == nil
+if err != nil && !os.IsNotExist(err) {
+return Change{}, err
+}
+
+switch mode {
+case WriteOverwrite:
+case WriteCreate:
+if exists {
+return Change{}, fmt.Errorf("%s already exists", path)
+}
+case WriteAppend:
+if len(old) > 0 && old[len(old)-1] != '\n' {
+// Start on a new line
+content = lineEnding(string(old)) + content
+}
+content = string(old) + content
+default:
+return Change{}, fmt.Errorf("unknown write mode %q, use %s, %s or %s", mode, WriteOverwrite, WriteCreate, WriteAppend)
+}
+
+c := newChange(path, string(old), content, !exists, false)
+if dryRun {
+return c, nil
+}
+if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+return Change{}, err
+}
+return c, writeFile(path, content)
+}
+
+// InsertLines inserts text before line (1-indexed) of the file at path.
+// Line may be one past the last line to append. The inserted lines use the
+// line endings of the file. With dryRun nothing is written.
+func (e *FileEditor) InsertLines(path string, line int, text string, dryRun bool) (Change, error) {
+path, err := e.resolve(path)
+if err != nil {
+return Change{}, err
+}
+content, err := os.ReadFile(path)
+if err != nil {
+return Change{}, err
+}
+old := string(content)
+lines := splitLines(old)
+if line < 1 || line > len(lines)+1 {
+return Change{}, fmt.Errorf("line %d is out of range, the file has %d lines", line, len(lines))
+}
+
+eol := lineEnding(old)
+var inserted []string
+for _, l := range splitLines(text) {
+inserted = append(inserted, trimEOL(l)+eol)
+}
+if len(inserted) == 0 {
+return Change{}, fmt.Errorf("no text to insert")
+}
+updated := append(append(append([]string{}, lines[:line-1]...), inserted...), lines[line-1:]...)
+if line > len(lines) && !strings.HasSuffix(text, "\n") {
+// Appended text keeps the file's lack of a final newline
+updated[len(updated)-1] = trimEOL(updated[len(updated)-1])
+}
+return e.commit(path, old, joinLines(updated, eol), dryRun)
+}
+
+// DeleteLines deletes lines start to end (1-indexed, inclusive) of the file
+// at path. With dryRun nothing is written.
+func (e *FileEditor) DeleteLines(path string, start, end int, dryRun bool) (Change, error) {
+path, err := e.resolve(path)
+if err != nil {
+return Change{}, err
+}
+content, err := os.ReadFile(path)
+if err != nil {
+return Change{}, err
+}
+old := string(content)
+lines := splitLines(old)
+if start < 1 || end < start || end > len(lines) {
+return Change{}, fmt.Errorf("lines %d-%d are out of range, the file has %d lines", start, end, len(lines))
+}
+updated := append(append([]string{}, lines[:start-1]...), lines[end:]...)
+if end == len(lines) && len(updated) > 0 && !strings.HasSuffix(old, "\n") {
+updated[len(updated)-1] = trimEOL(updated[len(updated)-1])
+}
+return e.commit(path, old, strings.Join(updated, ""), dryRun)
+}
+
+// commit writes the new content of an existing file unless dryRun is set.
+func (e *FileEditor) commit(path, old, new string, dryRun bool) (Change, error) {
+c := newChange(path, old, new, false, false)
+if dryRun {
+return c, nil
+}
+return c, writeFile(path, new)
+}
+
+// writeFile replaces the content of the file at path, keeping its
+// permissions if it exists.
+func writeFile(path, content string) error {
+return writeFileMode(path, content, 0644)
+}
+
+// writeFileMode writes content to a temporary file next to the file at
+// path and renames it over the file, so that the file is never left half
+// written. An existing file keeps its permissions and, where possible, its
+// owner, else perm is used. A symlink keeps pointing at the file it names.
+func writeFileMode(path, content string, perm os.FileMode) error {
+if target, err := filepath.EvalSymlinks(path); err == nil {
+path = target
+}
+info, err := os.Stat(path)
+if err == nil {
+perm = info.Mode().Perm()
+}
+
+tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+if err != nil {
+return err
+}
+defer os.Remove(tmp.Name())
+if _, err := tmp.WriteString(content); err != nil {
+tmp.Close()
+return err
+}
+if err := tmp.Chmod(perm); err != nil {
+tmp.Close()
+return err
+}
+if info != nil && !sameOwner(tmp, info) {
+// Renaming would hand the file to us, so write it in place
+tmp.Close()
+return os.WriteFile(path, []byte(content), perm)
+}
+if err := tmp.Sync(); err != nil {
+tmp.Close()
+return err
+}
+if err := tmp.Close(); err != nil {
+return err
+}
+return os.Rename(tmp.Name(), path)
+}
+
+// lineEnding returns the line ending of the first line of content, "\n"
+// when it has none.
+func lineEnding(content string) string {
+if i := strings.IndexByte(content, '\n'); i > 0 && content[i-1] == '\r' {
+return "\r\n"
+}
+return "\n"
+}
+
+// joinLines joins lines, ending every line but the last with eol if it
+// lacks a line ending.
+func joinLines(lines []string, eol string) string {
+var sb strings.Builder
+for i, l := range lines {
+sb.WriteString(l)
+if i < len(lines)-1 && !strings.HasSuffix(l, "\n") {
+sb.WriteString(eol)
+}
+}
+return sb.String()
 }
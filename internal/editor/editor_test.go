@@ -2,6 +2,7 @@ package editor
 
 import (
 "os"
+"path/filepath"
 "testing"
 
 "github.com/stretchr/testify/assert"
@@ -48,27 +49,126 @@ assert.NoError(t, err)
 tmpfile.Close()
 
 t.Run("Success", func(t *testing.T) {
-err := editor.Replace(tmpfile.Name(), "World", "Go")
+_, err := editor.Replace(tmpfile.Name(), "World", "Go", false)
 assert.NoError(t, err)
 newContent, _ := os.ReadFile(tmpfile.Name())
 assert.Contains(t, string(newContent), "Hello Go")
 })
 
 t.Run("NotFound", func(t *testing.T) {
-err := editor.Replace(tmpfile.Name(), "Missing", "New")
+_, err := editor.Replace(tmpfile.Name(), "Missing", "New", false)
 assert.Error(t, err)
 assert.Contains(t, err.Error(), "not found")
 })
 
 t.Run("MultipleFound", func(t *testing.T) {
 os.WriteFile(tmpfile.Name(), []byte("test test test"), 0644)
-err := editor.Replace(tmpfile.Name(), "test", "check")
+_, err := editor.Replace(tmpfile.Name(), "test", "check", false)
 assert.Error(t, err)
 assert.Contains(t, err.Error(), "multiple times")
 })
 
 t.Run("FileNotFound", func(t *testing.T) {
-err := editor.Replace("nonexistent", "a", "b")
+_, err := editor.Replace("nonexistent", "a", "b", false)
 assert.Error(t, err)
 })
 }
+
+func TestFileEditor_WriteFile(t *testing.T) {
+editor := NewFileEditor()
+path := filepath.Join(t.TempDir(), "sub", "file.txt")
+
+t.Run("Create", func(t *testing.T) {
+c, err := editor.WriteFile(path, "a\nb\n", WriteCreate, false)
+assert.NoError(t, err)
+assert.True(t, c.Created)
+assert.Equal(t, "Created "+path+" (2 lines)", c.Summary())
+assert.Contains(t, c.Diff, "--- /dev/null\n+++ "+path+"\n@@ -0,0 +1,2 @@\n+a\n+b\n")
+content, _ := os.ReadFile(path)
+assert.Equal(t, "a\nb\n", string(content))
+
+_, err = editor.WriteFile(path, "c\n", WriteCreate, false)
+assert.ErrorContains(t, err, "already exists")
+})
+
+t.Run("Append", func(t *testing.T) {
+os.WriteFile(path, []byte("a\r\nb"), 0644)
+os.Chmod(path, 0600)
+c, err := editor.WriteFile(path, "c", WriteAppend, false)
+assert.NoError(t, err)
+assert.Equal(t, "Changed "+path+" (+2 -1)", c.Summary())
+content, _ := os.ReadFile(path)
+assert.Equal(t, "a\r\nb\r\nc", string(content))
+info, _ := os.Stat(path)
+assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+})
+
+t.Run("Dry Run", func(t *testing.T) {
+c, err := editor.WriteFile(path, "new\n", WriteOverwrite, true)
+assert.NoError(t, err)
+assert.Contains(t, c.Diff, "+new\n")
+content, _ := os.ReadFile(path)
+assert.Equal(t, "a\r\nb\r\nc", string(content))
+})
+
+t.Run("Unknown Mode", func(t *testing.T) {
+_, err := editor.WriteFile(path, "x", "prepend", false)
+assert.ErrorContains(t, err, `unknown write mode "prepend"`)
+})
+}
+
+func TestFileEditor_InsertDeleteLines(t *testing.T) {
+editor := NewFileEditor()
+path := filepath.Join(t.TempDir(), "file.txt")
+os.WriteFile(path, []byte("one\r\ntwo\r\nthree"), 0644)
+read := func() string {
+content, _ := os.ReadFile(path)
+return string(content)
+}
+
+c, err := editor.InsertLines(path, 2, "1.5\n1.75\n", false)
+assert.NoError(t, err)
+assert.Equal(t, 2, c.Added)
+assert.Equal(t, "one\r\n1.5\r\n1.75\r\ntwo\r\nthree", read())
+
+_, err = editor.InsertLines(path, 6, "four", false)
+assert.NoError(t, err)
+assert.Equal(t, "one\r\n1.5\r\n1.75\r\ntwo\r\nthree\r\nfour", read())
+
+_, err = editor.InsertLines(path, 8, "x", false)
+assert.ErrorContains(t, err, "line 8 is out of range, the file has 6 lines")
+
+c, err = editor.DeleteLines(path, 2, 3, false)
+assert.NoError(t, err)
+assert.Equal(t, 2, c.Removed)
+assert.Equal(t, "one\r\ntwo\r\nthree\r\nfour", read())
+
+c, err = editor.DeleteLines(path, 4, 4, true)
+assert.NoError(t, err)
+assert.Contains(t, c.Diff, "-four\n\\ No newline at end of file\n")
+assert.Equal(t, "one\r\ntwo\r\nthree\r\nfour", read())
+
+_, err = editor.DeleteLines(path, 3, 5, false)
+assert.ErrorContains(t, err, "lines 3-5 are out of range")
+}
+
+func TestFileEditor_AtomicWrite(t *testing.T) {
+editor := NewFileEditor()
+dir := t.TempDir()
+target := filepath.Join(dir, "target.txt")
+link := filepath.Join(dir, "link.txt")
+assert.NoError(t, os.WriteFile(target, []byte("a\r\nb\r\n"), 0755))
+assert.NoError(t, os.Symlink(target, link))
+
+_, err := editor.Replace(link, "b", "b\nc", false)
+assert.NoError(t, err)
+
+content, _ := os.ReadFile(target)
+assert.Equal(t, "a\r\nb\r\nc\r\n", string(content))
+info, _ := os.Lstat(link)
+assert.Equal(t, os.ModeSymlink, info.Mode()&os.ModeSymlink)
+info, _ = os.Stat(target)
+assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+entries, _ := os.ReadDir(dir)
+assert.Len(t, entries, 2, "no temporary files are left")
+}
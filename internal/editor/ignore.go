@@ -0,0 +1,148 @@
+package editor
+
+import (
+"bufio"
+"os"
+"path"
+"path/filepath"
+"strings"
+)
+
+// matchGlob reports whether the slash-separated name matches pattern, in
+// which "**" matches any number of directories.
+func matchGlob(pattern, name string) bool {
+return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pat, segs []string) bool {
+for len(pat) > 0 {
+if pat[0] == "**" {
+pat = pat[1:]
+if len(pat) == 0 {
+return true
+}
+for i := range len(segs) + 1 {
+if matchSegments(pat, segs[i:]) {
+return true
+}
+}
+return false
+}
+if len(segs) == 0 {
+return false
+}
+if ok, _ := path.Match(pat[0], segs[0]); !ok {
+return false
+}
+pat, segs = pat[1:], segs[1:]
+}
+return len(segs) == 0
+}
+
+// matchName matches a pattern without a slash against the last element of
+// the slash-separated rel and one with a slash against all of it.
+func matchName(pattern, rel string) bool {
+if strings.Contains(pattern, "/") {
+return matchGlob(strings.TrimPrefix(pattern, "/"), rel)
+}
+ok, _ := path.Match(pattern, path.Base(rel))
+return ok
+}
+
+// ignoreRule is one pattern of a .gitignore file.
+type ignoreRule struct {
+// dir is the directory of the .gitignore file
+dir      string
+pattern  string
+negate   bool
+dirOnly  bool
+anchored bool
+}
+
+// ignorer decides which files the .gitignore files of a tree exclude.
+type ignorer struct {
+rules []ignoreRule
+}
+
+// newIgnorer returns an ignorer for the tree at root that knows the
+// .gitignore files of the repository root contains, up to root.
+func newIgnorer(root string) *ignorer {
+ig := &ignorer{}
+var dirs []string
+for dir := root; ; dir = filepath.Dir(dir) {
+dirs = append(dirs, dir)
+if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+break
+}
+if filepath.Dir(dir) == dir {
+// Not in a repository, only root's own file counts
+dirs = dirs[:1]
+break
+}
+}
+for i := len(dirs) - 1; i >= 0; i-- {
+ig.load(dirs[i])
+}
+return ig
+}
+
+// load adds the rules of the .gitignore file in dir, if any.
+func (ig *ignorer) load(dir string) {
+f, err := os.Open(filepath.Join(dir, ".gitignore"))
+if err != nil {
+return
+}
+defer f.Close()
+scanner := bufio.NewScanner(f)
+for scanner.Scan() {
+line := strings.TrimRight(scanner.Text(), " \t\r")
+if line == "" || strings.HasPrefix(line, "#") {
+continue
+}
+r := ignoreRule{dir: dir}
+if strings.HasPrefix(line, "!") {
+r.negate = true
+line = line[1:]
+}
+line = strings.TrimPrefix(line, `\`)
+if strings.HasSuffix(line, "/") {
+r.dirOnly = true
+line = strings.TrimSuffix(line, "/")
+}
+if strings.Contains(line, "/") {
+r.anchored = true
+line = strings.TrimPrefix(line, "/")
+}
+if line == "" {
+continue
+}
+r.pattern = line
+ig.rules = append(ig.rules, r)
+}
+}
+
+// ignored reports whether the file or directory at path is ignored. The
+// last matching rule decides, so that negated rules can re-include files.
+func (ig *ignorer) ignored(p string, isDir bool) bool {
+ignored := false
+for _, r := range ig.rules {
+if r.dirOnly && !isDir {
+continue
+}
+rel, err := filepath.Rel(r.dir, p)
+rel = filepath.ToSlash(rel)
+if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+continue
+}
+var match bool
+if r.anchored {
+match = matchGlob(r.pattern, rel)
+} else {
+match, _ = path.Match(r.pattern, path.Base(rel))
+}
+if match {
+ignored = !r.negate
+}
+}
+return ignored
+}
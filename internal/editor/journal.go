@@ -0,0 +1,221 @@
+package editor
+
+import (
+"crypto/sha256"
+"encoding/hex"
+"encoding/json"
+"errors"
+"fmt"
+"os"
+"path/filepath"
+"regexp"
+"strconv"
+"sync"
+"time"
+)
+
+// validSession matches session IDs that are safe to use as directory names.
+var validSession = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ErrEditNotFound is returned for an edit that is not in the journal.
+var ErrEditNotFound = errors.New("edit not found")
+
+// ErrEditConflict is returned when an edit cannot be undone because it was
+// undone already or its files changed since.
+var ErrEditConflict = errors.New("edit cannot be undone")
+
+// GetDefaultJournalDir returns the directory for change journals if none is configured.
+func GetDefaultJournalDir() string {
+home, _ := os.UserHomeDir()
+return filepath.Join(home, ".hyperagent", "edits")
+}
+
+// Edit is one recorded edit, of one or more files.
+type Edit struct {
+// ID numbers the edits of a session from 1
+ID       string     `json:"id"`
+Time     time.Time  `json:"time"`
+Tool     string     `json:"tool"`
+Files    []EditFile `json:"files"`
+Reverted bool       `json:"reverted"`
+}
+
+// EditFile is the part of an edit that changed one file.
+type EditFile struct {
+Path    string      `json:"path"`
+Created bool        `json:"created"`
+Deleted bool        `json:"deleted"`
+Mode    os.FileMode `json:"mode"`
+Diff    string      `json:"diff"`
+// After is the SHA-256 of the content the edit left
+After string `json:"after"`
+}
+
+// Journal records the edits of each session with the content the files had
+// before, so that edits can be undone. Each session has a directory with
+// an index of its edits and a backup file for every file it changed.
+type Journal struct {
+Dir string
+mu  sync.Mutex
+}
+
+// NewJournal creates a Journal rooted at dir, or at GetDefaultJournalDir when empty.
+func NewJournal(dir string) *Journal {
+if dir == "" {
+dir = GetDefaultJournalDir()
+}
+return &Journal{Dir: dir}
+}
+
+func (j *Journal) sessionDir(sessionID string) (string, error) {
+if !validSession.MatchString(sessionID) {
+return "", fmt.Errorf("invalid session ID %q", sessionID)
+}
+return filepath.Join(j.Dir, sessionID), nil
+}
+
+// backupPath is where the content of file n of an edit is kept.
+func backupPath(dir, id string, n int) string {
+return filepath.Join(dir, fmt.Sprintf("%s-%d.orig", id, n))
+}
+
+func (j *Journal) load(dir string) ([]Edit, error) {
+data, err := os.ReadFile(filepath.Join(dir, "edits.json"))
+if os.IsNotExist(err) {
+return nil, nil
+}
+if err != nil {
+return nil, fmt.Errorf("failed to read the change journal: %w", err)
+}
+var edits []Edit
+if err := json.Unmarshal(data, &edits); err != nil {
+return nil, fmt.Errorf("failed to read the change journal: %w", err)
+}
+return edits, nil
+}
+
+func (j *Journal) save(dir string, edits []Edit) error {
+data, err := json.MarshalIndent(edits, "", "  ")
+if err != nil {
+return err
+}
+if err := writeFileMode(filepath.Join(dir, "edits.json"), string(data), 0600); err != nil {
+return fmt.Errorf("failed to write the change journal: %w", err)
+}
+return nil
+}
+
+// Record adds the changes a tool made to the journal of a session.
+func (j *Journal) Record(sessionID, tool string, changes ...Change) (Edit, error) {
+dir, err := j.sessionDir(sessionID)
+if err != nil {
+return Edit{}, err
+}
+j.mu.Lock()
+defer j.mu.Unlock()
+if err := os.MkdirAll(dir, 0700); err != nil {
+return Edit{}, fmt.Errorf("failed to create the change journal: %w", err)
+}
+edits, err := j.load(dir)
+if err != nil {
+return Edit{}, err
+}
+
+e := Edit{ID: strconv.Itoa(len(edits) + 1), Time: time.Now(), Tool: tool}
+for i, c := range changes {
+if !c.Created {
+if err := os.WriteFile(backupPath(dir, e.ID, i), []byte(c.before), 0600); err != nil {
+return Edit{}, fmt.Errorf("failed to back up %s: %w", c.Path, err)
+}
+}
+path, err := filepath.Abs(c.Path)
+if err != nil {
+return Edit{}, err
+}
+e.Files = append(e.Files, EditFile{Path: path, Created: c.Created, Deleted: c.Deleted, Mode: c.mode, Diff: c.Diff, After: hash(c.after)})
+}
+return e, j.save(dir, append(edits, e))
+}
+
+// List returns the edits of a session, oldest first.
+func (j *Journal) List(sessionID string) ([]Edit, error) {
+dir, err := j.sessionDir(sessionID)
+if err != nil {
+return nil, err
+}
+j.mu.Lock()
+defer j.mu.Unlock()
+return j.load(dir)
+}
+
+// Revert undoes an edit of a session, or its latest edit not undone yet
+// when id is empty. Files the edit created are removed and the others get
+// back their content from before it. Nothing is changed when a file was
+// changed again after the edit; later edits must be undone first.
+func (j *Journal) Revert(sessionID, id string) (Edit, error) {
+dir, err := j.sessionDir(sessionID)
+if err != nil {
+return Edit{}, err
+}
+j.mu.Lock()
+defer j.mu.Unlock()
+edits, err := j.load(dir)
+if err != nil {
+return Edit{}, err
+}
+
+idx := -1
+for i := len(edits) - 1; i >= 0; i-- {
+if (id == "" && !edits[i].Reverted) || edits[i].ID == id {
+idx = i
+break
+}
+}
+if idx == -1 {
+if id == "" {
+return Edit{}, fmt.Errorf("%w: there are no edits to undo", ErrEditNotFound)
+}
+return Edit{}, fmt.Errorf("%w: %s", ErrEditNotFound, id)
+}
+e := edits[idx]
+if e.Reverted {
+return Edit{}, fmt.Errorf("%w: edit %s was already undone", ErrEditConflict, e.ID)
+}
+
+for _, f := range e.Files {
+content, err := os.ReadFile(f.Path)
+switch {
+case f.Deleted && err == nil:
+return Edit{}, fmt.Errorf("%w: %s was created again after edit %s", ErrEditConflict, f.Path, e.ID)
+case f.Deleted && os.IsNotExist(err):
+case err != nil:
+return Edit{}, fmt.Errorf("%w: %s: %v", ErrEditConflict, f.Path, err)
+case hash(string(content)) != f.After:
+return Edit{}, fmt.Errorf("%w: %s was changed after edit %s, undo the later edits first", ErrEditConflict, f.Path, e.ID)
+}
+}
+for i, f := range e.Files {
+if f.Created {
+err = os.Remove(f.Path)
+} else {
+var before []byte
+if before, err = os.ReadFile(backupPath(dir, e.ID, i)); err == nil && f.Deleted {
+err = os.MkdirAll(filepath.Dir(f.Path), 0755)
+}
+if err == nil {
+err = writeFileMode(f.Path, string(before), f.Mode)
+}
+}
+if err != nil {
+return Edit{}, fmt.Errorf("failed to restore %s: %w", f.Path, err)
+}
+}
+
+edits[idx].Reverted = true
+return edits[idx], j.save(dir, edits)
+}
+
+func hash(s string) string {
+sum := sha256.Sum256([]byte(s))
+return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,94 @@
+package editor
+
+import (
+"os"
+"path/filepath"
+"testing"
+
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+editor := NewFileEditor()
+j := NewJournal(t.TempDir())
+dir := t.TempDir()
+path := filepath.Join(dir, "a.txt")
+require.NoError(t, os.WriteFile(path, []byte("one\ntwo\n"), 0640))
+read := func(p string) string {
+content, _ := os.ReadFile(p)
+return string(content)
+}
+
+c, err := editor.Replace(path, "one", "ONE", false)
+require.NoError(t, err)
+e1, err := j.Record("s1", "replace_text", c)
+require.NoError(t, err)
+assert.Equal(t, "1", e1.ID)
+
+c, err = editor.WriteFile(filepath.Join(dir, "b.txt"), "new\n", WriteCreate, false)
+require.NoError(t, err)
+c2, err := editor.DeleteLines(path, 2, 2, false)
+require.NoError(t, err)
+e2, err := j.Record("s1", "apply_patch", c, c2)
+require.NoError(t, err)
+assert.Equal(t, "2", e2.ID)
+
+edits, err := j.List("s1")
+require.NoError(t, err)
+assert.Len(t, edits, 2)
+assert.Equal(t, path, edits[0].Files[0].Path)
+assert.Contains(t, edits[0].Files[0].Diff, "-one\n+ONE\n")
+edits, err = j.List("s2")
+require.NoError(t, err)
+assert.Empty(t, edits)
+
+// Edit 1 changed a.txt, which edit 2 changed again
+_, err = j.Revert("s1", "1")
+assert.ErrorIs(t, err, ErrEditConflict)
+assert.Equal(t, "ONE\n", read(path))
+
+e, err := j.Revert("s1", "")
+require.NoError(t, err)
+assert.Equal(t, "2", e.ID)
+assert.True(t, e.Reverted)
+assert.NoFileExists(t, filepath.Join(dir, "b.txt"))
+assert.Equal(t, "ONE\ntwo\n", read(path))
+
+e, err = j.Revert("s1", "")
+require.NoError(t, err)
+assert.Equal(t, "1", e.ID)
+assert.Equal(t, "one\ntwo\n", read(path))
+info, _ := os.Stat(path)
+assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+_, err = j.Revert("s1", "1")
+assert.ErrorIs(t, err, ErrEditConflict)
+_, err = j.Revert("s1", "")
+assert.ErrorIs(t, err, ErrEditNotFound)
+_, err = j.Revert("s1", "7")
+assert.ErrorIs(t, err, ErrEditNotFound)
+_, err = j.List("../x")
+assert.ErrorContains(t, err, "invalid session ID")
+}
+
+func TestJournal_RevertDelete(t *testing.T) {
+editor := NewFileEditor()
+j := NewJournal(t.TempDir())
+path := filepath.Join(t.TempDir(), "sub", "c.txt")
+require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+require.NoError(t, os.WriteFile(path, []byte("bye\n"), 0600))
+
+res, err := editor.ApplyPatch("--- a/"+path+"\n+++ /dev/null\n@@ -1 +0,0 @@\n-bye\n", false)
+require.NoError(t, err)
+require.NoError(t, os.Remove(filepath.Dir(path)))
+_, err = j.Record("s1", "apply_patch", res.Changes...)
+require.NoError(t, err)
+
+_, err = j.Revert("s1", "1")
+require.NoError(t, err)
+content, _ := os.ReadFile(path)
+assert.Equal(t, "bye\n", string(content))
+info, _ := os.Stat(path)
+assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
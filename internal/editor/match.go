@@ -0,0 +1,201 @@
+package editor
+
+import (
+"fmt"
+"strings"
+)
+
+const (
+// similarShown is the similarity from which the closest region is shown
+// when old text is not found.
+similarShown = 0.5
+// maxFuzzyWork bounds the closest match search, in pairs of characters
+// compared.
+maxFuzzyWork = 1 << 26
+// maxSimilarLine is the length of a line beyond which it is not compared.
+maxSimilarLine = 200
+)
+
+// lineMatcher is a tier of line-by-line matching of old text.
+type lineMatcher struct {
+// how describes the tier in messages
+how       string
+normalize func(string) string
+// reindent moves replacement lines to the indentation of the region
+reindent bool
+}
+
+var lineMatchers = []lineMatcher{
+{"ignoring line endings and trailing whitespace", func(s string) string { return strings.TrimRight(s, " \t\r\n") }, false},
+{"ignoring indentation", strings.TrimSpace, true},
+}
+
+// region is a range of lines, start inclusive and end exclusive.
+type region struct {
+start, end int
+}
+
+func (r region) String() string {
+if r.end-r.start == 1 {
+return fmt.Sprintf("line %d", r.start+1)
+}
+return fmt.Sprintf("lines %d-%d", r.start+1, r.end)
+}
+
+// replaceTiered replaces the one region of content that old matches when
+// compared line by line, first ignoring line endings and trailing
+// whitespace, then indentation. It returns the new content and how old
+// matched, or an error that shows the most similar region. That region is
+// never replaced, since it may not be the text the caller meant.
+func replaceTiered(content, old, new string) (string, string, error) {
+lines := splitLines(content)
+want := splitLines(old)
+if strings.TrimSpace(old) == "" || len(want) > len(lines) {
+return "", "", fmt.Errorf("old text not found in file")
+}
+
+for _, m := range lineMatchers {
+var found []region
+for i := 0; i+len(want) <= len(lines); i++ {
+if matchLines(lines[i:i+len(want)], want, m.normalize) {
+found = append(found, region{i, i + len(want)})
+}
+}
+switch {
+case len(found) == 1:
+return replaceRegion(lines, want, found[0], new, m.reindent), fmt.Sprintf("old text matched %s %s", found[0], m.how), nil
+case len(found) > 1:
+at := make([]string, len(found))
+for i, r := range found {
+at[i] = r.String()
+}
+return "", "", fmt.Errorf("old text found multiple times (%d) %s, at %s, please be more specific", len(found), m.how, strings.Join(at, ", "))
+}
+}
+
+best, score := closestRegion(lines, want)
+msg := "old text not found in file"
+if best.end > best.start && score >= similarShown {
+msg += fmt.Sprintf(". The closest match, %.0f%% similar, is at %s:\n", score*100, best)
+for i := best.start; i < best.end; i++ {
+msg += fmt.Sprintf("%d: %s\n", i+1, trimEOL(lines[i]))
+}
+msg += "Copy old text exactly from these lines"
+}
+return "", "", fmt.Errorf("%s", msg)
+}
+
+// matchLines reports whether lines equal want once both are normalized.
+func matchLines(lines, want []string, normalize func(string) string) bool {
+for i := range want {
+if normalize(lines[i]) != normalize(want[i]) {
+return false
+}
+}
+return true
+}
+
+// closestRegion returns the region of as many lines as want that is most
+// similar to it, ignoring indentation, with its similarity between 0 and
+// 1. It gives up on content too large to compare with want.
+func closestRegion(lines, want []string) (region, float64) {
+n := len(want)
+if similarSize(lines)*similarSize(want) > maxFuzzyWork {
+return region{}, 0
+}
+var best region
+bestScore := 0.0
+for i := 0; i+n <= len(lines); i++ {
+dist, size := 0, 0
+for k := range want {
+a, b := similarLine(lines[i+k]), similarLine(want[k])
+dist += levenshtein(a, b)
+size += max(len(a), len(b))
+}
+if size == 0 {
+continue
+}
+if score := 1 - float64(dist)/float64(size); score > bestScore {
+best, bestScore = region{i, i + n}, score
+}
+}
+return best, bestScore
+}
+
+// similarSize returns the number of characters of lines that are compared
+// for similarity. Every line of content is compared with at most every line
+// of old text, so the product of their sizes bounds the work.
+func similarSize(lines []string) int {
+size := 0
+for _, l := range lines {
+size += len(similarLine(l))
+}
+return size
+}
+
+// similarLine returns the part of a line that is compared for similarity.
+func similarLine(line string) string {
+line = strings.TrimSpace(line)
+if len(line) > maxSimilarLine {
+line = line[:maxSimilarLine]
+}
+return line
+}
+
+// levenshtein returns the edit distance of a and b in bytes.
+func levenshtein(a, b string) int {
+prev := make([]int, len(b)+1)
+cur := make([]int, len(b)+1)
+for j := range prev {
+prev[j] = j
+}
+for i := 1; i <= len(a); i++ {
+cur[0] = i
+for j := 1; j <= len(b); j++ {
+cost := 1
+if a[i-1] == b[j-1] {
+cost = 0
+}
+cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+}
+prev, cur = cur, prev
+}
+return prev[len(b)]
+}
+
+// replaceRegion replaces the lines of r with new. With reindent, lines of
+// new that start with the indentation of old get that of the region.
+func replaceRegion(lines, old []string, r region, new string, reindent bool) string {
+eol := lineEnding(strings.Join(lines, ""))
+var from, to string
+if reindent {
+for k, l := range old {
+if strings.TrimSpace(l) != "" {
+from, to = indentation(l), indentation(lines[r.start+k])
+break
+}
+}
+}
+
+var out []string
+out = append(out, lines[:r.start]...)
+added := splitLines(new)
+for _, l := range added {
+l = trimEOL(l)
+if reindent && strings.TrimSpace(l) != "" && strings.HasPrefix(l, from) {
+l = to + l[len(from):]
+}
+out = append(out, l+eol)
+}
+if len(added) > 0 && r.end == len(lines) && !strings.HasSuffix(lines[r.end-1], "\n") {
+// The region ended the file without a final newline
+out[len(out)-1] = trimEOL(out[len(out)-1])
+}
+out = append(out, lines[r.end:]...)
+return strings.Join(out, "")
+}
+
+// indentation returns the leading whitespace of a line.
+func indentation(line string) string {
+return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
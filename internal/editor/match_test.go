@@ -0,0 +1,82 @@
+package editor
+
+import (
+"os"
+"path/filepath"
+"strings"
+"testing"
+
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+func TestFileEditor_ReplaceTiers(t *testing.T) {
+editor := NewFileEditor()
+path := filepath.Join(t.TempDir(), "main.go")
+const source = "package main\n\nfunc main() {\n\tif ok {\n\t\tprintln(\"hello\")  \n\t}\n}\n"
+replace := func(content, old, new string) (Change, string, error) {
+require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+c, err := editor.Replace(path, old, new, false)
+updated, _ := os.ReadFile(path)
+return c, string(updated), err
+}
+
+t.Run("Exact", func(t *testing.T) {
+c, out, err := replace(source, "hello", "bye")
+require.NoError(t, err)
+assert.Empty(t, c.Note)
+assert.Contains(t, out, `println("bye")`)
+})
+
+t.Run("Line Endings", func(t *testing.T) {
+crlf := "a\r\nb  \r\nc\r\n"
+c, out, err := replace(crlf, "a\nb\n", "x\ny\n")
+require.NoError(t, err)
+assert.Equal(t, "old text matched lines 1-2 ignoring line endings and trailing whitespace", c.Note)
+assert.Equal(t, "x\r\ny\r\nc\r\n", out)
+})
+
+t.Run("Indentation", func(t *testing.T) {
+c, out, err := replace(source, "if ok {\n    println(\"hello\")\n}", "if ok {\n    println(\"bye\")\n    return\n}")
+require.NoError(t, err)
+assert.Equal(t, "old text matched lines 4-6 ignoring indentation", c.Note)
+assert.Equal(t, "package main\n\nfunc main() {\n\tif ok {\n\t    println(\"bye\")\n\t    return\n\t}\n}\n", out)
+
+// Replacement lines keep their indentation relative to old text
+c, out, err = replace(source, "  if ok {\n  \tprintln(\"hello\")", "  if !ok {\n  \tprintln(\"bye\")")
+require.NoError(t, err)
+assert.Equal(t, "old text matched lines 4-5 ignoring indentation", c.Note)
+assert.Equal(t, "package main\n\nfunc main() {\n\tif !ok {\n\t\tprintln(\"bye\")\n\t}\n}\n", out)
+})
+
+t.Run("Closest Match", func(t *testing.T) {
+// Similar lines are shown but not replaced
+_, out, err := replace(source, "func main() {\n\tif ok {\n\t\tprintln(\"hallo\")", "func main() {\n\tif ok {\n\t\tprintln(\"bye\")")
+assert.EqualError(t, err, "old text not found in file. The closest match, 97% similar, is at lines 3-5:\n3: func main() {\n4: \tif ok {\n5: \t\tprintln(\"hello\")  \nCopy old text exactly from these lines")
+assert.Equal(t, source, out)
+
+// Content too large to compare is not searched
+large := strings.Repeat("println(\"hello\")\n", 1<<14)
+_, out, err = replace(large, strings.Repeat("println(\"hallo\")\n", 512), "x")
+assert.EqualError(t, err, "old text not found in file")
+assert.Equal(t, large, out)
+})
+
+t.Run("Not Found", func(t *testing.T) {
+_, out, err := replace(source, "if done {\n\tprint(\"hello\")", "x")
+assert.EqualError(t, err, "old text not found in file. The closest match, 80% similar, is at lines 4-5:\n4: \tif ok {\n5: \t\tprintln(\"hello\")  \nCopy old text exactly from these lines")
+assert.Equal(t, source, out)
+
+_, _, err = replace(source, "completely unrelated", "x")
+assert.EqualError(t, err, "old text not found in file")
+})
+
+t.Run("Multiple", func(t *testing.T) {
+_, out, err := replace("  x = 1\nb\n\tx = 1  \n", "x = 1 \n", "y\n")
+assert.EqualError(t, err, "old text found multiple times (2) ignoring indentation, at line 1, line 3, please be more specific")
+assert.Equal(t, "  x = 1\nb\n\tx = 1  \n", out)
+
+_, _, err = replace("one two\nthree\none two\n", "one twa", "x")
+assert.ErrorContains(t, err, "old text not found in file")
+})
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package editor
+
+import (
+"os"
+"syscall"
+)
+
+// sameOwner gives f the owner and group of the file described by info and
+// reports whether that worked. Only root may give a file to someone else.
+func sameOwner(f *os.File, info os.FileInfo) bool {
+st, ok := info.Sys().(*syscall.Stat_t)
+if !ok {
+return true
+}
+return f.Chown(int(st.Uid), int(st.Gid)) == nil
+}
@@ -0,0 +1,393 @@
+package editor
+
+import (
+"fmt"
+"os"
+"path/filepath"
+"regexp"
+"strconv"
+"strings"
+)
+
+// maxFuzz is how many lines of leading and trailing context a hunk may
+// ignore to apply, as with patch's default fuzz factor.
+const maxFuzz = 2
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// filePatch is the part of a unified diff that changes one file.
+type filePatch struct {
+oldPath, newPath string
+hunks            []hunk
+}
+
+// hunk is one @@ section of a unified diff.
+type hunk struct {
+oldStart, oldCount int
+ops                []lineOp
+}
+
+// HunkResult reports how a hunk of a patch applied.
+type HunkResult struct {
+Path string
+// Hunk is the 1-indexed number of the hunk within its file
+Hunk int
+// Line is the 1-indexed line of the original file the hunk applied at
+Line int
+// Offset is how many lines Line is from where the hunk said it applies
+Offset int
+// Fuzz is how many lines of leading and trailing context were ignored
+Fuzz int
+// Err is why the hunk did not apply
+Err error
+}
+
+func (h HunkResult) String() string {
+if h.Err != nil {
+return fmt.Sprintf("%s: hunk %d FAILED: %v", h.Path, h.Hunk, h.Err)
+}
+s := fmt.Sprintf("%s: hunk %d applied at line %d", h.Path, h.Hunk, h.Line)
+var notes []string
+if h.Offset != 0 {
+notes = append(notes, fmt.Sprintf("offset %+d lines", h.Offset))
+}
+if h.Fuzz != 0 {
+notes = append(notes, fmt.Sprintf("fuzz %d", h.Fuzz))
+}
+if len(notes) > 0 {
+s += " (" + strings.Join(notes, ", ") + ")"
+}
+return s
+}
+
+// PatchResult reports the outcome of ApplyPatch.
+type PatchResult struct {
+Changes []Change
+Hunks   []HunkResult
+}
+
+// Report lists how every hunk applied.
+func (r PatchResult) Report() string {
+lines := make([]string, len(r.Hunks))
+for i, h := range r.Hunks {
+lines[i] = h.String()
+}
+return strings.Join(lines, "\n")
+}
+
+// ApplyPatch applies a unified diff that may change several files. Hunks
+// that do not apply where they say are searched for elsewhere in the file
+// and, failing that, with fewer context lines. Either every hunk applies or
+// nothing is written; the result reports each hunk either way. With dryRun
+// nothing is written.
+func (e *FileEditor) ApplyPatch(patch string, dryRun bool) (PatchResult, error) {
+files, err := parsePatch(patch)
+if err != nil {
+return PatchResult{}, err
+}
+
+type write struct {
+path, content string
+deleted       bool
+}
+var res PatchResult
+var writes []write
+failed := 0
+for _, fp := range files {
+created := fp.oldPath == "/dev/null"
+deleted := fp.newPath == "/dev/null"
+path := fp.newPath
+if deleted {
+path = fp.oldPath
+}
+if path, err = e.resolve(path); err != nil {
+return PatchResult{}, err
+}
+
+var old string
+content, err := os.ReadFile(path)
+switch {
+case err == nil && created:
+err = fmt.Errorf("%s already exists", path)
+case err == nil:
+old = string(content)
+case os.IsNotExist(err) && created:
+err = nil
+}
+if err != nil {
+for i := range fp.hunks {
+res.Hunks = append(res.Hunks, HunkResult{Path: path, Hunk: i + 1, Err: err})
+}
+failed += len(fp.hunks)
+continue
+}
+
+new, hunks := applyHunks(old, fp.hunks)
+for i := range hunks {
+hunks[i].Path = path
+if hunks[i].Err != nil {
+failed++
+}
+}
+res.Hunks = append(res.Hunks, hunks...)
+if deleted && new != "" {
+res.Hunks = append(res.Hunks, HunkResult{Path: path, Hunk: len(hunks), Err: fmt.Errorf("the file is not empty after removing the lines of the patch")})
+failed++
+}
+res.Changes = append(res.Changes, newChange(path, old, new, created, deleted))
+writes = append(writes, write{path, new, deleted})
+}
+
+if failed > 0 {
+return res, fmt.Errorf("patch not applied, %d of %d hunks failed:\n%s", failed, len(res.Hunks), res.Report())
+}
+if dryRun {
+return res, nil
+}
+for _, w := range writes {
+if w.deleted {
+err = os.Remove(w.path)
+} else if err = os.MkdirAll(filepath.Dir(w.path), 0755); err == nil {
+err = writeFile(w.path, w.content)
+}
+if err != nil {
+return res, fmt.Errorf("failed to write %s: %v", w.path, err)
+}
+}
+return res, nil
+}
+
+// applyHunks applies hunks to content in order.
+func applyHunks(content string, hunks []hunk) (string, []HunkResult) {
+lines := splitLines(content)
+eol := lineEnding(content)
+results := make([]HunkResult, len(hunks))
+var out []string
+// next is the first line not yet copied to out, offset the offset of
+// the last hunk that applied
+next, offset := 0, 0
+for i, h := range hunks {
+r := &results[i]
+r.Hunk = i + 1
+want := h.oldStart - 1
+if h.oldCount == 0 {
+// An insertion names the line it follows
+want = h.oldStart
+}
+
+pos, ops, fuzz := -1, h.ops, 0
+for ; fuzz <= maxFuzz; fuzz++ {
+ops = trimContext(h.ops, fuzz)
+if fuzz > 0 && len(ops) == len(trimContext(h.ops, fuzz-1)) {
+// Nothing more to trim
+break
+}
+lead := leadingContext(h.ops) - leadingContext(ops)
+if pos = findLines(lines, oldLines(ops), want+offset+lead, next); pos != -1 {
+pos -= lead
+break
+}
+}
+if pos == -1 {
+r.Err = fmt.Errorf("%s not found near line %d", describeContext(h.ops), want+1)
+continue
+}
+
+r.Line = pos + 1
+r.Offset = pos - want
+r.Fuzz = fuzz
+offset = r.Offset
+lead := leadingContext(h.ops) - leadingContext(ops)
+out = append(out, lines[next:pos+lead]...)
+at := pos + lead
+for _, op := range ops {
+switch op.kind {
+case ' ':
+out = append(out, lines[at])
+at++
+case '-':
+at++
+case '+':
+// Added lines use the file's line endings
+if strings.HasSuffix(op.text, "\n") {
+out = append(out, trimEOL(op.text)+eol)
+} else {
+out = append(out, op.text)
+}
+}
+}
+next = at
+}
+out = append(out, lines[next:]...)
+return joinLines(out, eol), results
+}
+
+// oldLines returns the lines ops expect to find.
+func oldLines(ops []lineOp) []string {
+var lines []string
+for _, op := range ops {
+if op.kind != '+' {
+lines = append(lines, op.text)
+}
+}
+return lines
+}
+
+// leadingContext returns the number of context lines ops start with.
+func leadingContext(ops []lineOp) int {
+n := 0
+for n < len(ops) && ops[n].kind == ' ' {
+n++
+}
+return n
+}
+
+// trimContext drops up to fuzz context lines from each end of ops.
+func trimContext(ops []lineOp, fuzz int) []lineOp {
+lead := min(fuzz, leadingContext(ops))
+trail := 0
+for trail < fuzz && trail < len(ops)-lead && ops[len(ops)-1-trail].kind == ' ' {
+trail++
+}
+return ops[lead : len(ops)-trail]
+}
+
+// describeContext names the first line a hunk expects, for error messages.
+func describeContext(ops []lineOp) string {
+for _, op := range ops {
+if op.kind != '+' {
+return fmt.Sprintf("expected line %q", trimEOL(op.text))
+}
+}
+return "insertion point"
+}
+
+// findLines returns the index of want in lines closest to near and not
+// before from, comparing lines without their endings, or -1.
+func findLines(lines, want []string, near, from int) int {
+near = max(0, min(near, len(lines)))
+if len(want) == 0 {
+return max(from, near)
+}
+matches := func(at int) bool {
+if at < from || at+len(want) > len(lines) {
+return false
+}
+for i, w := range want {
+if trimEOL(lines[at+i]) != trimEOL(w) {
+return false
+}
+}
+return true
+}
+for d := 0; d <= len(lines); d++ {
+if matches(near + d) {
+return near + d
+}
+if d > 0 && matches(near-d) {
+return near - d
+}
+}
+return -1
+}
+
+// parsePatch parses a unified diff. Line counts in hunk headers are not
+// trusted, a hunk ends where the next hunk or file starts.
+func parsePatch(patch string) ([]filePatch, error) {
+lines := strings.Split(strings.ReplaceAll(patch, "\r\n", "\n"), "\n")
+var files []filePatch
+var fp *filePatch
+var h *hunk
+for i := 0; i < len(lines); i++ {
+line := lines[i]
+switch {
+case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+files = append(files, newFilePatch(patchPath(line[4:]), patchPath(lines[i+1][4:])))
+fp, h = &files[len(files)-1], nil
+i++
+case strings.HasPrefix(line, "@@"):
+if fp == nil {
+return nil, fmt.Errorf("line %d: hunk without --- and +++ file header", i+1)
+}
+m := hunkHeader.FindStringSubmatch(line)
+if m == nil {
+return nil, fmt.Errorf("line %d: invalid hunk header %q", i+1, line)
+}
+start, _ := strconv.Atoi(m[1])
+count := 1
+if m[2] != "" {
+count, _ = strconv.Atoi(m[2])
+}
+fp.hunks = append(fp.hunks, hunk{oldStart: start, oldCount: count})
+h = &fp.hunks[len(fp.hunks)-1]
+case h == nil:
+// Text before the first hunk, like a commit message or git's
+// diff and index lines
+case line == "" || line[0] == ' ' || line[0] == '-' || line[0] == '+':
+text := ""
+if line != "" {
+text = line[1:]
+}
+kind := byte(' ')
+if line != "" {
+kind = line[0]
+}
+h.ops = append(h.ops, lineOp{kind, text + "\n"})
+case strings.HasPrefix(line, `\`):
+// No newline at end of file
+if n := len(h.ops); n > 0 {
+h.ops[n-1].text = strings.TrimSuffix(h.ops[n-1].text, "\n")
+}
+default:
+h = nil
+}
+}
+
+for i := range files {
+if files[i].oldPath == "/dev/null" && files[i].newPath == "/dev/null" {
+return nil, fmt.Errorf("file %d of the patch has no path", i+1)
+}
+for j := range files[i].hunks {
+trimBlankTail(&files[i].hunks[j])
+}
+if len(files[i].hunks) == 0 {
+return nil, fmt.Errorf("the patch for %s has no hunks", files[i].newPath)
+}
+}
+if len(files) == 0 {
+return nil, fmt.Errorf("no file changes found, the patch needs --- and +++ headers and @@ hunks")
+}
+return files, nil
+}
+
+// trimBlankTail drops the empty context lines a hunk gained from blank
+// lines after it, as long as it has more old lines than its header says.
+func trimBlankTail(h *hunk) {
+for n := len(h.ops); n > 0 && h.ops[n-1].kind == ' ' && h.ops[n-1].text == "\n" && len(oldLines(h.ops)) > h.oldCount; n-- {
+h.ops = h.ops[:n-1]
+}
+}
+
+// patchPath returns the path of a --- or +++ line, without a timestamp.
+func patchPath(s string) string {
+if i := strings.IndexByte(s, '\t'); i != -1 {
+s = s[:i]
+}
+return strings.TrimSpace(s)
+}
+
+// newFilePatch returns the patch of a file without the a/ and b/ prefixes
+// git puts in front of its paths.
+func newFilePatch(oldPath, newPath string) filePatch {
+oldGit := oldPath == "/dev/null" || strings.HasPrefix(oldPath, "a/")
+newGit := newPath == "/dev/null" || strings.HasPrefix(newPath, "b/")
+if oldGit && newGit {
+if oldPath != "/dev/null" {
+oldPath = oldPath[2:]
+}
+if newPath != "/dev/null" {
+newPath = newPath[2:]
+}
+}
+return filePatch{oldPath: oldPath, newPath: newPath}
+}
@@ -0,0 +1,144 @@
+package editor
+
+import (
+"os"
+"path/filepath"
+"strings"
+"testing"
+
+"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+old := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+new := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13"
+assert.Equal(t, `--- a/f
++++ b/f
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -10,3 +10,4 @@
+ 10
+ 11
+ 12
++13
+\ No newline at end of file
+`, unifiedDiff("f", old, new, false, false))
+}
+
+func TestFileEditor_ApplyPatch(t *testing.T) {
+editor := NewFileEditor()
+dir := t.TempDir()
+t.Chdir(dir)
+write := func(name, content string) {
+assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+}
+read := func(name string) string {
+content, _ := os.ReadFile(filepath.Join(dir, name))
+return string(content)
+}
+
+t.Run("Offset And Line Endings", func(t *testing.T) {
+write("a.txt", "x\r\nx\r\n1\r\n2\r\n3\r\n4\r\n")
+// The hunk says line 1, but two lines were added above since
+res, err := editor.ApplyPatch(`diff --git a/a.txt b/a.txt
+--- a/a.txt
++++ b/a.txt
+@@ -1,3 +1,3 @@
+ 1
+-2
++two
+ 3
+`, false)
+assert.NoError(t, err)
+assert.Equal(t, "a.txt: hunk 1 applied at line 3 (offset +2 lines)", res.Report())
+assert.Equal(t, "x\r\nx\r\n1\r\ntwo\r\n3\r\n4\r\n", read("a.txt"))
+})
+
+t.Run("Fuzz", func(t *testing.T) {
+write("b.txt", "a\nb\nc\nd\ne\n")
+res, err := editor.ApplyPatch(`--- b.txt
++++ b.txt
+@@ -1,5 +1,5 @@
+ changed
+ b
+-c
++C
+ d
+ e
+`, false)
+assert.NoError(t, err)
+assert.Equal(t, "b.txt: hunk 1 applied at line 1 (fuzz 1)", res.Report())
+assert.Equal(t, "a\nb\nC\nd\ne\n", read("b.txt"))
+})
+
+t.Run("Several Files", func(t *testing.T) {
+write("c.txt", "keep\ndrop\n")
+write("gone.txt", "bye\n")
+res, err := editor.ApplyPatch(`--- a/c.txt
++++ b/c.txt
+@@ -1,2 +1 @@
+ keep
+-drop
+--- /dev/null
++++ b/new/d.txt
+@@ -0,0 +1,2 @@
++hello
++world
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+`, false)
+assert.NoError(t, err)
+assert.Len(t, res.Changes, 3)
+assert.Equal(t, "Changed c.txt (+0 -1)", res.Changes[0].Summary())
+assert.Equal(t, "Created new/d.txt (2 lines)", res.Changes[1].Summary())
+assert.Equal(t, "Deleted gone.txt", res.Changes[2].Summary())
+assert.Equal(t, "keep\n", read("c.txt"))
+assert.Equal(t, "hello\nworld\n", read("new/d.txt"))
+assert.NoFileExists(t, filepath.Join(dir, "gone.txt"))
+})
+
+t.Run("All Or Nothing", func(t *testing.T) {
+write("e.txt", "1\n2\n3\n")
+write("f.txt", "x\n")
+res, err := editor.ApplyPatch(`--- a/e.txt
++++ b/e.txt
+@@ -1,3 +1,3 @@
+ 1
+-2
++two
+ 3
+--- a/f.txt
++++ b/f.txt
+@@ -1 +1 @@
+-missing
++y
+`, false)
+assert.ErrorContains(t, err, "patch not applied, 1 of 2 hunks failed")
+assert.ErrorContains(t, err, `f.txt: hunk 1 FAILED: expected line "missing" not found near line 1`)
+assert.Len(t, res.Hunks, 2)
+assert.Equal(t, "1\n2\n3\n", read("e.txt"))
+})
+
+t.Run("Dry Run", func(t *testing.T) {
+write("g.txt", "old\n")
+res, err := editor.ApplyPatch("--- a/g.txt\n+++ b/g.txt\n@@ -1 +1 @@\n-old\n+new\n", true)
+assert.NoError(t, err)
+assert.True(t, strings.HasSuffix(res.Changes[0].Diff, "-old\n+new\n"))
+assert.Equal(t, "old\n", read("g.txt"))
+})
+
+t.Run("Invalid", func(t *testing.T) {
+_, err := editor.ApplyPatch("just some text", false)
+assert.ErrorContains(t, err, "no file changes found")
+_, err = editor.ApplyPatch("@@ -1 +1 @@\n-a\n+b\n", false)
+assert.ErrorContains(t, err, "hunk without --- and +++ file header")
+})
+}
@@ -0,0 +1,340 @@
+package editor
+
+import (
+"bytes"
+"errors"
+"fmt"
+"io/fs"
+"os"
+"path/filepath"
+"regexp"
+"strings"
+)
+
+const (
+// defaultListLimit bounds the entries of ListDir and Glob.
+defaultListLimit = 500
+// defaultSearchLimit bounds the matches of Search.
+defaultSearchLimit = 100
+// maxSearchContext bounds the context lines around a match.
+maxSearchContext = 10
+// maxSearchSize is the size above which files are not searched.
+maxSearchSize = 4 << 20
+// maxMatchLine is the length at which matching lines are cut.
+maxMatchLine = 500
+)
+
+// Entry is a file or directory found by ListDir or Glob.
+type Entry struct {
+// Path starts with the directory that was listed
+Path string
+Dir  bool
+Size int64
+}
+
+// SearchOptions selects what Search looks for.
+type SearchOptions struct {
+// Pattern is a regular expression matched against each line
+Pattern    string
+IgnoreCase bool
+// Path is the file or directory to search, "." when empty
+Path string
+// Include and Exclude are globs of the files to search and to skip. A
+// glob without a slash matches the name of a file, one with a slash
+// its path below Path, where "**" matches any number of directories.
+// Excluded directories are not searched.
+Include []string
+Exclude []string
+// Context is the number of lines to show before and after a match
+Context int
+// Limit bounds the matches, 100 when 0
+Limit int
+}
+
+// Match is a line found by Search.
+type Match struct {
+Path string
+// Line is 1-indexed
+Line int
+Text string
+// Before and After are the context lines around the match that are not
+// shown with an adjacent match already
+Before []string
+After  []string
+}
+
+// walk calls fn for the files and directories below root, in lexical
+// order, with their path relative to root. It skips .git directories,
+// what .gitignore files exclude and, in a workspace, denied files and
+// symlinks that lead outside it. Directories that cannot be read are
+// skipped.
+func (e *FileEditor) walk(root string, fn func(path, rel string, d fs.DirEntry) error) error {
+ig := newIgnorer(root)
+var allowed func(string) bool
+if e.Workspace != nil {
+allowed = e.Workspace.allowed()
+}
+return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+if err != nil {
+if path == root {
+return err
+}
+if d != nil && d.IsDir() {
+return fs.SkipDir
+}
+return nil
+}
+if path == root {
+return nil
+}
+skip := func() error {
+if d.IsDir() {
+return fs.SkipDir
+}
+return nil
+}
+if d.IsDir() && d.Name() == ".git" {
+return fs.SkipDir
+}
+if ig.ignored(path, d.IsDir()) {
+return skip()
+}
+if allowed != nil && !allowed(path) {
+return skip()
+}
+if d.Type()&fs.ModeSymlink != 0 && e.Workspace != nil {
+if _, err := e.Workspace.Resolve(path); err != nil {
+return nil
+}
+}
+if d.IsDir() {
+ig.load(path)
+}
+rel, _ := filepath.Rel(root, path)
+return fn(path, filepath.ToSlash(rel), d)
+})
+}
+
+// resolveDir resolves a directory argument, "." when empty.
+func (e *FileEditor) resolveDir(dir string) (string, string, error) {
+if dir == "" {
+dir = "."
+}
+real, err := e.resolve(dir)
+if err != nil {
+return "", "", err
+}
+info, err := os.Stat(real)
+if err != nil {
+return "", "", err
+}
+if !info.IsDir() {
+return "", "", fmt.Errorf("%s is not a directory", dir)
+}
+return dir, real, nil
+}
+
+// entry describes a walked file for ListDir and Glob.
+func entry(given, rel string, d fs.DirEntry) Entry {
+en := Entry{Path: filepath.Join(given, filepath.FromSlash(rel)), Dir: d.IsDir()}
+if info, err := d.Info(); err == nil && !en.Dir {
+en.Size = info.Size()
+}
+return en
+}
+
+// ListDir lists the directory at dir and, up to depth levels, the
+// directories in it. Limit bounds the entries, 500 when 0; the result
+// reports whether there were more.
+func (e *FileEditor) ListDir(dir string, depth, limit int) ([]Entry, bool, error) {
+given, root, err := e.resolveDir(dir)
+if err != nil {
+return nil, false, err
+}
+depth = max(depth, 1)
+if limit <= 0 {
+limit = defaultListLimit
+}
+var entries []Entry
+truncated := false
+err = e.walk(root, func(path, rel string, d fs.DirEntry) error {
+if len(entries) == limit {
+truncated = true
+return fs.SkipAll
+}
+entries = append(entries, entry(given, rel, d))
+if d.IsDir() && strings.Count(rel, "/")+1 >= depth {
+return fs.SkipDir
+}
+return nil
+})
+return entries, truncated, err
+}
+
+// Glob returns the files and directories below dir whose path relative to
+// it matches pattern, in which "**" matches any number of directories.
+// Limit bounds the entries, 500 when 0; the result reports whether there
+// were more.
+func (e *FileEditor) Glob(dir, pattern string, limit int) ([]Entry, bool, error) {
+if _, err := filepath.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+return nil, false, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+}
+given, root, err := e.resolveDir(dir)
+if err != nil {
+return nil, false, err
+}
+if limit <= 0 {
+limit = defaultListLimit
+}
+pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "./")
+var entries []Entry
+truncated := false
+err = e.walk(root, func(path, rel string, d fs.DirEntry) error {
+if !matchGlob(pattern, rel) {
+return nil
+}
+if len(entries) == limit {
+truncated = true
+return fs.SkipAll
+}
+entries = append(entries, entry(given, rel, d))
+return nil
+})
+return entries, truncated, err
+}
+
+// Search finds the lines of the files below opts.Path that match
+// opts.Pattern. Binary files, files larger than 4 MiB and what .gitignore
+// excludes are skipped. The result reports whether there were more matches
+// than opts.Limit.
+func (e *FileEditor) Search(opts SearchOptions) ([]Match, bool, error) {
+pattern := opts.Pattern
+if opts.IgnoreCase {
+pattern = "(?i)" + pattern
+}
+re, err := regexp.Compile(pattern)
+if err != nil {
+return nil, false, fmt.Errorf("invalid pattern %q: %v", opts.Pattern, err)
+}
+for _, g := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+if _, err := filepath.Match(strings.ReplaceAll(g, "**", "*"), ""); err != nil {
+return nil, false, fmt.Errorf("invalid glob %q: %v", g, err)
+}
+}
+limit := opts.Limit
+if limit <= 0 {
+limit = defaultSearchLimit
+}
+context := min(max(opts.Context, 0), maxSearchContext)
+
+given := opts.Path
+if given == "" {
+given = "."
+}
+root, err := e.resolve(given)
+if err != nil {
+return nil, false, err
+}
+info, err := os.Stat(root)
+if err != nil {
+return nil, false, err
+}
+
+var matches []Match
+truncated := false
+search := func(path, display string) error {
+found, more := searchFile(path, display, re, context, limit-len(matches))
+matches = append(matches, found...)
+if more {
+truncated = true
+return fs.SkipAll
+}
+return nil
+}
+if !info.IsDir() {
+err = search(root, given)
+if errors.Is(err, fs.SkipAll) {
+err = nil
+}
+return matches, truncated, err
+}
+
+err = e.walk(root, func(path, rel string, d fs.DirEntry) error {
+for _, g := range opts.Exclude {
+if matchName(g, rel) {
+if d.IsDir() {
+return fs.SkipDir
+}
+return nil
+}
+}
+if d.IsDir() {
+return nil
+}
+if len(opts.Include) > 0 {
+included := false
+for _, g := range opts.Include {
+included = included || matchName(g, rel)
+}
+if !included {
+return nil
+}
+}
+return search(path, filepath.Join(given, filepath.FromSlash(rel)))
+})
+return matches, truncated, err
+}
+
+// searchFile returns up to limit lines of the file at path that match re,
+// and whether there were more.
+func searchFile(path, display string, re *regexp.Regexp, context, limit int) ([]Match, bool) {
+info, err := os.Stat(path)
+if err != nil || !info.Mode().IsRegular() || info.Size() > maxSearchSize {
+return nil, false
+}
+data, err := os.ReadFile(path)
+if err != nil || bytes.IndexByte(data[:min(len(data), 8000)], 0) != -1 {
+return nil, false
+}
+lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+var hits []int
+more := false
+for i, l := range lines {
+if re.MatchString(strings.TrimSuffix(l, "\r")) {
+if len(hits) == limit {
+more = true
+break
+}
+hits = append(hits, i)
+}
+}
+
+matches := make([]Match, len(hits))
+// shown is the index of the last line shown with an earlier match
+shown := -1
+for n, i := range hits {
+m := Match{Path: display, Line: i + 1, Text: cutLine(lines[i])}
+for j := max(i-context, shown+1); j < i; j++ {
+m.Before = append(m.Before, cutLine(lines[j]))
+}
+end := min(i+context, len(lines)-1)
+if n+1 < len(hits) {
+end = min(end, hits[n+1]-1)
+}
+for j := i + 1; j <= end; j++ {
+m.After = append(m.After, cutLine(lines[j]))
+}
+shown = end
+matches[n] = m
+}
+return matches, more
+}
+
+// cutLine returns a line without its line ending, cut at maxMatchLine.
+func cutLine(line string) string {
+line = strings.TrimSuffix(line, "\r")
+if len(line) > maxMatchLine {
+return strings.ToValidUTF8(line[:maxMatchLine], "") + "..."
+}
+return line
+}
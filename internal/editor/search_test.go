@@ -0,0 +1,167 @@
+package editor
+
+import (
+"os"
+"path/filepath"
+"testing"
+
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+// searchTree creates a small repository for the search tests.
+func searchTree(t *testing.T) string {
+root := t.TempDir()
+files := map[string]string{
+".git/HEAD":             "ref: refs/heads/main\n",
+".gitignore":            "*.log\nbuild/\n!keep.log\n/top.txt\n",
+"main.go":               "package main\n\nfunc main() {\n\tprintln(\"hello\")\n}\n",
+"top.txt":               "hello\n",
+"keep.log":              "hello\n",
+"debug.log":             "hello\n",
+"build/out.go":          "hello\n",
+"pkg/util.go":           "package pkg\n\n// Hello says hello\nfunc Hello() {}\n",
+"pkg/top.txt":           "hello\n",
+"pkg/.gitignore":        "gen_*.go\n",
+"pkg/gen_x.go":          "hello\n",
+"pkg/sub/deep.md":       "nothing\n",
+"vendor/lib/lib.go":     "hello\n",
+"bin.dat":               "hello\x00world\n",
+}
+for name, content := range files {
+path := filepath.Join(root, filepath.FromSlash(name))
+require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+return root
+}
+
+func paths(entries []Entry) []string {
+var res []string
+for _, en := range entries {
+res = append(res, filepath.ToSlash(en.Path))
+}
+return res
+}
+
+func TestFileEditor_ListDir(t *testing.T) {
+root := searchTree(t)
+editor := NewFileEditor()
+
+entries, truncated, err := editor.ListDir(root, 1, 0)
+require.NoError(t, err)
+assert.False(t, truncated)
+rel := func(entries []Entry) []string {
+var res []string
+for _, p := range paths(entries) {
+r, _ := filepath.Rel(root, p)
+res = append(res, filepath.ToSlash(r))
+}
+return res
+}
+assert.Equal(t, []string{".gitignore", "bin.dat", "keep.log", "main.go", "pkg", "vendor"}, rel(entries))
+assert.True(t, entries[4].Dir)
+assert.Equal(t, int64(12), entries[1].Size)
+
+entries, _, err = editor.ListDir(filepath.Join(root, "pkg"), 2, 0)
+require.NoError(t, err)
+assert.Equal(t, []string{"pkg/.gitignore", "pkg/sub", "pkg/sub/deep.md", "pkg/top.txt", "pkg/util.go"}, rel(entries))
+
+entries, truncated, err = editor.ListDir(root, 1, 2)
+require.NoError(t, err)
+assert.True(t, truncated)
+assert.Len(t, entries, 2)
+
+_, _, err = editor.ListDir(filepath.Join(root, "main.go"), 1, 0)
+assert.ErrorContains(t, err, "is not a directory")
+}
+
+func TestFileEditor_Glob(t *testing.T) {
+root := searchTree(t)
+editor := NewFileEditor().Confined(&Workspace{Roots: []string{root}})
+
+entries, _, err := editor.Glob(".", "**/*.go", 0)
+require.NoError(t, err)
+assert.Equal(t, []string{"main.go", "pkg/util.go", "vendor/lib/lib.go"}, paths(entries))
+
+entries, _, err = editor.Glob("pkg", "*", 0)
+require.NoError(t, err)
+assert.Equal(t, []string{"pkg/.gitignore", "pkg/sub", "pkg/top.txt", "pkg/util.go"}, paths(entries))
+
+entries, truncated, err := editor.Glob(".", "**", 3)
+require.NoError(t, err)
+assert.True(t, truncated)
+assert.Len(t, entries, 3)
+
+_, _, err = editor.Glob("..", "*", 0)
+assert.ErrorIs(t, err, ErrAccessDenied)
+}
+
+func TestFileEditor_Search(t *testing.T) {
+root := searchTree(t)
+require.NoError(t, os.WriteFile(filepath.Join(root, ".env"), []byte("hello=secret\n"), 0644))
+editor := NewFileEditor().Confined(&Workspace{Roots: []string{root}})
+
+t.Run("Gitignore", func(t *testing.T) {
+matches, truncated, err := editor.Search(SearchOptions{Pattern: "hello"})
+require.NoError(t, err)
+assert.False(t, truncated)
+var found []string
+for _, m := range matches {
+found = append(found, filepath.ToSlash(m.Path))
+}
+// Ignored, binary and denied files are not searched
+assert.Equal(t, []string{"keep.log", "main.go", "pkg/top.txt", "pkg/util.go", "vendor/lib/lib.go"}, found)
+})
+
+t.Run("Include And Exclude", func(t *testing.T) {
+matches, _, err := editor.Search(SearchOptions{Pattern: "HELLO", IgnoreCase: true, Include: []string{"*.go"}, Exclude: []string{"vendor"}})
+require.NoError(t, err)
+assert.Len(t, matches, 3)
+assert.Equal(t, Match{Path: "main.go", Line: 4, Text: "\tprintln(\"hello\")"}, matches[0])
+assert.Equal(t, "pkg/util.go", filepath.ToSlash(matches[1].Path))
+assert.Equal(t, 3, matches[1].Line)
+})
+
+t.Run("Context", func(t *testing.T) {
+matches, _, err := editor.Search(SearchOptions{Pattern: "package|func", Path: "main.go", Context: 2})
+require.NoError(t, err)
+assert.Equal(t, []Match{
+{Path: "main.go", Line: 1, Text: "package main", After: []string{""}},
+{Path: "main.go", Line: 3, Text: "func main() {", After: []string{"\tprintln(\"hello\")", "}"}},
+}, matches)
+})
+
+t.Run("Limit", func(t *testing.T) {
+matches, truncated, err := editor.Search(SearchOptions{Pattern: "hello", Limit: 2})
+require.NoError(t, err)
+assert.True(t, truncated)
+assert.Len(t, matches, 2)
+})
+
+t.Run("Errors", func(t *testing.T) {
+_, _, err := editor.Search(SearchOptions{Pattern: "("})
+assert.ErrorContains(t, err, `invalid pattern "("`)
+_, _, err = editor.Search(SearchOptions{Pattern: "x", Include: []string{"["}})
+assert.ErrorContains(t, err, `invalid glob "["`)
+_, _, err = editor.Search(SearchOptions{Pattern: "x", Path: ".env"})
+assert.ErrorIs(t, err, ErrAccessDenied)
+})
+}
+
+func TestMatchGlob(t *testing.T) {
+for _, tc := range []struct {
+pattern, name string
+want          bool
+}{
+{"*.go", "main.go", true},
+{"*.go", "pkg/main.go", false},
+{"**/*.go", "main.go", true},
+{"**/*.go", "a/b/main.go", true},
+{"pkg/**", "pkg/a/b", true},
+{"pkg/**/x.go", "pkg/x.go", true},
+{"pkg/**/x.go", "other/x.go", false},
+} {
+assert.Equal(t, tc.want, matchGlob(tc.pattern, tc.name), "%s %s", tc.pattern, tc.name)
+}
+}
@@ -0,0 +1,186 @@
+package editor
+
+import (
+"errors"
+"fmt"
+"os"
+"path/filepath"
+"strings"
+)
+
+// ErrAccessDenied is returned for a path outside the workspace or one that
+// matches a deny pattern.
+var ErrAccessDenied = errors.New("access denied")
+
+// DefaultDeny are the patterns of files with secrets that the file tools
+// may not access.
+var DefaultDeny = []string{
+".env",
+".env.*",
+"*.pem",
+"*.key",
+"*.p12",
+"*.pfx",
+"id_rsa*",
+"id_ecdsa*",
+"id_ed25519*",
+".ssh",
+".aws",
+".gnupg",
+".netrc",
+}
+
+// Workspace confines file access to directory trees.
+type Workspace struct {
+// Roots are the directories files may be in. Relative paths are
+// resolved against the first.
+Roots []string `yaml:"roots,omitempty"`
+// Deny are glob patterns of files that may not be accessed within the
+// roots either. A pattern without a slash matches any name in the
+// path, so that ".ssh" also denies the files in it; one with a slash
+// matches the path relative to the root. When nil, DefaultDeny is used.
+Deny []string `yaml:"deny,omitempty"`
+}
+
+// Validate checks that the roots are absolute and the patterns valid.
+func (w Workspace) Validate() error {
+for _, r := range w.Roots {
+if !filepath.IsAbs(r) {
+return fmt.Errorf("root %q is not absolute", r)
+}
+}
+for _, p := range w.Deny {
+if _, err := filepath.Match(p, ""); err != nil {
+return fmt.Errorf("invalid deny pattern %q: %v", p, err)
+}
+}
+return nil
+}
+
+// Resolve returns the absolute path, with symlinks and ".." resolved, of
+// a path in the workspace. It fails with ErrAccessDenied if the path is
+// outside every root or matches a deny pattern.
+func (w *Workspace) Resolve(path string) (string, error) {
+if len(w.Roots) == 0 {
+return "", fmt.Errorf("%w: no workspace is set", ErrAccessDenied)
+}
+if path == "" {
+return "", fmt.Errorf("empty path")
+}
+given := path
+if path == "~" || strings.HasPrefix(path, "~/") {
+if home, err := os.UserHomeDir(); err == nil {
+path = filepath.Join(home, path[1:])
+}
+}
+if !filepath.IsAbs(path) {
+path = filepath.Join(w.Roots[0], path)
+}
+real, err := realPath(path)
+if err != nil {
+return "", err
+}
+
+deny := w.Deny
+if deny == nil {
+deny = DefaultDeny
+}
+for _, root := range w.Roots {
+realRoot, err := realPath(root)
+if err != nil {
+continue
+}
+rel, err := filepath.Rel(realRoot, real)
+if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+continue
+}
+if p := denied(rel, deny); p != "" {
+return "", fmt.Errorf("%w: %s matches the deny pattern %q", ErrAccessDenied, given, p)
+}
+return real, nil
+}
+if real != filepath.Clean(path) {
+given = fmt.Sprintf("%s (a link to %s)", given, real)
+}
+return "", fmt.Errorf("%w: %s is outside the workspace %s", ErrAccessDenied, given, strings.Join(w.Roots, ", "))
+}
+
+// allowed returns a function that reports whether a resolved path is in
+// the workspace and matches no deny pattern, for checking many paths.
+func (w *Workspace) allowed() func(real string) bool {
+var roots []string
+for _, root := range w.Roots {
+if real, err := realPath(root); err == nil {
+roots = append(roots, real)
+}
+}
+deny := w.Deny
+if deny == nil {
+deny = DefaultDeny
+}
+return func(real string) bool {
+for _, root := range roots {
+rel, err := filepath.Rel(root, real)
+if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+continue
+}
+return denied(rel, deny) == ""
+}
+return false
+}
+}
+
+// maxLinks bounds the symlinks followed to resolve a path.
+const maxLinks = 40
+
+// realPath resolves the symlinks of the longest part of path that exists,
+// so that files yet to be created resolve too. A symlink to a file that
+// does not exist resolves to that file.
+func realPath(path string) (string, error) {
+path = filepath.Clean(path)
+var rest []string
+for links := 0; ; {
+real, err := filepath.EvalSymlinks(path)
+if err == nil {
+return filepath.Join(append([]string{real}, rest...)...), nil
+}
+if !os.IsNotExist(err) {
+return "", err
+}
+if target, err := os.Readlink(path); err == nil {
+if links++; links > maxLinks {
+return "", fmt.Errorf("too many links in %s", path)
+}
+if !filepath.IsAbs(target) {
+target = filepath.Join(filepath.Dir(path), target)
+}
+path = filepath.Clean(target)
+continue
+}
+parent := filepath.Dir(path)
+if parent == path {
+return filepath.Join(append([]string{path}, rest...)...), nil
+}
+rest = append([]string{filepath.Base(path)}, rest...)
+path = parent
+}
+}
+
+// denied returns the first pattern of deny that matches rel, or "".
+func denied(rel string, deny []string) string {
+names := strings.Split(rel, string(filepath.Separator))
+for _, p := range deny {
+if strings.Contains(p, "/") {
+if ok, _ := filepath.Match(filepath.FromSlash(p), rel); ok {
+return p
+}
+continue
+}
+for _, name := range names {
+if ok, _ := filepath.Match(p, name); ok {
+return p
+}
+}
+}
+return ""
+}
@@ -0,0 +1,93 @@
+package editor
+
+import (
+"os"
+"path/filepath"
+"testing"
+
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+func TestWorkspace_Resolve(t *testing.T) {
+root := t.TempDir()
+shared := t.TempDir()
+outside := t.TempDir()
+require.NoError(t, os.MkdirAll(filepath.Join(root, "src"), 0755))
+require.NoError(t, os.WriteFile(filepath.Join(root, "src", "main.go"), []byte("package main\n"), 0644))
+require.NoError(t, os.Symlink(outside, filepath.Join(root, "escape")))
+require.NoError(t, os.Symlink(filepath.Join(outside, "new.txt"), filepath.Join(root, "dangling")))
+require.NoError(t, os.Symlink(filepath.Join(root, "src"), filepath.Join(root, "inner")))
+ws := &Workspace{Roots: []string{root, shared}}
+
+for _, tc := range []struct {
+path, want string
+}{
+{"src/main.go", filepath.Join(root, "src", "main.go")},
+{"src/../new/file.txt", filepath.Join(root, "new", "file.txt")},
+{"inner/main.go", filepath.Join(root, "src", "main.go")},
+{filepath.Join(shared, "notes.md"), filepath.Join(shared, "notes.md")},
+} {
+got, err := ws.Resolve(tc.path)
+assert.NoError(t, err, tc.path)
+assert.Equal(t, tc.want, got, tc.path)
+}
+
+for _, path := range []string{
+"../x",
+"src/../../x",
+"/etc/passwd",
+"escape/file.txt",
+"dangling",
+filepath.Join(outside, "file.txt"),
+} {
+_, err := ws.Resolve(path)
+assert.ErrorIs(t, err, ErrAccessDenied, path)
+assert.ErrorContains(t, err, "is outside the workspace", path)
+}
+
+for path, pattern := range map[string]string{
+".env":                 ".env",
+"config/.env.local":    ".env.*",
+"certs/server.pem":     "*.pem",
+".ssh/authorized_keys": ".ssh",
+} {
+_, err := ws.Resolve(path)
+assert.ErrorIs(t, err, ErrAccessDenied, path)
+assert.ErrorContains(t, err, "matches the deny pattern \""+pattern+"\"", path)
+}
+
+ws.Deny = []string{"secrets/*"}
+_, err := ws.Resolve("secrets/token")
+assert.ErrorContains(t, err, `matches the deny pattern "secrets/*"`)
+_, err = ws.Resolve(".env")
+assert.NoError(t, err)
+}
+
+func TestFileEditor_Confined(t *testing.T) {
+root := t.TempDir()
+outside := filepath.Join(t.TempDir(), "secret.txt")
+require.NoError(t, os.WriteFile(outside, []byte("secret\n"), 0644))
+editor := NewFileEditor().Confined(&Workspace{Roots: []string{root}})
+
+c, err := editor.WriteFile("notes.txt", "hello\n", WriteCreate, false)
+require.NoError(t, err)
+assert.Equal(t, filepath.Join(root, "notes.txt"), c.Path)
+lines, err := editor.ReadLines("notes.txt", 1, 0)
+require.NoError(t, err)
+assert.Equal(t, []string{"hello"}, lines)
+
+_, err = editor.ReadLines(outside, 1, 0)
+assert.ErrorIs(t, err, ErrAccessDenied)
+_, err = editor.Replace(outside, "secret", "x", false)
+assert.ErrorIs(t, err, ErrAccessDenied)
+_, err = editor.InsertLines(outside, 1, "x", false)
+assert.ErrorIs(t, err, ErrAccessDenied)
+_, err = editor.DeleteLines(outside, 1, 1, false)
+assert.ErrorIs(t, err, ErrAccessDenied)
+_, err = editor.ApplyPatch("--- a/../x.txt\n+++ b/../x.txt\n@@ -1 +1 @@\n-a\n+b\n", false)
+assert.ErrorIs(t, err, ErrAccessDenied)
+
+content, _ := os.ReadFile(outside)
+assert.Equal(t, "secret\n", string(content))
+}
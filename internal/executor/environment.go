@@ -0,0 +1,76 @@
+package executor
+
+import (
+"path"
+"sort"
+"strings"
+)
+
+// DefaultEnvDeny matches the names of variables that usually hold secrets.
+var DefaultEnvDeny = []string{"*_KEY", "*_TOKEN", "*SECRET*", "*PASSWORD*", "*PASSWD*", "*_CREDENTIALS"}
+
+// Environment selects the variables shells inherit from the daemon and
+// adds variables of their own.
+type Environment struct {
+// Allow lists the names of inherited variables to keep, with "*" and "?"
+// as wildcards. When empty, every variable not denied is kept.
+Allow []string `yaml:"allow,omitempty"`
+// Deny lists the names of inherited variables to drop. When unset,
+// DefaultEnvDeny is used; an empty list drops nothing.
+Deny []string `yaml:"deny,omitempty"`
+// Set adds variables, replacing inherited ones of the same name.
+Set map[string]string `yaml:"set,omitempty"`
+}
+
+// Environ filters base, a list of NAME=VALUE entries as returned by
+// os.Environ, and adds the variables of Set. The result is sorted by name.
+func (e Environment) Environ(base []string) []string {
+deny := e.Deny
+if deny == nil {
+deny = DefaultEnvDeny
+}
+vars := make(map[string]string)
+for _, kv := range base {
+name, value, ok := strings.Cut(kv, "=")
+if !ok || name == "" {
+continue
+}
+if len(e.Allow) > 0 && !matchName(e.Allow, name) {
+continue
+}
+if matchName(deny, name) {
+continue
+}
+vars[name] = value
+}
+for name, value := range e.Set {
+vars[name] = value
+}
+
+env := make([]string, 0, len(vars))
+for name, value := range vars {
+env = append(env, name+"="+value)
+}
+sort.Strings(env)
+return env
+}
+
+func matchName(patterns []string, name string) bool {
+for _, p := range patterns {
+if ok, _ := path.Match(p, name); ok {
+return true
+}
+}
+return false
+}
+
+// envMap turns NAME=VALUE entries into a map.
+func envMap(env []string) map[string]string {
+m := make(map[string]string, len(env))
+for _, kv := range env {
+if name, value, ok := strings.Cut(kv, "="); ok && name != "" {
+m[name] = value
+}
+}
+return m
+}
@@ -0,0 +1,76 @@
+package executor
+
+import (
+"context"
+"os"
+"path/filepath"
+"testing"
+
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+func TestEnvironment_Environ(t *testing.T) {
+base := []string{"PATH=/bin", "HOME=/root", "GEMINI_API_KEY=k", "GITHUB_TOKEN=t", "DB_PASSWORD=p", "LANG=C", "EQ=a=b", "broken"}
+
+t.Run("default deny", func(t *testing.T) {
+assert.Equal(t, []string{"EQ=a=b", "HOME=/root", "LANG=C", "PATH=/bin"}, Environment{}.Environ(base))
+})
+
+t.Run("allow", func(t *testing.T) {
+env := Environment{Allow: []string{"PATH", "L*", "GEMINI_*"}}.Environ(base)
+assert.Equal(t, []string{"LANG=C", "PATH=/bin"}, env)
+})
+
+t.Run("empty deny keeps everything", func(t *testing.T) {
+env := Environment{Deny: []string{}}.Environ(base)
+assert.Len(t, env, 7)
+})
+
+t.Run("set", func(t *testing.T) {
+env := Environment{Deny: []string{"HOME"}, Set: map[string]string{"PATH": "/opt/bin", "CI": "1"}}.Environ(base)
+assert.Contains(t, env, "PATH=/opt/bin")
+assert.Contains(t, env, "CI=1")
+assert.NotContains(t, env, "HOME=/root")
+assert.NotContains(t, env, "PATH=/bin")
+})
+}
+
+func TestShellSession_Config(t *testing.T) {
+dir, err := filepath.EvalSymlinks(t.TempDir())
+require.NoError(t, err)
+m := NewSessionManager()
+defer m.Cleanup()
+m.Config = func(id string) SessionConfig {
+return SessionConfig{Dir: dir, Env: []string{"PATH=" + os.Getenv("PATH"), "PROJECT=" + id}}
+}
+
+info := m.Info("proj")
+assert.False(t, info.Running)
+assert.Equal(t, dir, info.Dir)
+assert.Equal(t, "proj", info.Env["PROJECT"])
+
+s, err := m.GetOrCreate("proj")
+if err != nil {
+t.Skip("PTY not available")
+}
+res, err := s.Execute(context.Background(), "pwd; echo $PROJECT; echo ${HOME:-unset}")
+require.NoError(t, err)
+assert.Equal(t, dir+"\nproj\nunset", res.Output)
+
+_, err = s.Execute(context.Background(), "mkdir sub && cd sub && export STAGE=build")
+require.NoError(t, err)
+info = m.Info("proj")
+assert.True(t, info.Running)
+assert.False(t, info.Busy)
+assert.NotZero(t, info.PID)
+assert.Equal(t, filepath.Join(dir, "sub"), info.Dir)
+assert.Equal(t, "build", info.Env["STAGE"])
+assert.Equal(t, "proj", info.Env["PROJECT"])
+
+// Commands with separate stderr use the same environment
+e := &ShellExecutor{Manager: m}
+out, err := e.Execute(context.Background(), "proj", Command{Line: "echo $PROJECT; pwd", SeparateStderr: true})
+require.NoError(t, err)
+assert.Equal(t, "proj\n"+filepath.Join(dir, "sub"), out.Output)
+}
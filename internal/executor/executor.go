@@ -1,56 +1,172 @@
 package executor
 
 import (
+"context"
+"errors"
 "fmt"
 "log/slog"
+"regexp"
 "strings"
+"time"
+
+"github.com/LeeroyDing/hyperagent/internal/policy"
 )
 
+// ShellProgram is the shell that runs commands, in sessions and piped.
+const ShellProgram = "bash"
+
 type Executor interface {
-	Execute(sessionID, command string) (string, error)
+	Execute(ctx context.Context, sessionID string, cmd Command) (Result, error)
+}
+
+// Timeouts bounds the run time of a single command.
+type Timeouts struct {
+// Default applies to commands that do not request a timeout.
+Default time.Duration `yaml:"default"`
+// Max caps the timeout a command may request.
+Max time.Duration `yaml:"max"`
+}
+
+// DefaultTimeouts returns the timeouts used when none are configured.
+func DefaultTimeouts() Timeouts {
+return Timeouts{
+Default: 2 * time.Minute,
+Max:     30 * time.Minute,
+}
+}
+
+func (t Timeouts) withDefaults() Timeouts {
+def := DefaultTimeouts()
+if t.Default <= 0 {
+t.Default = def.Default
+}
+if t.Max <= 0 {
+t.Max = def.Max
+}
+if t.Default > t.Max {
+t.Default = t.Max
+}
+return t
+}
+
+// timeout returns the timeout for a command that requested one, zero
+// meaning none, and whether the request was capped.
+func (t Timeouts) timeout(requested time.Duration) (time.Duration, bool) {
+t = t.withDefaults()
+if requested <= 0 {
+return t.Default, false
+}
+if requested > t.Max {
+return t.Max, true
+}
+return requested, false
+}
+
+// workingDirer is implemented by shells that can report their current directory.
+type workingDirer interface {
+WorkingDir() (string, error)
 }
 
 type ShellExecutor struct {
-Allowlist []string
-Manager   *SessionManager
+// Policy decides which commands may run. A nil policy allows everything.
+Policy   *policy.Policy
+Manager  *SessionManager
+Timeouts Timeouts
+// Prompts recognize a command waiting for input, which then keeps
+// running in the background instead of timing out.
+Prompts []*regexp.Regexp
 }
 
+// NewShellExecutor returns an executor that only runs the programs in
+// allowlist, or any program when it is empty.
 func NewShellExecutor(allowlist []string) *ShellExecutor {
+// The default patterns always compile
+prompts, _ := CompilePrompts(nil)
 return &ShellExecutor{
-Allowlist: allowlist,
-Manager:   NewSessionManager(),
+Policy:   policy.FromAllowlist(allowlist),
+Manager:  NewSessionManager(),
+Timeouts: DefaultTimeouts(),
+Prompts:  prompts,
 }
 }
 
-func (e *ShellExecutor) Execute(sessionID, command string) (string, error) {
-cmdParts := strings.Fields(command)
-if len(cmdParts) == 0 {
-return "", fmt.Errorf("empty command")
+func (e *ShellExecutor) Execute(ctx context.Context, sessionID string, cmd Command) (Result, error) {
+if strings.TrimSpace(cmd.Line) == "" {
+return Result{}, fmt.Errorf("empty command")
 }
-
-baseCmd := cmdParts[0]
-if len(e.Allowlist) > 0 {
-allowed := false
-for _, a := range e.Allowlist {
-if baseCmd == a {
-allowed = true
-break
+if cmd.Background && cmd.SeparateStderr {
+return Result{}, fmt.Errorf("background commands run in the terminal and cannot capture stderr separately")
 }
+
+// Relative paths in the command are relative to the shell's directory
+dir := e.Manager.Dir(sessionID)
+if err := e.Policy.Check(cmd.Line, dir); err != nil {
+slog.Warn("Command blocked by policy", "session", sessionID, "command", cmd.Line, "reason", err)
+return Result{}, err
 }
-if !allowed {
-slog.Warn("Command blocked by allowlist", "command", baseCmd)
-return "", fmt.Errorf("command '%s' is not in the allowlist", baseCmd)
+
+session, restarted, err := e.Manager.acquire(sessionID)
+if err != nil {
+return Result{}, fmt.Errorf("failed to get shell session: %v", err)
 }
+defer e.Manager.touch(sessionID)
+if restarted != "" {
+// The new shell starts in its configured directory
+dir = e.Manager.Dir(sessionID)
 }
 
-slog.Debug("Executing shell command in session", "session", sessionID, "command", command)
+slog.Debug("Executing shell command in session", "session", sessionID, "command", cmd.Line)
 
-session, err := e.Manager.GetOrCreate(sessionID)
-if err != nil {
-return "", fmt.Errorf("failed to get shell session: %v", err)
+timeout, capped := e.Timeouts.timeout(cmd.Timeout)
+cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+defer cancel()
+
+var res Result
+js, isJobShell := session.(jobShell)
+switch {
+case cmd.SeparateStderr:
+// Run next to the shell so that relative paths mean the same thing
+start := time.Now()
+res, err = runPiped(cmdCtx, e.Manager.configFor(sessionID), dir, cmd.Line)
+res.Duration = time.Since(start)
+case cmd.Background && isJobShell:
+res, err = e.startBackground(ctx, js, cmd.Line)
+case cmd.Background:
+return Result{}, fmt.Errorf("the shell of this session cannot run background commands")
+case isJobShell:
+res, err = js.Run(cmdCtx, cmd.Line, e.Prompts)
+default:
+res, err = session.Execute(cmdCtx, cmd.Line)
+}
+res.Restarted = restarted
+
+switch {
+case err == nil:
+return res, nil
+case errors.Is(err, ErrJobRunning):
+return res, fmt.Errorf("%v. Follow it with read_output, answer it with send_input or stop it by sending ctrl-c before running another command", err)
+case errors.Is(err, ErrSessionClosed):
+// Start over with a fresh shell on the next command
+e.Manager.Remove(sessionID)
+return res, fmt.Errorf("shell session ended, a new one will be started for the next command: %v", err)
+case ctx.Err() != nil:
+slog.Info("Command cancelled", "session", sessionID, "command", cmd.Line)
+return res, fmt.Errorf("command was cancelled and interrupted: %v", ctx.Err())
+case errors.Is(err, context.DeadlineExceeded):
+slog.Info("Command timed out", "session", sessionID, "command", cmd.Line, "timeout", timeout)
+reason := fmt.Sprintf("command timed out after %s and was interrupted", timeout)
+if capped {
+reason += fmt.Sprintf(" (the requested timeout exceeds the maximum of %s)", timeout)
+}
+return res, errors.New(reason)
+default:
+return res, err
+}
 }
 
-return session.Execute(command)
+// SessionDir returns the directory the session's commands run in.
+func (e *ShellExecutor) SessionDir(sessionID string) string {
+return e.Manager.Dir(sessionID)
 }
 
 func (e *ShellExecutor) Cleanup() {
@@ -1,7 +1,11 @@
 package executor
 
 import (
+"context"
+"io"
+"path/filepath"
 "testing"
+"time"
 
 "github.com/stretchr/testify/assert"
 )
@@ -18,3 +22,150 @@ s2, err := m.GetOrCreate("sess1")
 assert.NoError(t, err)
 assert.Equal(t, s1, s2)
 }
+
+func TestShellSession_Interrupt(t *testing.T) {
+s, err := NewShellSession()
+if err != nil {
+t.Skip("PTY not available")
+}
+defer s.Close()
+
+_, err = s.Execute(context.Background(), "export MARK=kept")
+assert.NoError(t, err)
+
+ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+defer cancel()
+start := time.Now()
+out, err := s.Execute(ctx, "echo started; sleep 30; echo finished")
+assert.ErrorIs(t, err, context.DeadlineExceeded)
+assert.Less(t, time.Since(start), 10*time.Second)
+assert.Contains(t, out.Output, "started")
+assert.NotContains(t, out.Output, "finished")
+assert.Equal(t, -1, out.ExitCode)
+
+// The same shell keeps running after the interrupt
+out, err = s.Execute(context.Background(), "echo $MARK")
+assert.NoError(t, err)
+assert.Equal(t, Result{Output: "kept", Duration: out.Duration}, out)
+}
+
+func TestShellSession_ExitStatus(t *testing.T) {
+s, err := NewShellSession()
+if err != nil {
+t.Skip("PTY not available")
+}
+defer s.Close()
+ctx := context.Background()
+
+tests := []struct {
+command string
+output  string
+code    int
+}{
+{"echo hello", "hello", 0},
+{"false", "", 1},
+{"(exit 3)", "", 3},
+{"echo out; echo err >&2; (exit 2)", "out\nerr", 2},
+{"printf no-newline", "no-newline", 0},
+{"sleep 0 &", "", 0},
+{"echo done # trailing comment", "done", 0},
+{"fi", "bash: syntax error near unexpected token `fi'", 2},
+{"echo __SENTINEL___:0; echo after", "after", 0},
+}
+for _, tt := range tests {
+t.Run(tt.command, func(t *testing.T) {
+res, err := s.Execute(ctx, tt.command)
+assert.NoError(t, err)
+assert.Equal(t, tt.code, res.ExitCode)
+if tt.command != "sleep 0 &" {
+assert.Equal(t, tt.output, res.Output)
+}
+assert.False(t, res.Truncated)
+})
+}
+}
+
+func TestShellSession_LastStatus(t *testing.T) {
+s, err := NewShellSession()
+if err != nil {
+t.Skip("PTY not available")
+}
+defer s.Close()
+ctx := context.Background()
+
+_, err = s.Execute(ctx, "export MARK=info; ls missing-file >/dev/null 2>&1")
+assert.NoError(t, err)
+// Reading the environment leaves $? and $_ of the last command
+assert.Equal(t, "info", s.Info().Env["MARK"])
+res, err := s.Execute(ctx, `echo "$? $_"`)
+assert.NoError(t, err)
+assert.Equal(t, "2 missing-file", res.Output)
+assert.Equal(t, 0, res.ExitCode)
+}
+
+func TestShellExecutor_SeparateStderr(t *testing.T) {
+e := NewShellExecutor(nil)
+defer e.Cleanup()
+ctx := context.Background()
+
+if _, err := e.Execute(ctx, "sess1", Command{Line: "cd /tmp"}); err != nil {
+t.Skip("PTY not available")
+}
+
+res, err := e.Execute(ctx, "sess1", Command{Line: "pwd; echo oops >&2; exit 4", SeparateStderr: true})
+assert.NoError(t, err)
+assert.True(t, res.SeparateStderr)
+assert.Equal(t, "/tmp", res.Output)
+assert.Equal(t, "oops", res.Stderr)
+assert.Equal(t, 4, res.ExitCode)
+
+start := time.Now()
+res, err = e.Execute(ctx, "sess1", Command{Line: "echo started; sleep 30", SeparateStderr: true, Timeout: 100 * time.Millisecond})
+assert.EqualError(t, err, "command timed out after 100ms and was interrupted")
+assert.Less(t, time.Since(start), 10*time.Second)
+assert.Equal(t, "started", res.Output)
+assert.Equal(t, -1, res.ExitCode)
+}
+
+func TestShellSession_Truncation(t *testing.T) {
+s, err := NewShellSession()
+if err != nil {
+t.Skip("PTY not available")
+}
+defer s.Close()
+
+// A single line longer than the capture limit still ends at the sentinel
+res, err := s.Execute(context.Background(), "head -c 1100000 /dev/zero | tr '\\0' a")
+assert.NoError(t, err)
+assert.True(t, res.Truncated)
+assert.Equal(t, 0, res.ExitCode)
+assert.Len(t, res.Output, maxCapture)
+}
+
+func TestShellSession_LateInput(t *testing.T) {
+s, err := NewShellSession()
+if err != nil {
+t.Skip("PTY not available")
+}
+defer s.Close()
+ctx := context.Background()
+ran := filepath.Join(t.TempDir(), "ran")
+
+j, err := s.Start("read -r a; echo \"answer=$a\"")
+assert.NoError(t, err)
+assert.ErrorContains(t, s.Send(j, "x\n__SENTINEL_go id\n"), `input must not contain "__SENTINEL_"`)
+assert.NoError(t, s.Send(j, "y\n"))
+_, err = j.wait(ctx, nil)
+assert.NoError(t, err)
+assert.Equal(t, "answer=y", j.take().Output)
+assert.ErrorIs(t, s.Send(j, "y\n"), ErrNoJob)
+
+// Input that reaches the terminal after the command ended, as when it
+// races with the sentinel, is not run by the shell
+_, err = io.WriteString(s.Pty, "touch "+ran+"\npartial")
+assert.NoError(t, err)
+res, err := s.Execute(ctx, "echo next")
+assert.NoError(t, err)
+assert.Equal(t, "next", res.Output)
+assert.NoFileExists(t, ran)
+}
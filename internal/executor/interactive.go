@@ -0,0 +1,163 @@
+package executor
+
+import (
+"context"
+"errors"
+"fmt"
+"regexp"
+"sort"
+"strings"
+"time"
+)
+
+// backgroundPeek is how long a background command is watched before the
+// call that started it returns, so that early output and failures show.
+const backgroundPeek = 2 * time.Second
+
+// Interactive is implemented by executors whose commands can keep running
+// in the background and take input.
+type Interactive interface {
+// SendInput types into the command running in the session and returns
+// its output once it ends, waits for input or in.Wait has passed.
+SendInput(ctx context.Context, sessionID string, in Input) (Result, error)
+// ReadOutput returns the output of the command running in the session
+// once it ends, waits for input or wait has passed.
+ReadOutput(ctx context.Context, sessionID string, wait time.Duration) (Result, error)
+}
+
+// Input is what to type into a running command.
+type Input struct {
+Text string
+// Key is pressed after Text, Enter when empty. See Keys.
+Key  string
+Wait time.Duration
+}
+
+// Keys are the keys Input can press by name.
+var Keys = map[string]string{
+"enter":  "\r",
+"none":   "",
+"ctrl-c": "\x03",
+"ctrl-d": "\x04",
+"ctrl-z": "\x1a",
+"tab":    "\t",
+"escape": "\x1b",
+"up":     "\x1b[A",
+"down":   "\x1b[B",
+}
+
+// KeyNames returns the names of Keys in order.
+func KeyNames() []string {
+names := make([]string, 0, len(Keys))
+for name := range Keys {
+names = append(names, name)
+}
+sort.Strings(names)
+return names
+}
+
+// jobShell is implemented by shells that run commands as jobs, which can
+// outlive the call that started them and take input.
+type jobShell interface {
+Run(ctx context.Context, command string, prompts []*regexp.Regexp) (Result, error)
+Start(command string) (*Job, error)
+Job() *Job
+Send(j *Job, input string) error
+}
+
+// startBackground starts line and returns its first output.
+func (e *ShellExecutor) startBackground(ctx context.Context, js jobShell, line string) (Result, error) {
+j, err := js.Start(line)
+if err != nil {
+return Result{}, err
+}
+peek, cancel := context.WithTimeout(ctx, backgroundPeek)
+defer cancel()
+return e.follow(peek, j)
+}
+
+// follow waits until j ends, waits for input or ctx is done, and returns
+// the output not read yet.
+func (e *ShellExecutor) follow(ctx context.Context, j *Job) (Result, error) {
+prompt, err := j.wait(ctx, e.Prompts)
+res := j.take()
+res.Prompt = prompt
+if err != nil && ctx.Err() != context.DeadlineExceeded {
+return res, fmt.Errorf("stopped waiting for the command: %v", err)
+}
+if jerr := j.failed(); jerr != nil {
+return res, fmt.Errorf("%w: %v", ErrSessionClosed, jerr)
+}
+return res, nil
+}
+
+// job returns the last job of the session's shell.
+func (e *ShellExecutor) job(sessionID string) (jobShell, *Job, error) {
+s, ok := e.Manager.lookup(sessionID)
+if !ok {
+return nil, nil, ErrNoJob
+}
+js, ok := s.(jobShell)
+if !ok {
+return nil, nil, fmt.Errorf("the shell of this session cannot run background commands")
+}
+j := js.Job()
+if j == nil {
+return nil, nil, ErrNoJob
+}
+return js, j, nil
+}
+
+// wait returns how long a call that asked for wait may block.
+func (e *ShellExecutor) wait(wait time.Duration) time.Duration {
+if max := e.Timeouts.withDefaults().Max; wait > max {
+return max
+}
+return wait
+}
+
+func (e *ShellExecutor) SendInput(ctx context.Context, sessionID string, in Input) (Result, error) {
+key := in.Key
+if key == "" {
+key = "enter"
+}
+seq, ok := Keys[key]
+if !ok {
+return Result{}, fmt.Errorf("unknown key %q, known keys are %s", key, strings.Join(KeyNames(), ", "))
+}
+js, j, err := e.job(sessionID)
+if err != nil {
+return Result{}, err
+}
+defer e.Manager.touch(sessionID)
+if err := js.Send(j, in.Text+seq); err != nil {
+return Result{}, err
+}
+
+// Let the command react even when asked not to wait
+wait := max(e.wait(in.Wait), promptQuiet)
+waitCtx, cancel := context.WithTimeout(ctx, wait)
+defer cancel()
+return e.finishFollow(waitCtx, sessionID, j)
+}
+
+func (e *ShellExecutor) ReadOutput(ctx context.Context, sessionID string, wait time.Duration) (Result, error) {
+_, j, err := e.job(sessionID)
+if err != nil {
+return Result{}, err
+}
+defer e.Manager.touch(sessionID)
+waitCtx, cancel := context.WithTimeout(ctx, e.wait(wait))
+defer cancel()
+return e.finishFollow(waitCtx, sessionID, j)
+}
+
+// finishFollow follows j and replaces the shell if its terminal failed.
+func (e *ShellExecutor) finishFollow(ctx context.Context, sessionID string, j *Job) (Result, error) {
+res, err := e.follow(ctx, j)
+if errors.Is(err, ErrSessionClosed) {
+e.Manager.Remove(sessionID)
+return res, fmt.Errorf("shell session ended, a new one will be started for the next command: %v", err)
+}
+return res, err
+}
@@ -0,0 +1,77 @@
+package executor
+
+import (
+"context"
+"testing"
+"time"
+
+"github.com/stretchr/testify/assert"
+)
+
+func TestShellExecutor_Interactive(t *testing.T) {
+e := NewShellExecutor(nil)
+defer e.Cleanup()
+ctx := context.Background()
+
+if _, err := e.Execute(ctx, "sess1", Command{Line: "true"}); err != nil {
+t.Skip("PTY not available")
+}
+
+t.Run("Prompt", func(t *testing.T) {
+res, err := e.Execute(ctx, "sess1", Command{Line: "read -r -p 'Overwrite config? [y/N] ' a; echo \"answer=$a\"", Timeout: 10 * time.Second})
+assert.NoError(t, err)
+assert.True(t, res.Running)
+assert.Equal(t, "Overwrite config? [y/N]", res.Prompt)
+assert.Contains(t, res.Format(), "Exit code: none (still running)")
+assert.Contains(t, res.Format(), "The command is waiting for input (\"Overwrite config? [y/N]\"). Answer it with send_input.")
+
+_, err = e.Execute(ctx, "sess1", Command{Line: "ls"})
+assert.ErrorContains(t, err, "a command is still running in this shell: read -r -p")
+
+_, err = e.SendInput(ctx, "sess1", Input{Text: "y", Key: "f1"})
+assert.ErrorContains(t, err, `unknown key "f1", known keys are ctrl-c, ctrl-d`)
+
+res, err = e.SendInput(ctx, "sess1", Input{Text: "y", Wait: 5 * time.Second})
+assert.NoError(t, err)
+assert.False(t, res.Running)
+assert.Equal(t, "answer=y", res.Output)
+assert.Equal(t, 0, res.ExitCode)
+
+_, err = e.SendInput(ctx, "sess1", Input{Text: "y"})
+assert.ErrorIs(t, err, ErrNoJob)
+})
+
+t.Run("Background", func(t *testing.T) {
+res, err := e.Execute(ctx, "sess1", Command{Line: "echo tick 1; sleep 2.5; echo tick 2", Background: true})
+assert.NoError(t, err)
+assert.True(t, res.Running)
+assert.Equal(t, "tick 1", res.Output)
+assert.Contains(t, res.Format(), "The command is still running.")
+
+res, err = e.ReadOutput(ctx, "sess1", 0)
+assert.NoError(t, err)
+assert.True(t, res.Running)
+assert.Empty(t, res.Output)
+
+res, err = e.ReadOutput(ctx, "sess1", 10*time.Second)
+assert.NoError(t, err)
+assert.False(t, res.Running)
+assert.Equal(t, "tick 2", res.Output)
+assert.Equal(t, 0, res.ExitCode)
+
+_, err = e.Execute(ctx, "sess1", Command{Line: "ls", Background: true, SeparateStderr: true})
+assert.Error(t, err)
+})
+
+t.Run("Stop", func(t *testing.T) {
+_, err := e.Execute(ctx, "sess1", Command{Line: "sleep 30", Background: true})
+assert.NoError(t, err)
+res, err := e.SendInput(ctx, "sess1", Input{Key: "ctrl-c", Wait: 5 * time.Second})
+assert.NoError(t, err)
+assert.False(t, res.Running)
+assert.Equal(t, 130, res.ExitCode)
+})
+
+_, err := e.ReadOutput(ctx, "other", 0)
+assert.ErrorIs(t, err, ErrNoJob)
+}
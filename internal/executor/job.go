@@ -0,0 +1,213 @@
+package executor
+
+import (
+"context"
+"fmt"
+"regexp"
+"strings"
+"sync"
+"time"
+)
+
+// promptQuiet is how long the output must pause before a last line that
+// looks like a prompt is taken to mean the command waits for input.
+const promptQuiet = 300 * time.Millisecond
+
+// sentinelMarker starts the line the shell prints when a command ends. It
+// also names the shell variable holding the ID of the running command, so
+// that the command line is recognized too should the terminal echo it.
+const sentinelMarker = "__SENTINEL_"
+
+// DefaultPrompts are the patterns that recognize a command waiting for
+// input by the last line of its output.
+var DefaultPrompts = []string{
+`(?i)(password|passphrase|passcode)[^:\n]*:\s*$`,
+`(?i)\((yes/no|y/n)[^)]*\)\s*[:?]?\s*$`,
+`(?i)\[(y/n|yes/no)\]\s*[:?]?\s*$`,
+`(?i)(continue|proceed|overwrite|replace)\?\s*$`,
+`^(>>>|\.\.\.|In \[\d+\]:|>|irb\([^)]*\)[^>]*>|mysql>|postgres=[#>]|sqlite>)\s?$`,
+}
+
+// CompilePrompts compiles prompt patterns. Nil patterns mean DefaultPrompts.
+func CompilePrompts(patterns []string) ([]*regexp.Regexp, error) {
+if patterns == nil {
+patterns = DefaultPrompts
+}
+res := make([]*regexp.Regexp, 0, len(patterns))
+for _, p := range patterns {
+re, err := regexp.Compile(p)
+if err != nil {
+return nil, fmt.Errorf("invalid prompt pattern %q: %v", p, err)
+}
+res = append(res, re)
+}
+return res, nil
+}
+
+// Job is a command running in the terminal of a shell. Its output is
+// collected while it runs, so that it can be read in parts.
+type Job struct {
+Command string
+Started time.Time
+
+id        string
+sentinel  string
+startLine string
+// begun is closed once the shell has read the command and runs it
+begun     chan struct{}
+beginOnce sync.Once
+
+mu sync.Mutex
+// out is the output not read yet
+out *limitedBuffer
+// partial is the last line of output until it ends
+partial    []byte
+lastOutput time.Time
+finished   bool
+exitCode   int
+// err is set when the terminal failed before the command ended
+err     error
+changed chan struct{}
+done    chan struct{}
+}
+
+func newJob(id, command string) *Job {
+return &Job{
+Command:  command,
+Started:  time.Now(),
+id:       id,
+sentinel:  sentinelMarker + id + "__:",
+startLine: sentinelMarker + id + "__start",
+begun:     make(chan struct{}),
+out:      &limitedBuffer{limit: maxCapture},
+exitCode: -1,
+changed:  make(chan struct{}, 1),
+done:     make(chan struct{}),
+}
+}
+
+// Done reports whether the command has ended.
+func (j *Job) Done() bool {
+select {
+case <-j.done:
+return true
+default:
+return false
+}
+}
+
+// write adds output of the command.
+func (j *Job) write(p []byte) {
+if len(p) == 0 {
+return
+}
+j.mu.Lock()
+j.out.Write(p)
+if i := strings.LastIndexByte(string(p), '\n'); i != -1 {
+j.partial = append(j.partial[:0], p[i+1:]...)
+} else {
+j.partial = append(j.partial, p...)
+}
+j.lastOutput = time.Now()
+j.mu.Unlock()
+select {
+case j.changed <- struct{}{}:
+default:
+}
+}
+
+// begin records that the shell runs the command.
+func (j *Job) begin() {
+j.beginOnce.Do(func() { close(j.begun) })
+}
+
+// waitBegun waits until the shell runs the command, so that input is not
+// read by the shell instead.
+func (j *Job) waitBegun() error {
+select {
+case <-j.begun:
+return nil
+case <-time.After(resyncTimeout):
+return fmt.Errorf("the shell did not start the command within %s", resyncTimeout)
+}
+}
+
+// finish records how the command ended.
+func (j *Job) finish(code int, err error) {
+j.begin()
+j.mu.Lock()
+j.finished = true
+j.exitCode = code
+j.err = err
+j.mu.Unlock()
+close(j.done)
+}
+
+// failed returns the error of the terminal, if it failed.
+func (j *Job) failed() error {
+j.mu.Lock()
+defer j.mu.Unlock()
+return j.err
+}
+
+// take returns the output not read yet and the state of the command.
+func (j *Job) take() Result {
+j.mu.Lock()
+defer j.mu.Unlock()
+res := Result{
+Output:    j.out.String(),
+ExitCode:  j.exitCode,
+Truncated: j.out.truncated,
+Running:   !j.finished,
+Duration:  time.Since(j.Started),
+}
+j.out = &limitedBuffer{limit: maxCapture}
+return res
+}
+
+// prompt returns the last line of output if it matches one of prompts.
+// When it matches but the output paused for less than promptQuiet, it
+// also returns how much longer to wait.
+func (j *Job) prompt(prompts []*regexp.Regexp) (string, time.Duration) {
+j.mu.Lock()
+defer j.mu.Unlock()
+line := strings.ReplaceAll(string(j.partial), "\r", "")
+for _, re := range prompts {
+if re.MatchString(line) {
+return strings.TrimSpace(line), promptQuiet - time.Since(j.lastOutput)
+}
+}
+return "", 0
+}
+
+// wait waits until the command ends, ctx is done or, when prompts are
+// given, the command waits for input. It returns the prompt in the latter
+// case and the context error when ctx is done first.
+func (j *Job) wait(ctx context.Context, prompts []*regexp.Regexp) (string, error) {
+for {
+if j.Done() {
+return "", nil
+}
+var quiet <-chan time.Time
+var t *time.Timer
+if line, remaining := j.prompt(prompts); line != "" {
+if remaining <= 0 {
+return line, nil
+}
+t = time.NewTimer(remaining)
+quiet = t.C
+}
+select {
+case <-j.done:
+case <-ctx.Done():
+if !j.Done() {
+return "", ctx.Err()
+}
+case <-j.changed:
+case <-quiet:
+}
+if t != nil {
+t.Stop()
+}
+}
+}
@@ -0,0 +1,132 @@
+package executor
+
+import (
+"context"
+"testing"
+"time"
+
+"github.com/stretchr/testify/assert"
+)
+
+func waitJob(t *testing.T, j *Job) {
+t.Helper()
+ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+defer cancel()
+_, err := j.wait(ctx, nil)
+assert.NoError(t, err)
+}
+
+func TestShellSession_Jobs(t *testing.T) {
+s, err := NewShellSession()
+if err != nil {
+t.Skip("PTY not available")
+}
+defer s.Close()
+ctx := context.Background()
+prompts, _ := CompilePrompts(nil)
+
+t.Run("Prompt", func(t *testing.T) {
+res, err := s.Run(ctx, "read -r -p 'Password: ' pw; echo \"got $pw\"", prompts)
+assert.NoError(t, err)
+assert.True(t, res.Running)
+assert.Equal(t, "Password:", res.Prompt)
+assert.Equal(t, -1, res.ExitCode)
+
+_, err = s.Start("echo other")
+assert.ErrorIs(t, err, ErrJobRunning)
+
+j := s.Job()
+assert.NoError(t, s.Send(j, "hunter2\r"))
+waitJob(t, j)
+res = j.take()
+assert.False(t, res.Running)
+assert.Equal(t, "got hunter2", res.Output)
+assert.Equal(t, 0, res.ExitCode)
+assert.ErrorIs(t, s.Send(j, "late\r"), ErrNoJob)
+})
+
+t.Run("Standard Input", func(t *testing.T) {
+j, err := s.Start("cat; echo done")
+assert.NoError(t, err)
+assert.NoError(t, s.Send(j, "hello\r"))
+assert.Eventually(t, func() bool {
+j.mu.Lock()
+defer j.mu.Unlock()
+return string(j.out.buf.Bytes()) != ""
+}, 5*time.Second, 10*time.Millisecond)
+assert.Equal(t, "hello", j.take().Output)
+assert.False(t, j.Done())
+
+assert.NoError(t, s.Send(j, "\x04"))
+waitJob(t, j)
+assert.Equal(t, Result{Output: "done", Duration: time.Second}, withDuration(j.take(), time.Second))
+})
+
+t.Run("Interrupt", func(t *testing.T) {
+j, err := s.Start("sleep 30; echo finished")
+assert.NoError(t, err)
+// Give bash time to hand the terminal to sleep, as a user would
+time.Sleep(200 * time.Millisecond)
+assert.NoError(t, s.Send(j, "\x03"))
+waitJob(t, j)
+res := j.take()
+assert.Equal(t, 130, res.ExitCode)
+assert.NotContains(t, res.Output, "finished")
+
+out, err := s.Execute(ctx, "echo ok")
+assert.NoError(t, err)
+assert.Equal(t, "ok", out.Output)
+})
+
+t.Run("Syntax Error", func(t *testing.T) {
+res, err := s.Execute(ctx, "echo (")
+assert.NoError(t, err)
+assert.Equal(t, 2, res.ExitCode)
+assert.Contains(t, res.Output, "syntax error")
+
+out, err := s.Execute(ctx, "echo ok")
+assert.NoError(t, err)
+assert.Equal(t, "ok", out.Output)
+})
+
+t.Run("No Prompt", func(t *testing.T) {
+res, err := s.Run(ctx, "printf 'progress: 50%%'; sleep 0.5; echo", prompts)
+assert.NoError(t, err)
+assert.False(t, res.Running)
+assert.Equal(t, "progress: 50%", res.Output)
+})
+}
+
+func withDuration(r Result, d time.Duration) Result {
+r.Duration = d
+return r
+}
+
+func TestCompilePrompts(t *testing.T) {
+prompts, err := CompilePrompts(nil)
+assert.NoError(t, err)
+match := func(line string) bool {
+for _, re := range prompts {
+if re.MatchString(line) {
+return true
+}
+}
+return false
+}
+for _, line := range []string{
+"[sudo] password for me: ",
+"Enter passphrase for key '/home/me/.ssh/id_ed25519': ",
+"Are you sure you want to continue connecting (yes/no/[fingerprint])? ",
+"Do you want to continue? [Y/n] ",
+">>> ",
+"mysql> ",
+} {
+assert.True(t, match(line), line)
+}
+for _, line := range []string{"Compiling main.go", "password changed", "50% done"} {
+assert.False(t, match(line), line)
+}
+
+_, err = CompilePrompts([]string{"("})
+assert.ErrorContains(t, err, `invalid prompt pattern "("`)
+}
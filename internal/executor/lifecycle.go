@@ -0,0 +1,141 @@
+package executor
+
+import (
+"context"
+"fmt"
+"log/slog"
+"time"
+)
+
+// SessionLimits bounds how many shells are kept and for how long.
+type SessionLimits struct {
+// IdleTimeout closes shells that have not run a command for this long.
+// Negative means never.
+IdleTimeout time.Duration `yaml:"idle_timeout"`
+// MaxSessions caps the number of shells. Starting another closes the
+// least recently used idle one. Negative means no cap.
+MaxSessions int `yaml:"max_sessions"`
+}
+
+// DefaultSessionLimits returns the limits used when none are configured.
+func DefaultSessionLimits() SessionLimits {
+return SessionLimits{
+IdleTimeout: time.Hour,
+MaxSessions: 32,
+}
+}
+
+// WithDefaults replaces zero limits with the defaults.
+func (l SessionLimits) WithDefaults() SessionLimits {
+def := DefaultSessionLimits()
+if l.IdleTimeout == 0 {
+l.IdleTimeout = def.IdleTimeout
+}
+if l.MaxSessions == 0 {
+l.MaxSessions = def.MaxSessions
+}
+return l
+}
+
+// reapInterval is how often Run looks for idle and dead shells.
+const reapInterval = time.Minute
+
+// aliver is implemented by shells that can tell whether they still run.
+type aliver interface {
+// Alive returns nil while the shell can run commands and why it
+// cannot otherwise.
+Alive() error
+}
+
+// busier is implemented by shells that can tell whether a command runs.
+type busier interface {
+Busy() bool
+}
+
+func alive(s Shell) error {
+if a, ok := s.(aliver); ok {
+return a.Alive()
+}
+return nil
+}
+
+func busy(s Shell) bool {
+b, ok := s.(busier)
+return ok && b.Busy()
+}
+
+// Run closes idle and dead shells until ctx is done.
+func (m *SessionManager) Run(ctx context.Context) {
+interval := reapInterval
+if idle := m.Limits.WithDefaults().IdleTimeout; idle > 0 && idle/2 < interval {
+interval = idle / 2
+}
+t := time.NewTicker(interval)
+defer t.Stop()
+for {
+select {
+case <-ctx.Done():
+return
+case <-t.C:
+m.Reap()
+}
+}
+}
+
+// Reap closes the shells that exited or have been idle for longer than
+// the idle timeout. The next command of such a session starts a new shell
+// and reports why the old one is gone.
+func (m *SessionManager) Reap() {
+idle := m.Limits.WithDefaults().IdleTimeout
+now := time.Now()
+
+m.mu.Lock()
+var closing []Shell
+for id, e := range m.sessions {
+reason := ""
+if err := alive(e.shell); err != nil {
+reason = err.Error()
+} else if idle > 0 && now.Sub(e.lastUsed) > idle && !busy(e.shell) {
+reason = fmt.Sprintf("it was idle for more than %s", idle)
+}
+if reason == "" {
+continue
+}
+slog.Info("Closing shell session", "session", id, "reason", reason)
+delete(m.sessions, id)
+m.ended[id] = reason
+closing = append(closing, e.shell)
+}
+m.mu.Unlock()
+
+for _, s := range closing {
+s.Close()
+}
+}
+
+// evictLocked makes room for one more shell by closing the least recently
+// used idle one, other than that of id, once MaxSessions is reached.
+// Shells being started count towards the limit.
+func (m *SessionManager) evictLocked(id string) (Shell, error) {
+max := m.Limits.WithDefaults().MaxSessions
+if max < 0 || len(m.sessions)+len(m.starting) < max {
+return nil, nil
+}
+victim := ""
+for other, e := range m.sessions {
+if other == id || busy(e.shell) {
+continue
+}
+if victim == "" || e.lastUsed.Before(m.sessions[victim].lastUsed) {
+victim = other
+}
+}
+if victim == "" {
+return nil, fmt.Errorf("all %d shell sessions are running commands", len(m.sessions)+len(m.starting))
+}
+s := m.sessions[victim].shell
+slog.Info("Closing least recently used shell session", "session", victim, "max", max)
+delete(m.sessions, victim)
+m.ended[victim] = fmt.Sprintf("the maximum of %d shell sessions was reached and it was the least recently used", max)
+return s, nil
+}
@@ -0,0 +1,177 @@
+package executor
+
+import (
+"context"
+"errors"
+"testing"
+"time"
+
+"github.com/stretchr/testify/assert"
+)
+
+// lifecycleShell is a MockShell that can die and be busy.
+type lifecycleShell struct {
+MockShell
+dead error
+busy bool
+}
+
+func (l *lifecycleShell) Alive() error { return l.dead }
+func (l *lifecycleShell) Busy() bool   { return l.busy }
+
+func newLifecycleManager(shells map[string]*lifecycleShell) *SessionManager {
+m := NewSessionManager()
+m.Creator = func(id string) (Shell, error) {
+s := &lifecycleShell{}
+shells[id] = s
+return s, nil
+}
+return m
+}
+
+func TestSessionManager_MaxSessions(t *testing.T) {
+shells := make(map[string]*lifecycleShell)
+m := newLifecycleManager(shells)
+m.Limits = SessionLimits{MaxSessions: 2}
+e := &ShellExecutor{Manager: m}
+ctx := context.Background()
+
+_, err := e.Execute(ctx, "s1", Command{Line: "ls"})
+assert.NoError(t, err)
+_, err = e.Execute(ctx, "s2", Command{Line: "ls"})
+assert.NoError(t, err)
+_, err = e.Execute(ctx, "s1", Command{Line: "ls"})
+assert.NoError(t, err)
+
+// s2 is the least recently used
+_, err = e.Execute(ctx, "s3", Command{Line: "ls"})
+assert.NoError(t, err)
+assert.True(t, shells["s2"].Closed)
+assert.False(t, shells["s1"].Closed)
+
+res, err := e.Execute(ctx, "s2", Command{Line: "ls"})
+assert.NoError(t, err)
+assert.Contains(t, res.Restarted, "maximum of 2 shell sessions")
+assert.True(t, shells["s1"].Closed)
+
+res, err = e.Execute(ctx, "s2", Command{Line: "ls"})
+assert.NoError(t, err)
+assert.Empty(t, res.Restarted)
+
+// Busy shells are not evicted
+shells["s2"].busy = true
+shells["s3"].busy = true
+_, err = e.Execute(ctx, "s4", Command{Line: "ls"})
+assert.ErrorContains(t, err, "all 2 shell sessions are running commands")
+}
+
+func TestSessionManager_Reap(t *testing.T) {
+shells := make(map[string]*lifecycleShell)
+m := newLifecycleManager(shells)
+m.Limits = SessionLimits{IdleTimeout: 20 * time.Millisecond}
+e := &ShellExecutor{Manager: m}
+ctx := context.Background()
+
+for _, id := range []string{"idle", "busy", "dead", "fresh"} {
+_, err := e.Execute(ctx, id, Command{Line: "ls"})
+assert.NoError(t, err)
+}
+shells["busy"].busy = true
+time.Sleep(30 * time.Millisecond)
+_, err := e.Execute(ctx, "fresh", Command{Line: "ls"})
+assert.NoError(t, err)
+shells["dead"].dead = errors.New("the shell exited (signal: killed)")
+
+m.Reap()
+assert.True(t, shells["idle"].Closed)
+assert.True(t, shells["dead"].Closed)
+assert.False(t, shells["busy"].Closed)
+assert.False(t, shells["fresh"].Closed)
+assert.Len(t, m.List(), 2)
+
+res, err := e.Execute(ctx, "idle", Command{Line: "ls"})
+assert.NoError(t, err)
+assert.Equal(t, "it was idle for more than 20ms", res.Restarted)
+assert.Contains(t, res.Format(), "Note: this command ran in a new shell because the previous one was closed: it was idle for more than 20ms.")
+res, err = e.Execute(ctx, "dead", Command{Line: "ls"})
+assert.NoError(t, err)
+assert.Equal(t, "the shell exited (signal: killed)", res.Restarted)
+}
+
+func TestSessionManager_Respawn(t *testing.T) {
+e := NewShellExecutor(nil)
+defer e.Cleanup()
+ctx := context.Background()
+
+if _, err := e.Execute(ctx, "sess1", Command{Line: "cd /tmp"}); err != nil {
+t.Skip("PTY not available")
+}
+shell, _ := e.Manager.GetOrCreate("sess1")
+shell.(*ShellSession).Cmd.Process.Kill()
+assert.Eventually(t, func() bool { return shell.(*ShellSession).Alive() != nil }, 5*time.Second, 10*time.Millisecond)
+
+res, err := e.Execute(ctx, "sess1", Command{Line: "pwd"})
+assert.NoError(t, err)
+assert.Equal(t, "the shell exited (signal: killed)", res.Restarted)
+assert.NotEqual(t, "/tmp", res.Output)
+
+// Killing a session ends its running command
+done := make(chan error, 1)
+go func() {
+_, err := e.Execute(ctx, "sess1", Command{Line: "sleep 30"})
+done <- err
+}()
+assert.Eventually(t, func() bool { return e.Manager.Info("sess1").Busy }, 5*time.Second, 10*time.Millisecond)
+assert.True(t, e.Manager.Kill("sess1"))
+select {
+case err := <-done:
+assert.ErrorContains(t, err, "shell session ended")
+case <-time.After(10 * time.Second):
+t.Fatal("command kept running after the shell was killed")
+}
+assert.False(t, e.Manager.Kill("sess1"))
+
+res, err = e.Execute(ctx, "sess1", Command{Line: "true"})
+assert.NoError(t, err)
+assert.Equal(t, "it was killed through the API", res.Restarted)
+}
+
+func TestSessionManager_ConcurrentStart(t *testing.T) {
+m := NewSessionManager()
+release := make(chan struct{})
+started := make(chan string, 4)
+m.Creator = func(id string) (Shell, error) {
+started <- id
+if id == "slow" {
+<-release
+}
+if id == "broken" {
+return nil, errors.New("failed to start")
+}
+return &lifecycleShell{}, nil
+}
+
+shells := make(chan Shell, 2)
+for range 2 {
+go func() {
+s, err := m.GetOrCreate("slow")
+assert.NoError(t, err)
+shells <- s
+}()
+}
+assert.Equal(t, "slow", <-started)
+
+// Other sessions start while the slow shell is starting
+_, err := m.GetOrCreate("fast")
+assert.NoError(t, err)
+assert.Equal(t, "fast", <-started)
+_, err = m.GetOrCreate("broken")
+assert.ErrorContains(t, err, "failed to start")
+assert.Equal(t, "broken", <-started)
+
+// The slow shell is started once for both calls
+close(release)
+assert.Same(t, <-shells, <-shells)
+assert.Len(t, started, 0)
+assert.Len(t, m.List(), 2)
+}
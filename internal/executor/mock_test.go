@@ -1,8 +1,10 @@
 package executor
 
 import (
+"context"
 "fmt"
 "testing"
+"time"
 
 "github.com/stretchr/testify/assert"
 )
@@ -13,14 +15,14 @@ Responses map[string]string
 Closed    bool
 }
 
-func (m *MockShell) Execute(command string) (string, error) {
+func (m *MockShell) Execute(ctx context.Context, command string) (Result, error) {
 if m.Closed {
-return "", fmt.Errorf("session closed")
+return Result{}, ErrSessionClosed
 }
 if resp, ok := m.Responses[command]; ok {
-return resp, nil
+return Result{Output: resp}, nil
 }
-return "mock output for " + command, nil
+return Result{Output: "mock output for " + command}, nil
 }
 
 func (m *MockShell) Close() error {
@@ -29,6 +31,8 @@ return nil
 }
 
 func TestShellExecutor_WithMock(t *testing.T) {
+ctx := context.Background()
+
 t.Run("Allowlist and Mock Execution", func(t *testing.T) {
 e := NewShellExecutor([]string{"ls", "echo"})
 
@@ -43,17 +47,17 @@ return mockShell, nil
 }
 
 // Test allowed command
-got, err := e.Execute("sess1", "echo hello")
+got, err := e.Execute(ctx, "sess1", Command{Line: "echo hello"})
 assert.NoError(t, err)
-assert.Equal(t, "hello", got)
+assert.Equal(t, "hello", got.Output)
 
 // Test blocked command
-_, err = e.Execute("sess1", "pwd")
+_, err = e.Execute(ctx, "sess1", Command{Line: "pwd"})
 assert.Error(t, err)
 assert.Contains(t, err.Error(), "not in the allowlist")
 
 // Test empty command
-_, err = e.Execute("sess1", "")
+_, err = e.Execute(ctx, "sess1", Command{Line: ""})
 assert.Error(t, err)
 assert.Equal(t, "empty command", err.Error())
 })
@@ -67,9 +71,9 @@ count++
 return &MockShell{}, nil
 }
 
-_, _ = e.Execute("sess1", "cmd1")
-_, _ = e.Execute("sess1", "cmd2")
-_, _ = e.Execute("sess2", "cmd1")
+_, _ = e.Execute(ctx, "sess1", Command{Line: "cmd1"})
+_, _ = e.Execute(ctx, "sess1", Command{Line: "cmd2"})
+_, _ = e.Execute(ctx, "sess2", Command{Line: "cmd1"})
 
 assert.Equal(t, 2, count, "Should have created exactly 2 sessions")
 })
@@ -81,7 +85,7 @@ e.Manager.Creator = func(id string) (Shell, error) {
 return mock, nil
 }
 
-_, _ = e.Execute("sess1", "cmd")
+_, _ = e.Execute(ctx, "sess1", Command{Line: "cmd"})
 e.Cleanup()
 assert.True(t, mock.Closed)
 })
@@ -92,8 +96,83 @@ e.Manager.Creator = func(id string) (Shell, error) {
 return nil, fmt.Errorf("spawn failed")
 }
 
-_, err := e.Execute("sess1", "cmd")
+_, err := e.Execute(ctx, "sess1", Command{Line: "cmd"})
 assert.Error(t, err)
 assert.Contains(t, err.Error(), "spawn failed")
 })
 }
+
+// blockingShell runs every command until ctx is done.
+type blockingShell struct {
+MockShell
+deadline time.Time
+}
+
+func (b *blockingShell) Execute(ctx context.Context, command string) (Result, error) {
+b.deadline, _ = ctx.Deadline()
+<-ctx.Done()
+return Result{Output: "partial", ExitCode: -1}, ctx.Err()
+}
+
+func TestShellExecutor_Timeouts(t *testing.T) {
+shell := &blockingShell{}
+e := NewShellExecutor(nil)
+e.Timeouts = Timeouts{Default: 20 * time.Millisecond, Max: 50 * time.Millisecond}
+e.Manager.Creator = func(id string) (Shell, error) {
+return shell, nil
+}
+
+t.Run("Default", func(t *testing.T) {
+out, err := e.Execute(context.Background(), "sess1", Command{Line: "make"})
+assert.Equal(t, Result{Output: "partial", ExitCode: -1}, out)
+assert.EqualError(t, err, "command timed out after 20ms and was interrupted")
+})
+
+t.Run("Requested", func(t *testing.T) {
+start := time.Now()
+_, err := e.Execute(context.Background(), "sess1", Command{Line: "make", Timeout: 30 * time.Millisecond})
+assert.EqualError(t, err, "command timed out after 30ms and was interrupted")
+assert.WithinDuration(t, start.Add(30*time.Millisecond), shell.deadline, 10*time.Millisecond)
+})
+
+t.Run("Capped", func(t *testing.T) {
+_, err := e.Execute(context.Background(), "sess1", Command{Line: "make", Timeout: time.Hour})
+assert.ErrorContains(t, err, "timed out after 50ms and was interrupted (the requested timeout exceeds the maximum of 50ms)")
+})
+
+t.Run("Cancelled", func(t *testing.T) {
+ctx, cancel := context.WithCancel(context.Background())
+go func() {
+time.Sleep(5 * time.Millisecond)
+cancel()
+}()
+_, err := e.Execute(ctx, "sess1", Command{Line: "make"})
+assert.ErrorContains(t, err, "command was cancelled and interrupted: context canceled")
+})
+}
+
+func TestShellExecutor_SessionClosed(t *testing.T) {
+e := NewShellExecutor(nil)
+count := 0
+e.Manager.Creator = func(id string) (Shell, error) {
+count++
+return &MockShell{Closed: count == 1}, nil
+}
+
+_, err := e.Execute(context.Background(), "sess1", Command{Line: "ls"})
+assert.ErrorContains(t, err, "a new one will be started")
+
+out, err := e.Execute(context.Background(), "sess1", Command{Line: "ls"})
+assert.NoError(t, err)
+assert.Equal(t, "mock output for ls", out.Output)
+assert.Equal(t, 2, count)
+}
+
+func TestTimeouts_Defaults(t *testing.T) {
+d, capped := Timeouts{}.timeout(0)
+assert.Equal(t, DefaultTimeouts().Default, d)
+assert.False(t, capped)
+
+d, _ = Timeouts{Default: time.Hour, Max: time.Minute}.timeout(0)
+assert.Equal(t, time.Minute, d)
+}
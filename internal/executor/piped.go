@@ -0,0 +1,56 @@
+package executor
+
+import (
+"context"
+"errors"
+"os/exec"
+"time"
+)
+
+// interruptGrace is how long an interrupted command may take to exit before it is killed.
+const interruptGrace = 5 * time.Second
+
+// runPiped runs line with bash outside of any PTY, capturing stdout and
+// stderr separately, in the environment and sandbox of cfg. On
+// cancellation the process group receives SIGINT and is killed if it does
+// not exit within interruptGrace. An enabled sandbox runs the command in a
+// sandbox of its own, which shares the writable paths but not /tmp with
+// the session's shell.
+func runPiped(ctx context.Context, cfg SessionConfig, dir, line string) (Result, error) {
+stdout := &limitedBuffer{limit: maxCapture}
+stderr := &limitedBuffer{limit: maxCapture}
+
+var cmd *exec.Cmd
+if cfg.Sandbox.Enabled {
+var err error
+if cmd, err = cfg.sandboxConfig().Command(ctx, cfg.Env, ShellProgram, "--noprofile", "--norc", "-c", line); err != nil {
+return Result{ExitCode: -1}, err
+}
+} else {
+cmd = exec.CommandContext(ctx, ShellProgram, "--noprofile", "--norc", "-c", line)
+cmd.Env = cfg.Env
+}
+cmd.Dir = dir
+cmd.Stdout = stdout
+cmd.Stderr = stderr
+interruptGroup(cmd)
+cmd.WaitDelay = interruptGrace
+
+err := cmd.Run()
+res := Result{
+Output:         stdout.String(),
+Stderr:         stderr.String(),
+SeparateStderr: true,
+ExitCode:       -1,
+Truncated:      stdout.truncated || stderr.truncated,
+}
+if ctx.Err() != nil {
+return res, ctx.Err()
+}
+var exitErr *exec.ExitError
+if err != nil && !errors.As(err, &exitErr) {
+return res, err
+}
+res.ExitCode = cmd.ProcessState.ExitCode()
+return res, nil
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package executor
+
+import (
+"os/exec"
+"syscall"
+)
+
+// interruptGroup starts cmd in a process group of its own and makes
+// cancellation send SIGINT to the whole group.
+func interruptGroup(cmd *exec.Cmd) {
+if cmd.SysProcAttr == nil {
+cmd.SysProcAttr = &syscall.SysProcAttr{}
+}
+cmd.SysProcAttr.Setpgid = true
+cmd.Cancel = func() error {
+return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+}
+}
@@ -0,0 +1,7 @@
+package executor
+
+import "os/exec"
+
+// interruptGroup keeps the default cancellation, which kills the process:
+// Windows has no SIGINT for process groups.
+func interruptGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,107 @@
+package executor
+
+import (
+"bytes"
+"fmt"
+"strings"
+"time"
+)
+
+// maxCapture bounds the output kept in memory per stream of a command.
+const maxCapture = 1 << 20
+
+// Command is a request to run a shell command.
+type Command struct {
+// Line is the command line passed to the shell.
+Line string
+// Timeout replaces the default timeout. It is capped at Timeouts.Max.
+Timeout time.Duration
+// SeparateStderr runs the command without a PTY, in the working directory
+// of the session, so that stdout and stderr are captured separately.
+SeparateStderr bool
+// Background returns shortly after starting the command, which keeps
+// running in the terminal of the session without a timeout.
+Background bool
+}
+
+// Result is the outcome of a command.
+type Result struct {
+// Output is the terminal output, or stdout when stderr is separate.
+Output string
+Stderr string
+// SeparateStderr is set when Output and Stderr were captured separately.
+SeparateStderr bool
+// ExitCode is the exit status, or -1 when the command did not finish.
+ExitCode  int
+Duration  time.Duration
+Truncated bool
+// Running is set when the command still runs in the background. Prompt
+// is then the last line of output if it asks for input.
+Running bool
+Prompt  string
+// Restarted is why the session's previous shell ended, when the command
+// ran in a new shell that replaced it.
+Restarted string
+}
+
+// Format renders the result for the model.
+func (r Result) Format() string {
+var sb strings.Builder
+if r.Restarted != "" {
+fmt.Fprintf(&sb, "Note: this command ran in a new shell because the previous one was closed: %s. Its working directory, variables and background jobs are gone.\n", r.Restarted)
+}
+if r.Running {
+sb.WriteString("Exit code: none (still running)\n")
+} else if r.ExitCode < 0 {
+sb.WriteString("Exit code: none (interrupted)\n")
+} else {
+fmt.Fprintf(&sb, "Exit code: %d\n", r.ExitCode)
+}
+fmt.Fprintf(&sb, "Duration: %s\n", r.Duration.Round(time.Millisecond))
+if r.SeparateStderr {
+writeSection(&sb, "Stdout", r.Output)
+writeSection(&sb, "Stderr", r.Stderr)
+} else {
+writeSection(&sb, "Output", r.Output)
+}
+if r.Truncated {
+fmt.Fprintf(&sb, "[output truncated to %d bytes per stream]\n", maxCapture)
+}
+if r.Prompt != "" {
+fmt.Fprintf(&sb, "The command is waiting for input (%q). Answer it with send_input.\n", r.Prompt)
+} else if r.Running {
+sb.WriteString("The command is still running. Follow it with read_output, type into it with send_input or stop it by sending ctrl-c.\n")
+}
+return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func writeSection(sb *strings.Builder, name, text string) {
+if text == "" {
+fmt.Fprintf(sb, "%s: (empty)\n", name)
+return
+}
+fmt.Fprintf(sb, "%s:\n%s\n", name, text)
+}
+
+// limitedBuffer keeps the first limit bytes written to it and drops the rest.
+type limitedBuffer struct {
+buf       bytes.Buffer
+limit     int
+truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+if room := b.limit - b.buf.Len(); len(p) > room {
+b.truncated = true
+if room > 0 {
+b.buf.Write(p[:room])
+}
+return len(p), nil
+}
+return b.buf.Write(p)
+}
+
+// String returns the kept output with terminal line endings normalized.
+func (b *limitedBuffer) String() string {
+return strings.TrimSpace(strings.ReplaceAll(b.buf.String(), "\r\n", "\n"))
+}
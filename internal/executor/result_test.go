@@ -0,0 +1,48 @@
+package executor
+
+import (
+"strings"
+"testing"
+"time"
+
+"github.com/stretchr/testify/assert"
+)
+
+func TestResult_Format(t *testing.T) {
+t.Run("PTY", func(t *testing.T) {
+r := Result{Output: "ok", ExitCode: 0, Duration: 1234567 * time.Microsecond}
+assert.Equal(t, "Exit code: 0\nDuration: 1.235s\nOutput:\nok", r.Format())
+})
+
+t.Run("Separate", func(t *testing.T) {
+r := Result{Output: "out", SeparateStderr: true, ExitCode: 2, Duration: time.Second, Truncated: true}
+assert.Equal(t, "Exit code: 2\nDuration: 1s\nStdout:\nout\nStderr: (empty)\n[output truncated to 1048576 bytes per stream]", r.Format())
+})
+
+t.Run("Interrupted", func(t *testing.T) {
+r := Result{ExitCode: -1}
+assert.Equal(t, "Exit code: none (interrupted)\nDuration: 0s\nOutput: (empty)", r.Format())
+})
+
+t.Run("Waiting", func(t *testing.T) {
+r := Result{Output: "[sudo] password for me:", ExitCode: -1, Running: true, Prompt: "[sudo] password for me:", Restarted: "it was idle for more than 1h0m0s"}
+assert.Equal(t, "Note: this command ran in a new shell because the previous one was closed: it was idle for more than 1h0m0s. Its working directory, variables and background jobs are gone.\nExit code: none (still running)\nDuration: 0s\nOutput:\n[sudo] password for me:\nThe command is waiting for input (\"[sudo] password for me:\"). Answer it with send_input.", r.Format())
+})
+}
+
+func TestLimitedBuffer(t *testing.T) {
+b := &limitedBuffer{limit: 5}
+n, err := b.Write([]byte("abc"))
+assert.NoError(t, err)
+assert.Equal(t, 3, n)
+n, _ = b.Write([]byte("defg"))
+assert.Equal(t, 4, n)
+b.Write([]byte("h"))
+assert.Equal(t, "abcde", b.String())
+assert.True(t, b.truncated)
+
+b = &limitedBuffer{limit: 100}
+b.Write([]byte(" a\r\nb\r\n"))
+assert.Equal(t, "a\nb", b.String())
+assert.False(t, strings.Contains(b.String(), "\r"))
+}
@@ -0,0 +1,77 @@
+//go:build linux
+
+package executor
+
+import (
+"context"
+"os"
+"path/filepath"
+"testing"
+"time"
+
+"github.com/LeeroyDing/hyperagent/internal/sandbox"
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+sandbox.Init()
+os.Exit(m.Run())
+}
+
+func TestShellExecutor_Sandbox(t *testing.T) {
+work := t.TempDir()
+work, err := filepath.EvalSymlinks(work)
+require.NoError(t, err)
+require.NoError(t, os.Mkdir(filepath.Join(work, "sub"), 0755))
+
+e := NewShellExecutor(nil)
+defer e.Cleanup()
+e.Manager.Config = func(id string) SessionConfig {
+// The workspace defaults to the session's directory
+return SessionConfig{Dir: work, Sandbox: sandbox.Config{Enabled: id == "boxed"}}
+}
+ctx := context.Background()
+
+res, err := e.Execute(ctx, "boxed", Command{Line: "cd sub && pwd && echo hi > out.txt"})
+if err != nil {
+t.Skipf("sandbox not available: %v", err)
+}
+assert.Equal(t, filepath.Join(work, "sub"), res.Output)
+data, err := os.ReadFile(filepath.Join(work, "sub", "out.txt"))
+require.NoError(t, err)
+assert.Equal(t, "hi\n", string(data))
+
+res, err = e.Execute(ctx, "boxed", Command{Line: "touch /usr/boxed"})
+assert.NoError(t, err)
+assert.Equal(t, 1, res.ExitCode)
+assert.Contains(t, res.Output, "Read-only file system")
+
+// Piped commands run in a sandbox too, next to the shell
+res, err = e.Execute(ctx, "boxed", Command{Line: "pwd; echo $$; touch /usr/boxed", SeparateStderr: true})
+assert.NoError(t, err)
+assert.Equal(t, filepath.Join(work, "sub")+"\n1", res.Output)
+assert.Contains(t, res.Stderr, "Read-only file system")
+
+// Other sessions are not sandboxed
+res, err = e.Execute(ctx, "host", Command{Line: "echo $$"})
+assert.NoError(t, err)
+assert.NotEqual(t, "1", res.Output)
+}
+
+func TestShellSession_SandboxWallTime(t *testing.T) {
+s, err := NewShellSessionWith(SessionConfig{Sandbox: sandbox.Config{
+Enabled:       true,
+WritablePaths: []string{t.TempDir()},
+Limits:        sandbox.Limits{WallTime: 300 * time.Millisecond},
+}})
+if err != nil {
+t.Skipf("sandbox not available: %v", err)
+}
+defer s.Close()
+
+_, err = s.Execute(context.Background(), "sleep 5")
+assert.ErrorIs(t, err, ErrSessionClosed)
+_, err = s.Execute(context.Background(), "true")
+assert.ErrorIs(t, err, ErrSessionClosed)
+}
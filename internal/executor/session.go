@@ -4,23 +4,88 @@ import (
 "bufio"
 "bytes"
 "context"
+"errors"
 "fmt"
+"io"
+"log/slog"
 "os"
 "os/exec"
+"regexp"
+"sort"
+"strconv"
 "strings"
 "sync"
 "time"
 
+"github.com/LeeroyDing/hyperagent/internal/sandbox"
 "github.com/creack/pty"
 "github.com/google/uuid"
 )
 
+// ErrSessionClosed is returned by a Shell that can no longer run commands.
+var ErrSessionClosed = errors.New("session closed")
+
+// ErrJobRunning is returned when a command is started while another one
+// still runs in the shell.
+var ErrJobRunning = errors.New("a command is still running in this shell")
+
+// ErrNoJob is returned when input is sent while no command runs.
+var ErrNoJob = errors.New("no command is running in this shell")
+
+// resyncTimeout bounds the wait for the shell prompt after an interrupt.
+const resyncTimeout = 5 * time.Second
+
+// interruptRetry is how long to wait for an interrupted command to end
+// before pressing Ctrl-C again.
+const interruptRetry = 500 * time.Millisecond
+
+// refreshTimeout bounds the wait for the environment of an idle shell.
+const refreshTimeout = 2 * time.Second
+
 // Shell defines the interface for a shell session
 type Shell interface {
-Execute(command string) (string, error)
+// Execute runs command until it finishes or ctx is done. On cancellation
+// the command is interrupted, the session stays usable and the output
+// produced so far is returned together with the context error.
+Execute(ctx context.Context, command string) (Result, error)
 Close() error
 }
 
+// SessionConfig describes how the shell of a session is started.
+type SessionConfig struct {
+// Dir is the initial working directory. When empty, the daemon's is used.
+Dir string
+// Env is the environment. When nil, the daemon's is inherited.
+Env     []string
+Sandbox sandbox.Config
+}
+
+// sandboxConfig returns the sandbox, whose workspace defaults to Dir.
+func (c SessionConfig) sandboxConfig() sandbox.Config {
+sb := c.Sandbox
+if len(sb.WritablePaths) == 0 && c.Dir != "" {
+sb.WritablePaths = []string{c.Dir}
+}
+return sb
+}
+
+// SessionInfo describes the shell of a session.
+type SessionInfo struct {
+ID string `json:"id"`
+// Running is false when no shell has been started yet; Dir and Env are
+// then those the shell will start with.
+Running bool `json:"running"`
+// Busy is set while a command runs. Env is then the last one seen.
+Busy      bool              `json:"busy"`
+PID       int               `json:"pid,omitempty"`
+Dir       string            `json:"dir"`
+Env       map[string]string `json:"env"`
+Sandboxed bool              `json:"sandboxed"`
+StartedAt time.Time         `json:"started_at,omitempty"`
+// LastUsed is when the session last ran a command in this shell.
+LastUsed time.Time `json:"last_used,omitempty"`
+}
+
 // ShellSession represents a persistent PTY session
 type ShellSession struct {
 ID      string
@@ -31,43 +96,119 @@ errChan chan error
 mu      sync.Mutex
 closed  bool
 stop    chan struct{}
+// wallTimer kills a sandboxed shell when its wall time is up
+wallTimer *time.Timer
+sandboxed bool
+started   time.Time
+// exited is closed once the shell process has exited
+exited chan struct{}
+
+// jobMu guards job, the last job started with Start
+jobMu sync.Mutex
+job   *Job
+
+// envMu guards env, the environment last read from the shell
+envMu sync.Mutex
+env   []string
 }
 
-// NewShellSession spawns a new persistent shell
-func NewShellSession(id string) (*ShellSession, error) {
-c := exec.Command("bash", "--noprofile", "--norc")
+// NewShellSession spawns a new persistent shell in the daemon's working
+// directory and environment.
+func NewShellSession() (*ShellSession, error) {
+return NewShellSessionWith(SessionConfig{})
+}
 
+// NewShellSessionWith spawns a new persistent shell as described by cfg. A
+// sandboxed shell is killed when the sandbox's wall time is up.
+func NewShellSessionWith(cfg SessionConfig) (*ShellSession, error) {
+var c *exec.Cmd
+if cfg.Sandbox.Enabled {
+var err error
+if c, err = cfg.sandboxConfig().Command(context.Background(), cfg.Env, ShellProgram, "--noprofile", "--norc"); err != nil {
+return nil, err
+}
+} else {
+c = exec.Command(ShellProgram, "--noprofile", "--norc")
+c.Env = cfg.Env
+}
+c.Dir = cfg.Dir
+
+s, err := startShellSession(c)
+if err != nil {
+return nil, err
+}
+s.sandboxed = cfg.Sandbox.Enabled
+s.env = cfg.Env
+if s.env == nil {
+s.env = os.Environ()
+}
+if wall := cfg.Sandbox.Limits.WithDefaults().WallTime; cfg.Sandbox.Enabled && wall > 0 {
+s.wallTimer = time.AfterFunc(wall, func() { c.Process.Kill() })
+}
+return s, nil
+}
+
+func startShellSession(c *exec.Cmd) (*ShellSession, error) {
 f, err := pty.Start(c)
 if err != nil {
 return nil, err
 }
 
 s := &ShellSession{
-ID:      id,
 Cmd:     c,
 Pty:     f,
 outChan: make(chan byte, 8192),
 errChan: make(chan error, 1),
 stop:    make(chan struct{}),
+started: time.Now(),
+exited:  make(chan struct{}),
 }
 
 go s.readLoop()
+go func() {
+c.Wait()
+close(s.exited)
+}()
 
-// Disable echo immediately
-fmt.Fprintln(f, "stty -echo")
+// Disable echo, prompts and job control notices immediately so that they
+// do not end up in the output, and history expansion so that "!" cannot
+// run commands the policy has not seen. Every return to the prompt prints
+// the sentinel
+s.mu.Lock()
+j := newJob("init", "")
+fmt.Fprintf(f, "stty -echo; set +m +H; PS1=''; PS2=''; PROMPT_COMMAND=%s; bind 'set enable-bracketed-paste off' 2>/dev/null; %s=%s\n", promptCommand, sentinelMarker, j.id)
+go s.collect(j)
 
-// Initial sync to consume the 'stty -echo' output and any initial prompt
 ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 defer cancel()
-_, err = s.executeWithSentinel(ctx, "echo ready")
+_, err = j.wait(ctx, nil)
+if err == nil {
+err = j.failed()
+}
 if err != nil {
+out := j.take().Output
 s.Close()
+if out != "" {
+return nil, fmt.Errorf("init failed: %v: %s", err, out)
+}
 return nil, fmt.Errorf("init failed: %v", err)
 }
 
 return s, nil
 }
 
+// promptCommand runs before every prompt. It prints the sentinel with the
+// ID of the command and its exit status, clears the ID, and then discards
+// what is typed into the terminal until the gate line of the next command,
+// which sets its ID. Input that reaches the terminal after a command ended
+// is thus never run by the shell, and a command line that fails to parse
+// still ends with its own sentinel.
+const promptCommand = `'__SENTINEL_status=$?; echo "__SENTINEL_${__SENTINEL_}__:$__SENTINEL_status"; __SENTINEL_=; while IFS=" " read -r __SENTINEL_input __SENTINEL_; do [[ $__SENTINEL_input == ` + gateLine + ` ]] && break; done'`
+
+// gateLine, followed by the ID of a command, lets the command past the
+// gate of promptCommand.
+const gateLine = sentinelMarker + "go"
+
 func (s *ShellSession) readLoop() {
 reader := bufio.NewReader(s.Pty)
 for {
@@ -87,108 +228,620 @@ return
 }
 }
 
-func (s *ShellSession) Execute(command string) (string, error) {
+// Execute runs command until it finishes or ctx is done.
+func (s *ShellSession) Execute(ctx context.Context, command string) (Result, error) {
+return s.Run(ctx, command, nil)
+}
+
+// Run runs command as a job and waits until it finishes or, when prompts
+// are given, until it waits for input. A command waiting for input keeps
+// running; the result then has Running and Prompt set. When ctx is done
+// first the command is interrupted.
+func (s *ShellSession) Run(ctx context.Context, command string, prompts []*regexp.Regexp) (Result, error) {
+if strings.TrimSpace(command) == "" {
+return Result{}, nil
+}
+j, err := s.Start(command)
+if err != nil {
+return Result{}, err
+}
+
+prompt, err := j.wait(ctx, prompts)
+res := j.take()
+res.Prompt = prompt
+if err == nil {
+if jerr := j.failed(); jerr != nil {
+// The PTY failed, the shell is gone
+return res, fmt.Errorf("%w: %v", ErrSessionClosed, jerr)
+}
+return res, nil
+}
+res.Running = false
+if ierr := s.interrupt(j); ierr != nil {
+s.Close()
+return res, fmt.Errorf("%w: %v", ErrSessionClosed, ierr)
+}
+return res, err
+}
+
+// Start runs command as a job and returns without waiting for it. Only one
+// job runs at a time.
+func (s *ShellSession) Start(command string) (*Job, error) {
+if j := s.Job(); j != nil && !j.Done() {
+return nil, fmt.Errorf("%w: %s", ErrJobRunning, j.Command)
+}
 s.mu.Lock()
-defer s.mu.Unlock()
+j, err := s.startLocked(command)
+if err != nil {
+s.mu.Unlock()
+return nil, err
+}
+s.jobMu.Lock()
+s.job = j
+s.jobMu.Unlock()
+return j, nil
+}
 
-if s.closed {
-return "", fmt.Errorf("session closed")
+// Job returns the last job started with Start, which may have finished.
+func (s *ShellSession) Job() *Job {
+s.jobMu.Lock()
+defer s.jobMu.Unlock()
+return s.job
 }
 
-ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-defer cancel()
+// Send types input into the terminal of j if it still runs. Input that
+// arrives after j ended is discarded by the shell, so input that could pass
+// for the gate line is refused.
+func (s *ShellSession) Send(j *Job, input string) error {
+if strings.Contains(input, sentinelMarker) {
+return fmt.Errorf("input must not contain %q", sentinelMarker)
+}
+if err := j.waitBegun(); err != nil {
+return err
+}
+j.mu.Lock()
+defer j.mu.Unlock()
+if j.finished {
+return ErrNoJob
+}
+// The prompt is answered, a new one must show up
+j.partial = j.partial[:0]
+j.lastOutput = time.Now()
+_, err := io.WriteString(s.Pty, input)
+return err
+}
 
-return s.executeWithSentinel(ctx, command)
+// tryStart starts an internal command unless a job runs.
+func (s *ShellSession) tryStart(command string) (*Job, bool) {
+if !s.mu.TryLock() {
+return nil, false
+}
+j, err := s.startLocked(command)
+if err != nil {
+s.mu.Unlock()
+return nil, false
+}
+return j, true
 }
 
-func (s *ShellSession) executeWithSentinel(ctx context.Context, command string) (string, error) {
+// startLocked sends command to the shell. The lock is released once the
+// command has finished.
+func (s *ShellSession) startLocked(command string) (*Job, error) {
+if s.closed {
+return nil, ErrSessionClosed
+}
 // Drain outChan
 for len(s.outChan) > 0 { <-s.outChan }
 
-u := uuid.New().String()
-sentinel := "__SENTINEL_" + u + "__"
-
-// Send command and sentinel
-_, err := fmt.Fprintln(s.Pty, command + "; echo " + sentinel)
-if err != nil {
-return "", err
+j := newJob(uuid.New().String(), command)
+// Bash reads the whole group before running it, so the command cannot
+// consume the lines that follow as its input. The start line shows that
+// bash has read it; Ctrl-C before that would only discard the input. The
+// sentinel is printed when bash returns to the prompt, also after Ctrl-C
+// ended the command. $? and $_ are saved before the start line and restored
+// after it, so the command sees those the previous command left. The gate
+// line starts on a line of its own, as input typed after the previous
+// command may have left one unfinished.
+if _, err := fmt.Fprintf(s.Pty, "\n%s %s\n%ss=$? %su=$_ %s=%s; echo %s; %s {\n%s\n}\n", gateLine, j.id, sentinelMarker, sentinelMarker, sentinelMarker, j.id, j.startLine, restoreStatus, command); err != nil {
+return nil, err
+}
+go s.collect(j)
+return j, nil
 }
 
-var output bytes.Buffer
+// collect passes the shell's output to j until its sentinel shows up, then
+// releases the lock taken for j.
+func (s *ShellSession) collect(j *Job) {
+defer s.mu.Unlock()
+var pending []byte
 for {
+var chunk []byte
 select {
 case b := <-s.outChan:
-output.WriteByte(b)
-str := output.String()
+chunk = append(chunk, b)
+for len(chunk) < 32*1024 && len(s.outChan) > 0 {
+chunk = append(chunk, <-s.outChan)
+}
+case err := <-s.errChan:
+j.write(pending)
+j.finish(-1, err)
+s.closeLocked()
+return
+}
+pending = append(pending, chunk...)
 
-// Check if sentinel is present
-if idx := strings.Index(str, sentinel); idx != -1 {
-lastIdx := strings.LastIndex(str, sentinel)
-result := str[:lastIdx]
-lines := strings.Split(strings.ReplaceAll(result, "\r\n", "\n"), "\n")
+var out []byte
+for {
+i := bytes.IndexByte(pending, '\n')
+if i == -1 {
+break
+}
+line := string(pending[:i+1])
+pending = pending[i+1:]
+if before, code, ok := j.sentinelLine(line); ok {
+j.write(append(out, before...))
+j.finish(code, nil)
+return
+}
+if strings.Contains(line, j.startLine) {
+j.begin()
+}
+if idx := strings.Index(line, sentinelMarker); idx != -1 {
+// The start line, the echoed command line or a stale sentinel
+out = append(out, line[:idx]...)
+continue
+}
+out = append(out, line...)
+}
+// Hold back what may be the start of a sentinel until its line ends
+if idx := bytes.Index(pending, []byte(sentinelMarker)); idx != -1 && len(pending) <= maxCapture {
+out = append(out, pending[:idx]...)
+pending = append([]byte(nil), pending[idx:]...)
+} else {
+keep := markerPrefix(pending)
+out = append(out, pending[:len(pending)-keep]...)
+pending = append([]byte(nil), pending[len(pending)-keep:]...)
+}
+j.write(out)
+}
+}
 
-// Strip the command echo line if it matches
-if len(lines) > 0 && strings.Contains(lines[0], u) {
-lines = lines[1:]
+// sentinelLine reports whether line ends j, returning the output that
+// precedes the sentinel and the exit status.
+func (j *Job) sentinelLine(line string) (string, int, bool) {
+idx := strings.Index(line, j.sentinel)
+if idx == -1 {
+return "", 0, false
+}
+code, err := strconv.Atoi(strings.TrimSpace(line[idx+len(j.sentinel):]))
+if err != nil {
+code = -1
+}
+return line[:idx], code, true
 }
 
-return strings.TrimSpace(strings.Join(lines, "\n")), nil
+// markerPrefix returns the length of the longest suffix of b that starts
+// sentinelMarker.
+func markerPrefix(b []byte) int {
+for n := min(len(b), len(sentinelMarker)-1); n > 0; n-- {
+if strings.HasPrefix(sentinelMarker, string(b[len(b)-n:])) {
+return n
 }
-case err := <-s.errChan:
-return output.String(), err
-case <-ctx.Done():
-return output.String(), ctx.Err()
 }
+return 0
+}
+
+// Alive returns nil while the shell can run commands, and why it cannot
+// once it has exited or been closed.
+func (s *ShellSession) Alive() error {
+select {
+case <-s.exited:
+return fmt.Errorf("the shell exited (%s)", s.Cmd.ProcessState)
+default:
+}
+if !s.mu.TryLock() {
+// A command is running
+return nil
+}
+defer s.mu.Unlock()
+if s.closed {
+return ErrSessionClosed
+}
+return nil
+}
+
+// Busy reports whether a command is running.
+func (s *ShellSession) Busy() bool {
+if s.mu.TryLock() {
+s.mu.Unlock()
+return false
+}
+return true
+}
+
+// WorkingDir returns the current directory of the shell.
+func (s *ShellSession) WorkingDir() (string, error) {
+return os.Readlink(fmt.Sprintf("/proc/%d/cwd", s.Cmd.Process.Pid))
+}
+
+// Info describes the shell. The environment is read from the shell when it
+// is idle, so that variables exported by commands show up.
+func (s *ShellSession) Info() SessionInfo {
+info := SessionInfo{
+ID:        s.ID,
+Running:   true,
+PID:       s.Cmd.Process.Pid,
+Sandboxed: s.sandboxed,
+StartedAt: s.started,
+}
+if j, ok := s.tryStart(envProbe); ok {
+s.refreshEnv(j)
+} else {
+info.Busy = true
+}
+info.Dir, _ = s.WorkingDir()
+s.envMu.Lock()
+info.Env = envMap(s.env)
+s.envMu.Unlock()
+return info
+}
+
+// restoreStatus sets $_ and $? back to the values saved in
+// __SENTINEL_u and __SENTINEL_s.
+const restoreStatus = `: "$__SENTINEL_u"; (exit $__SENTINEL_s);`
+
+// envProbe prints the environment followed by envDone. It leaves $? and $_
+// as they were, so that the probe does not show up in the user's next
+// command.
+const envProbe = `__SENTINEL_s=$? __SENTINEL_u=$_; env -0 && printf '` + envDone + `'; ` + restoreStatus
+
+// envDone ends the output of envProbe when env succeeded. The exit status
+// of the probe is the restored one and cannot tell.
+const envDone = "ENV_PROBE_DONE"
+
+// refreshEnv reads the environment printed by j.
+func (s *ShellSession) refreshEnv(j *Job) {
+ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+defer cancel()
+if _, err := j.wait(ctx, nil); err != nil {
+return
+}
+out, ok := strings.CutSuffix(j.take().Output, envDone)
+if !ok {
+return
 }
+env := strings.Split(strings.TrimRight(out, "\x00"), "\x00")
+s.envMu.Lock()
+s.env = env
+s.envMu.Unlock()
 }
 
+// interrupt sends Ctrl-C to the job, as a user at the terminal would, and
+// waits until the shell accepts commands again.
+func (s *ShellSession) interrupt(j *Job) error {
+if err := j.waitBegun(); err != nil {
+return err
+}
+ctx, cancel := context.WithTimeout(context.Background(), resyncTimeout)
+defer cancel()
+// Ctrl-C that arrives while bash hands the terminal to the command only
+// reaches bash, so it is repeated until the command ends
+for !j.Done() {
+if _, err := s.Pty.Write([]byte{0x03}); err != nil {
+return fmt.Errorf("failed to interrupt command: %v", err)
+}
+retry, cancelRetry := context.WithTimeout(ctx, interruptRetry)
+_, err := j.wait(retry, nil)
+cancelRetry()
+if ctx.Err() != nil {
+return fmt.Errorf("shell did not recover after interrupt: %v", err)
+}
+}
+// Bash restores terminal echo after a job is killed
+s.mu.Lock()
+resync, err := s.startLocked("stty -echo")
+if err != nil {
+s.mu.Unlock()
+return fmt.Errorf("shell did not recover after interrupt: %v", err)
+}
+if _, err := resync.wait(ctx, nil); err != nil {
+return fmt.Errorf("shell did not recover after interrupt: %v", err)
+}
+return nil
+}
+
+// Close kills the shell. A running command is ended with it.
 func (s *ShellSession) Close() error {
+if !s.mu.TryLock() {
+// Killing the shell and hanging up its terminal makes the running
+// command return, releasing the lock
+s.Cmd.Process.Kill()
+s.Pty.Close()
 s.mu.Lock()
+}
 defer s.mu.Unlock()
+return s.closeLocked()
+}
+
+func (s *ShellSession) closeLocked() error {
 if s.closed {
 return nil
 }
 s.closed = true
 close(s.stop)
+if s.wallTimer != nil {
+s.wallTimer.Stop()
+}
 s.Pty.Close()
-return s.Cmd.Process.Kill()
+if err := s.Cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+return err
+}
+return nil
+}
+
+// infoer is implemented by shells that can describe themselves.
+type infoer interface {
+Info() SessionInfo
 }
 
 // SessionManager manages multiple shell sessions
 type SessionManager struct {
-sessions map[string]Shell
-mu       sync.RWMutex
-Creator  func(id string) (Shell, error)
+sessions map[string]*managedShell
+// ended records why the last shell of a session was closed, until a new
+// one is started
+ended   map[string]string
+// starting holds the sessions whose shell is being started, outside mu
+starting map[string]*startingShell
+mu      sync.RWMutex
+Creator func(id string) (Shell, error)
+// Config returns how the shell of a session is started. When nil,
+// shells start in the daemon's directory and environment.
+Config func(id string) SessionConfig
+Limits SessionLimits
+}
+
+type managedShell struct {
+shell    Shell
+lastUsed time.Time
+}
+
+// startingShell is a shell being started. done is closed once it runs or
+// failed to start with err.
+type startingShell struct {
+done chan struct{}
+err  error
 }
 
 func NewSessionManager() *SessionManager {
-return &SessionManager{
-sessions: make(map[string]Shell),
-Creator: func(id string) (Shell, error) {
-return NewShellSession(id)
-},
+m := &SessionManager{
+sessions: make(map[string]*managedShell),
+ended:    make(map[string]string),
+starting: make(map[string]*startingShell),
 }
+m.Creator = func(id string) (Shell, error) {
+s, err := NewShellSessionWith(m.configFor(id))
+if err != nil {
+return nil, err
+}
+s.ID = id
+return s, nil
+}
+return m
 }
 
-func (m *SessionManager) GetOrCreate(id string) (Shell, error) {
+// configFor returns the config of a session.
+func (m *SessionManager) configFor(id string) SessionConfig {
+if m.Config == nil {
+return SessionConfig{}
+}
+return m.Config(id)
+}
+
+// lookup returns the shell of a session, if one is running.
+func (m *SessionManager) lookup(id string) (Shell, bool) {
+m.mu.RLock()
+defer m.mu.RUnlock()
+e, ok := m.sessions[id]
+if !ok {
+return nil, false
+}
+return e.shell, true
+}
+
+// Dir returns the current directory of a session's shell, or the one it
+// will start in.
+func (m *SessionManager) Dir(id string) string {
+s, ok := m.lookup(id)
+if wd, isWD := s.(workingDirer); ok && isWD {
+if dir, err := wd.WorkingDir(); err == nil {
+return dir
+}
+}
+if dir := m.configFor(id).Dir; dir != "" {
+return dir
+}
+dir, _ := os.Getwd()
+return dir
+}
+
+// Info describes the shell of a session, or the shell it would start
+// with if none is running.
+func (m *SessionManager) Info(id string) SessionInfo {
+m.mu.RLock()
+e, ok := m.sessions[id]
+var lastUsed time.Time
+if ok {
+lastUsed = e.lastUsed
+}
+m.mu.RUnlock()
+if ok {
+if i, isInfoer := e.shell.(infoer); isInfoer {
+info := i.Info()
+info.LastUsed = lastUsed
+return info
+}
+}
+
+cfg := m.configFor(id)
+info := SessionInfo{ID: id, Running: ok, Dir: cfg.Dir, Sandboxed: cfg.Sandbox.Enabled, LastUsed: lastUsed}
+if info.Dir == "" {
+info.Dir, _ = os.Getwd()
+}
+env := cfg.Env
+if env == nil {
+env = os.Environ()
+}
+info.Env = envMap(env)
+return info
+}
+
+// List describes the running shells, ordered by session ID.
+func (m *SessionManager) List() []SessionInfo {
 m.mu.RLock()
-s, ok := m.sessions[id]
+ids := make([]string, 0, len(m.sessions))
+for id := range m.sessions {
+ids = append(ids, id)
+}
 m.mu.RUnlock()
-if ok { return s, nil }
+sort.Strings(ids)
 
+infos := make([]SessionInfo, 0, len(ids))
+for _, id := range ids {
+if info := m.Info(id); info.Running {
+infos = append(infos, info)
+}
+}
+return infos
+}
+
+func (m *SessionManager) GetOrCreate(id string) (Shell, error) {
+s, _, err := m.acquire(id)
+return s, err
+}
+
+// acquire returns the shell of a session, starting one if none is running
+// or the running one died. When the new shell replaces one that was closed
+// or died, acquire also returns why that happened. The shell is started
+// without holding mu, so that other sessions are not held up meanwhile;
+// concurrent calls for the same session wait for it.
+func (m *SessionManager) acquire(id string) (Shell, string, error) {
+for {
+s, p, owner, err := m.reserve(id)
+switch {
+case err != nil || s != nil:
+return s, "", err
+case owner:
+return m.start(id, p)
+}
+// Another call is starting the shell
+<-p.done
+if p.err != nil {
+return nil, "", p.err
+}
+}
+}
+
+// reserve returns the running shell of a session or, when there is none,
+// the shell being started for it. owner reports whether reserve made that
+// slot, leaving the start to the caller. The dead shell and the one evicted
+// to make room are closed after mu is released.
+func (m *SessionManager) reserve(id string) (s Shell, p *startingShell, owner bool, err error) {
 m.mu.Lock()
-defer m.mu.Unlock()
-if s, ok := m.sessions[id]; ok { return s, nil }
+var closing []Shell
+defer func() {
+m.mu.Unlock()
+for _, s := range closing {
+s.Close()
+}
+}()
 
+if e, ok := m.sessions[id]; ok {
+err := alive(e.shell)
+if err == nil {
+e.lastUsed = time.Now()
+return e.shell, nil, false, nil
+}
+slog.Info("Shell session died, starting a new one", "session", id, "reason", err)
+delete(m.sessions, id)
+m.ended[id] = err.Error()
+closing = append(closing, e.shell)
+}
+if p, ok := m.starting[id]; ok {
+return nil, p, false, nil
+}
+
+victim, err := m.evictLocked(id)
+if err != nil {
+return nil, nil, false, err
+}
+if victim != nil {
+closing = append(closing, victim)
+}
+p = &startingShell{done: make(chan struct{})}
+m.starting[id] = p
+return nil, p, true, nil
+}
+
+// start starts the shell of the slot p reserved for a session and
+// publishes it.
+func (m *SessionManager) start(id string, p *startingShell) (Shell, string, error) {
 s, err := m.Creator(id)
-if err != nil { return nil, err }
-m.sessions[id] = s
-return s, nil
+
+m.mu.Lock()
+defer m.mu.Unlock()
+delete(m.starting, id)
+p.err = err
+close(p.done)
+if err != nil {
+return nil, "", err
+}
+m.sessions[id] = &managedShell{shell: s, lastUsed: time.Now()}
+reason := m.ended[id]
+delete(m.ended, id)
+return s, reason, nil
+}
+
+// touch marks the shell of a session as used now.
+func (m *SessionManager) touch(id string) {
+m.mu.Lock()
+defer m.mu.Unlock()
+if e, ok := m.sessions[id]; ok {
+e.lastUsed = time.Now()
+}
+}
+
+// Remove closes and forgets a session so that the next GetOrCreate starts a new one.
+func (m *SessionManager) Remove(id string) {
+m.mu.Lock()
+e, ok := m.sessions[id]
+delete(m.sessions, id)
+m.mu.Unlock()
+if ok {
+e.shell.Close()
+}
+}
+
+// Kill closes the shell of a session, ending any running command. The
+// next command of the session starts a new shell and is told why. Kill
+// reports whether a shell was running.
+func (m *SessionManager) Kill(id string) bool {
+m.mu.Lock()
+e, ok := m.sessions[id]
+if ok {
+delete(m.sessions, id)
+m.ended[id] = "it was killed through the API"
+}
+m.mu.Unlock()
+if ok {
+slog.Info("Killing shell session", "session", id)
+e.shell.Close()
+}
+return ok
 }
 
 func (m *SessionManager) Cleanup() {
 m.mu.Lock()
 defer m.mu.Unlock()
-for _, s := range m.sessions { s.Close() }
-m.sessions = make(map[string]Shell)
+for _, e := range m.sessions { e.shell.Close() }
+m.sessions = make(map[string]*managedShell)
 }
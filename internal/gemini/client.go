@@ -7,38 +7,27 @@ import (
 "log/slog"
 "time"
 
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/LeeroyDing/hyperagent/internal/token"
 "github.com/google/generative-ai-go/genai"
+"github.com/google/uuid"
+"google.golang.org/api/iterator"
 "google.golang.org/api/option"
 )
 
-type Message struct {
-Role    string
-Content string
-}
-
-type ToolCall struct {
-Name      string
-Arguments map[string]interface{}
-}
-
-type ToolResponse struct {
-Name    string
-Content string
-}
-
-// GeminiClient defines the interface for interacting with the Gemini API.
-type GeminiClient interface {
-GenerateContent(ctx context.Context, messages []Message, tools []*genai.Tool) (string, []ToolCall, error)
-SendToolResponse(ctx context.Context, messages []Message, tools []*genai.Tool, toolResponses []ToolResponse) (string, []ToolCall, error)
-EmbedContent(ctx context.Context, text string) ([]float32, error)
-Close() error
-}
-
+// Client adapts the Google genai SDK to llm.Client.
 type Client struct {
 client *genai.Client
 model  *genai.GenerativeModel
+// counter counts tokens without the tools configured on model.
+counter *genai.GenerativeModel
 }
 
+var (
+_ llm.StreamClient   = (*Client)(nil)
+_ token.TokenCounter = (*Client)(nil)
+)
+
 func NewClient(ctx context.Context, apiKey string, modelName string) (*Client, error) {
 client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
 if err != nil {
@@ -47,135 +36,291 @@ return nil, fmt.Errorf("failed to create genai client: %w", err)
 
 model := client.GenerativeModel(modelName)
 return &Client{
-client: client,
-model:  model,
+client:  client,
+model:   model,
+counter: client.GenerativeModel(modelName),
 }, nil
 }
 
-func (c *Client) GenerateContent(ctx context.Context, messages []Message, tools []*genai.Tool) (string, []ToolCall, error) {
-c.model.Tools = tools
-cs := c.model.StartChat()
+func (c *Client) GenerateContent(ctx context.Context, messages []llm.Message, tools []llm.Tool) (*llm.Response, error) {
+m, history, last, err := c.startChat(messages, tools)
+if err != nil {
+return nil, err
+}
 
-// Map roles and set history except the last message
-if len(messages) > 1 {
-for _, m := range messages[:len(messages)-1] {
-role := "user"
-if m.Role == "assistant" || m.Role == "model" {
-role = "model"
+var lastErr error
+for i := 0; i < 3; i++ {
+if err := backoff(ctx, i); err != nil {
+return nil, err
 }
-cs.History = append(cs.History, &genai.Content{
-Parts: []genai.Part{genai.Text(m.Content)},
-Role:  role,
-})
+resp, err := newChat(m, history).SendMessage(ctx, last.Parts...)
+if err == nil {
+return fromGenaiResponse(resp)
 }
+lastErr = err
+slog.Warn("Gemini API call failed", "attempt", i+1, "error", err)
+}
+return nil, fmt.Errorf("failed after 3 attempts: %w", lastErr)
 }
 
-// Last message is the prompt
-lastMsg := messages[len(messages)-1]
+// GenerateContentStream uses SendMessageStream and reports text as it arrives.
+// Failures are only retried while no text has been delivered, each attempt
+// on a new chat so that the last message is not sent twice.
+func (c *Client) GenerateContentStream(ctx context.Context, messages []llm.Message, tools []llm.Tool, onText func(string)) (*llm.Response, error) {
+m, history, last, err := c.startChat(messages, tools)
+if err != nil {
+return nil, err
+}
+
+var lastErr error
+for i := 0; i < 3; i++ {
+if err := backoff(ctx, i); err != nil {
+return nil, err
+}
+iter := newChat(m, history).SendMessageStream(ctx, last.Parts...)
+delivered := false
+for {
+chunk, err := iter.Next()
+if err == iterator.Done {
+if iter.MergedResponse() == nil {
+return nil, fmt.Errorf("empty response stream")
+}
+return fromGenaiResponse(iter.MergedResponse())
+}
+if err != nil {
+if delivered {
+return nil, fmt.Errorf("stream interrupted: %w", err)
+}
+lastErr = err
+break
+}
+for _, cand := range chunk.Candidates {
+if cand.Content == nil {
+continue
+}
+for _, part := range cand.Content.Parts {
+if t, ok := part.(genai.Text); ok && t != "" {
+delivered = true
+onText(string(t))
+}
+}
+}
+}
+slog.Warn("Gemini API stream failed", "attempt", i+1, "error", lastErr)
+}
+return nil, fmt.Errorf("failed after 3 attempts: %w", lastErr)
+}
+
+// startChat configures a copy of the model for one request, so that
+// concurrent requests do not share its tools and system instruction. It
+// returns the copy, the history of all but the last message, and the last
+// one, which is the prompt or the latest function results.
+func (c *Client) startChat(messages []llm.Message, tools []llm.Tool) (*genai.GenerativeModel, []*genai.Content, *genai.Content, error) {
+if len(messages) == 0 {
+return nil, nil, nil, fmt.Errorf("no messages to send")
+}
+m := *c.model
+m.Tools = toGenaiTools(tools)
+m.SystemInstruction = nil
+
+var history []*genai.Content
+for _, msg := range messages[:len(messages)-1] {
+if msg.Role == llm.RoleSystem {
+m.SystemInstruction = toGenaiContent(msg)
+continue
+}
+history = append(history, toGenaiContent(msg))
+}
+last := toGenaiContent(messages[len(messages)-1])
 
 slog.Debug("Gemini API Request", "messages", messages, "tools_count", len(tools))
+return &m, history, last, nil
+}
+
+// newChat starts a chat on m with history. SendMessage and
+// SendMessageStream append the message they send to the chat's history
+// even when they fail, so every attempt gets a new chat that cannot grow
+// history itself.
+func newChat(m *genai.GenerativeModel, history []*genai.Content) *genai.ChatSession {
+cs := m.StartChat()
+cs.History = history[:len(history):len(history)]
+return cs
+}
+
+// retryDelay is the wait before the first retry; it doubles for each one.
+var retryDelay = time.Second
+
+// backoff waits before attempt i, counted from zero, unless it is the
+// first. It returns early with ctx's error when ctx is done.
+func backoff(ctx context.Context, i int) error {
+if i == 0 {
+return nil
+}
+timer := time.NewTimer(time.Duration(1<<(i-1)) * retryDelay)
+select {
+case <-ctx.Done():
+timer.Stop()
+return ctx.Err()
+case <-timer.C:
+return nil
+}
+}
+
+// CountTokens asks the CountTokens API for the size of text; it implements token.TokenCounter.
+func (c *Client) CountTokens(ctx context.Context, text string) (int, error) {
+resp, err := c.counter.CountTokens(ctx, genai.Text(text))
+if err != nil {
+return 0, fmt.Errorf("failed to count tokens: %w", err)
+}
+return int(resp.TotalTokens), nil
+}
 
+func (c *Client) EmbedContent(ctx context.Context, text string) ([]float32, error) {
+em := c.client.EmbeddingModel("gemini-embedding-001")
 var lastErr error
 for i := 0; i < 3; i++ {
-resp, err := cs.SendMessage(ctx, genai.Text(lastMsg.Content))
+if err := backoff(ctx, i); err != nil {
+return nil, err
+}
+resp, err := em.EmbedContent(ctx, genai.Text(text))
 if err == nil {
-if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
-return "", nil, fmt.Errorf("no candidates or parts in response")
+return resp.Embedding.Values, nil
+}
+lastErr = err
+slog.Warn("Gemini Embedding API call failed", "attempt", i+1, "error", err)
+}
+return nil, fmt.Errorf("failed after 3 attempts: %w", lastErr)
 }
 
-var toolCalls []ToolCall
-var textResponse string
+func (c *Client) Close() error {
+return c.client.Close()
+}
+
+func fromGenaiResponse(resp *genai.GenerateContentResponse) (*llm.Response, error) {
+if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+return nil, fmt.Errorf("no candidates or parts in response")
+}
 
+respJSON, _ := json.Marshal(resp.Candidates[0].Content)
+slog.Debug("Gemini API Response", "content", string(respJSON))
+
+out := &llm.Response{Message: llm.Message{Role: llm.RoleModel}}
 for _, part := range resp.Candidates[0].Content.Parts {
 switch p := part.(type) {
 case genai.Text:
-textResponse += string(p)
+out.Message.Parts = append(out.Message.Parts, llm.Part{Text: string(p)})
 case genai.FunctionCall:
-toolCalls = append(toolCalls, ToolCall{
+out.Message.Parts = append(out.Message.Parts, llm.Part{FunctionCall: &llm.FunctionCall{
+// Gemini does not issue call IDs, so mint one for correlation
+ID:        "call_" + uuid.New().String()[:8],
 Name:      p.Name,
 Arguments: p.Args,
-})
+}})
 }
 }
 
-respJSON, _ := json.Marshal(resp.Candidates[0].Content)
-slog.Debug("Gemini API Response", "content", string(respJSON))
-
-return textResponse, toolCalls, nil
+if u := resp.UsageMetadata; u != nil {
+out.Usage = llm.Usage{
+PromptTokens:     int(u.PromptTokenCount),
+CompletionTokens: int(u.CandidatesTokenCount),
+TotalTokens:      int(u.TotalTokenCount),
 }
-lastErr = err
-slog.Warn("Gemini API call failed, retrying...", "attempt", i+1, "error", err)
-time.Sleep(time.Duration(1<<i) * time.Second)
 }
-return "", nil, fmt.Errorf("failed after 3 attempts: %w", lastErr)
+return out, nil
 }
 
-func (c *Client) SendToolResponse(ctx context.Context, messages []Message, tools []*genai.Tool, toolResponses []ToolResponse) (string, []ToolCall, error) {
-c.model.Tools = tools
-cs := c.model.StartChat()
-
-// Reconstruct history
-for _, m := range messages {
+func toGenaiContent(m llm.Message) *genai.Content {
 role := "user"
-if m.Role == "assistant" || m.Role == "model" {
+if m.Role == llm.RoleModel {
 role = "model"
 }
-cs.History = append(cs.History, &genai.Content{
-Parts: []genai.Part{genai.Text(m.Content)},
-Role:  role,
-})
-}
 
-var parts []genai.Part
-for _, tr := range toolResponses {
-parts = append(parts, genai.FunctionResponse{
-Name:     tr.Name,
-Response: map[string]interface{}{"result": tr.Content},
+content := &genai.Content{Role: role}
+for _, p := range m.Parts {
+switch {
+case p.FunctionCall != nil:
+content.Parts = append(content.Parts, genai.FunctionCall{
+Name: p.FunctionCall.Name,
+Args: p.FunctionCall.Arguments,
 })
+case p.FunctionResult != nil:
+content.Parts = append(content.Parts, toGenaiFunctionResponse(p.FunctionResult))
+default:
+content.Parts = append(content.Parts, genai.Text(p.Text))
+}
+}
+if len(content.Parts) == 0 {
+content.Parts = []genai.Part{genai.Text("")}
+}
+return content
 }
 
-slog.Debug("Gemini API Tool Response Request", "tool_responses", toolResponses)
-
-resp, err := cs.SendMessage(ctx, parts...)
-if err != nil {
-return "", nil, err
+func toGenaiFunctionResponse(r *llm.FunctionResult) genai.FunctionResponse {
+key := "result"
+if r.IsError {
+key = "error"
+}
+return genai.FunctionResponse{
+Name:     r.Name,
+Response: map[string]interface{}{key: r.Content},
+}
 }
 
-var toolCalls []ToolCall
-var textResponse string
-for _, part := range resp.Candidates[0].Content.Parts {
-switch p := part.(type) {
-case genai.Text:
-textResponse += string(p)
-case genai.FunctionCall:
-toolCalls = append(toolCalls, ToolCall{
-Name:      p.Name,
-Arguments: p.Args,
+func toGenaiTools(tools []llm.Tool) []*genai.Tool {
+if len(tools) == 0 {
+return nil
+}
+decls := make([]*genai.FunctionDeclaration, 0, len(tools))
+for _, t := range tools {
+decls = append(decls, &genai.FunctionDeclaration{
+Name:        t.Name,
+Description: t.Description,
+Parameters:  toGenaiSchema(t.Parameters),
 })
 }
+return []*genai.Tool{{FunctionDeclarations: decls}}
 }
 
-respJSON, _ := json.Marshal(resp.Candidates[0].Content)
-slog.Debug("Gemini API Response (after tool)", "content", string(respJSON))
-
-return textResponse, toolCalls, nil
+func toGenaiSchema(s *llm.Schema) *genai.Schema {
+if s == nil {
+return nil
 }
-
-func (c *Client) EmbedContent(ctx context.Context, text string) ([]float32, error) {
-em := c.client.EmbeddingModel("gemini-embedding-001")
-var lastErr error
-for i := 0; i < 3; i++ {
-resp, err := em.EmbedContent(ctx, genai.Text(text))
-if err == nil {
-return resp.Embedding.Values, nil
+out := &genai.Schema{
+Type:        toGenaiType(s.Type),
+Description: s.Description,
+Nullable:    s.Nullable,
+Enum:        s.Enum,
+Required:    s.Required,
+Items:       toGenaiSchema(s.Items),
 }
-lastErr = err
-slog.Warn("Gemini Embedding API call failed, retrying...", "attempt", i+1, "error", err)
-time.Sleep(time.Duration(1<<i) * time.Second)
+// Gemini only accepts a small set of string formats
+if s.Type == llm.TypeString && (s.Format == "enum" || s.Format == "date-time") {
+out.Format = s.Format
 }
-return nil, fmt.Errorf("failed after 3 attempts: %w", lastErr)
+if len(s.Properties) > 0 {
+out.Properties = make(map[string]*genai.Schema, len(s.Properties))
+for name, p := range s.Properties {
+out.Properties[name] = toGenaiSchema(p)
+}
+}
+return out
 }
 
-func (c *Client) Close() error {
-return c.client.Close()
+func toGenaiType(t llm.Type) genai.Type {
+switch t {
+case llm.TypeString:
+return genai.TypeString
+case llm.TypeNumber:
+return genai.TypeNumber
+case llm.TypeInteger:
+return genai.TypeInteger
+case llm.TypeBoolean:
+return genai.TypeBoolean
+case llm.TypeArray:
+return genai.TypeArray
+case llm.TypeObject:
+return genai.TypeObject
+default:
+return genai.TypeUnspecified
+}
 }
@@ -0,0 +1,130 @@
+package gemini
+
+import (
+"context"
+"testing"
+"time"
+
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/google/generative-ai-go/genai"
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+func TestToGenaiContent(t *testing.T) {
+t.Run("FunctionCall", func(t *testing.T) {
+msg := llm.Message{Role: llm.RoleModel, Parts: []llm.Part{
+{Text: "running"},
+{FunctionCall: &llm.FunctionCall{ID: "c1", Name: "execute_command", Arguments: map[string]interface{}{"command": "ls"}}},
+}}
+c := toGenaiContent(msg)
+assert.Equal(t, "model", c.Role)
+require.Len(t, c.Parts, 2)
+assert.Equal(t, genai.Text("running"), c.Parts[0])
+assert.Equal(t, genai.FunctionCall{Name: "execute_command", Args: map[string]interface{}{"command": "ls"}}, c.Parts[1])
+})
+
+t.Run("FunctionResult", func(t *testing.T) {
+msg := llm.Message{Role: llm.RoleTool, Parts: []llm.Part{
+{FunctionResult: &llm.FunctionResult{ID: "c1", Name: "read_file", Content: "denied", IsError: true}},
+}}
+c := toGenaiContent(msg)
+assert.Equal(t, "user", c.Role)
+assert.Equal(t, genai.FunctionResponse{Name: "read_file", Response: map[string]interface{}{"error": "denied"}}, c.Parts[0])
+})
+}
+
+func TestStartChat_SystemInstruction(t *testing.T) {
+c := &Client{model: &genai.GenerativeModel{}}
+m, history, last, err := c.startChat([]llm.Message{
+llm.NewTextMessage(llm.RoleSystem, "be brief"),
+llm.NewTextMessage(llm.RoleUser, "earlier"),
+llm.NewTextMessage(llm.RoleModel, "ok"),
+llm.NewTextMessage(llm.RoleUser, "now"),
+}, []llm.Tool{{Name: "read_file"}})
+require.NoError(t, err)
+assert.Equal(t, genai.Text("be brief"), m.SystemInstruction.Parts[0])
+assert.Len(t, m.Tools, 1)
+assert.Len(t, history, 2)
+assert.Equal(t, genai.Text("now"), last.Parts[0])
+
+// The shared model is left untouched for concurrent requests
+assert.Nil(t, c.model.SystemInstruction)
+assert.Nil(t, c.model.Tools)
+
+m, _, _, err = c.startChat([]llm.Message{llm.NewTextMessage(llm.RoleUser, "hi")}, nil)
+require.NoError(t, err)
+assert.Nil(t, m.SystemInstruction)
+}
+
+func TestNewChat(t *testing.T) {
+history := make([]*genai.Content, 1, 4)
+history[0] = genai.NewUserContent(genai.Text("earlier"))
+cs := newChat(&genai.GenerativeModel{}, history)
+// As SendMessage does before a request that may fail
+cs.History = append(cs.History, genai.NewUserContent(genai.Text("now")))
+
+cs = newChat(&genai.GenerativeModel{}, history)
+assert.Len(t, cs.History, 1)
+assert.Equal(t, history[:1], cs.History)
+assert.Nil(t, history[:2][1])
+}
+
+func TestToGenaiTools(t *testing.T) {
+tools := toGenaiTools([]llm.Tool{{
+Name: "read_file",
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"path":  {Type: llm.TypeString, Format: "uri"},
+"lines": {Type: llm.TypeArray, Items: &llm.Schema{Type: llm.TypeInteger}},
+},
+Required: []string{"path"},
+},
+}})
+require.Len(t, tools, 1)
+decl := tools[0].FunctionDeclarations[0]
+assert.Equal(t, "read_file", decl.Name)
+assert.Equal(t, genai.TypeObject, decl.Parameters.Type)
+assert.Equal(t, "", decl.Parameters.Properties["path"].Format)
+assert.Equal(t, genai.TypeInteger, decl.Parameters.Properties["lines"].Items.Type)
+assert.Nil(t, toGenaiTools(nil))
+}
+
+func TestFromGenaiResponse(t *testing.T) {
+resp, err := fromGenaiResponse(&genai.GenerateContentResponse{
+Candidates: []*genai.Candidate{{Content: &genai.Content{Parts: []genai.Part{
+genai.Text("ok"),
+genai.FunctionCall{Name: "memory_load", Args: map[string]interface{}{"query": "q"}},
+}}}},
+UsageMetadata: &genai.UsageMetadata{PromptTokenCount: 10, CandidatesTokenCount: 5, TotalTokenCount: 15},
+})
+require.NoError(t, err)
+assert.Equal(t, "ok", resp.Text())
+calls := resp.FunctionCalls()
+require.Len(t, calls, 1)
+assert.Equal(t, "memory_load", calls[0].Name)
+assert.NotEmpty(t, calls[0].ID)
+assert.Equal(t, llm.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}, resp.Usage)
+
+_, err = fromGenaiResponse(&genai.GenerateContentResponse{})
+assert.Error(t, err)
+}
+
+func TestBackoff(t *testing.T) {
+defer func(d time.Duration) { retryDelay = d }(retryDelay)
+retryDelay = time.Hour
+
+// The first attempt does not wait
+assert.NoError(t, backoff(context.Background(), 0))
+
+// Cancelling stops the wait
+ctx, cancel := context.WithCancel(context.Background())
+time.AfterFunc(10*time.Millisecond, cancel)
+start := time.Now()
+assert.ErrorIs(t, backoff(ctx, 2), context.Canceled)
+assert.Less(t, time.Since(start), 5*time.Second)
+
+retryDelay = time.Millisecond
+assert.NoError(t, backoff(context.Background(), 1))
+}
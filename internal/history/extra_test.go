@@ -1,8 +1,9 @@
 package history
 
 import (
+"fmt"
 "os"
-"path/filepath"
+"sync"
 "testing"
 "github.com/stretchr/testify/assert"
 )
@@ -47,4 +48,31 @@ _ = os.WriteFile(path, []byte("invalid json"), 0644)
 name := h.GetSessionName(sessionID)
 assert.Equal(t, "New Conversation", name)
 })
+
+t.Run("SetMetadata_CorruptedMeta", func(t *testing.T) {
+sessionID := "badmeta-set"
+path := h.GetMetadataPath(sessionID)
+_ = os.WriteFile(path, []byte("invalid json"), 0644)
+
+assert.Error(t, h.SetSessionProfile(sessionID, "coder"))
+data, _ := os.ReadFile(path)
+assert.Equal(t, "invalid json", string(data))
+})
+
+t.Run("SetMetadata_Concurrent", func(t *testing.T) {
+sessionID := "concurrent"
+var wg sync.WaitGroup
+for i := 0; i < 20; i++ {
+wg.Add(4)
+go func() { defer wg.Done(); h.SetSessionName(sessionID, "name") }()
+go func() { defer wg.Done(); h.SetSessionProfile(sessionID, "profile") }()
+go func() { defer wg.Done(); h.SetSessionSandbox(sessionID, "sandbox") }()
+go func() { defer wg.Done(); h.SetSessionWorkdir(sessionID, fmt.Sprint("/work/", i)) }()
+}
+wg.Wait()
+assert.Equal(t, "name", h.GetSessionName(sessionID))
+assert.Equal(t, "profile", h.GetSessionProfile(sessionID))
+assert.Equal(t, "sandbox", h.GetSessionSandbox(sessionID))
+assert.NotEmpty(t, h.GetSessionWorkdir(sessionID))
+})
 }
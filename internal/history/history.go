@@ -6,6 +6,7 @@ import (
 "os"
 "path/filepath"
 "sort"
+"sync"
 "time"
 
 "github.com/google/uuid"
@@ -16,6 +17,28 @@ type Message struct {
 Role    string    `json:"role"` // "user", "assistant", "system", "tool"
 Content string    `json:"content"`
 Time    time.Time `json:"time"`
+// ToolCalls is set on model messages that requested function calls.
+ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+// ToolResults is set on "tool" messages carrying the outcome of those calls.
+ToolResults []ToolResult `json:"tool_results,omitempty"`
+// StopReason is set on the final model message of a run that was cut short.
+StopReason string `json:"stop_reason,omitempty"`
+}
+
+// ToolCall records a function call requested by the model.
+type ToolCall struct {
+ID        string                 `json:"id"`
+Name      string                 `json:"name"`
+Arguments map[string]interface{} `json:"arguments,omitempty"`
+}
+
+// ToolResult records the outcome of a ToolCall.
+type ToolResult struct {
+CallID     string `json:"call_id"`
+Name       string `json:"name"`
+Output     string `json:"output"`
+Error      string `json:"error,omitempty"`
+DurationMs int64  `json:"duration_ms"`
 }
 
 // Session represents a chat session metadata.
@@ -23,6 +46,9 @@ type Session struct {
 ID        string    `json:"id"`
 Name      string    `json:"name"`
 UpdatedAt time.Time `json:"updated_at"`
+Profile   string    `json:"profile,omitempty"`
+Sandbox   string    `json:"sandbox,omitempty"`
+Workdir   string    `json:"workdir,omitempty"`
 Messages  []Message `json:"messages,omitempty"`
 }
 
@@ -30,15 +56,25 @@ Messages  []Message `json:"messages,omitempty"`
 type History interface {
 CreateSession(name string) (string, error)
 AddMessage(sessionID, role, content string) error
+AppendMessage(sessionID string, msg Message) error
 LoadHistory(sessionID string) ([]Message, error)
 ListSessions() ([]Session, error)
 SetSessionName(sessionID, name string) error
 GetSessionName(sessionID string) string
+SetSessionProfile(sessionID, profile string) error
+GetSessionProfile(sessionID string) string
+SetSessionSandbox(sessionID, sandbox string) error
+GetSessionSandbox(sessionID string) string
+SetSessionWorkdir(sessionID, dir string) error
+GetSessionWorkdir(sessionID string) string
 }
 
 // FileHistory implements the History interface using local files.
 type FileHistory struct {
 StorageDir string
+
+// metaMu serializes updates of session metadata files
+metaMu sync.Mutex
 }
 
 // GetDefaultHistoryDir returns the default directory for storing history.
@@ -83,10 +119,13 @@ return id, nil
 }
 
 func (h *FileHistory) AddMessage(sessionID, role, content string) error {
-msg := Message{
-Role:    role,
-Content: content,
-Time:    time.Now(),
+return h.AppendMessage(sessionID, Message{Role: role, Content: content})
+}
+
+// AppendMessage stores a full message, including any tool calls or results.
+func (h *FileHistory) AppendMessage(sessionID string, msg Message) error {
+if msg.Time.IsZero() {
+msg.Time = time.Now()
 }
 
 path := h.GetSessionPath(sessionID)
@@ -109,26 +148,101 @@ return nil
 }
 
 func (h *FileHistory) SetSessionName(sessionID, name string) error {
-path := h.GetMetadataPath(sessionID)
-meta := map[string]string{"name": name}
-data, err := json.Marshal(meta)
-if err != nil {
-return err
-}
-return os.WriteFile(path, data, 0644)
+return h.setMetadata(sessionID, "name", name)
 }
 
 func (h *FileHistory) GetSessionName(sessionID string) string {
-path := h.GetMetadataPath(sessionID)
-data, err := os.ReadFile(path)
+meta, err := h.readMetadata(sessionID)
 if err != nil {
 return "New Conversation"
 }
+return meta["name"]
+}
+
+// SetSessionProfile records the system prompt profile used by the session.
+func (h *FileHistory) SetSessionProfile(sessionID, profile string) error {
+return h.setMetadata(sessionID, "profile", profile)
+}
+
+// GetSessionProfile returns the session's profile, or "" if none was chosen.
+func (h *FileHistory) GetSessionProfile(sessionID string) string {
+meta, _ := h.readMetadata(sessionID)
+return meta["profile"]
+}
+
+// SetSessionSandbox records the named sandbox the session's commands run in.
+func (h *FileHistory) SetSessionSandbox(sessionID, sandbox string) error {
+return h.setMetadata(sessionID, "sandbox", sandbox)
+}
+
+// GetSessionSandbox returns the session's sandbox, or "" if none was chosen.
+func (h *FileHistory) GetSessionSandbox(sessionID string) string {
+meta, _ := h.readMetadata(sessionID)
+return meta["sandbox"]
+}
+
+// SetSessionWorkdir records the directory the session's shell starts in.
+func (h *FileHistory) SetSessionWorkdir(sessionID, dir string) error {
+return h.setMetadata(sessionID, "workdir", dir)
+}
+
+// GetSessionWorkdir returns the session's working directory, or "" if none was chosen.
+func (h *FileHistory) GetSessionWorkdir(sessionID string) string {
+meta, _ := h.readMetadata(sessionID)
+return meta["workdir"]
+}
+
+func (h *FileHistory) readMetadata(sessionID string) (map[string]string, error) {
+data, err := os.ReadFile(h.GetMetadataPath(sessionID))
+if err != nil {
+return nil, err
+}
 var meta map[string]string
 if err := json.Unmarshal(data, &meta); err != nil {
-return "New Conversation"
+return nil, err
 }
-return meta["name"]
+return meta, nil
+}
+
+// setMetadata updates one key of the session metadata, keeping the others.
+// The file is replaced atomically so that readers never see it half
+// written.
+func (h *FileHistory) setMetadata(sessionID, key, value string) error {
+h.metaMu.Lock()
+defer h.metaMu.Unlock()
+
+meta, err := h.readMetadata(sessionID)
+if os.IsNotExist(err) {
+meta, err = make(map[string]string), nil
+}
+if err != nil {
+return fmt.Errorf("failed to read session metadata: %w", err)
+}
+meta[key] = value
+data, err := json.Marshal(meta)
+if err != nil {
+return err
+}
+
+f, err := os.CreateTemp(h.StorageDir, sessionID+".meta-*.tmp")
+if err != nil {
+return fmt.Errorf("failed to write session metadata: %w", err)
+}
+defer os.Remove(f.Name())
+_, err = f.Write(data)
+if cerr := f.Close(); err == nil {
+err = cerr
+}
+if err == nil {
+err = os.Chmod(f.Name(), 0644)
+}
+if err == nil {
+err = os.Rename(f.Name(), h.GetMetadataPath(sessionID))
+}
+if err != nil {
+return fmt.Errorf("failed to write session metadata: %w", err)
+}
+return nil
 }
 
 func (h *FileHistory) LoadHistory(sessionID string) ([]Message, error) {
@@ -174,10 +288,17 @@ continue
 }
 
 id := f.Name()[:len(f.Name())-6]
+meta, err := h.readMetadata(id)
+if err != nil {
+meta = map[string]string{"name": "New Conversation"}
+}
 sessions = append(sessions, Session{
 ID:        id,
-Name:      h.GetSessionName(id),
+Name:      meta["name"],
 UpdatedAt: info.ModTime(),
+Profile:   meta["profile"],
+Sandbox:   meta["sandbox"],
+Workdir:   meta["workdir"],
 })
 }
 
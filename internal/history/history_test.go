@@ -59,6 +59,47 @@ assert.NoError(t, err)
 assert.Equal(t, "New", h.GetSessionName(id))
 })
 
+t.Run("SessionProfile", func(t *testing.T) {
+id, err := h.CreateSession("Profiled")
+assert.NoError(t, err)
+assert.Equal(t, "", h.GetSessionProfile(id))
+
+assert.NoError(t, h.SetSessionProfile(id, "reviewer"))
+assert.Equal(t, "reviewer", h.GetSessionProfile(id))
+assert.Equal(t, "Profiled", h.GetSessionName(id))
+
+assert.NoError(t, h.SetSessionName(id, "Renamed"))
+assert.Equal(t, "reviewer", h.GetSessionProfile(id))
+})
+
+t.Run("SessionSandbox", func(t *testing.T) {
+id, err := h.CreateSession("Boxed")
+assert.NoError(t, err)
+assert.Equal(t, "", h.GetSessionSandbox(id))
+
+assert.NoError(t, h.SetSessionSandbox(id, "offline"))
+assert.NoError(t, h.SetSessionProfile(id, "reviewer"))
+assert.Equal(t, "offline", h.GetSessionSandbox(id))
+assert.Equal(t, "reviewer", h.GetSessionProfile(id))
+})
+
+t.Run("SessionWorkdir", func(t *testing.T) {
+id, err := h.CreateSession("Project")
+assert.NoError(t, err)
+assert.Equal(t, "", h.GetSessionWorkdir(id))
+
+assert.NoError(t, h.SetSessionWorkdir(id, "/src/project"))
+assert.Equal(t, "/src/project", h.GetSessionWorkdir(id))
+
+sessions, err := h.ListSessions()
+assert.NoError(t, err)
+for _, s := range sessions {
+if s.ID == id {
+assert.Equal(t, "/src/project", s.Workdir)
+}
+}
+})
+
 t.Run("GetNonExistentName", func(t *testing.T) {
 assert.Equal(t, "New Conversation", h.GetSessionName("none"))
 })
@@ -100,6 +141,27 @@ assert.Equal(t, "user", msgs[0].Role)
 assert.Equal(t, "hello", msgs[0].Content)
 })
 
+t.Run("ToolRecords", func(t *testing.T) {
+id := "tool-test"
+err := h.AppendMessage(id, Message{
+Role:      "model",
+ToolCalls: []ToolCall{{ID: "c1", Name: "execute_command", Arguments: map[string]interface{}{"command": "ls"}}},
+})
+assert.NoError(t, err)
+err = h.AppendMessage(id, Message{
+Role:        "tool",
+ToolResults: []ToolResult{{CallID: "c1", Name: "execute_command", Error: "denied", DurationMs: 12}},
+})
+assert.NoError(t, err)
+
+msgs, err := h.LoadHistory(id)
+assert.NoError(t, err)
+assert.Len(t, msgs, 2)
+assert.False(t, msgs[0].Time.IsZero())
+assert.Equal(t, "ls", msgs[0].ToolCalls[0].Arguments["command"])
+assert.Equal(t, ToolResult{CallID: "c1", Name: "execute_command", Error: "denied", DurationMs: 12}, msgs[1].ToolResults[0])
+})
+
 t.Run("LoadNonExistent", func(t *testing.T) {
 msgs, err := h.LoadHistory("ghost")
 assert.NoError(t, err)
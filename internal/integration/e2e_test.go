@@ -5,17 +5,17 @@ import (
 "testing"
 
 "github.com/LeeroyDing/hyperagent/internal/agent"
-"github.com/LeeroyDing/hyperagent/internal/gemini"
+"github.com/LeeroyDing/hyperagent/internal/llm"
 )
 
 func TestE2E_AgentLoop(t *testing.T) {
 // 1. Setup Mocks
-mockGemini := &MockGeminiClient{
+mockGemini := &MockLLMClient{
 Responses: []string{
 "", // First response triggers a tool call
 "The output is: Mock output for: echo 'hello'", // Second response after tool execution
 },
-ToolCalls: [][]gemini.ToolCall{
+ToolCalls: [][]llm.FunctionCall{
 {
 {Name: "execute_command", Arguments: map[string]interface{}{"command": "echo 'hello'"}},
 },
@@ -3,50 +3,45 @@ package integration
 import (
 "context"
 
-"github.com/LeeroyDing/hyperagent/internal/gemini"
+"github.com/LeeroyDing/hyperagent/internal/executor"
 "github.com/LeeroyDing/hyperagent/internal/history"
-"github.com/google/generative-ai-go/genai"
+"github.com/LeeroyDing/hyperagent/internal/llm"
 "github.com/philippgille/chromem-go"
 )
 
-// MockGeminiClient implements the gemini.GeminiClient interface for testing.
-type MockGeminiClient struct {
+// MockLLMClient implements the llm.Client interface for testing.
+type MockLLMClient struct {
 Responses     []string
-ToolCalls     [][]gemini.ToolCall
+ToolCalls     [][]llm.FunctionCall
 ResponseIndex int
 }
 
-func (m *MockGeminiClient) GenerateContent(ctx context.Context, messages []gemini.Message, tools []*genai.Tool) (string, []gemini.ToolCall, error) {
+func (m *MockLLMClient) next(fallback string) *llm.Response {
 if m.ResponseIndex >= len(m.Responses) {
-return "Mock response", nil, nil
+return &llm.Response{Message: llm.NewTextMessage(llm.RoleModel, fallback)}
+}
+msg := llm.Message{Role: llm.RoleModel}
+if text := m.Responses[m.ResponseIndex]; text != "" {
+msg.Parts = append(msg.Parts, llm.Part{Text: text})
 }
-response := m.Responses[m.ResponseIndex]
-var toolCalls []gemini.ToolCall
 if m.ResponseIndex < len(m.ToolCalls) {
-toolCalls = m.ToolCalls[m.ResponseIndex]
+for i := range m.ToolCalls[m.ResponseIndex] {
+msg.Parts = append(msg.Parts, llm.Part{FunctionCall: &m.ToolCalls[m.ResponseIndex][i]})
+}
 }
 m.ResponseIndex++
-return response, toolCalls, nil
+return &llm.Response{Message: msg}
 }
 
-func (m *MockGeminiClient) SendToolResponse(ctx context.Context, messages []gemini.Message, tools []*genai.Tool, toolResponses []gemini.ToolResponse) (string, []gemini.ToolCall, error) {
-if m.ResponseIndex >= len(m.Responses) {
-return "Mock tool response", nil, nil
-}
-response := m.Responses[m.ResponseIndex]
-var toolCalls []gemini.ToolCall
-if m.ResponseIndex < len(m.ToolCalls) {
-toolCalls = m.ToolCalls[m.ResponseIndex]
-}
-m.ResponseIndex++
-return response, toolCalls, nil
+func (m *MockLLMClient) GenerateContent(ctx context.Context, messages []llm.Message, tools []llm.Tool) (*llm.Response, error) {
+return m.next("Mock response"), nil
 }
 
-func (m *MockGeminiClient) EmbedContent(ctx context.Context, text string) ([]float32, error) {
+func (m *MockLLMClient) EmbedContent(ctx context.Context, text string) ([]float32, error) {
 return []float32{0.1, 0.2, 0.3}, nil
 }
 
-func (m *MockGeminiClient) Close() error {
+func (m *MockLLMClient) Close() error {
 return nil
 }
 
@@ -60,9 +55,9 @@ type MockExecutor struct {
 ExecutedCommands []string
 }
 
-func (m *MockExecutor) Execute(sessionID, command string) (string, error) {
-m.ExecutedCommands = append(m.ExecutedCommands, command)
-return "Mock output for: " + command, nil
+func (m *MockExecutor) Execute(ctx context.Context, sessionID string, cmd executor.Command) (executor.Result, error) {
+m.ExecutedCommands = append(m.ExecutedCommands, cmd.Line)
+return executor.Result{Output: "Mock output for: " + cmd.Line}, nil
 }
 
 // MockMemory implements the memory.Memory interface for testing.
@@ -112,13 +107,14 @@ return id, nil
 }
 
 func (h *MockHistory) AddMessage(sessionID, role, content string) error {
+return h.AppendMessage(sessionID, history.Message{Role: role, Content: content})
+}
+
+func (h *MockHistory) AppendMessage(sessionID string, msg history.Message) error {
 if h.Sessions == nil {
 h.Sessions = make(map[string][]history.Message)
 }
-h.Sessions[sessionID] = append(h.Sessions[sessionID], history.Message{
-Role:    role,
-Content: content,
-})
+h.Sessions[sessionID] = append(h.Sessions[sessionID], msg)
 return nil
 }
 
@@ -140,3 +136,27 @@ return nil
 func (h *MockHistory) GetSessionName(sessionID string) string {
 return "Mock Session"
 }
+
+func (h *MockHistory) SetSessionProfile(sessionID, profile string) error {
+return nil
+}
+
+func (h *MockHistory) GetSessionProfile(sessionID string) string {
+return ""
+}
+
+func (h *MockHistory) SetSessionSandbox(sessionID, sandbox string) error {
+return nil
+}
+
+func (h *MockHistory) GetSessionSandbox(sessionID string) string {
+return ""
+}
+
+func (h *MockHistory) SetSessionWorkdir(sessionID, dir string) error {
+return nil
+}
+
+func (h *MockHistory) GetSessionWorkdir(sessionID string) string {
+return ""
+}
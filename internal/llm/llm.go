@@ -0,0 +1,157 @@
+// Package llm defines a provider-neutral model of conversations, tools and
+// responses. Backends such as Gemini or OpenAI-compatible servers adapt it to
+// their own wire formats.
+package llm
+
+import (
+"context"
+"strings"
+)
+
+// Role identifies the author of a message.
+type Role string
+
+const (
+RoleSystem Role = "system"
+RoleUser   Role = "user"
+RoleModel  Role = "model"
+// RoleTool carries function results back to the model.
+RoleTool Role = "tool"
+)
+
+// NormalizeRole maps legacy role names such as "assistant" onto the Role constants.
+func NormalizeRole(role string) Role {
+switch role {
+case "model", "assistant":
+return RoleModel
+case "system":
+return RoleSystem
+case "tool", "function":
+return RoleTool
+default:
+return RoleUser
+}
+}
+
+// FunctionCall is a request from the model to invoke a tool.
+type FunctionCall struct {
+// ID correlates the call with its FunctionResult. Providers that do not
+// issue IDs get one generated by their adapter.
+ID        string                 `json:"id,omitempty"`
+Name      string                 `json:"name"`
+Arguments map[string]interface{} `json:"arguments,omitempty"`
+}
+
+// FunctionResult is the outcome of a FunctionCall.
+type FunctionResult struct {
+ID      string `json:"id,omitempty"`
+Name    string `json:"name"`
+Content string `json:"content"`
+IsError bool   `json:"is_error,omitempty"`
+}
+
+// Part is one piece of a message. Exactly one field is set.
+type Part struct {
+Text           string          `json:"text,omitempty"`
+FunctionCall   *FunctionCall   `json:"function_call,omitempty"`
+FunctionResult *FunctionResult `json:"function_result,omitempty"`
+}
+
+// Message is a single turn in a conversation.
+type Message struct {
+Role  Role   `json:"role"`
+Parts []Part `json:"parts"`
+}
+
+// NewTextMessage creates a message with a single text part.
+func NewTextMessage(role Role, text string) Message {
+return Message{Role: role, Parts: []Part{{Text: text}}}
+}
+
+// Text concatenates the text parts of the message.
+func (m Message) Text() string {
+var sb strings.Builder
+for _, p := range m.Parts {
+sb.WriteString(p.Text)
+}
+return sb.String()
+}
+
+// FunctionCalls returns the function calls contained in the message.
+func (m Message) FunctionCalls() []FunctionCall {
+var calls []FunctionCall
+for _, p := range m.Parts {
+if p.FunctionCall != nil {
+calls = append(calls, *p.FunctionCall)
+}
+}
+return calls
+}
+
+// FunctionResults returns the function results contained in the message.
+func (m Message) FunctionResults() []FunctionResult {
+var results []FunctionResult
+for _, p := range m.Parts {
+if p.FunctionResult != nil {
+results = append(results, *p.FunctionResult)
+}
+}
+return results
+}
+
+// Usage reports token consumption of a single request.
+type Usage struct {
+PromptTokens     int `json:"prompt_tokens"`
+CompletionTokens int `json:"completion_tokens"`
+TotalTokens      int `json:"total_tokens"`
+}
+
+// Response is the model's reply to a request.
+type Response struct {
+Message Message
+Usage   Usage
+}
+
+// Text returns the text of the reply.
+func (r *Response) Text() string {
+return r.Message.Text()
+}
+
+// FunctionCalls returns the tool invocations requested by the reply.
+func (r *Response) FunctionCalls() []FunctionCall {
+return r.Message.FunctionCalls()
+}
+
+// Tool declares a function the model may call.
+type Tool struct {
+Name        string
+Description string
+Parameters  *Schema
+}
+
+// NewFunctionResultMessage wraps tool results in a single RoleTool message.
+func NewFunctionResultMessage(results []FunctionResult) Message {
+msg := Message{Role: RoleTool}
+for i := range results {
+msg.Parts = append(msg.Parts, Part{FunctionResult: &results[i]})
+}
+return msg
+}
+
+// Client is implemented by every model backend.
+type Client interface {
+// GenerateContent sends the whole conversation and returns the model's next turn.
+// The conversation may contain earlier function calls and their results.
+GenerateContent(ctx context.Context, messages []Message, tools []Tool) (*Response, error)
+EmbedContent(ctx context.Context, text string) ([]float32, error)
+Close() error
+}
+
+// StreamClient is implemented by backends that can stream a reply as it is
+// generated.
+type StreamClient interface {
+Client
+// GenerateContentStream behaves like GenerateContent but calls onText with
+// every text delta before returning the complete response.
+GenerateContentStream(ctx context.Context, messages []Message, tools []Tool, onText func(string)) (*Response, error)
+}
@@ -0,0 +1,137 @@
+package llm
+
+// Type is a JSON Schema primitive type name.
+type Type string
+
+const (
+TypeString  Type = "string"
+TypeNumber  Type = "number"
+TypeInteger Type = "integer"
+TypeBoolean Type = "boolean"
+TypeArray   Type = "array"
+TypeObject  Type = "object"
+)
+
+// Schema is the subset of JSON Schema used to describe tool parameters.
+type Schema struct {
+Type        Type
+Description string
+Format      string
+Nullable    bool
+Enum        []string
+Items       *Schema
+Properties  map[string]*Schema
+Required    []string
+}
+
+// SchemaFromJSON converts a decoded JSON Schema object into a Schema.
+// Keywords outside the supported subset are dropped.
+func SchemaFromJSON(v map[string]interface{}) *Schema {
+s := &Schema{}
+
+switch t := v["type"].(type) {
+case string:
+s.Type = schemaType(t)
+case []interface{}:
+// e.g. ["string", "null"]
+for _, item := range t {
+name, _ := item.(string)
+if name == "null" {
+s.Nullable = true
+continue
+}
+if s.Type == "" {
+s.Type = schemaType(name)
+}
+}
+}
+if s.Type == "" {
+if _, ok := v["properties"]; ok {
+s.Type = TypeObject
+} else {
+s.Type = TypeString
+}
+}
+
+if d, ok := v["description"].(string); ok {
+s.Description = d
+}
+if f, ok := v["format"].(string); ok {
+s.Format = f
+}
+if enum, ok := v["enum"].([]interface{}); ok && s.Type == TypeString {
+for _, e := range enum {
+if str, ok := e.(string); ok {
+s.Enum = append(s.Enum, str)
+}
+}
+}
+
+switch s.Type {
+case TypeObject:
+if props, ok := v["properties"].(map[string]interface{}); ok && len(props) > 0 {
+s.Properties = make(map[string]*Schema, len(props))
+for name, p := range props {
+if pm, ok := p.(map[string]interface{}); ok {
+s.Properties[name] = SchemaFromJSON(pm)
+} else {
+s.Properties[name] = &Schema{Type: TypeString}
+}
+}
+}
+if req, ok := v["required"].([]interface{}); ok {
+for _, r := range req {
+if name, ok := r.(string); ok {
+if _, exists := s.Properties[name]; exists {
+s.Required = append(s.Required, name)
+}
+}
+}
+}
+case TypeArray:
+if items, ok := v["items"].(map[string]interface{}); ok {
+s.Items = SchemaFromJSON(items)
+} else {
+s.Items = &Schema{Type: TypeString}
+}
+}
+
+return s
+}
+
+// JSON renders the schema as a decoded JSON Schema object.
+func (s *Schema) JSON() map[string]interface{} {
+out := map[string]interface{}{"type": string(s.Type)}
+if s.Description != "" {
+out["description"] = s.Description
+}
+if s.Format != "" {
+out["format"] = s.Format
+}
+if len(s.Enum) > 0 {
+out["enum"] = s.Enum
+}
+if s.Items != nil {
+out["items"] = s.Items.JSON()
+}
+if s.Type == TypeObject {
+props := map[string]interface{}{}
+for name, p := range s.Properties {
+props[name] = p.JSON()
+}
+out["properties"] = props
+if len(s.Required) > 0 {
+out["required"] = s.Required
+}
+}
+return out
+}
+
+func schemaType(name string) Type {
+switch t := Type(name); t {
+case TypeString, TypeNumber, TypeInteger, TypeBoolean, TypeArray, TypeObject:
+return t
+default:
+return ""
+}
+}
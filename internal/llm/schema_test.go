@@ -0,0 +1,45 @@
+package llm
+
+import (
+"testing"
+
+"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaFromJSON(t *testing.T) {
+s := SchemaFromJSON(map[string]interface{}{
+"type": "object",
+"properties": map[string]interface{}{
+"tags":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+"count": map[string]interface{}{"type": []interface{}{"integer", "null"}},
+"mode":  map[string]interface{}{"type": "string", "enum": []interface{}{"a", "b"}},
+},
+"required": []interface{}{"tags", "missing"},
+})
+
+assert.Equal(t, TypeObject, s.Type)
+assert.Equal(t, TypeArray, s.Properties["tags"].Type)
+assert.Equal(t, TypeString, s.Properties["tags"].Items.Type)
+assert.Equal(t, TypeInteger, s.Properties["count"].Type)
+assert.True(t, s.Properties["count"].Nullable)
+assert.Equal(t, []string{"a", "b"}, s.Properties["mode"].Enum)
+assert.Equal(t, []string{"tags"}, s.Required)
+}
+
+func TestSchema_JSON(t *testing.T) {
+s := &Schema{
+Type: TypeObject,
+Properties: map[string]*Schema{
+"path": {Type: TypeString, Description: "Path"},
+},
+Required: []string{"path"},
+}
+
+assert.Equal(t, map[string]interface{}{
+"type": "object",
+"properties": map[string]interface{}{
+"path": map[string]interface{}{"type": "string", "description": "Path"},
+},
+"required": []string{"path"},
+}, s.JSON())
+}
@@ -3,27 +3,90 @@ package mcp
 import (
 "context"
 "fmt"
+"hash/fnv"
+"log/slog"
+"strings"
+"sync"
+
 "github.com/mark3labs/mcp-go/client"
 "github.com/mark3labs/mcp-go/mcp"
 )
 
+// toolNameSeparator joins a server name and a tool name into the name exposed to the model.
+const toolNameSeparator = "__"
+
+// maxToolNameLength is the longest function name accepted by the Gemini API.
+const maxToolNameLength = 64
+
 type ServerConfig struct {
-Name    string   `yaml:"name"` 
-Command string   `yaml:"command"` 
-Args    []string `yaml:"args"` 
-Env     []string `yaml:"env"` 
+Name     string   `yaml:"name"`
+Command  string   `yaml:"command"`
+Args     []string `yaml:"args"`
+Env      []string `yaml:"env"`
+Disabled bool     `yaml:"disabled"`
+}
+
+// RemoteTool is a tool discovered on an MCP server.
+type RemoteTool struct {
+Server string
+Tool   mcp.Tool
 }
 
 type MCPManager struct {
 Clients map[string]*client.Client
-Tools   map[string]mcp.Tool
+// Tools is keyed by the namespaced name returned by QualifiedName.
+Tools map[string]RemoteTool
+// Options controls how servers started with Start are supervised.
+Options SupervisorOptions
+
+mu       sync.RWMutex
+statuses map[string]*ServerStatus
+cancel   context.CancelFunc
+wg       sync.WaitGroup
 }
 
 func NewMCPManager() *MCPManager {
 return &MCPManager{
-Clients: make(map[string]*client.Client),
-Tools:   make(map[string]mcp.Tool),
+Clients:  make(map[string]*client.Client),
+Tools:    make(map[string]RemoteTool),
+Options:  DefaultSupervisorOptions(),
+statuses: make(map[string]*ServerStatus),
+}
+}
+
+// QualifiedName returns the name under which a server's tool is exposed to the model.
+// Characters the Gemini API rejects in function names are replaced with underscores.
+// Names that are too long are cut and end in a hash of the full names, so
+// that tools sharing a long prefix keep apart.
+func QualifiedName(serverName, toolName string) string {
+name := sanitizeName(serverName) + toolNameSeparator + sanitizeName(toolName)
+if len(name) > maxToolNameLength {
+name = hashedName(name, serverName, toolName)
 }
+return name
+}
+
+// hashedName replaces the end of name with a hash of the server and tool
+// names, keeping it within maxToolNameLength.
+func hashedName(name, serverName, toolName string) string {
+h := fnv.New32a()
+h.Write([]byte(serverName + "\x00" + toolName))
+suffix := fmt.Sprintf("_%08x", h.Sum32())
+if len(name) > maxToolNameLength-len(suffix) {
+name = name[:maxToolNameLength-len(suffix)]
+}
+return name + suffix
+}
+
+func sanitizeName(s string) string {
+return strings.Map(func(r rune) rune {
+switch {
+case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+return r
+default:
+return '_'
+}
+}, s)
 }
 
 func (m *MCPManager) AddServer(ctx context.Context, config ServerConfig) error {
@@ -32,8 +95,17 @@ if err != nil {
 return fmt.Errorf("failed to create MCP client %s: %w", config.Name, err)
 }
 
+if err := m.AddClient(ctx, config.Name, c); err != nil {
+c.Close()
+return err
+}
+return nil
+}
+
+// AddClient initializes an already started client and registers its tools under serverName.
+func (m *MCPManager) AddClient(ctx context.Context, serverName string, c *client.Client) error {
 // Initialize the client
-_, err = c.Initialize(ctx, mcp.InitializeRequest{
+_, err := c.Initialize(ctx, mcp.InitializeRequest{
 Params: mcp.InitializeParams{
 ProtocolVersion: mcp.LATEST_PROTOCOL_VERSION,
 Capabilities:    mcp.ClientCapabilities{},
@@ -44,26 +116,84 @@ Version: "0.1.0",
 },
 })
 if err != nil {
-return fmt.Errorf("failed to initialize MCP client %s: %w", config.Name, err)
+return fmt.Errorf("failed to initialize MCP client %s: %w", serverName, err)
 }
 
-m.Clients[config.Name] = c
-
 // Discover tools
 toolsResp, err := c.ListTools(ctx, mcp.ListToolsRequest{})
 if err != nil {
-return fmt.Errorf("failed to list tools for %s: %w", config.Name, err)
+return fmt.Errorf("failed to list tools for %s: %w", serverName, err)
 }
 
+m.mu.Lock()
+defer m.mu.Unlock()
+m.Clients[serverName] = c
+m.removeToolsLocked(serverName)
 for _, tool := range toolsResp.Tools {
-m.Tools[tool.Name] = tool
+name := QualifiedName(serverName, tool.Name)
+if other, ok := m.Tools[name]; ok {
+// Names that differ only in characters replaced by sanitizeName
+renamed := hashedName(name, serverName, tool.Name)
+slog.Warn("MCP tool name is taken, renaming it", "server", serverName, "tool", tool.Name, "name", name, "taken_by", other.Server+"/"+other.Tool.Name, "renamed", renamed)
+if _, ok := m.Tools[renamed]; ok {
+slog.Error("MCP tool name is taken, skipping the tool", "server", serverName, "tool", tool.Name, "name", renamed)
+continue
+}
+name = renamed
+}
+m.Tools[name] = RemoteTool{Server: serverName, Tool: tool}
+}
+
+return nil
+}
+
+// removeServer forgets a server's client and tools and returns the client, if any.
+func (m *MCPManager) removeServer(serverName string) *client.Client {
+m.mu.Lock()
+defer m.mu.Unlock()
+c := m.Clients[serverName]
+delete(m.Clients, serverName)
+m.removeToolsLocked(serverName)
+return c
 }
 
+func (m *MCPManager) removeToolsLocked(serverName string) {
+for name, t := range m.Tools {
+if t.Server == serverName {
+delete(m.Tools, name)
+}
+}
+}
+
+// Lookup resolves a namespaced tool name to the server and tool that provide it.
+func (m *MCPManager) Lookup(name string) (RemoteTool, bool) {
+if m == nil {
+return RemoteTool{}, false
+}
+m.mu.RLock()
+defer m.mu.RUnlock()
+t, ok := m.Tools[name]
+return t, ok
+}
+
+// ListTools returns a snapshot of all currently registered tools keyed by namespaced name.
+func (m *MCPManager) ListTools() map[string]RemoteTool {
+if m == nil {
 return nil
 }
+m.mu.RLock()
+defer m.mu.RUnlock()
+tools := make(map[string]RemoteTool, len(m.Tools))
+for name, t := range m.Tools {
+tools[name] = t
+}
+return tools
+}
 
 func (m *MCPManager) CallTool(ctx context.Context, serverName, toolName string, arguments map[string]interface{}) (*mcp.CallToolResult, error) {
+m.mu.RLock()
 client, ok := m.Clients[serverName]
+m.mu.RUnlock()
 if !ok {
 return nil, fmt.Errorf("MCP server %s not found", serverName)
 }
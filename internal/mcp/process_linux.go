@@ -0,0 +1,21 @@
+package mcp
+
+import "golang.org/x/sys/unix"
+
+// processExited returns a channel that is closed when the child process
+// pid exits. The process is not reaped, so that whoever started it can
+// still wait for it.
+func processExited(pid int) <-chan struct{} {
+exited := make(chan struct{})
+go func() {
+defer close(exited)
+var info unix.Siginfo
+for {
+err := unix.Waitid(unix.P_PID, pid, &info, unix.WEXITED|unix.WNOWAIT, nil)
+if err != unix.EINTR {
+return
+}
+}
+}()
+return exited
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package mcp
+
+// processExited returns nil where exits cannot be watched without reaping
+// the process; crashes are then found by the health checks.
+func processExited(pid int) <-chan struct{} {
+return nil
+}
@@ -0,0 +1,88 @@
+package mcp
+
+import (
+"encoding/base64"
+"encoding/json"
+"fmt"
+"strings"
+
+"github.com/mark3labs/mcp-go/mcp"
+)
+
+// FormatResult renders the content of a tool call result as text for the model.
+// Binary payloads such as images are summarized rather than inlined.
+func FormatResult(res *mcp.CallToolResult) string {
+if res == nil {
+return ""
+}
+
+var parts []string
+for _, c := range res.Content {
+if s := formatContent(c); s != "" {
+parts = append(parts, s)
+}
+}
+
+// Fall back to structured content when the server sent nothing else
+if len(parts) == 0 && res.StructuredContent != nil {
+data, err := json.Marshal(res.StructuredContent)
+if err == nil {
+parts = append(parts, string(data))
+}
+}
+
+return strings.Join(parts, "\n")
+}
+
+func formatContent(c mcp.Content) string {
+switch v := c.(type) {
+case mcp.TextContent:
+return v.Text
+case *mcp.TextContent:
+return v.Text
+case mcp.ImageContent:
+return formatBinary("image", v.MIMEType, v.Data)
+case *mcp.ImageContent:
+return formatBinary("image", v.MIMEType, v.Data)
+case mcp.AudioContent:
+return formatBinary("audio", v.MIMEType, v.Data)
+case *mcp.AudioContent:
+return formatBinary("audio", v.MIMEType, v.Data)
+case mcp.ResourceLink:
+return fmt.Sprintf("[resource link: %s <%s>]", v.Name, v.URI)
+case *mcp.ResourceLink:
+return fmt.Sprintf("[resource link: %s <%s>]", v.Name, v.URI)
+case mcp.EmbeddedResource:
+return formatResource(v.Resource)
+case *mcp.EmbeddedResource:
+return formatResource(v.Resource)
+default:
+return ""
+}
+}
+
+func formatResource(r mcp.ResourceContents) string {
+switch v := r.(type) {
+case mcp.TextResourceContents:
+return fmt.Sprintf("[resource %s]\n%s", v.URI, v.Text)
+case *mcp.TextResourceContents:
+return fmt.Sprintf("[resource %s]\n%s", v.URI, v.Text)
+case mcp.BlobResourceContents:
+return fmt.Sprintf("[resource %s: %s]", v.URI, describeBlob(v.MIMEType, v.Blob))
+case *mcp.BlobResourceContents:
+return fmt.Sprintf("[resource %s: %s]", v.URI, describeBlob(v.MIMEType, v.Blob))
+default:
+return ""
+}
+}
+
+func formatBinary(kind, mimeType, data string) string {
+return fmt.Sprintf("[%s: %s]", kind, describeBlob(mimeType, data))
+}
+
+func describeBlob(mimeType, data string) string {
+if mimeType == "" {
+mimeType = "application/octet-stream"
+}
+return fmt.Sprintf("%s, %d bytes", mimeType, base64.StdEncoding.DecodedLen(len(data)))
+}
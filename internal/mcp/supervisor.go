@@ -0,0 +1,261 @@
+package mcp
+
+import (
+"context"
+"errors"
+"fmt"
+"log/slog"
+"os"
+"os/exec"
+"sort"
+"time"
+
+"github.com/mark3labs/mcp-go/client"
+"github.com/mark3labs/mcp-go/client/transport"
+)
+
+// ServerState describes where a supervised MCP server is in its lifecycle.
+type ServerState string
+
+const (
+StateStarting ServerState = "starting"
+StateReady    ServerState = "ready"
+StateCrashed  ServerState = "crashed"
+StateDisabled ServerState = "disabled"
+)
+
+// ServerStatus reports the health of one configured MCP server.
+type ServerStatus struct {
+Name      string      `json:"name"`
+State     ServerState `json:"state"`
+Restarts  int         `json:"restarts"`
+LastError string      `json:"last_error,omitempty"`
+}
+
+// SupervisorOptions controls health checking and restart behaviour for servers launched by Start.
+type SupervisorOptions struct {
+// HealthInterval is how often a ready server is pinged.
+HealthInterval time.Duration
+// PingTimeout bounds a single health check.
+PingTimeout time.Duration
+// StartTimeout bounds initialization and tool discovery of a freshly spawned server.
+StartTimeout time.Duration
+// InitialBackoff is the delay before the first restart; it doubles up to MaxBackoff.
+InitialBackoff time.Duration
+MaxBackoff     time.Duration
+// MaxRestarts disables a server after this many consecutive failed restarts (0 means unlimited).
+MaxRestarts int
+}
+
+// DefaultSupervisorOptions returns the options used by NewMCPManager.
+func DefaultSupervisorOptions() SupervisorOptions {
+return SupervisorOptions{
+HealthInterval: 30 * time.Second,
+PingTimeout:    5 * time.Second,
+StartTimeout:   30 * time.Second,
+InitialBackoff: 1 * time.Second,
+MaxBackoff:     1 * time.Minute,
+MaxRestarts:    5,
+}
+}
+
+// Start launches every enabled server in the background and keeps it running until Close.
+// Crashed servers are restarted with exponential backoff and their tools rediscovered.
+func (m *MCPManager) Start(ctx context.Context, configs []ServerConfig) {
+ctx, cancel := context.WithCancel(ctx)
+m.mu.Lock()
+m.cancel = cancel
+m.mu.Unlock()
+
+for _, cfg := range configs {
+if cfg.Disabled {
+m.setStatus(cfg.Name, StateDisabled, nil)
+slog.Info("MCP server disabled in config", "server", cfg.Name)
+continue
+}
+m.setStatus(cfg.Name, StateStarting, nil)
+m.wg.Add(1)
+go m.supervise(ctx, cfg)
+}
+}
+
+// Statuses returns the current state of every supervised server, sorted by name.
+func (m *MCPManager) Statuses() []ServerStatus {
+m.mu.RLock()
+defer m.mu.RUnlock()
+statuses := make([]ServerStatus, 0, len(m.statuses))
+for _, s := range m.statuses {
+statuses = append(statuses, *s)
+}
+sort.Slice(statuses, func(i, j int) bool {
+return statuses[i].Name < statuses[j].Name
+})
+return statuses
+}
+
+// Close stops supervision and shuts down every connected server.
+func (m *MCPManager) Close() error {
+m.mu.RLock()
+cancel := m.cancel
+m.mu.RUnlock()
+if cancel != nil {
+cancel()
+}
+m.wg.Wait()
+
+m.mu.Lock()
+defer m.mu.Unlock()
+var errs []error
+for name, c := range m.Clients {
+if err := c.Close(); err != nil {
+errs = append(errs, fmt.Errorf("failed to close MCP client %s: %w", name, err))
+}
+delete(m.Clients, name)
+m.removeToolsLocked(name)
+}
+return errors.Join(errs...)
+}
+
+func (m *MCPManager) setStatus(name string, state ServerState, err error) {
+m.mu.Lock()
+defer m.mu.Unlock()
+s, ok := m.statuses[name]
+if !ok {
+s = &ServerStatus{Name: name}
+m.statuses[name] = s
+}
+s.State = state
+if err != nil {
+s.LastError = err.Error()
+}
+}
+
+func (m *MCPManager) countRestart(name string) {
+m.mu.Lock()
+defer m.mu.Unlock()
+if s, ok := m.statuses[name]; ok {
+s.Restarts++
+}
+}
+
+func (m *MCPManager) supervise(ctx context.Context, cfg ServerConfig) {
+defer m.wg.Done()
+
+opts := m.Options
+backoff := opts.InitialBackoff
+failures := 0
+for {
+m.setStatus(cfg.Name, StateStarting, nil)
+cmd, err := m.launch(ctx, cfg)
+if err == nil {
+m.setStatus(cfg.Name, StateReady, nil)
+slog.Info("MCP server ready", "server", cfg.Name)
+var healthy bool
+healthy, err = m.watch(ctx, cfg.Name, processExited(cmd.Process.Pid))
+m.stop(cfg.Name, cmd)
+if healthy {
+// The server ran fine for a while, so start counting from scratch
+failures = 0
+backoff = opts.InitialBackoff
+}
+}
+if ctx.Err() != nil {
+return
+}
+
+failures++
+m.setStatus(cfg.Name, StateCrashed, err)
+slog.Warn("MCP server crashed", "server", cfg.Name, "error", err, "failures", failures)
+
+if opts.MaxRestarts > 0 && failures > opts.MaxRestarts {
+m.setStatus(cfg.Name, StateDisabled, fmt.Errorf("giving up after %d failed restarts: %w", opts.MaxRestarts, err))
+slog.Error("MCP server disabled after repeated crashes", "server", cfg.Name)
+return
+}
+
+select {
+case <-ctx.Done():
+return
+case <-time.After(backoff):
+}
+backoff *= 2
+if backoff > opts.MaxBackoff {
+backoff = opts.MaxBackoff
+}
+m.countRestart(cfg.Name)
+}
+}
+
+// launch spawns the server process, initializes it and registers its tools.
+func (m *MCPManager) launch(ctx context.Context, cfg ServerConfig) (*exec.Cmd, error) {
+var cmd *exec.Cmd
+cmdFunc := func(_ context.Context, command string, env []string, args []string) (*exec.Cmd, error) {
+cmd = exec.Command(command, args...)
+cmd.Env = append(os.Environ(), env...)
+return cmd, nil
+}
+
+c, err := client.NewStdioMCPClientWithOptions(cfg.Command, cfg.Env, cfg.Args, transport.WithCommandFunc(cmdFunc))
+if err != nil {
+return nil, fmt.Errorf("failed to create MCP client %s: %w", cfg.Name, err)
+}
+
+startCtx, cancel := context.WithTimeout(ctx, m.Options.StartTimeout)
+defer cancel()
+if err := m.AddClient(startCtx, cfg.Name, c); err != nil {
+killAndClose(c, cmd)
+return nil, err
+}
+return cmd, nil
+}
+
+// watch pings the server until a health check fails, its process exits or
+// ctx is cancelled. It reports whether at least one health check succeeded.
+func (m *MCPManager) watch(ctx context.Context, name string, exited <-chan struct{}) (bool, error) {
+ticker := time.NewTicker(m.Options.HealthInterval)
+defer ticker.Stop()
+
+healthy := false
+for {
+select {
+case <-ctx.Done():
+return healthy, nil
+case <-exited:
+return healthy, fmt.Errorf("the server process exited")
+case <-ticker.C:
+}
+
+m.mu.RLock()
+c, ok := m.Clients[name]
+m.mu.RUnlock()
+if !ok {
+return healthy, fmt.Errorf("MCP client %s disappeared", name)
+}
+
+pingCtx, cancel := context.WithTimeout(ctx, m.Options.PingTimeout)
+err := c.Ping(pingCtx)
+cancel()
+if err != nil {
+if ctx.Err() != nil {
+return healthy, nil
+}
+return healthy, fmt.Errorf("health check failed: %w", err)
+}
+healthy = true
+}
+}
+
+// stop unregisters a server and terminates its process.
+func (m *MCPManager) stop(name string, cmd *exec.Cmd) {
+if c := m.removeServer(name); c != nil {
+killAndClose(c, cmd)
+}
+}
+
+func killAndClose(c *client.Client, cmd *exec.Cmd) {
+if cmd != nil && cmd.Process != nil {
+cmd.Process.Kill()
+}
+// Close reaps the process; a "signal: killed" wait error is expected here
+c.Close()
+}
@@ -0,0 +1,191 @@
+package mcp
+
+import (
+"context"
+"os"
+"runtime"
+"strings"
+"testing"
+"time"
+
+"github.com/mark3labs/mcp-go/mcp"
+"github.com/mark3labs/mcp-go/server"
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+// stubServerEnv makes the test binary act as a stub MCP server over stdio.
+const stubServerEnv = "HYPERAGENT_MCP_STUB"
+
+func TestMain(m *testing.M) {
+if os.Getenv(stubServerEnv) == "1" {
+runStubServer()
+return
+}
+os.Exit(m.Run())
+}
+
+func runStubServer() {
+srv := server.NewMCPServer("stub", "1.0.0", server.WithToolCapabilities(false))
+srv.AddTool(mcp.NewTool("hello"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+return mcp.NewToolResultText("hello from stub"), nil
+})
+srv.AddTool(mcp.NewTool("crash"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+os.Exit(3)
+return nil, nil
+})
+server.ServeStdio(srv)
+}
+
+func stubConfig(name string) ServerConfig {
+return ServerConfig{
+Name:    name,
+Command: os.Args[0],
+Env:     []string{stubServerEnv + "=1"},
+}
+}
+
+func testOptions() SupervisorOptions {
+return SupervisorOptions{
+HealthInterval: 50 * time.Millisecond,
+PingTimeout:    500 * time.Millisecond,
+StartTimeout:   5 * time.Second,
+InitialBackoff: 10 * time.Millisecond,
+MaxBackoff:     50 * time.Millisecond,
+MaxRestarts:    3,
+}
+}
+
+func statusOf(m *MCPManager, name string) ServerStatus {
+for _, s := range m.Statuses() {
+if s.Name == name {
+return s
+}
+}
+return ServerStatus{}
+}
+
+func TestMCPManager_Supervision(t *testing.T) {
+ctx := context.Background()
+
+t.Run("Start and restart after crash", func(t *testing.T) {
+m := NewMCPManager()
+m.Options = testOptions()
+m.Start(ctx, []ServerConfig{stubConfig("stub")})
+defer m.Close()
+
+require.Eventually(t, func() bool {
+return statusOf(m, "stub").State == StateReady
+}, 5*time.Second, 10*time.Millisecond)
+
+rt, ok := m.Lookup("stub__hello")
+require.True(t, ok)
+res, err := m.CallTool(ctx, rt.Server, rt.Tool.Name, nil)
+require.NoError(t, err)
+assert.Equal(t, "hello from stub", FormatResult(res))
+
+callCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+m.CallTool(callCtx, "stub", "crash", nil)
+cancel()
+
+require.Eventually(t, func() bool {
+s := statusOf(m, "stub")
+return s.Restarts >= 1 && s.State == StateReady
+}, 5*time.Second, 10*time.Millisecond)
+assert.NotEmpty(t, statusOf(m, "stub").LastError)
+
+// Tools are rediscovered after the restart
+_, ok = m.Lookup("stub__hello")
+assert.True(t, ok)
+})
+
+t.Run("Restart when the process exits between health checks", func(t *testing.T) {
+if runtime.GOOS != "linux" {
+t.Skip("process exits are only watched on Linux")
+}
+m := NewMCPManager()
+m.Options = testOptions()
+m.Options.HealthInterval = time.Hour
+m.Start(ctx, []ServerConfig{stubConfig("stub")})
+defer m.Close()
+
+require.Eventually(t, func() bool {
+return statusOf(m, "stub").State == StateReady
+}, 5*time.Second, 10*time.Millisecond)
+callCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+m.CallTool(callCtx, "stub", "crash", nil)
+cancel()
+
+require.Eventually(t, func() bool {
+s := statusOf(m, "stub")
+return s.Restarts >= 1 && s.State == StateReady
+}, 5*time.Second, 10*time.Millisecond)
+assert.Equal(t, "the server process exited", statusOf(m, "stub").LastError)
+})
+
+t.Run("Colliding tool names", func(t *testing.T) {
+m := NewMCPManager()
+m.Options = testOptions()
+m.Start(ctx, []ServerConfig{stubConfig("a b"), stubConfig("a_b")})
+defer m.Close()
+
+require.Eventually(t, func() bool {
+return statusOf(m, "a b").State == StateReady && statusOf(m, "a_b").State == StateReady
+}, 5*time.Second, 10*time.Millisecond)
+// Both servers keep their tools, one of them under a hashed name
+servers := map[string]int{}
+for name, rt := range m.ListTools() {
+assert.LessOrEqual(t, len(name), maxToolNameLength)
+servers[rt.Server]++
+}
+assert.Equal(t, map[string]int{"a b": 2, "a_b": 2}, servers)
+})
+
+t.Run("Disabled in config", func(t *testing.T) {
+m := NewMCPManager()
+cfg := stubConfig("off")
+cfg.Disabled = true
+m.Start(ctx, []ServerConfig{cfg})
+defer m.Close()
+
+assert.Equal(t, StateDisabled, statusOf(m, "off").State)
+assert.Empty(t, m.ListTools())
+})
+
+t.Run("Gives up on a server that never starts", func(t *testing.T) {
+m := NewMCPManager()
+m.Options = testOptions()
+m.Start(ctx, []ServerConfig{{Name: "broken", Command: "/nonexistent/mcp-server"}})
+defer m.Close()
+
+require.Eventually(t, func() bool {
+return statusOf(m, "broken").State == StateDisabled
+}, 5*time.Second, 10*time.Millisecond)
+assert.Equal(t, 3, statusOf(m, "broken").Restarts)
+})
+
+t.Run("Close shuts down clients", func(t *testing.T) {
+m := NewMCPManager()
+m.Options = testOptions()
+m.Start(ctx, []ServerConfig{stubConfig("stub")})
+require.Eventually(t, func() bool {
+return statusOf(m, "stub").State == StateReady
+}, 5*time.Second, 10*time.Millisecond)
+
+assert.NoError(t, m.Close())
+assert.Empty(t, m.Clients)
+assert.Empty(t, m.ListTools())
+})
+}
+
+func TestQualifiedName(t *testing.T) {
+assert.Equal(t, "fs__read_file", QualifiedName("fs", "read_file"))
+assert.Equal(t, "my_server__get.item", QualifiedName("my server", "get.item"))
+
+// Long names that share a prefix stay apart
+long := strings.Repeat("x", 70)
+a, b := QualifiedName("srv", long+"a"), QualifiedName("srv", long+"b")
+assert.Len(t, a, maxToolNameLength)
+assert.NotEqual(t, a, b)
+assert.Equal(t, a, QualifiedName("srv", long+"a"))
+}
@@ -0,0 +1,295 @@
+package openai
+
+import (
+"bytes"
+"context"
+"encoding/json"
+"fmt"
+"io"
+"log/slog"
+"net/http"
+"strings"
+"time"
+
+"github.com/LeeroyDing/hyperagent/internal/llm"
+)
+
+// DefaultBaseURL is used when no base URL is configured.
+const DefaultBaseURL = "https://api.openai.com/v1"
+
+// Client implements llm.Client against an OpenAI-compatible
+// /v1/chat/completions and /v1/embeddings API (OpenAI, llama.cpp, vLLM, Ollama).
+type Client struct {
+baseURL        string
+apiKey         string
+model          string
+embeddingModel string
+httpClient     *http.Client
+retryDelay     time.Duration
+}
+
+var _ llm.Client = (*Client)(nil)
+
+// NewClient creates a client for the server at baseURL, which should include the /v1 prefix.
+func NewClient(baseURL, apiKey, model, embeddingModel string) *Client {
+if baseURL == "" {
+baseURL = DefaultBaseURL
+}
+return &Client{
+baseURL:        strings.TrimRight(baseURL, "/"),
+apiKey:         apiKey,
+model:          model,
+embeddingModel: embeddingModel,
+httpClient:     &http.Client{Timeout: 5 * time.Minute},
+retryDelay:     time.Second,
+}
+}
+
+type chatMessage struct {
+Role       string     `json:"role"`
+Content    string     `json:"content"`
+ToolCalls  []toolCall `json:"tool_calls,omitempty"`
+ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+type toolCall struct {
+ID       string       `json:"id"`
+Type     string       `json:"type"`
+Function functionCall `json:"function"`
+}
+
+type functionCall struct {
+Name      string `json:"name"`
+Arguments string `json:"arguments"`
+}
+
+type toolDef struct {
+Type     string      `json:"type"`
+Function functionDef `json:"function"`
+}
+
+type functionDef struct {
+Name        string                 `json:"name"`
+Description string                 `json:"description,omitempty"`
+Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+type chatRequest struct {
+Model    string        `json:"model"`
+Messages []chatMessage `json:"messages"`
+Tools    []toolDef     `json:"tools,omitempty"`
+}
+
+type chatResponse struct {
+Choices []struct {
+Message      chatMessage `json:"message"`
+FinishReason string      `json:"finish_reason"`
+} `json:"choices"`
+Usage struct {
+PromptTokens     int `json:"prompt_tokens"`
+CompletionTokens int `json:"completion_tokens"`
+TotalTokens      int `json:"total_tokens"`
+} `json:"usage"`
+}
+
+type embeddingRequest struct {
+Model string `json:"model"`
+Input string `json:"input"`
+}
+
+type embeddingResponse struct {
+Data []struct {
+Embedding []float32 `json:"embedding"`
+} `json:"data"`
+}
+
+type apiError struct {
+Error struct {
+Message string `json:"message"`
+} `json:"error"`
+}
+
+func (c *Client) GenerateContent(ctx context.Context, messages []llm.Message, tools []llm.Tool) (*llm.Response, error) {
+msgs := convertMessages(messages)
+req := chatRequest{
+Model:    c.model,
+Messages: msgs,
+Tools:    convertTools(tools),
+}
+
+slog.Debug("OpenAI API Request", "messages", len(msgs), "tools_count", len(req.Tools))
+
+var resp chatResponse
+if err := c.post(ctx, "/chat/completions", req, &resp); err != nil {
+return nil, err
+}
+if len(resp.Choices) == 0 {
+return nil, fmt.Errorf("no choices in response")
+}
+
+msg := resp.Choices[0].Message
+out := &llm.Response{
+Message: llm.Message{Role: llm.RoleModel},
+Usage: llm.Usage{
+PromptTokens:     resp.Usage.PromptTokens,
+CompletionTokens: resp.Usage.CompletionTokens,
+TotalTokens:      resp.Usage.TotalTokens,
+},
+}
+if msg.Content != "" {
+out.Message.Parts = append(out.Message.Parts, llm.Part{Text: msg.Content})
+}
+for i, tc := range msg.ToolCalls {
+if tc.ID == "" {
+tc.ID = fmt.Sprintf("call_%d", i)
+}
+args := map[string]interface{}{}
+if tc.Function.Arguments != "" {
+if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+return nil, fmt.Errorf("invalid arguments for tool %s: %w", tc.Function.Name, err)
+}
+}
+out.Message.Parts = append(out.Message.Parts, llm.Part{FunctionCall: &llm.FunctionCall{
+ID:        tc.ID,
+Name:      tc.Function.Name,
+Arguments: args,
+}})
+}
+
+return out, nil
+}
+
+func (c *Client) EmbedContent(ctx context.Context, text string) ([]float32, error) {
+var resp embeddingResponse
+if err := c.post(ctx, "/embeddings", embeddingRequest{Model: c.embeddingModel, Input: text}, &resp); err != nil {
+return nil, err
+}
+if len(resp.Data) == 0 {
+return nil, fmt.Errorf("no embedding in response")
+}
+return resp.Data[0].Embedding, nil
+}
+
+func (c *Client) Close() error {
+c.httpClient.CloseIdleConnections()
+return nil
+}
+
+// post sends a JSON request, retrying transient failures with exponential backoff.
+func (c *Client) post(ctx context.Context, path string, body, out interface{}) error {
+data, err := json.Marshal(body)
+if err != nil {
+return fmt.Errorf("failed to marshal request: %w", err)
+}
+
+var lastErr error
+for i := 0; i < 3; i++ {
+retry, err := c.doPost(ctx, path, data, out)
+if err == nil {
+return nil
+}
+lastErr = err
+if !retry || ctx.Err() != nil {
+return err
+}
+if i == 2 {
+break
+}
+slog.Warn("OpenAI API call failed, retrying...", "attempt", i+1, "error", err)
+timer := time.NewTimer(time.Duration(1<<i) * c.retryDelay)
+select {
+case <-ctx.Done():
+timer.Stop()
+return ctx.Err()
+case <-timer.C:
+}
+}
+return fmt.Errorf("failed after 3 attempts: %w", lastErr)
+}
+
+// doPost performs a single request and reports whether a failure is worth retrying.
+func (c *Client) doPost(ctx context.Context, path string, data []byte, out interface{}) (bool, error) {
+req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(data))
+if err != nil {
+return false, err
+}
+req.Header.Set("Content-Type", "application/json")
+if c.apiKey != "" {
+req.Header.Set("Authorization", "Bearer "+c.apiKey)
+}
+
+resp, err := c.httpClient.Do(req)
+if err != nil {
+return true, err
+}
+defer resp.Body.Close()
+
+respBody, err := io.ReadAll(resp.Body)
+if err != nil {
+return true, fmt.Errorf("failed to read response: %w", err)
+}
+
+if resp.StatusCode != http.StatusOK {
+msg := strings.TrimSpace(string(respBody))
+var apiErr apiError
+if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Error.Message != "" {
+msg = apiErr.Error.Message
+}
+retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+return retry, fmt.Errorf("API error (%d): %s", resp.StatusCode, msg)
+}
+
+slog.Debug("OpenAI API Response", "path", path, "body", string(respBody))
+
+if err := json.Unmarshal(respBody, out); err != nil {
+return false, fmt.Errorf("failed to decode response: %w", err)
+}
+return false, nil
+}
+
+func convertMessages(messages []llm.Message) []chatMessage {
+msgs := make([]chatMessage, 0, len(messages))
+for _, m := range messages {
+switch m.Role {
+case llm.RoleModel:
+msg := chatMessage{Role: "assistant", Content: m.Text()}
+for _, fc := range m.FunctionCalls() {
+args, _ := json.Marshal(fc.Arguments)
+msg.ToolCalls = append(msg.ToolCalls, toolCall{
+ID:       fc.ID,
+Type:     "function",
+Function: functionCall{Name: fc.Name, Arguments: string(args)},
+})
+}
+msgs = append(msgs, msg)
+case llm.RoleTool:
+// OpenAI expects one message per tool result
+for _, r := range m.FunctionResults() {
+msgs = append(msgs, chatMessage{Role: "tool", Content: r.Content, ToolCallID: r.ID})
+}
+case llm.RoleSystem:
+msgs = append(msgs, chatMessage{Role: "system", Content: m.Text()})
+default:
+msgs = append(msgs, chatMessage{Role: "user", Content: m.Text()})
+}
+}
+return msgs
+}
+
+func convertTools(tools []llm.Tool) []toolDef {
+var defs []toolDef
+for _, t := range tools {
+def := toolDef{
+Type: "function",
+Function: functionDef{
+Name:        t.Name,
+Description: t.Description,
+},
+}
+if t.Parameters != nil {
+def.Function.Parameters = t.Parameters.JSON()
+}
+defs = append(defs, def)
+}
+return defs
+}
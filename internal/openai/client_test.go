@@ -0,0 +1,167 @@
+package openai
+
+import (
+"context"
+"encoding/json"
+"net/http"
+"net/http/httptest"
+"testing"
+"time"
+
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+var testTools = []llm.Tool{{
+Name:        "execute_command",
+Description: "Execute a shell command",
+Parameters: &llm.Schema{
+Type: llm.TypeObject,
+Properties: map[string]*llm.Schema{
+"command": {Type: llm.TypeString},
+},
+Required: []string{"command"},
+},
+}}
+
+func TestClient_ToolCallRoundTrip(t *testing.T) {
+var requests []chatRequest
+srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+var req chatRequest
+require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+requests = append(requests, req)
+
+if len(requests) == 1 {
+w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_abc","type":"function","function":{"name":"execute_command","arguments":"{\"command\":\"ls\"}"}}]}}]}`))
+return
+}
+w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"done"}}]}`))
+}))
+defer srv.Close()
+
+c := NewClient(srv.URL+"/v1", "secret", "local-model", "")
+ctx := context.Background()
+messages := []llm.Message{llm.NewTextMessage(llm.RoleModel, "earlier"), llm.NewTextMessage(llm.RoleUser, "list files")}
+
+resp, err := c.GenerateContent(ctx, messages, testTools)
+require.NoError(t, err)
+assert.Empty(t, resp.Text())
+calls := resp.FunctionCalls()
+require.Len(t, calls, 1)
+assert.Equal(t, "call_abc", calls[0].ID)
+assert.Equal(t, "execute_command", calls[0].Name)
+assert.Equal(t, "ls", calls[0].Arguments["command"])
+
+first := requests[0]
+assert.Equal(t, "local-model", first.Model)
+assert.Equal(t, "assistant", first.Messages[0].Role)
+require.Len(t, first.Tools, 1)
+assert.Equal(t, "object", first.Tools[0].Function.Parameters["type"])
+
+messages = append(messages, resp.Message, llm.NewFunctionResultMessage([]llm.FunctionResult{{ID: "call_abc", Name: "execute_command", Content: "a.txt"}}))
+resp, err = c.GenerateContent(ctx, messages, testTools)
+require.NoError(t, err)
+assert.Equal(t, "done", resp.Text())
+assert.Empty(t, resp.FunctionCalls())
+
+second := requests[1]
+require.Len(t, second.Messages, 4)
+assert.Equal(t, "assistant", second.Messages[2].Role)
+assert.Equal(t, "call_abc", second.Messages[2].ToolCalls[0].ID)
+assert.Equal(t, "tool", second.Messages[3].Role)
+assert.Equal(t, "call_abc", second.Messages[3].ToolCallID)
+assert.Equal(t, "a.txt", second.Messages[3].Content)
+}
+
+func TestClient_EmbedContent(t *testing.T) {
+srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+assert.Equal(t, "/v1/embeddings", r.URL.Path)
+var req embeddingRequest
+require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+assert.Equal(t, "embed-model", req.Model)
+assert.Equal(t, "hello", req.Input)
+w.Write([]byte(`{"data":[{"embedding":[0.1,0.2]}]}`))
+}))
+defer srv.Close()
+
+c := NewClient(srv.URL+"/v1/", "", "m", "embed-model")
+emb, err := c.EmbedContent(context.Background(), "hello")
+require.NoError(t, err)
+assert.Equal(t, []float32{0.1, 0.2}, emb)
+}
+
+func TestClient_Errors(t *testing.T) {
+t.Run("client error is not retried", func(t *testing.T) {
+calls := 0
+srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+calls++
+w.WriteHeader(http.StatusBadRequest)
+w.Write([]byte(`{"error":{"message":"bad model"}}`))
+}))
+defer srv.Close()
+
+c := NewClient(srv.URL, "", "m", "")
+_, err := c.GenerateContent(context.Background(), []llm.Message{llm.NewTextMessage(llm.RoleUser, "hi")}, nil)
+assert.Error(t, err)
+assert.Contains(t, err.Error(), "bad model")
+assert.Equal(t, 1, calls)
+})
+
+t.Run("server error is retried", func(t *testing.T) {
+calls := 0
+srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+calls++
+if calls < 3 {
+w.WriteHeader(http.StatusServiceUnavailable)
+return
+}
+w.Write([]byte(`{"choices":[{"message":{"content":"ok"}}]}`))
+}))
+defer srv.Close()
+
+c := NewClient(srv.URL, "", "m", "")
+c.retryDelay = 0
+resp, err := c.GenerateContent(context.Background(), []llm.Message{llm.NewTextMessage(llm.RoleUser, "hi")}, nil)
+assert.NoError(t, err)
+assert.Equal(t, "ok", resp.Text())
+assert.Equal(t, 3, calls)
+})
+
+t.Run("cancel stops the backoff", func(t *testing.T) {
+srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+w.WriteHeader(http.StatusServiceUnavailable)
+}))
+defer srv.Close()
+
+c := NewClient(srv.URL, "", "m", "")
+c.retryDelay = time.Hour
+ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+defer cancel()
+start := time.Now()
+_, err := c.GenerateContent(ctx, []llm.Message{llm.NewTextMessage(llm.RoleUser, "hi")}, nil)
+assert.ErrorIs(t, err, context.DeadlineExceeded)
+assert.Less(t, time.Since(start), 10*time.Second)
+})
+
+t.Run("no wait after the last attempt", func(t *testing.T) {
+calls := 0
+srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+calls++
+w.WriteHeader(http.StatusServiceUnavailable)
+}))
+defer srv.Close()
+
+c := NewClient(srv.URL, "", "m", "")
+c.retryDelay = 200 * time.Millisecond
+start := time.Now()
+_, err := c.GenerateContent(context.Background(), []llm.Message{llm.NewTextMessage(llm.RoleUser, "hi")}, nil)
+assert.ErrorContains(t, err, "failed after 3 attempts")
+assert.Equal(t, 3, calls)
+// 200ms and 400ms between the attempts, not 800ms more after them
+assert.Less(t, time.Since(start), 1100*time.Millisecond)
+})
+}
@@ -2,7 +2,9 @@ package orchestrator
 
 import (
 "context"
+"fmt"
 "sync"
+"time"
 )
 
 // Task represents a single tool call to be executed.
@@ -14,33 +16,87 @@ ToolArgs map[string]interface{}
 
 // Result represents the outcome of a task execution.
 type Result struct {
-TaskID string
-Output string
-Error  error
+TaskID   string
+Output   string
+Error    error
+Duration time.Duration
+}
+
+// Options bounds parallel execution. Zero fields mean no limit.
+type Options struct {
+// MaxConcurrency caps the number of tasks running at the same time.
+MaxConcurrency int `yaml:"max_concurrency"`
+// TaskTimeout caps the time a single task may run.
+TaskTimeout time.Duration `yaml:"task_timeout"`
+}
+
+// DefaultOptions returns the options used by NewOrchestrator.
+func DefaultOptions() Options {
+return Options{
+MaxConcurrency: 4,
+TaskTimeout:    2 * time.Minute,
+}
 }
 
 // Orchestrator manages parallel task execution.
-type Orchestrator struct{}
+type Orchestrator struct {
+Options Options
+}
 
-// NewOrchestrator creates a new Orchestrator.
+// NewOrchestrator creates a new Orchestrator with DefaultOptions.
 func NewOrchestrator() *Orchestrator {
-return &Orchestrator{}
+return NewOrchestratorWithOptions(DefaultOptions())
 }
 
-// RunParallel executes multiple tasks concurrently.
+// NewOrchestratorWithOptions creates a new Orchestrator. Zero fields of opts
+// are taken from DefaultOptions.
+func NewOrchestratorWithOptions(opts Options) *Orchestrator {
+def := DefaultOptions()
+if opts.MaxConcurrency <= 0 {
+opts.MaxConcurrency = def.MaxConcurrency
+}
+if opts.TaskTimeout <= 0 {
+opts.TaskTimeout = def.TaskTimeout
+}
+return &Orchestrator{Options: opts}
+}
+
+// RunParallel executes multiple tasks concurrently, at most MaxConcurrency at
+// a time. Results are returned in the order of tasks. A task that exceeds
+// TaskTimeout, or whose turn never comes because ctx is done, fails with the
+// context error without waiting for executeFunc to return. Its slot is
+// only freed once executeFunc returns, which it should soon do since its
+// context is cancelled, so that no more than MaxConcurrency tasks run.
 func (o *Orchestrator) RunParallel(ctx context.Context, tasks []Task, executeFunc func(context.Context, Task) (string, error)) []Result {
 var wg sync.WaitGroup
 results := make([]Result, len(tasks))
 
+var sem chan struct{}
+if o.Options.MaxConcurrency > 0 {
+sem = make(chan struct{}, o.Options.MaxConcurrency)
+}
+
 for i, task := range tasks {
 wg.Add(1)
 go func(idx int, t Task) {
 defer wg.Done()
-output, err := executeFunc(ctx, t)
+release := func() {}
+if sem != nil {
+select {
+case sem <- struct{}{}:
+release = func() { <-sem }
+case <-ctx.Done():
+results[idx] = Result{TaskID: t.ID, Error: ctx.Err()}
+return
+}
+}
+start := time.Now()
+output, err := o.run(ctx, t, executeFunc, release)
 results[idx] = Result{
-TaskID: t.ID,
-Output: output,
-Error:  err,
+TaskID:   t.ID,
+Output:   output,
+Error:    err,
+Duration: time.Since(start),
 }
 }(i, task)
 }
@@ -48,3 +104,39 @@ Error:  err,
 wg.Wait()
 return results
 }
+
+// run executes a single task under the task timeout. release is called
+// when executeFunc returns, also after run gave up on it.
+func (o *Orchestrator) run(ctx context.Context, t Task, executeFunc func(context.Context, Task) (string, error), release func()) (string, error) {
+if o.Options.TaskTimeout > 0 {
+var cancel context.CancelFunc
+ctx, cancel = context.WithTimeout(ctx, o.Options.TaskTimeout)
+defer cancel()
+}
+
+type outcome struct {
+output string
+err    error
+}
+done := make(chan outcome, 1)
+go func() {
+defer release()
+defer func() {
+if r := recover(); r != nil {
+done <- outcome{err: fmt.Errorf("task %s panicked: %v", t.ToolName, r)}
+}
+}()
+output, err := executeFunc(ctx, t)
+done <- outcome{output, err}
+}()
+
+select {
+case res := <-done:
+return res.output, res.err
+case <-ctx.Done():
+if ctx.Err() == context.DeadlineExceeded {
+return "", fmt.Errorf("%s timed out after %s", t.ToolName, o.Options.TaskTimeout)
+}
+return "", ctx.Err()
+}
+}
@@ -3,7 +3,10 @@ package orchestrator
 import (
 "context"
 "errors"
+"fmt"
+"sync"
 "testing"
+"time"
 
 "github.com/stretchr/testify/assert"
 )
@@ -41,3 +44,97 @@ assert.Equal(t, "", results[2].Output)
 assert.Error(t, results[2].Error)
 assert.Equal(t, "execution error", results[2].Error.Error())
 }
+
+func TestOrchestrator_ConcurrencyCap(t *testing.T) {
+o := NewOrchestratorWithOptions(Options{MaxConcurrency: 2})
+assert.Equal(t, DefaultOptions().TaskTimeout, o.Options.TaskTimeout)
+
+var mu sync.Mutex
+running, peak := 0, 0
+tasks := make([]Task, 6)
+for i := range tasks {
+tasks[i] = Task{ID: fmt.Sprint(i), ToolName: "test"}
+}
+
+results := o.RunParallel(context.Background(), tasks, func(ctx context.Context, task Task) (string, error) {
+mu.Lock()
+running++
+if running > peak {
+peak = running
+}
+mu.Unlock()
+time.Sleep(20 * time.Millisecond)
+mu.Lock()
+running--
+mu.Unlock()
+return task.ID, nil
+})
+
+assert.Equal(t, 2, peak)
+for i, r := range results {
+assert.Equal(t, fmt.Sprint(i), r.Output)
+assert.Positive(t, r.Duration)
+}
+}
+
+func TestOrchestrator_TaskTimeout(t *testing.T) {
+o := NewOrchestratorWithOptions(Options{TaskTimeout: 20 * time.Millisecond})
+block := make(chan struct{})
+defer close(block)
+
+tasks := []Task{{ID: "slow", ToolName: "slow"}, {ID: "ignores", ToolName: "ignores"}, {ID: "fast", ToolName: "fast"}}
+results := o.RunParallel(context.Background(), tasks, func(ctx context.Context, task Task) (string, error) {
+switch task.ToolName {
+case "slow":
+<-ctx.Done()
+return "", ctx.Err()
+case "ignores":
+<-block
+return "late", nil
+}
+return "done", nil
+})
+
+assert.EqualError(t, results[0].Error, "slow timed out after 20ms")
+assert.EqualError(t, results[1].Error, "ignores timed out after 20ms")
+assert.NoError(t, results[2].Error)
+assert.Equal(t, "done", results[2].Output)
+}
+
+func TestOrchestrator_TimeoutKeepsSlot(t *testing.T) {
+o := NewOrchestratorWithOptions(Options{MaxConcurrency: 1, TaskTimeout: 20 * time.Millisecond})
+
+var mu sync.Mutex
+var wg sync.WaitGroup
+running, peak := 0, 0
+tasks := []Task{{ID: "1", ToolName: "ignores"}, {ID: "2", ToolName: "ignores"}}
+wg.Add(len(tasks))
+results := o.RunParallel(context.Background(), tasks, func(ctx context.Context, task Task) (string, error) {
+defer wg.Done()
+mu.Lock()
+running++
+peak = max(peak, running)
+mu.Unlock()
+// Keeps running after the timeout
+time.Sleep(100 * time.Millisecond)
+mu.Lock()
+running--
+mu.Unlock()
+return "late", nil
+})
+
+for _, r := range results {
+assert.EqualError(t, r.Error, "ignores timed out after 20ms")
+}
+// The second task only starts once the first one returned
+wg.Wait()
+assert.Equal(t, 1, peak)
+}
+
+func TestOrchestrator_Panic(t *testing.T) {
+o := NewOrchestrator()
+results := o.RunParallel(context.Background(), []Task{{ID: "1", ToolName: "boom"}}, func(ctx context.Context, task Task) (string, error) {
+panic("oops")
+})
+assert.EqualError(t, results[0].Error, "task boom panicked: oops")
+}
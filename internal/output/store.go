@@ -0,0 +1,101 @@
+package output
+
+import (
+"fmt"
+"os"
+"path/filepath"
+"regexp"
+"strings"
+"time"
+
+"github.com/google/uuid"
+)
+
+// validName matches session and artifact IDs that are safe to use as file names.
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// invalidChars matches what may not appear in an artifact ID.
+var invalidChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// GetDefaultArtifactDir returns the directory for artifacts if none is configured.
+func GetDefaultArtifactDir() string {
+home, _ := os.UserHomeDir()
+return filepath.Join(home, ".hyperagent", "artifacts")
+}
+
+// Store keeps full tool results that were truncated, one directory per session.
+type Store struct {
+Dir string
+}
+
+// NewStore creates a Store rooted at dir, or at GetDefaultArtifactDir when empty.
+func NewStore(dir string) *Store {
+if dir == "" {
+dir = GetDefaultArtifactDir()
+}
+return &Store{Dir: dir}
+}
+
+func (s *Store) path(sessionID, id string) (string, error) {
+if !validName.MatchString(sessionID) {
+return "", fmt.Errorf("invalid session ID %q", sessionID)
+}
+if !validName.MatchString(id) {
+return "", fmt.Errorf("invalid artifact ID %q", id)
+}
+return filepath.Join(s.Dir, sessionID, id+".txt"), nil
+}
+
+// Save stores content for a session and returns its artifact ID. The ID
+// starts with prefix, typically the tool name, to make it recognizable.
+func (s *Store) Save(sessionID, prefix, content string) (string, error) {
+id := fmt.Sprintf("%s-%s-%s", invalidChars.ReplaceAllString(prefix, "_"), time.Now().Format("150405"), uuid.New().String()[:8])
+path, err := s.path(sessionID, id)
+if err != nil {
+return "", err
+}
+if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+return "", fmt.Errorf("failed to create artifact directory: %w", err)
+}
+if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+return "", fmt.Errorf("failed to save artifact: %w", err)
+}
+return id, nil
+}
+
+// Page is a range of lines of an artifact.
+type Page struct {
+// Start is the 1-indexed number of the first line.
+Start int
+Lines []string
+// Total is the number of lines in the artifact.
+Total int
+}
+
+// ReadLines returns up to count lines of an artifact starting at line start (1-indexed).
+func (s *Store) ReadLines(sessionID, id string, start, count int) (Page, error) {
+path, err := s.path(sessionID, id)
+if err != nil {
+return Page{}, err
+}
+data, err := os.ReadFile(path)
+if err != nil {
+if os.IsNotExist(err) {
+return Page{}, fmt.Errorf("artifact %s not found", id)
+}
+return Page{}, fmt.Errorf("failed to read artifact: %w", err)
+}
+
+lines := strings.Split(string(data), "\n")
+if start < 1 {
+start = 1
+}
+if start > len(lines) {
+return Page{}, fmt.Errorf("start line %d is past the end of artifact %s (%d lines)", start, id, len(lines))
+}
+end := len(lines)
+if count > 0 && start-1+count < end {
+end = start - 1 + count
+}
+return Page{Start: start, Lines: lines[start-1 : end], Total: len(lines)}, nil
+}
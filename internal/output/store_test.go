@@ -0,0 +1,41 @@
+package output
+
+import (
+"os"
+"path/filepath"
+"strings"
+"testing"
+
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+s := NewStore(t.TempDir())
+
+id, err := s.Save("sess-1", "files.read", numbered(10))
+require.NoError(t, err)
+assert.True(t, strings.HasPrefix(id, "files_read-"))
+_, err = os.Stat(filepath.Join(s.Dir, "sess-1", id+".txt"))
+assert.NoError(t, err)
+
+page, err := s.ReadLines("sess-1", id, 3, 2)
+require.NoError(t, err)
+assert.Equal(t, Page{Start: 3, Lines: []string{"line 3", "line 4"}, Total: 10}, page)
+
+page, err = s.ReadLines("sess-1", id, 9, 100)
+require.NoError(t, err)
+assert.Equal(t, []string{"line 9", "line 10"}, page.Lines)
+
+_, err = s.ReadLines("sess-1", id, 11, 1)
+assert.ErrorContains(t, err, "past the end")
+
+_, err = s.ReadLines("sess-2", id, 1, 1)
+assert.ErrorContains(t, err, "not found")
+
+_, err = s.ReadLines("sess-1", "../../etc/passwd", 1, 1)
+assert.ErrorContains(t, err, "invalid artifact ID")
+
+_, err = s.Save("../x", "tool", "text")
+assert.ErrorContains(t, err, "invalid session ID")
+}
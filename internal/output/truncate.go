@@ -0,0 +1,133 @@
+// Package output keeps tool results within the size the model can take.
+// Oversized results are cut to their head and tail, and the full text can be
+// kept in a per-session artifact store for paging.
+package output
+
+import (
+"fmt"
+"strings"
+"unicode/utf8"
+)
+
+// Limits caps a single tool result. Zero fields fall back to DefaultLimits.
+type Limits struct {
+MaxBytes  int `yaml:"max_bytes"`
+MaxLines  int `yaml:"max_lines"`
+MaxTokens int `yaml:"max_tokens"`
+}
+
+// DefaultLimits returns the limits used when none are configured.
+func DefaultLimits() Limits {
+return Limits{
+MaxBytes:  32 * 1024,
+MaxLines:  400,
+MaxTokens: 8000,
+}
+}
+
+// WithDefaults fills zero fields from DefaultLimits.
+func (l Limits) WithDefaults() Limits {
+def := DefaultLimits()
+if l.MaxBytes <= 0 {
+l.MaxBytes = def.MaxBytes
+}
+if l.MaxLines <= 0 {
+l.MaxLines = def.MaxLines
+}
+if l.MaxTokens <= 0 {
+l.MaxTokens = def.MaxTokens
+}
+return l
+}
+
+// CountFunc counts the tokens of a text.
+type CountFunc func(string) int
+
+// fits reports whether text is within the limits.
+func (l Limits) fits(text string, lines int, count CountFunc) bool {
+if len(text) > l.MaxBytes || lines > l.MaxLines {
+return false
+}
+return count == nil || count(text) <= l.MaxTokens
+}
+
+// Truncate cuts text that exceeds the limits down to its first and last
+// lines around a "[... N lines omitted ...]" marker, and reports whether it
+// did. Text without enough line breaks is cut by bytes instead. count may be
+// nil to skip the token limit.
+func Truncate(text string, l Limits, count CountFunc) (string, bool) {
+l = l.WithDefaults()
+lines := strings.Split(text, "\n")
+if l.fits(text, len(lines), count) {
+return text, false
+}
+
+// Shrink the byte budget by the overshoot until the token count fits as well
+maxBytes := l.MaxBytes
+for i := 0; i < 8; i++ {
+out := cut(text, lines, l.MaxLines, maxBytes)
+if count == nil {
+return out, true
+}
+tokens := count(out)
+if tokens <= l.MaxTokens {
+return out, true
+}
+maxBytes = maxBytes * l.MaxTokens / tokens * 9 / 10
+}
+return cut(text, lines, l.MaxLines, maxBytes), true
+}
+
+// cut keeps as many head and tail lines as maxLines and maxBytes allow,
+// alternating so that both ends are represented.
+func cut(text string, lines []string, maxLines, maxBytes int) string {
+// Leave room for the marker line
+maxLines--
+maxBytes -= 40
+
+head, tail := 0, 0
+used := 0
+for head+tail < len(lines) && head+tail < maxLines {
+var next string
+if head <= tail {
+next = lines[head]
+} else {
+next = lines[len(lines)-1-tail]
+}
+if used+len(next)+1 > maxBytes {
+break
+}
+used += len(next) + 1
+if head <= tail {
+head++
+} else {
+tail++
+}
+}
+
+if head+tail == 0 {
+return cutBytes(text, maxBytes)
+}
+omitted := len(lines) - head - tail
+var sb strings.Builder
+sb.WriteString(strings.Join(lines[:head], "\n"))
+fmt.Fprintf(&sb, "\n[... %d lines omitted ...]\n", omitted)
+sb.WriteString(strings.Join(lines[len(lines)-tail:], "\n"))
+return sb.String()
+}
+
+// cutBytes keeps the first and last maxBytes/2 bytes of text, on rune boundaries.
+func cutBytes(text string, maxBytes int) string {
+if maxBytes < 2 {
+maxBytes = 2
+}
+head := maxBytes / 2
+for head > 0 && !utf8.RuneStart(text[head]) {
+head--
+}
+tail := len(text) - maxBytes/2
+for tail < len(text) && !utf8.RuneStart(text[tail]) {
+tail++
+}
+return fmt.Sprintf("%s\n[... %d bytes omitted ...]\n%s", text[:head], tail-head, text[tail:])
+}
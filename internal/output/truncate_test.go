@@ -0,0 +1,64 @@
+package output
+
+import (
+"fmt"
+"strings"
+"testing"
+
+"github.com/stretchr/testify/assert"
+)
+
+func numbered(n int) string {
+lines := make([]string, n)
+for i := range lines {
+lines[i] = fmt.Sprintf("line %d", i+1)
+}
+return strings.Join(lines, "\n")
+}
+
+func TestTruncate(t *testing.T) {
+t.Run("Fits", func(t *testing.T) {
+out, truncated := Truncate("a\nb", Limits{}, nil)
+assert.False(t, truncated)
+assert.Equal(t, "a\nb", out)
+})
+
+t.Run("Lines", func(t *testing.T) {
+out, truncated := Truncate(numbered(100), Limits{MaxLines: 11}, nil)
+assert.True(t, truncated)
+assert.Equal(t, "line 1\nline 2\nline 3\nline 4\nline 5\n[... 90 lines omitted ...]\nline 96\nline 97\nline 98\nline 99\nline 100", out)
+})
+
+t.Run("Bytes", func(t *testing.T) {
+out, truncated := Truncate(numbered(1000), Limits{MaxBytes: 200}, nil)
+assert.True(t, truncated)
+assert.LessOrEqual(t, len(out), 200)
+assert.True(t, strings.HasPrefix(out, "line 1\n"))
+assert.True(t, strings.HasSuffix(out, "\nline 1000"))
+assert.Contains(t, out, "lines omitted ...]")
+})
+
+t.Run("Tokens", func(t *testing.T) {
+words := func(s string) int { return len(strings.Fields(s)) }
+out, truncated := Truncate(numbered(1000), Limits{MaxTokens: 50}, words)
+assert.True(t, truncated)
+assert.LessOrEqual(t, words(out), 50)
+assert.Contains(t, out, "lines omitted ...]")
+})
+
+t.Run("Long line", func(t *testing.T) {
+text := strings.Repeat("é", 1000)
+out, truncated := Truncate(text, Limits{MaxBytes: 100}, nil)
+assert.True(t, truncated)
+assert.LessOrEqual(t, len(out), 100)
+assert.Contains(t, out, "bytes omitted ...]")
+for _, part := range strings.Split(out, "\n") {
+assert.True(t, strings.Trim(part, "é") == "" || strings.HasPrefix(part, "[..."), part)
+}
+})
+}
+
+func TestLimits_WithDefaults(t *testing.T) {
+assert.Equal(t, DefaultLimits(), Limits{}.WithDefaults())
+assert.Equal(t, 10, Limits{MaxLines: 10}.WithDefaults().MaxLines)
+}
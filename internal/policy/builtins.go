@@ -0,0 +1,354 @@
+package policy
+
+import (
+"fmt"
+"strings"
+
+"mvdan.cc/sh/v3/syntax"
+)
+
+// checkBuiltin checks the builtins that run commands given as strings
+// later, or that assign variables named by their arguments.
+func (c *checker) checkBuiltin(name string, args []word) error {
+switch name {
+case "trap":
+return c.checkTrap(args)
+case "alias":
+return checkAlias(args)
+case "complete", "compgen":
+return checkComplete(name, args)
+case "fc":
+return checkListing(name, args, "lnr0123456789", "runs commands from the history, which cannot be checked")
+case "bind":
+return checkListing(name, args, "lpPsSvVX", "can make keys run commands that cannot be checked")
+case "set":
+return checkSet(args)
+case "enable":
+return checkEnable(args)
+case "printf":
+return checkPrintf(args)
+case "read":
+return checkTargets(name, args, "adinNptu", "a", true)
+case "mapfile", "readarray":
+return checkTargets(name, args, "dnOsuCc", "", true)
+case "wait":
+return checkTargets(name, args, "p", "p", false)
+case "getopts":
+return checkGetopts(args)
+case "declare", "typeset", "local", "export", "readonly":
+// Run through builtin or command, which the parser does not see as
+// a declaration
+opts, ops, ok := parseOpts(args, "")
+if !ok || !allStatic(ops) {
+return computedArgs(name)
+}
+var decl []declArg
+for _, o := range ops {
+n, v, assign := strings.Cut(o.value, "=")
+decl = append(decl, declArg{name: n, value: word{value: v, static: true}, assign: assign})
+}
+if d := checkDeclArgs(name, opts, decl); d != nil {
+return d
+}
+}
+return nil
+}
+
+// option is a short option of a builtin with its value, if it takes one.
+type option struct {
+letter byte
+value  word
+}
+
+// parseOpts splits the leading short options of a builtin from its
+// operands. The letters in withValue take a value, attached or in the next
+// argument. ok is false when an option is computed at run time.
+func parseOpts(args []word, withValue string) (opts []option, operands []word, ok bool) {
+for i := 0; i < len(args); i++ {
+a := args[i]
+if !a.static {
+return nil, nil, false
+}
+if a.value == "--" {
+return opts, args[i+1:], true
+}
+if len(a.value) < 2 || a.value[0] != '-' {
+return opts, args[i:], true
+}
+for j := 1; j < len(a.value); j++ {
+o := option{letter: a.value[j]}
+if strings.IndexByte(withValue, o.letter) < 0 {
+opts = append(opts, o)
+continue
+}
+switch {
+case j+1 < len(a.value):
+o.value = word{value: a.value[j+1:], static: true}
+case i+1 < len(args):
+i++
+o.value = args[i]
+default:
+o.value = word{static: true}
+}
+opts = append(opts, o)
+break
+}
+}
+return opts, nil, true
+}
+
+func allStatic(args []word) bool {
+for _, a := range args {
+if !a.static {
+return false
+}
+}
+return true
+}
+
+func computedArgs(name string) *Denial {
+return &Denial{Reason: fmt.Sprintf("the arguments of %s are computed at run time and cannot be checked", name)}
+}
+
+// checkTrap checks the command of trap as a script, since the shell runs
+// it when the signal arrives.
+func (c *checker) checkTrap(args []word) error {
+_, ops, ok := parseOpts(args, "")
+if !ok {
+return computedArgs("trap")
+}
+// A single operand, or "-", resets the signals
+if len(ops) < 2 || ops[0].static && ops[0].value == "-" {
+return nil
+}
+return c.checkNested("trap", ops[:1])
+}
+
+// checkAlias denies defining aliases: an alias makes a later command run
+// something other than the program it names, e.g. eval or sh, whose
+// arguments would then not be checked.
+func checkAlias(args []word) error {
+_, ops, ok := parseOpts(args, "")
+if !ok {
+return computedArgs("alias")
+}
+for _, o := range ops {
+if !o.static || strings.Contains(o.value, "=") {
+return &Denial{Reason: "defining aliases is not allowed, they would hide what later commands run"}
+}
+}
+return nil
+}
+
+// checkComplete denies the completions that run commands given as strings.
+// Functions given with -F are checked where they are defined.
+func checkComplete(name string, args []word) error {
+opts, ops, ok := parseOpts(args, "oAGWFCXPS")
+if !ok || !allStatic(ops) {
+return computedArgs(name)
+}
+for _, o := range opts {
+switch {
+case o.letter == 'C':
+return &Denial{Reason: fmt.Sprintf("%s -C runs a command on completion that cannot be checked", name)}
+case o.letter == 'W' && (!o.value.static || strings.ContainsAny(o.value.value, "$`")):
+return &Denial{Reason: fmt.Sprintf("the word list of %s -W is expanded on completion and cannot be checked", name)}
+}
+}
+return nil
+}
+
+// checkListing allows a builtin only with options that list its state.
+func checkListing(name string, args []word, listing, reason string) error {
+opts, ops, ok := parseOpts(args, "")
+if ok && len(opts) > 0 {
+for _, o := range opts {
+if strings.IndexByte(listing, o.letter) < 0 {
+ok = false
+}
+}
+// fc -l takes a range of history entries
+if ok && (name == "fc" || len(ops) == 0) {
+return nil
+}
+}
+return &Denial{Reason: fmt.Sprintf("%s %s", name, reason)}
+}
+
+// checkSet denies turning history expansion back on, which would let "!"
+// run commands from the history.
+func checkSet(args []word) error {
+for i, a := range args {
+if !a.static {
+return computedArgs("set")
+}
+if a.value == "--" || a.value == "-" {
+return nil
+}
+if a.value == "-o" && i+1 < len(args) && args[i+1].value == "histexpand" ||
+strings.HasPrefix(a.value, "-") && !strings.HasPrefix(a.value, "--") && strings.Contains(a.value, "H") {
+return &Denial{Reason: "history expansion would run commands from the history that cannot be checked"}
+}
+}
+return nil
+}
+
+// checkEnable denies loading builtins from shared objects.
+func checkEnable(args []word) error {
+opts, _, ok := parseOpts(args, "f")
+if !ok {
+return computedArgs("enable")
+}
+for _, o := range opts {
+if o.letter == 'f' {
+return &Denial{Reason: "enable -f loads code that cannot be checked"}
+}
+}
+return nil
+}
+
+// checkPrintf checks the variable printf -v assigns.
+func checkPrintf(args []word) error {
+if len(args) == 0 {
+return nil
+}
+if !args[0].static {
+// The format might turn out to be -v, making the next argument the variable
+if len(args) > 1 {
+return checkTarget("printf", args[1])
+}
+return nil
+}
+if target, ok := strings.CutPrefix(args[0].value, "-v"); ok {
+if target != "" {
+return checkTarget("printf", word{value: target, static: true})
+}
+if len(args) > 1 {
+return checkTarget("printf", args[1])
+}
+}
+return nil
+}
+
+// checkTargets checks the variables a builtin such as read assigns: the
+// values of the options in targets and, with operands, its operands.
+func checkTargets(name string, args []word, withValue, targets string, operands bool) error {
+opts, ops, ok := parseOpts(args, withValue)
+if !ok {
+return computedArgs(name)
+}
+for _, o := range opts {
+if strings.IndexByte(targets, o.letter) >= 0 {
+if err := checkTarget(name, o.value); err != nil {
+return err
+}
+}
+}
+if operands {
+for _, o := range ops {
+if err := checkTarget(name, o); err != nil {
+return err
+}
+}
+}
+return nil
+}
+
+// checkGetopts checks the variable of getopts OPTSTRING NAME.
+func checkGetopts(args []word) error {
+if len(args) < 2 {
+return nil
+}
+return checkTarget("getopts", args[1])
+}
+
+// checkTarget checks a variable that a builtin assigns by name.
+func checkTarget(builtin string, w word) error {
+if !w.static {
+return &Denial{Reason: fmt.Sprintf("the variable %s assigns is computed at run time and cannot be checked", builtin)}
+}
+if d := checkAssign(varName(w.value)); d != nil {
+return d
+}
+return nil
+}
+
+// varName returns the variable of an assignment target such as a[1] or a+.
+func varName(s string) string {
+if i := strings.IndexByte(s, '['); i >= 0 {
+s = s[:i]
+}
+return strings.TrimSuffix(s, "+")
+}
+
+// declArg is an operand of declare or one of its relatives.
+type declArg struct {
+name string
+// value is assigned when assign is set
+value  word
+assign bool
+}
+
+// checkDeclArgs checks the variables a declaration assigns. A nameref
+// declared with -n assigns the variable its value names whenever it is
+// assigned itself, so that variable is checked too.
+func checkDeclArgs(variant string, opts []option, args []declArg) *Denial {
+nameref := variant == "nameref"
+if variant == "declare" || variant == "typeset" || variant == "local" {
+for _, o := range opts {
+nameref = nameref || o.letter == 'n'
+}
+}
+for _, a := range args {
+if a.assign {
+if d := checkAssign(varName(a.name)); d != nil {
+return d
+}
+}
+if !nameref {
+continue
+}
+if !a.assign || !a.value.static {
+return &Denial{Reason: fmt.Sprintf("the variable the nameref %s refers to cannot be checked", a.name)}
+}
+if d := checkAssign(varName(a.value.value)); d != nil {
+return d
+}
+}
+return nil
+}
+
+// declClauseArgs returns the options and operands of a declaration clause.
+func declClauseArgs(d *syntax.DeclClause) ([]option, []declArg, error) {
+var opts []option
+var args []declArg
+for _, a := range d.Args {
+if a.Name != nil {
+da := declArg{name: a.Name.Value, assign: !a.Naked, value: word{static: true}}
+switch {
+case a.Value != nil:
+da.value = literal(a.Value)
+case a.Array != nil:
+da.value = word{}
+}
+args = append(args, da)
+continue
+}
+// Flags and quoted operands such as "PATH=/tmp"
+w := literal(a.Value)
+if !w.static {
+return nil, nil, computedArgs(d.Variant.Value)
+}
+if strings.HasPrefix(w.value, "-") || strings.HasPrefix(w.value, "+") {
+if w.value[0] == '-' {
+for j := 1; j < len(w.value); j++ {
+opts = append(opts, option{letter: w.value[j]})
+}
+}
+continue
+}
+n, v, assign := strings.Cut(w.value, "=")
+args = append(args, declArg{name: n, value: word{value: v, static: true}, assign: assign})
+}
+return opts, args, nil
+}
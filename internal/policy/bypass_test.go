@@ -0,0 +1,287 @@
+package policy
+
+import (
+"testing"
+
+"github.com/stretchr/testify/assert"
+)
+
+// bypassPolicy allows a few harmless programs, and wrappers and
+// interpreters whose commands are checked in turn. Every command in the
+// corpus below tries to run something else, or to write outside the
+// workspace, and must be denied.
+var bypassPolicy = &Policy{
+Rules: []Rule{
+{Program: "ls", Action: Allow},
+{Program: "echo", Action: Allow},
+{Program: "cat", Action: Allow},
+{Program: "grep", Action: Allow},
+{Program: "find", Action: Allow},
+{Program: "xargs", Action: Allow},
+{Program: "env", Action: Allow},
+{Program: "timeout", Action: Allow},
+{Program: "cd", Action: Allow},
+{Program: "sudo", Action: Allow},
+{Program: "nohup", Action: Allow},
+{Program: "exec", Action: Allow},
+{Program: "command", Action: Allow},
+{Program: "builtin", Action: Allow},
+{Program: "eval", Action: Allow},
+{Program: "sh", Action: Allow},
+{Program: "bash", Action: Allow},
+{Program: "export", Action: Allow},
+{Program: "declare", Action: Allow},
+{Program: "printf", Action: Allow},
+{Program: "read", Action: Allow},
+{Program: "mapfile", Action: Allow},
+},
+WritePaths: []string{"/work"},
+}
+
+var bypassCorpus = []string{
+// command chaining
+"ls; rm -rf /",
+"ls && rm -rf /",
+"ls || rm -rf /",
+"ls & rm -rf /",
+"ls | sh",
+"ls |& sh",
+"ls\nrm -rf /",
+// nesting
+"(rm -rf /)",
+"{ rm -rf /; }",
+"echo $(rm -rf /)",
+"echo `rm -rf /`",
+"echo \"$(rm -rf /)\"",
+"cat <(rm -rf /)",
+"ls > >(rm -rf /)",
+"echo $((`rm -rf /`))",
+"f() { rm -rf /; }; f",
+"for i in 1; do rm -rf /; done",
+"while rm -rf /; do ls; done",
+"case x in x) rm -rf /;; esac",
+"[[ $(rm -rf /) ]]",
+"echo ${x:-$(rm -rf /)}",
+// quoting and escaping
+"r\\m -rf /",
+"'rm' -rf /",
+"\"rm\" -rf /",
+"r''m -rf /",
+"r\"\"m -rf /",
+"$'rm' -rf /",
+"$'\\x72m' -rf /",
+// computed program names
+"$CMD",
+"${CMD} -rf /",
+"$(echo rm) -rf /",
+"`echo rm` -rf /",
+"/bin/r? -rf /",
+"/bin/r* -rf /",
+"/bin/{rm,ls} -rf /",
+"r[m] -rf /",
+// paths
+"/bin/rm -rf /",
+"./ls",
+"../../tmp/ls",
+"~/bin/ls",
+// wrappers and interpreters
+"env rm -rf /",
+"env -i FOO=1 rm -rf /",
+"timeout 5 rm -rf /",
+"timeout -s KILL 5 rm -rf /",
+"xargs rm -rf < files",
+"xargs -I{} rm {}",
+`find . -exec rm {} \;`,
+`find . -execdir sh -c 'rm x' \;`,
+"find . -delete -o -ok rm {} +",
+"sudo rm -rf /",
+"sudo -u root rm -rf /",
+"nohup rm -rf /",
+"sh -c 'rm -rf /'",
+"sh -ec 'ls; rm -rf /'",
+"bash -c 'bash -c \"rm -rf /\"'",
+"sh -c \"$X\"",
+"bash -c \"ls; rm -rf /\"",
+"eval rm -rf /",
+"eval 'ls; rm -rf /'",
+"exec rm -rf /",
+"command rm -rf /",
+"builtin eval rm",
+"eval \"ls\" '&& rm -rf /'",
+"env sh -c 'ls && rm -rf /'",
+"env sh -c \"$X\"",
+"eval \"$X\"",
+"source script.sh",
+". script.sh",
+"declare -x PATH=/tmp",
+// environment
+"PATH=/tmp ls",
+"LD_PRELOAD=/tmp/evil.so ls",
+"BASH_ENV=/tmp/x ls",
+"export PATH=/tmp",
+"IFS=/ ls",
+"env PATH=/tmp ls",
+"printf -v PATH %s /tmp",
+"printf -vPATH %s /tmp",
+"printf \"$F\" PATH /tmp",
+"read PATH <<< /tmp",
+"read -r -a PATH <<< /tmp",
+"mapfile PATH < /dev/null",
+"declare -n p=PATH; p=/tmp",
+"declare -n p; p=PATH; p=/tmp",
+"declare 'PATH=/tmp'",
+"declare $X",
+"builtin declare PATH=/tmp",
+"command export PATH=/tmp",
+"PS1='$(rm -rf /)'",
+// redirections
+"echo x > /etc/passwd",
+"echo x >> /etc/profile",
+"echo x >| /etc/passwd",
+"echo x &> /etc/passwd",
+"echo x &>> /etc/passwd",
+"echo x 2> /etc/passwd",
+"ls >& /etc/passwd",
+"cat <> /etc/passwd",
+"echo x > ../escape",
+"echo x > $FILE",
+"echo x > ~/.bashrc",
+"echo x > /work/../etc/passwd",
+"cd /etc && echo x > passwd",
+"cd $DIR && echo x > f",
+"echo x > /work/*/../../etc/passwd",
+"exec 3> /etc/passwd",
+// parse errors
+"ls $(",
+"ls 'unterminated",
+}
+
+// denyPolicy only denies a few programs. Every command in denyCorpus runs
+// one of them through a builtin, wrapper or variable and must be denied.
+var denyPolicy = &Policy{
+Rules: []Rule{
+{Program: "rm", Action: Deny},
+{Program: "curl", Action: Deny},
+},
+}
+
+var denyCorpus = []string{
+// bracket expressions in the program name
+"[r]m x",
+"/bin/r[!]]m x",
+// env -S splits its argument into a command line
+"env -S 'rm -rf /tmp/x'",
+"env -iS'rm -rf /tmp/x'",
+"env --split-string='rm -rf /tmp/x'",
+"env --split-string 'ls; rm x'",
+"env -S \"$X\"",
+// builtins that run strings later
+"trap 'rm -rf /tmp/x' EXIT",
+"trap -- 'curl x' INT",
+"alias ls='rm x'",
+"alias e=eval",
+"complete -C 'rm x' ls",
+"compgen -C 'rm x' l",
+"complete -W '$(rm x)' ls",
+"fc -s rm",
+"fc -e vi 10",
+"fc",
+"bind -x '\"\\C-a\": rm x'",
+"bind '\"\\C-a\": \"rm x\\n\"'",
+"set -H",
+"set -o histexpand",
+"enable -f /tmp/evil.so evil",
+// wrappers and shells
+"watch rm x",
+"watch -n 1 'ls; rm x'",
+"busybox rm x",
+"toybox rm x",
+"busybox sh -c 'rm x'",
+"sudo -s",
+"sudo -i",
+"sudo -s 'ls; rm x'",
+"sudo -Es 'ls; rm x'",
+"doas -s",
+"su",
+"su -c 'rm x'",
+// variables assigned by name
+"printf -v PATH %s /tmp",
+"read PATH <<< /tmp",
+"mapfile -t PATH < /dev/null",
+"getopts a PATH",
+"wait -p PATH",
+"declare -n p=PATH; p=/tmp",
+"local -n p=PATH",
+"declare -n p; p=PATH; p=/tmp",
+"PS1='$(rm x)'",
+}
+
+func TestPolicy_DenyRuleBypass(t *testing.T) {
+for _, cmd := range denyCorpus {
+t.Run(cmd, func(t *testing.T) {
+err := denyPolicy.Check(cmd, "/work")
+assert.Error(t, err, "command should be denied")
+})
+}
+
+allowed := []string{
+"env -S 'ls -l'",
+"trap 'echo bye' EXIT",
+"trap - EXIT",
+"alias",
+"complete -F _ls -W 'a b' ls",
+"fc -l",
+"fc -ln -10",
+"bind -p",
+"set -euo pipefail",
+"set +H",
+"watch -n 1 ls",
+"busybox ls",
+"sudo -s ls",
+"su -c ls",
+"printf -v out %s x",
+"read -r line",
+"mapfile -t lines < /dev/null",
+"declare -n ref=out",
+"builtin declare x=1",
+"[ -f x ] && ls",
+"[ ! -d x ] || ls [",
+"test -f x && ls",
+"[[ -f x ]] && ls",
+}
+for _, cmd := range allowed {
+t.Run("allows "+cmd, func(t *testing.T) {
+assert.NoError(t, denyPolicy.Check(cmd, "/work"))
+})
+}
+}
+
+func TestPolicy_BypassCorpus(t *testing.T) {
+for _, cmd := range bypassCorpus {
+t.Run(cmd, func(t *testing.T) {
+err := bypassPolicy.Check(cmd, "/work")
+assert.Error(t, err, "command should be denied")
+})
+}
+}
+
+func TestPolicy_NestingLimit(t *testing.T) {
+cmd := "ls"
+for i := 0; i < maxDepth+2; i++ {
+cmd = "eval " + shellQuote(cmd)
+}
+p := &Policy{Rules: []Rule{{Program: "ls", Action: Allow}, {Program: "eval", Action: Allow}}}
+assert.ErrorContains(t, p.Check(cmd, "/"), "nested too deeply")
+}
+
+func shellQuote(s string) string {
+out := "'"
+for _, r := range s {
+if r == '\'' {
+out += `'\''`
+continue
+}
+out += string(r)
+}
+return out + "'"
+}
@@ -0,0 +1,668 @@
+package policy
+
+import (
+"fmt"
+"os"
+"path"
+"path/filepath"
+"strings"
+
+"mvdan.cc/sh/v3/syntax"
+)
+
+// maxDepth bounds nested scripts such as sh -c "eval '...'".
+const maxDepth = 8
+
+// systemBinDirs hold the programs an allow rule for a bare name covers when
+// they are invoked by path.
+var systemBinDirs = map[string]bool{
+"/bin": true, "/sbin": true, "/usr/bin": true, "/usr/sbin": true,
+"/usr/local/bin": true, "/usr/local/sbin": true,
+}
+
+// protectedVars change how programs are found or what the shell runs
+// implicitly, so setting them could bypass the rules.
+var protectedVars = map[string]bool{
+"PATH": true, "LD_PRELOAD": true, "LD_LIBRARY_PATH": true, "LD_AUDIT": true,
+"BASH_ENV": true, "ENV": true, "PROMPT_COMMAND": true, "IFS": true,
+"SHELLOPTS": true, "BASHOPTS": true, "PS4": true, "CDPATH": true,
+// Prompts are expanded, running their command substitutions
+"PS0": true, "PS1": true, "PS2": true,
+}
+
+// wrapperOptions lists programs that run another program given as their
+// arguments, with the options that take a separate value.
+var wrapperOptions = map[string][]string{
+"builtin": nil,
+"busybox": nil,
+"command": nil,
+"doas":    {"-u", "-C"},
+"env":     {"-u", "--unset", "-C", "--chdir"},
+"exec":    {"-a"},
+"ionice":  {"-c", "-n", "--class", "--classdata"},
+"nice":    {"-n", "--adjustment"},
+"nohup":   nil,
+"setsid":  nil,
+"stdbuf":  {"-i", "-o", "-e"},
+"sudo":    {"-u", "-g", "-h", "-p", "-C", "-D", "-r", "-t", "-U", "-T", "--user", "--group"},
+"time":    {"-f", "-o", "--format", "--output"},
+"timeout": {"-s", "-k", "--signal", "--kill-after"},
+"toybox":  nil,
+"watch":   {"-n", "-q", "--interval", "--equexit"},
+"xargs":   {"-I", "-n", "-P", "-d", "-s", "-L", "-a", "-E", "--max-args", "--max-procs", "--delimiter", "--arg-file"},
+}
+
+// shells run the script given with -c.
+var shells = map[string]bool{"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true}
+
+// shellFlags are the options with which sudo and doas run the command in
+// a shell, or without a command start an interactive one.
+var shellFlags = map[string]bool{"-s": true, "-i": true, "--shell": true, "--login": true}
+
+// writeOps are redirections that write to their target.
+var writeOps = map[syntax.RedirOperator]bool{
+syntax.RdrOut: true, syntax.AppOut: true, syntax.RdrInOut: true,
+syntax.ClbOut: true, syntax.RdrAll: true, syntax.AppAll: true,
+}
+
+// alwaysWritable are redirect targets that never need a write path.
+var alwaysWritable = map[string]bool{
+"/dev/null": true, "/dev/stdout": true, "/dev/stderr": true, "/dev/tty": true,
+}
+
+type checker struct {
+p     *Policy
+home  string
+depth int
+// workdir is where relative paths are resolved; it is empty once a
+// cd to an unknown directory makes it unpredictable.
+workdir string
+}
+
+func newChecker(p *Policy, workdir string) *checker {
+home, _ := os.UserHomeDir()
+if workdir == "" {
+workdir, _ = os.Getwd()
+}
+return &checker{p: p, home: home, workdir: workdir}
+}
+
+// checkScript parses and checks a complete script.
+func (c *checker) checkScript(src string) error {
+c.depth++
+defer func() { c.depth-- }()
+if c.depth > maxDepth {
+return &Denial{Reason: "commands are nested too deeply to be checked"}
+}
+
+file, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(src), "")
+if err != nil {
+return &Denial{Reason: fmt.Sprintf("the command cannot be parsed: %v", err)}
+}
+
+var denial error
+syntax.Walk(file, func(node syntax.Node) bool {
+if denial != nil {
+return false
+}
+switch n := node.(type) {
+case *syntax.CallExpr:
+denial = c.checkCall(n)
+case *syntax.DeclClause:
+denial = c.checkDecl(n)
+case *syntax.Redirect:
+denial = c.checkRedirect(n)
+}
+return denial == nil
+})
+return denial
+}
+
+func (c *checker) checkCall(ce *syntax.CallExpr) error {
+for _, a := range ce.Assigns {
+if err := checkAssign(a.Name.Value); err != nil {
+err.Command = printNode(ce)
+return err
+}
+}
+if len(ce.Args) == 0 {
+return nil
+}
+if err := c.checkArgs(ce.Args); err != nil {
+if d, ok := err.(*Denial); ok && d.Command == "" {
+d.Command = printNode(ce)
+}
+return err
+}
+return nil
+}
+
+func (c *checker) checkDecl(d *syntax.DeclClause) error {
+opts, args, err := declClauseArgs(d)
+if err == nil {
+if denial := checkDeclArgs(d.Variant.Value, opts, args); denial != nil {
+err = denial
+}
+}
+if err != nil {
+err.(*Denial).Command = printNode(d)
+return err
+}
+if err := c.checkProgram(d.Variant.Value, nil); err != nil {
+err.Command = printNode(d)
+return err
+}
+return nil
+}
+
+func checkAssign(name string) *Denial {
+if protectedVars[name] || strings.HasPrefix(name, "BASH_FUNC_") {
+return &Denial{Reason: fmt.Sprintf("setting %s is not allowed", name)}
+}
+return nil
+}
+
+// checkArgs checks a program invocation, args[0] being the program, and
+// whatever it runs in turn.
+func (c *checker) checkArgs(args []*syntax.Word) error {
+prog := literal(args[0])
+if !prog.static || prog.glob {
+return &Denial{Reason: fmt.Sprintf("the program name %s is computed at run time and cannot be checked", printNode(args[0]))}
+}
+rest := make([]word, len(args)-1)
+for i, a := range args[1:] {
+rest[i] = literal(a)
+}
+if err := c.checkProgram(prog.value, rest); err != nil {
+return err
+}
+
+name := path.Base(prog.value)
+switch {
+case name == "cd" || name == "pushd" || name == "popd":
+c.changeDir(name, rest)
+case name == "eval":
+return c.checkNested(name, rest)
+case name == "su":
+return c.checkSu(rest)
+case shells[name]:
+if script, ok := shellScript(rest); ok {
+if !script.static {
+return &Denial{Reason: fmt.Sprintf("the script passed to %s -c is computed at run time and cannot be checked", name)}
+}
+return c.checkScript(script.value)
+}
+return &Denial{Reason: fmt.Sprintf("%s without -c runs commands from a file or standard input that cannot be checked", name)}
+case name == "find":
+return c.checkFindExec(args[1:])
+case name == "env" && hasSplitString(rest):
+return c.checkEnvSplit(rest)
+case isWrapper(name):
+inner := unwrap(name, args[1:])
+innerWords := rest[len(rest)-len(inner):]
+switch {
+case name == "watch" && len(inner) > 0:
+// watch runs its arguments joined with sh -c
+return c.checkNested(name, innerWords)
+case name == "sudo" || name == "doas":
+for _, a := range rest[:len(rest)-len(inner)] {
+if shellFlags[a.value] || isShortCluster(a.value) && strings.ContainsAny(a.value, "si") {
+if len(inner) == 0 {
+return &Denial{Reason: fmt.Sprintf("%s %s starts a shell whose commands cannot be checked", name, a.value)}
+}
+return c.checkNested(name, innerWords)
+}
+}
+}
+if name == "env" {
+// env NAME=VALUE sets variables like an assignment
+for _, a := range rest[:len(rest)-len(inner)] {
+if i := strings.IndexByte(a.value, '='); i > 0 {
+if err := checkAssign(a.value[:i]); err != nil {
+return err
+}
+}
+}
+}
+if len(inner) > 0 {
+return c.checkArgs(inner)
+}
+default:
+return c.checkBuiltin(name, rest)
+}
+return nil
+}
+
+// isShortCluster reports whether s is a cluster of short options such as -Es.
+func isShortCluster(s string) bool {
+return len(s) > 1 && s[0] == '-' && s[1] != '-'
+}
+
+// hasSplitString reports whether the options of env include -S or
+// --split-string, which split a string into the command and its arguments.
+func hasSplitString(args []word) bool {
+_, _, ok := envSplitString(args)
+return ok
+}
+
+// envSplitString finds the -S option of env. It returns the index of the
+// argument with the string and where in it the string starts.
+func envSplitString(args []word) (int, int, bool) {
+for i := 0; i < len(args); i++ {
+v := args[i].value
+switch {
+case !args[i].static || v == "--" || !strings.HasPrefix(v, "-"):
+return 0, 0, false
+case v == "--split-string":
+return i + 1, 0, i+1 < len(args)
+case strings.HasPrefix(v, "--split-string="):
+return i, len("--split-string="), true
+case v == "-u" || v == "-C" || v == "--unset" || v == "--chdir":
+i++
+case isShortCluster(v):
+for j := 1; j < len(v); j++ {
+switch v[j] {
+case 'S':
+if j+1 < len(v) {
+return i, j + 1, true
+}
+return i + 1, 0, i+1 < len(args)
+case 'u', 'C':
+// The rest, or the next argument, is the value
+if j+1 == len(v) {
+i++
+}
+j = len(v)
+}
+}
+}
+}
+return 0, 0, false
+}
+
+// checkEnvSplit checks env -S STRING as env does: the string is split like
+// a command line and takes the place of the option. The result is checked
+// as a script so that quotes and expansions in the string are understood.
+func (c *checker) checkEnvSplit(args []word) error {
+if !allStatic(args) {
+return computedArgs("env")
+}
+at, from, _ := envSplitString(args)
+var parts []string
+for i, a := range args {
+switch {
+case i < at:
+parts = append(parts, quoteWord(a.value))
+case i == at:
+opt := strings.TrimSuffix(strings.TrimSuffix(a.value[:from], "S"), "--split-string=")
+if opt != "" && opt != "-" {
+parts = append(parts, quoteWord(opt))
+}
+parts = append(parts, a.value[from:])
+default:
+parts = append(parts, quoteWord(a.value))
+}
+}
+if from == 0 && at > 0 {
+// -S was the previous argument
+parts = append(parts[:at-1], parts[at:]...)
+}
+return c.checkScript("env " + strings.Join(parts, " "))
+}
+
+// checkSu checks su -c COMMAND as a script. Without -c su starts an
+// interactive shell.
+func (c *checker) checkSu(args []word) error {
+for i, a := range args {
+if !a.static {
+return computedArgs("su")
+}
+switch {
+case (a.value == "-c" || a.value == "--command" || a.value == "--session-command") && i+1 < len(args):
+return c.checkNested("su", args[i+1:i+2])
+case strings.HasPrefix(a.value, "--command="):
+return c.checkNested("su", []word{{value: strings.TrimPrefix(a.value, "--command="), static: true}})
+}
+}
+return &Denial{Reason: "su without -c starts a shell whose commands cannot be checked"}
+}
+
+func isWrapper(name string) bool {
+_, ok := wrapperOptions[name]
+return ok
+}
+
+// checkNested checks the arguments of eval as a script.
+func (c *checker) checkNested(name string, args []word) error {
+var parts []string
+for _, a := range args {
+if !a.static {
+return &Denial{Reason: fmt.Sprintf("the script passed to %s is computed at run time and cannot be checked", name)}
+}
+parts = append(parts, a.value)
+}
+return c.checkScript(strings.Join(parts, " "))
+}
+
+// shellScript finds the script of "sh -c script".
+func shellScript(args []word) (word, bool) {
+for i, a := range args {
+if a.value == "--" || !strings.HasPrefix(a.value, "-") {
+break
+}
+if !strings.HasPrefix(a.value, "--") && strings.Contains(a.value, "c") && i+1 < len(args) {
+return args[i+1], true
+}
+}
+return word{}, false
+}
+
+// unwrap returns the command run by a wrapper such as sudo or env.
+func unwrap(name string, args []*syntax.Word) []*syntax.Word {
+takesValue := make(map[string]bool)
+for _, o := range wrapperOptions[name] {
+takesValue[o] = true
+}
+positional := 0
+if name == "timeout" {
+// timeout DURATION COMMAND
+positional = 1
+}
+for i := 0; i < len(args); i++ {
+w := literal(args[i])
+switch {
+case w.static && w.value == "--":
+continue
+case w.static && strings.HasPrefix(w.value, "-") && len(w.value) > 1:
+if takesValue[w.value] {
+i++
+}
+case name == "env" && w.static && strings.Contains(w.value, "=") && !strings.HasPrefix(w.value, "="):
+// NAME=VALUE, checked like an assignment by the caller
+continue
+case positional > 0:
+positional--
+default:
+return args[i:]
+}
+}
+return nil
+}
+
+// checkFindExec checks the commands of find -exec and friends.
+func (c *checker) checkFindExec(args []*syntax.Word) error {
+for i := 0; i < len(args); i++ {
+switch literal(args[i]).value {
+case "-exec", "-execdir", "-ok", "-okdir":
+j := i + 1
+for j < len(args) {
+v := literal(args[j]).value
+if v == ";" || v == "+" {
+break
+}
+j++
+}
+if j > i+1 {
+if err := c.checkArgs(args[i+1 : j]); err != nil {
+return err
+}
+}
+i = j
+}
+}
+return nil
+}
+
+// changeDir tracks cd so that later relative paths resolve correctly.
+func (c *checker) changeDir(name string, args []word) {
+if name == "popd" || c.workdir == "" {
+c.workdir = ""
+return
+}
+var dest *word
+for i := range args {
+if !strings.HasPrefix(args[i].value, "-") || args[i].value == "-" {
+dest = &args[i]
+break
+}
+}
+switch {
+case dest == nil:
+c.workdir = c.home
+case !dest.static || dest.glob || dest.value == "-":
+c.workdir = ""
+default:
+c.workdir, _ = c.resolve(dest.value)
+}
+}
+
+// checkProgram applies the rules to one invocation. args are the static
+// values of the arguments as far as they are known.
+func (c *checker) checkProgram(prog string, args []word) *Denial {
+name := path.Base(prog)
+
+for _, r := range c.p.Rules {
+if r.Action != Deny || !matchProgram(r, prog) {
+continue
+}
+if len(r.Args) > 0 && !argsMatch(r.Args, args, true) {
+continue
+}
+if len(r.Paths) > 0 && !c.anyPathInside(args, r.Paths) {
+continue
+}
+reason := fmt.Sprintf("%s is denied by policy", name)
+if r.Reason != "" {
+reason += ": " + r.Reason
+}
+return &Denial{Reason: reason}
+}
+
+var argsDenial, pathDenial *Denial
+for _, r := range c.p.Rules {
+if r.Action != Allow || !matchProgram(r, prog) {
+continue
+}
+if len(r.Args) > 0 && !argsMatch(r.Args, args, false) {
+argsDenial = &Denial{Reason: fmt.Sprintf("the arguments of %s do not match any allowed pattern (%s)", name, strings.Join(r.Args, ", "))}
+continue
+}
+if len(r.Paths) > 0 {
+if d := c.allPathsInside(name, args, r.Paths); d != nil {
+pathDenial = d
+continue
+}
+}
+return nil
+}
+
+switch {
+case pathDenial != nil:
+return pathDenial
+case argsDenial != nil:
+return argsDenial
+case c.p.defaultAction() == Allow:
+return nil
+case strings.Contains(prog, "/") && c.coveredByName(name):
+return &Denial{Reason: fmt.Sprintf("%s is only allowed from the system bin directories, not as %s", name, prog)}
+default:
+return &Denial{Reason: fmt.Sprintf("%s is not in the allowlist", name)}
+}
+}
+
+// coveredByName reports whether an allow rule names the program without a path.
+func (c *checker) coveredByName(name string) bool {
+for _, r := range c.p.Rules {
+if r.Action == Allow && !strings.Contains(r.Program, "/") && wildcard(r.Program, name) {
+return true
+}
+}
+return false
+}
+
+// matchProgram reports whether rule r is about prog.
+func matchProgram(r Rule, prog string) bool {
+if strings.Contains(r.Program, "/") {
+return wildcard(r.Program, path.Clean(prog))
+}
+if !wildcard(r.Program, path.Base(prog)) {
+return false
+}
+// A bare name in an allow rule means the system program of that name
+if r.Action == Allow && strings.Contains(prog, "/") {
+return systemBinDirs[path.Dir(path.Clean(prog))]
+}
+return true
+}
+
+// argsMatch matches the joined arguments against patterns. Arguments that
+// are not known before the command runs match deny rules and do not match
+// allow rules.
+func argsMatch(patterns []string, args []word, deny bool) bool {
+values := make([]string, len(args))
+for i, a := range args {
+if !a.static {
+return deny
+}
+values[i] = a.value
+}
+joined := strings.Join(values, " ")
+for _, p := range patterns {
+if wildcard(p, joined) {
+return true
+}
+}
+return false
+}
+
+// pathArgs returns the arguments that may name files: operands and the
+// values of --option=value.
+func pathArgs(args []word) []word {
+var out []word
+for _, a := range args {
+if strings.HasPrefix(a.value, "-") {
+if i := strings.IndexByte(a.value, '='); i > 0 {
+out = append(out, word{value: a.value[i+1:], static: a.static, glob: a.glob})
+}
+continue
+}
+out = append(out, a)
+}
+return out
+}
+
+// allPathsInside returns a denial unless every path argument is inside dirs.
+func (c *checker) allPathsInside(name string, args []word, dirs []string) *Denial {
+for _, a := range pathArgs(args) {
+p, ok := c.resolveArg(a)
+if !ok {
+return &Denial{Reason: fmt.Sprintf("the path %s of %s cannot be checked", a.value, name)}
+}
+if !c.inside(p, dirs) {
+return &Denial{Reason: fmt.Sprintf("%s may only access paths inside %s, not %s", name, strings.Join(dirs, ", "), p)}
+}
+}
+return nil
+}
+
+// anyPathInside reports whether a path argument is, or may be, inside dirs.
+func (c *checker) anyPathInside(args []word, dirs []string) bool {
+for _, a := range pathArgs(args) {
+p, ok := c.resolveArg(a)
+if !ok || c.inside(p, dirs) {
+return true
+}
+}
+return false
+}
+
+// resolveArg resolves a path argument. For wildcards the directory before
+// the first wildcard is used.
+func (c *checker) resolveArg(a word) (string, bool) {
+if !a.static {
+return "", false
+}
+v := a.value
+if a.glob {
+if i := strings.IndexAny(v, "*?[{"); i >= 0 {
+v = v[:i]
+if j := strings.LastIndexByte(v, '/'); j >= 0 {
+v = v[:j+1]
+} else {
+v = "."
+}
+}
+}
+return c.resolve(v)
+}
+
+// resolve makes p absolute and clean.
+func (c *checker) resolve(p string) (string, bool) {
+switch {
+case p == "~" || strings.HasPrefix(p, "~/"):
+p = c.home + p[1:]
+case strings.HasPrefix(p, "~"):
+// ~user
+return "", false
+}
+if !filepath.IsAbs(p) {
+if c.workdir == "" {
+return "", false
+}
+p = filepath.Join(c.workdir, p)
+}
+return filepath.Clean(p), true
+}
+
+// inside reports whether p is one of dirs or below one of them.
+func (c *checker) inside(p string, dirs []string) bool {
+for _, d := range dirs {
+dir, ok := c.resolve(d)
+if !ok {
+continue
+}
+rel, err := filepath.Rel(dir, p)
+if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+return true
+}
+}
+return false
+}
+
+func (c *checker) checkRedirect(r *syntax.Redirect) error {
+if len(c.p.WritePaths) == 0 || r.Word == nil {
+return nil
+}
+target := literal(r.Word)
+if r.Op == syntax.DplOut && target.static && isFD(target.value) {
+return nil
+}
+if !writeOps[r.Op] && r.Op != syntax.DplOut {
+return nil
+}
+if target.static && alwaysWritable[target.value] {
+return nil
+}
+
+p, ok := c.resolveArg(target)
+if !ok || target.glob {
+return &Denial{Reason: fmt.Sprintf("the redirect target %s cannot be checked", printNode(r.Word))}
+}
+if !c.inside(p, c.p.WritePaths) {
+return &Denial{Reason: fmt.Sprintf("output may only be written inside %s, not to %s", strings.Join(c.p.WritePaths, ", "), p)}
+}
+return nil
+}
+
+// isFD reports whether s is a file descriptor number or "-" as in 2>&1 or >&-.
+func isFD(s string) bool {
+if s == "-" {
+return true
+}
+for _, r := range s {
+if r < '0' || r > '9' {
+return false
+}
+}
+return s != ""
+}
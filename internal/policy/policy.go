@@ -0,0 +1,121 @@
+// Package policy decides whether a shell command may run. Commands are
+// parsed into a shell syntax tree and every program they would invoke,
+// including those in pipelines, subshells, command substitutions and
+// wrappers such as sudo, env or sh -c, is checked against allow and deny
+// rules. Output redirections can be confined to a set of directories.
+package policy
+
+import (
+"fmt"
+)
+
+// Action is what a rule does with a matching command.
+type Action string
+
+const (
+Allow Action = "allow"
+Deny  Action = "deny"
+)
+
+// Rule matches invocations of a program.
+type Rule struct {
+// Program is the program name, matched against the base name of the
+// invoked program, or an absolute path matched against the full path.
+// "*" and "?" are wildcards. An allow rule for a bare name does not
+// cover programs run by path from outside the system bin directories,
+// so "ls" does not allow "./ls".
+Program string `yaml:"program"`
+Action  Action `yaml:"action"`
+// Args restricts the rule to invocations whose arguments, joined by
+// spaces, match one of these wildcard patterns, e.g. "status*" or "*-r*".
+Args []string `yaml:"args,omitempty"`
+// Paths confines path arguments. An allow rule only allows commands
+// whose path arguments are all inside one of these directories; a deny
+// rule denies commands with a path argument inside one of them. Relative
+// entries are relative to the shell's working directory.
+Paths []string `yaml:"paths,omitempty"`
+// Reason is reported to the model when the rule denies a command.
+Reason string `yaml:"reason,omitempty"`
+}
+
+// Policy is a set of rules. Deny rules take precedence over allow rules.
+// The zero value allows everything.
+type Policy struct {
+Rules []Rule `yaml:"rules"`
+// Default applies to programs no rule matches. When empty, programs are
+// denied if there is at least one allow rule and allowed otherwise.
+Default Action `yaml:"default,omitempty"`
+// WritePaths confines the targets of output redirections. When empty,
+// output may be redirected anywhere.
+WritePaths []string `yaml:"write_paths,omitempty"`
+}
+
+// FromAllowlist builds the policy equivalent to a plain list of allowed
+// program names. An empty list allows everything.
+func FromAllowlist(allowlist []string) *Policy {
+p := &Policy{}
+for _, name := range allowlist {
+p.Rules = append(p.Rules, Rule{Program: name, Action: Allow})
+}
+return p
+}
+
+// Validate reports configuration errors.
+func (p *Policy) Validate() error {
+switch p.Default {
+case "", Allow, Deny:
+default:
+return fmt.Errorf("invalid default action %q, expected allow or deny", p.Default)
+}
+for i, r := range p.Rules {
+if r.Program == "" {
+return fmt.Errorf("rule %d has no program", i+1)
+}
+if r.Action != Allow && r.Action != Deny {
+return fmt.Errorf("rule %d for %s has invalid action %q, expected allow or deny", i+1, r.Program, r.Action)
+}
+}
+return nil
+}
+
+// defaultAction resolves Default.
+func (p *Policy) defaultAction() Action {
+if p.Default != "" {
+return p.Default
+}
+for _, r := range p.Rules {
+if r.Action == Allow {
+return Deny
+}
+}
+return Allow
+}
+
+// unrestricted reports whether the policy allows every command.
+func (p *Policy) unrestricted() bool {
+return len(p.Rules) == 0 && len(p.WritePaths) == 0 && p.defaultAction() == Allow
+}
+
+// Denial explains why a command was rejected.
+type Denial struct {
+// Command is the part of the command line that was rejected.
+Command string
+Reason  string
+}
+
+func (d *Denial) Error() string {
+if d.Command == "" {
+return "command denied: " + d.Reason
+}
+return fmt.Sprintf("command denied: %s (in %q)", d.Reason, d.Command)
+}
+
+// Check parses command and returns a *Denial if it must not run. workdir
+// is the directory the shell is in, used to resolve relative paths.
+func (p *Policy) Check(command, workdir string) error {
+if p == nil || p.unrestricted() {
+return nil
+}
+c := newChecker(p, workdir)
+return c.checkScript(command)
+}
@@ -0,0 +1,133 @@
+package policy
+
+import (
+"testing"
+
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+func TestPolicy_Check(t *testing.T) {
+p := &Policy{
+Rules: []Rule{
+{Program: "ls", Action: Allow},
+{Program: "cat", Action: Allow},
+{Program: "grep", Action: Allow},
+{Program: "echo", Action: Allow},
+{Program: "cd", Action: Allow},
+{Program: "git", Action: Allow, Args: []string{"status*", "diff*", "log*"}},
+{Program: "rm", Action: Allow, Paths: []string{"/work/build"}},
+{Program: "cat", Action: Deny, Paths: []string{"/etc/shadow"}, Reason: "secrets"},
+},
+WritePaths: []string{"/work"},
+}
+
+allowed := []string{
+"ls -la",
+"ls | grep foo",
+"cat a.txt && echo done; ls",
+"(cd sub && ls)",
+"echo $(ls)",
+"git status --short",
+"git log -n 5",
+"rm -rf /work/build/out",
+"rm build/obj.o",
+"cd build && rm obj.o",
+"echo hi > out.txt",
+"echo hi >> /work/logs/a.log",
+"ls 2>&1 | grep x",
+"ls 2>/dev/null",
+"/bin/ls",
+"/usr/bin/cat a.txt",
+"FOO=bar ls",
+}
+for _, cmd := range allowed {
+t.Run("allows "+cmd, func(t *testing.T) {
+assert.NoError(t, p.Check(cmd, "/work"))
+})
+}
+
+denied := map[string]string{
+"pwd":                     "pwd is not in the allowlist",
+"ls | sort":               "sort is not in the allowlist",
+"git push origin main":    "the arguments of git do not match any allowed pattern",
+"rm -rf /":                "rm may only access paths inside /work/build, not /",
+"rm ../build/x":           "not /build/x",
+"cd /tmp && rm build/x":   "not /tmp/build/x",
+"cat /etc/shadow":         "cat is denied by policy: secrets",
+"echo hi > /etc/passwd":   "output may only be written inside /work, not to /etc/passwd",
+"echo hi > ../outside":    "not to /outside",
+"./ls":                    "ls is only allowed from the system bin directories",
+"/tmp/ls":                 "ls is only allowed from the system bin directories",
+"ls \"$(pwd)\"":           "pwd is not in the allowlist",
+"echo `id`":               "id is not in the allowlist",
+"ls; (cd /; pwd)":         "pwd is not in the allowlist",
+"if true; then ls; fi":    "true is not in the allowlist",
+"ls &&":                   "cannot be parsed",
+}
+for cmd, reason := range denied {
+t.Run("denies "+cmd, func(t *testing.T) {
+err := p.Check(cmd, "/work")
+require.Error(t, err)
+var d *Denial
+require.ErrorAs(t, err, &d)
+assert.Contains(t, err.Error(), reason)
+})
+}
+}
+
+func TestPolicy_Denial(t *testing.T) {
+p := FromAllowlist([]string{"ls"})
+err := p.Check("ls && rm -rf /", "/")
+require.Error(t, err)
+assert.Equal(t, `command denied: rm is not in the allowlist (in "rm -rf /")`, err.Error())
+}
+
+func TestPolicy_Defaults(t *testing.T) {
+t.Run("nil and empty policies allow everything", func(t *testing.T) {
+var p *Policy
+assert.NoError(t, p.Check("rm -rf /", "/"))
+assert.NoError(t, FromAllowlist(nil).Check("rm -rf / | sh", "/"))
+})
+
+t.Run("deny rules alone allow other programs", func(t *testing.T) {
+p := &Policy{Rules: []Rule{{Program: "rm", Action: Deny}}}
+assert.NoError(t, p.Check("ls | sort", "/"))
+assert.Error(t, p.Check("ls; rm x", "/"))
+})
+
+t.Run("explicit default", func(t *testing.T) {
+p := &Policy{Default: Deny}
+assert.Error(t, p.Check("ls", "/"))
+p = &Policy{Default: Allow, Rules: []Rule{{Program: "ls", Action: Allow}}}
+assert.NoError(t, p.Check("pwd", "/"))
+})
+
+t.Run("deny args match dynamic arguments", func(t *testing.T) {
+p := &Policy{Rules: []Rule{{Program: "git", Action: Deny, Args: []string{"push*"}}}}
+assert.NoError(t, p.Check("git status", "/"))
+assert.Error(t, p.Check("git push", "/"))
+assert.Error(t, p.Check("git $CMD", "/"))
+})
+
+t.Run("absolute program rules", func(t *testing.T) {
+p := &Policy{Rules: []Rule{{Program: "/opt/tools/*", Action: Allow}}}
+assert.NoError(t, p.Check("/opt/tools/lint ./...", "/"))
+assert.Error(t, p.Check("lint", "/"))
+})
+}
+
+func TestPolicy_Validate(t *testing.T) {
+assert.NoError(t, FromAllowlist([]string{"ls"}).Validate())
+assert.Error(t, (&Policy{Default: "maybe"}).Validate())
+assert.Error(t, (&Policy{Rules: []Rule{{Action: Allow}}}).Validate())
+assert.Error(t, (&Policy{Rules: []Rule{{Program: "ls", Action: "permit"}}}).Validate())
+}
+
+func TestWildcard(t *testing.T) {
+assert.True(t, wildcard("status*", "status --short"))
+assert.True(t, wildcard("*-r*", "-rf /"))
+assert.True(t, wildcard("l?", "ls"))
+assert.False(t, wildcard("status*", "push status"))
+assert.False(t, wildcard("a.b", "axb"))
+}
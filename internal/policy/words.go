@@ -0,0 +1,138 @@
+package policy
+
+import (
+"regexp"
+"strings"
+
+"mvdan.cc/sh/v3/syntax"
+)
+
+// word is the static value of a shell word.
+type word struct {
+value string
+// static is false when the value depends on expansions such as $VAR,
+// $(...) or $((...)) and is not known before the command runs.
+static bool
+// glob is set when unquoted wildcards or braces may expand the word.
+glob bool
+}
+
+// literal resolves quoting and escapes of w.
+func literal(w *syntax.Word) word {
+var sb strings.Builder
+res := word{static: true}
+for _, part := range w.Parts {
+switch p := part.(type) {
+case *syntax.Lit:
+v, glob := unescape(p.Value)
+sb.WriteString(v)
+res.glob = res.glob || glob
+case *syntax.SglQuoted:
+if p.Dollar {
+// $'...' escapes are not decoded
+res.static = false
+}
+sb.WriteString(p.Value)
+case *syntax.DblQuoted:
+for _, dp := range p.Parts {
+lit, ok := dp.(*syntax.Lit)
+if !ok {
+res.static = false
+continue
+}
+sb.WriteString(unescapeDouble(lit.Value))
+}
+default:
+res.static = false
+}
+}
+res.value = sb.String()
+return res
+}
+
+// unescape removes backslash escapes from unquoted text and reports whether
+// it contains unescaped wildcards or braces. A "[" is only a wildcard when
+// a "]" closes it, so that the test command "[" stays a literal.
+func unescape(s string) (string, bool) {
+var sb strings.Builder
+glob := false
+for i := 0; i < len(s); i++ {
+c := s[i]
+if c == '\\' && i+1 < len(s) {
+i++
+if s[i] != '\n' {
+sb.WriteByte(s[i])
+}
+continue
+}
+switch c {
+case '*', '?', '{':
+glob = true
+case '[':
+glob = glob || closesBracket(s[i+1:])
+}
+sb.WriteByte(c)
+}
+return sb.String(), glob
+}
+
+// closesBracket reports whether s, the text after a "[", has the "]" that
+// ends the bracket expression. A "]" first in the expression, after an
+// optional "!" or "^", is one of its characters.
+func closesBracket(s string) bool {
+start := 0
+if strings.HasPrefix(s, "!") || strings.HasPrefix(s, "^") {
+start = 1
+}
+for j := start; j < len(s); j++ {
+switch {
+case s[j] == '\\':
+j++
+case s[j] == ']' && j > start:
+return true
+}
+}
+return false
+}
+
+// unescapeDouble removes the escapes that are special inside double quotes.
+func unescapeDouble(s string) string {
+var sb strings.Builder
+for i := 0; i < len(s); i++ {
+if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+i++
+if s[i] != '\n' {
+sb.WriteByte(s[i])
+}
+continue
+}
+sb.WriteByte(s[i])
+}
+return sb.String()
+}
+
+// wildcard matches text against a pattern where "*" matches any run of
+// characters, including "/" and spaces, and "?" a single character.
+func wildcard(pattern, text string) bool {
+expr := regexp.QuoteMeta(pattern)
+expr = strings.ReplaceAll(expr, `\*`, ".*")
+expr = strings.ReplaceAll(expr, `\?`, ".")
+ok, _ := regexp.MatchString("^(?s:"+expr+")$", text)
+return ok
+}
+
+// printNode renders n as it appears in the command line.
+func printNode(n syntax.Node) string {
+var sb strings.Builder
+syntax.NewPrinter(syntax.SingleLine(true)).Print(&sb, n)
+s := strings.TrimSpace(sb.String())
+if len(s) > 120 {
+s = s[:117] + "..."
+}
+return s
+}
+
+// quoteWord quotes s for the shell.
+func quoteWord(s string) string {
+return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
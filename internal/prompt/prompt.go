@@ -0,0 +1,162 @@
+// Package prompt renders the system prompt sent with every request from
+// named, templated profiles.
+package prompt
+
+import (
+"fmt"
+"os"
+"runtime"
+"sort"
+"strings"
+"text/template"
+"time"
+)
+
+// DefaultProfile is the name of the built-in profile.
+const DefaultProfile = "default"
+
+// DefaultTemplate is the built-in system prompt.
+const DefaultTemplate = `You are Hyperagent, an autonomous assistant that operates the user's computer through tools.
+
+Environment:
+- OS: {{.OS}}/{{.Arch}}
+- Hostname: {{.Hostname}}
+- Shell: {{.Shell}}
+- Working directory: {{.Cwd}}
+- Current time: {{.Time.Format "Mon, 02 Jan 2006 15:04 MST"}}
+
+Available tools:
+{{range .Tools}}- {{.Name}}: {{.Description}}
+{{end}}
+{{- if .Allowlist}}
+Only these commands may be run with execute_command: {{join .Allowlist ", "}}.
+{{end}}
+Rules:
+- Inspect before you change: read files and check state before editing or running commands that modify the system.
+- Prefer the dedicated file tools over shell commands for reading and editing files.
+- Never run destructive commands (deleting data, formatting disks, killing unrelated processes) unless the user asked for exactly that.
+- If a tool fails, read the error and adjust instead of repeating the same call.
+- Keep answers short and report what you did.`
+
+// Profile is a named system prompt template. Templates use text/template
+// syntax and are rendered with Data.
+type Profile struct {
+Name        string `yaml:"name" json:"name"`
+Description string `yaml:"description,omitempty" json:"description,omitempty"`
+Template    string `yaml:"template" json:"-"`
+}
+
+// Tool is the part of a tool declaration shown in the prompt.
+type Tool struct {
+Name        string
+Description string
+}
+
+// Data is available to profile templates.
+type Data struct {
+OS        string
+Arch      string
+Hostname  string
+Shell     string
+Cwd       string
+Time      time.Time
+Tools     []Tool
+Allowlist []string
+}
+
+// NewData describes the current host. Tools and Allowlist are left to the caller.
+func NewData() Data {
+hostname, _ := os.Hostname()
+cwd, _ := os.Getwd()
+shell := os.Getenv("SHELL")
+if shell == "" {
+shell = "/bin/sh"
+}
+return Data{
+OS:       runtime.GOOS,
+Arch:     runtime.GOARCH,
+Hostname: hostname,
+Shell:    shell,
+Cwd:      cwd,
+Time:     time.Now(),
+}
+}
+
+var funcs = template.FuncMap{"join": strings.Join}
+
+// Library holds the available profiles. The built-in default profile is
+// always present and may be overridden by a profile of the same name.
+type Library struct {
+templates   map[string]*template.Template
+profiles    map[string]Profile
+defaultName string
+}
+
+// NewLibrary parses profiles and selects defaultName, or DefaultProfile when
+// empty, as the fallback for sessions without a profile.
+func NewLibrary(profiles []Profile, defaultName string) (*Library, error) {
+l := &Library{
+templates: make(map[string]*template.Template),
+profiles:  make(map[string]Profile),
+}
+all := append([]Profile{{Name: DefaultProfile, Description: "Built-in general purpose assistant", Template: DefaultTemplate}}, profiles...)
+for _, p := range all {
+if p.Name == "" {
+return nil, fmt.Errorf("profile without a name")
+}
+tmpl, err := template.New(p.Name).Funcs(funcs).Parse(p.Template)
+if err != nil {
+return nil, fmt.Errorf("invalid template for profile %q: %w", p.Name, err)
+}
+l.templates[p.Name] = tmpl
+l.profiles[p.Name] = p
+}
+
+if defaultName == "" {
+defaultName = DefaultProfile
+}
+if _, ok := l.profiles[defaultName]; !ok {
+return nil, fmt.Errorf("default profile %q is not defined", defaultName)
+}
+l.defaultName = defaultName
+return l, nil
+}
+
+// Default returns the name of the profile used by sessions without one.
+func (l *Library) Default() string {
+return l.defaultName
+}
+
+// Has reports whether a profile exists.
+func (l *Library) Has(name string) bool {
+_, ok := l.profiles[name]
+return ok
+}
+
+// Resolve returns the name of the profile used for name: name itself if it
+// exists, the default profile otherwise.
+func (l *Library) Resolve(name string) string {
+if l.Has(name) {
+return name
+}
+return l.defaultName
+}
+
+// Profiles lists the available profiles sorted by name.
+func (l *Library) Profiles() []Profile {
+out := make([]Profile, 0, len(l.profiles))
+for _, p := range l.profiles {
+out = append(out, p)
+}
+sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+return out
+}
+
+// Render renders the profile name, or the default profile if it does not exist.
+func (l *Library) Render(name string, data Data) (string, error) {
+var sb strings.Builder
+if err := l.templates[l.Resolve(name)].Execute(&sb, data); err != nil {
+return "", fmt.Errorf("failed to render system prompt: %w", err)
+}
+return sb.String(), nil
+}
@@ -0,0 +1,75 @@
+package prompt
+
+import (
+"testing"
+"time"
+
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+func testData() Data {
+return Data{
+OS:        "linux",
+Arch:      "amd64",
+Hostname:  "box",
+Shell:     "/bin/bash",
+Cwd:       "/home/me",
+Time:      time.Date(2026, 1, 2, 15, 4, 0, 0, time.UTC),
+Tools:     []Tool{{Name: "read_file", Description: "Read a file"}},
+Allowlist: []string{"ls", "git"},
+}
+}
+
+func TestLibrary_Default(t *testing.T) {
+l, err := NewLibrary(nil, "")
+require.NoError(t, err)
+assert.Equal(t, DefaultProfile, l.Default())
+
+out, err := l.Render("", testData())
+require.NoError(t, err)
+assert.Contains(t, out, "OS: linux/amd64")
+assert.Contains(t, out, "Working directory: /home/me")
+assert.Contains(t, out, "Current time: Fri, 02 Jan 2026 15:04 UTC")
+assert.Contains(t, out, "- read_file: Read a file\n")
+assert.Contains(t, out, "execute_command: ls, git.")
+
+data := testData()
+data.Allowlist = nil
+out, err = l.Render(DefaultProfile, data)
+require.NoError(t, err)
+assert.NotContains(t, out, "execute_command:")
+}
+
+func TestLibrary_Profiles(t *testing.T) {
+l, err := NewLibrary([]Profile{{Name: "terse", Template: "Be terse on {{.OS}}."}}, "terse")
+require.NoError(t, err)
+assert.Equal(t, "terse", l.Default())
+assert.True(t, l.Has("default"))
+assert.Equal(t, "terse", l.Resolve("missing"))
+assert.Equal(t, []string{"default", "terse"}, []string{l.Profiles()[0].Name, l.Profiles()[1].Name})
+
+out, err := l.Render("missing", testData())
+require.NoError(t, err)
+assert.Equal(t, "Be terse on linux.", out)
+
+out, err = l.Render("default", testData())
+require.NoError(t, err)
+assert.Contains(t, out, "You are Hyperagent")
+}
+
+func TestLibrary_Errors(t *testing.T) {
+_, err := NewLibrary([]Profile{{Name: "bad", Template: "{{.OS"}}, "")
+assert.ErrorContains(t, err, `invalid template for profile "bad"`)
+
+_, err = NewLibrary([]Profile{{Template: "x"}}, "")
+assert.Error(t, err)
+
+_, err = NewLibrary(nil, "missing")
+assert.ErrorContains(t, err, `default profile "missing" is not defined`)
+
+l, err := NewLibrary([]Profile{{Name: "broken", Template: "{{.Nope}}"}}, "")
+require.NoError(t, err)
+_, err = l.Render("broken", testData())
+assert.Error(t, err)
+}
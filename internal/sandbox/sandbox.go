@@ -0,0 +1,154 @@
+// Package sandbox runs programs in Linux namespaces with a read-only view
+// of the host, writable workspace directories, no network and resource
+// limits.
+//
+// Sandboxed programs are started through the running binary, which sets up
+// the namespaces and then executes the program. Binaries that create
+// sandboxes must call Init at the start of main, and tests of packages
+// that create sandboxes must call it from TestMain.
+package sandbox
+
+import (
+"errors"
+"fmt"
+"os"
+"path/filepath"
+"time"
+)
+
+// ErrUnsupported is returned when sandboxes cannot be created on this host.
+var ErrUnsupported = errors.New("sandboxing is not supported on this platform")
+
+// specEnv carries the Spec from Command to Init.
+const specEnv = "HYPERAGENT_SANDBOX_SPEC"
+
+// Config describes a sandbox.
+type Config struct {
+Enabled bool `yaml:"enabled"`
+// Network gives the sandbox the host network. Without it the sandbox
+// only has a loopback interface.
+Network bool `yaml:"network"`
+// ReadOnlyPaths are host paths visible read-only in the sandbox.
+ReadOnlyPaths []string `yaml:"read_only_paths,omitempty"`
+// WritablePaths are host directories mounted writable at the same path.
+// The first one is where the shell starts. When empty, the current
+// directory is used.
+WritablePaths []string `yaml:"writable_paths,omitempty"`
+Limits        Limits   `yaml:"limits,omitempty"`
+}
+
+// Limits caps the resources of sandboxed processes. Zero fields take the
+// defaults of DefaultLimits and negative ones mean no limit.
+type Limits struct {
+// CPUTime is the CPU time each process may use.
+CPUTime time.Duration `yaml:"cpu_time"`
+// Memory is the address space of each process in bytes.
+Memory int64 `yaml:"memory"`
+// Processes is the number of processes and threads in the sandbox. The
+// kernel does not enforce it when the daemon runs as root.
+Processes int `yaml:"processes"`
+// FileSize is the largest file a process may write, in bytes.
+FileSize int64 `yaml:"file_size"`
+// OpenFiles is the number of files each process may keep open.
+OpenFiles int `yaml:"open_files"`
+// WallTime is how long the sandbox may exist before it is killed.
+WallTime time.Duration `yaml:"wall_time"`
+}
+
+// DefaultReadOnlyPaths are the system directories shared with sandboxes.
+var DefaultReadOnlyPaths = []string{"/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32", "/usr", "/etc", "/opt"}
+
+// DefaultLimits returns the limits used when none are configured.
+func DefaultLimits() Limits {
+return Limits{
+CPUTime:   30 * time.Minute,
+Memory:    4 << 30,
+Processes: 512,
+FileSize:  1 << 30,
+OpenFiles: 1024,
+WallTime:  8 * time.Hour,
+}
+}
+
+// WithDefaults fills zero fields from DefaultLimits.
+func (l Limits) WithDefaults() Limits {
+def := DefaultLimits()
+if l.CPUTime == 0 {
+l.CPUTime = def.CPUTime
+}
+if l.Memory == 0 {
+l.Memory = def.Memory
+}
+if l.Processes == 0 {
+l.Processes = def.Processes
+}
+if l.FileSize == 0 {
+l.FileSize = def.FileSize
+}
+if l.OpenFiles == 0 {
+l.OpenFiles = def.OpenFiles
+}
+if l.WallTime == 0 {
+l.WallTime = def.WallTime
+}
+return l
+}
+
+// WithDefaults fills unset paths and limits.
+func (c Config) WithDefaults() Config {
+if len(c.ReadOnlyPaths) == 0 {
+c.ReadOnlyPaths = DefaultReadOnlyPaths
+}
+c.Limits = c.Limits.WithDefaults()
+return c
+}
+
+// Validate reports configuration errors.
+func (c Config) Validate() error {
+for _, p := range c.ReadOnlyPaths {
+if !filepath.IsAbs(p) {
+return fmt.Errorf("read-only path %q is not absolute", p)
+}
+}
+for _, p := range c.WritablePaths {
+if !filepath.IsAbs(p) {
+return fmt.Errorf("writable path %q is not absolute", p)
+}
+}
+return nil
+}
+
+// Spec is what Init needs to set up a sandbox.
+type Spec struct {
+Config Config
+// Argv is the program to run and its arguments.
+Argv []string
+// Dir is the working directory of the program.
+Dir string
+}
+
+// spec resolves the paths of c for running argv.
+func (c Config) spec(argv []string) (Spec, error) {
+c = c.WithDefaults()
+if err := c.Validate(); err != nil {
+return Spec{}, err
+}
+if len(c.WritablePaths) == 0 {
+wd, err := os.Getwd()
+if err != nil {
+return Spec{}, err
+}
+c.WritablePaths = []string{wd}
+}
+writable := make([]string, len(c.WritablePaths))
+for i, p := range c.WritablePaths {
+// Symlinks would point somewhere else inside the sandbox
+resolved, err := filepath.EvalSymlinks(p)
+if err != nil {
+return Spec{}, fmt.Errorf("writable path: %w", err)
+}
+writable[i] = resolved
+}
+c.WritablePaths = writable
+return Spec{Config: c, Argv: argv, Dir: c.WritablePaths[0]}, nil
+}
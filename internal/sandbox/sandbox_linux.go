@@ -0,0 +1,384 @@
+package sandbox
+
+import (
+"context"
+"encoding/json"
+"fmt"
+"os"
+"os/exec"
+"path/filepath"
+"runtime"
+"sort"
+"strconv"
+"strings"
+"syscall"
+
+"golang.org/x/sys/unix"
+)
+
+// initArg is argv[0] of the process that sets up a sandbox.
+const initArg = "hyperagent-sandbox"
+
+// devices are the host devices available in the sandbox.
+var devices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// Command returns a command that runs name with args in a sandbox, with
+// env as its environment or the daemon's when env is nil. The command is
+// started like any other, e.g. with pty.Start. It runs in the command's
+// Dir if that is inside a writable path, and in the first writable path
+// otherwise.
+func (c Config) Command(ctx context.Context, env []string, name string, args ...string) (*exec.Cmd, error) {
+spec, err := c.spec(append([]string{name}, args...))
+if err != nil {
+return nil, err
+}
+data, err := json.Marshal(spec)
+if err != nil {
+return nil, err
+}
+
+cmd := exec.CommandContext(ctx, "/proc/self/exe")
+cmd.Args = []string{initArg}
+if env == nil {
+env = os.Environ()
+}
+cmd.Env = append(env[:len(env):len(env)], specEnv+"="+string(data))
+
+flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC
+if !spec.Config.Network {
+flags |= syscall.CLONE_NEWNET
+}
+// Processes keep the daemon's user and group, without any privileges
+cmd.SysProcAttr = &syscall.SysProcAttr{
+Cloneflags:  uintptr(flags),
+UidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}},
+GidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}},
+}
+return cmd, nil
+}
+
+// Init sets up the sandbox and runs its program when the binary was
+// started by Command. Otherwise it returns immediately.
+func Init() {
+data, ok := os.LookupEnv(specEnv)
+if !ok || len(os.Args) == 0 || os.Args[0] != initArg {
+return
+}
+os.Unsetenv(specEnv)
+// Namespaces and privileges are set up for the thread that calls exec
+runtime.LockOSThread()
+
+var spec Spec
+err := json.Unmarshal([]byte(data), &spec)
+if wd, werr := os.Getwd(); werr == nil && within(wd, spec.Config.WritablePaths) {
+spec.Dir = wd
+}
+if err == nil {
+err = setup(spec)
+}
+if err == nil {
+err = run(spec)
+}
+fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+os.Exit(1)
+}
+
+// within reports whether dir is one of paths or below one of them.
+func within(dir string, paths []string) bool {
+for _, p := range paths {
+rel, err := filepath.Rel(p, dir)
+if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+return true
+}
+}
+return false
+}
+
+// mountTree is a detached copy of a host path, to be attached in the sandbox.
+type mountTree struct {
+path string
+fd   int
+dir  bool
+// link is the target when path is a symlink, which is recreated instead
+link string
+}
+
+// cloneTree copies the mounts at path. attrs are added to every mount.
+func cloneTree(path string, attrs uint64) (*mountTree, error) {
+fi, err := os.Lstat(path)
+if err != nil {
+return nil, err
+}
+t := &mountTree{path: path, fd: -1, dir: fi.IsDir()}
+if fi.Mode()&os.ModeSymlink != 0 {
+t.link, err = os.Readlink(path)
+return t, err
+}
+t.fd, err = unix.OpenTree(unix.AT_FDCWD, path, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC|unix.AT_RECURSIVE)
+if err != nil {
+return nil, fmt.Errorf("failed to clone %s: %w", path, err)
+}
+if attrs != 0 {
+err = unix.MountSetattr(t.fd, "", unix.AT_EMPTY_PATH|unix.AT_RECURSIVE, &unix.MountAttr{Attr_set: attrs})
+if err != nil {
+return nil, fmt.Errorf("failed to restrict %s: %w", path, err)
+}
+}
+return t, nil
+}
+
+// attach places t at the same path below root.
+func (t *mountTree) attach(root string) error {
+target := filepath.Join(root, t.path)
+if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+return err
+}
+if t.link != "" {
+return os.Symlink(t.link, target)
+}
+if t.dir {
+if err := os.MkdirAll(target, 0755); err != nil {
+return err
+}
+} else if err := touch(target); err != nil {
+return err
+}
+if err := unix.MoveMount(t.fd, "", unix.AT_FDCWD, target, unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+return fmt.Errorf("failed to mount %s: %w", t.path, err)
+}
+return nil
+}
+
+func touch(path string) error {
+f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0644)
+if err != nil && os.IsExist(err) {
+return nil
+}
+if err != nil {
+return err
+}
+return f.Close()
+}
+
+// setup builds the sandbox's file system, network and limits. It runs as
+// the first process of new user, mount and PID namespaces.
+func setup(spec Spec) error {
+c := spec.Config
+if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+return fmt.Errorf("failed to make mounts private: %w", err)
+}
+
+// Copy the host paths before the new root hides any of them
+var readOnly, writable, devs []*mountTree
+for _, p := range c.ReadOnlyPaths {
+t, err := cloneTree(filepath.Clean(p), unix.MOUNT_ATTR_RDONLY|unix.MOUNT_ATTR_NOSUID|unix.MOUNT_ATTR_NODEV)
+if os.IsNotExist(err) {
+continue
+}
+if err != nil {
+return err
+}
+readOnly = append(readOnly, t)
+}
+for _, p := range c.WritablePaths {
+t, err := cloneTree(p, unix.MOUNT_ATTR_NOSUID|unix.MOUNT_ATTR_NODEV)
+if err != nil {
+return err
+}
+writable = append(writable, t)
+}
+for _, d := range devices {
+t, err := cloneTree("/dev/"+d, 0)
+if os.IsNotExist(err) {
+continue
+}
+if err != nil {
+return err
+}
+devs = append(devs, t)
+}
+// Parents go first so that nested paths stay visible
+sort.Slice(readOnly, func(i, j int) bool { return readOnly[i].path < readOnly[j].path })
+sort.Slice(writable, func(i, j int) bool { return writable[i].path < writable[j].path })
+
+root := os.TempDir()
+if err := unix.Mount("tmpfs", root, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=0755"); err != nil {
+return fmt.Errorf("failed to create the root file system: %w", err)
+}
+for _, t := range readOnly {
+if err := t.attach(root); err != nil {
+return err
+}
+}
+if err := mountFS(filepath.Join(root, "tmp"), "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+return err
+}
+for _, t := range writable {
+if err := t.attach(root); err != nil {
+return err
+}
+}
+if err := setupDev(filepath.Join(root, "dev"), devs); err != nil {
+return err
+}
+if err := setupProc(filepath.Join(root, "proc")); err != nil {
+return err
+}
+if err := unix.Mount("", root, "", unix.MS_REMOUNT|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+return fmt.Errorf("failed to make the root file system read-only: %w", err)
+}
+
+if err := os.Chdir(root); err != nil {
+return err
+}
+// Stack the new root on the old one, then detach the old one
+if err := unix.PivotRoot(".", "."); err != nil {
+return fmt.Errorf("failed to switch to the new root: %w", err)
+}
+if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+return fmt.Errorf("failed to detach the host file system: %w", err)
+}
+
+if err := unix.Sethostname([]byte("sandbox")); err != nil {
+return err
+}
+if !c.Network {
+if err := loopbackUp(); err != nil {
+return err
+}
+}
+return setLimits(c.Limits)
+}
+
+func mountFS(target, fstype string, flags uintptr, data string) error {
+if err := os.MkdirAll(target, 0755); err != nil {
+return err
+}
+if err := unix.Mount(fstype, target, fstype, flags, data); err != nil {
+return fmt.Errorf("failed to mount %s: %w", target, err)
+}
+return nil
+}
+
+// setupDev creates a minimal /dev with the host devices in devs.
+func setupDev(dev string, devs []*mountTree) error {
+if err := mountFS(dev, "tmpfs", unix.MS_NOSUID|unix.MS_NOEXEC, "mode=0755"); err != nil {
+return err
+}
+for _, t := range devs {
+if err := t.attach(filepath.Dir(dev)); err != nil {
+return err
+}
+}
+links := map[string]string{
+"fd": "/proc/self/fd", "stdin": "/proc/self/fd/0", "stdout": "/proc/self/fd/1",
+"stderr": "/proc/self/fd/2", "ptmx": "pts/ptmx",
+}
+for name, target := range links {
+if err := os.Symlink(target, filepath.Join(dev, name)); err != nil {
+return err
+}
+}
+if err := mountFS(filepath.Join(dev, "shm"), "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+return err
+}
+// Programs that need a terminal of their own get a private devpts
+if err := mountFS(filepath.Join(dev, "pts"), "devpts", unix.MS_NOSUID|unix.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
+return err
+}
+return unix.Mount("", dev, "", unix.MS_REMOUNT|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NOEXEC, "")
+}
+
+// setupProc mounts a /proc that only shows the sandbox's processes. Hosts
+// that hide parts of their /proc, such as some containers, do not allow
+// new proc mounts; the sandbox then fails to start rather than see the
+// host's processes, whose root and cwd links lead out of it.
+func setupProc(proc string) error {
+if err := mountFS(proc, "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+return fmt.Errorf("%w, the host does not allow a private /proc", err)
+}
+return nil
+}
+
+// loopbackUp brings up the loopback interface of a new network namespace.
+func loopbackUp() error {
+fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+if err != nil {
+return err
+}
+defer unix.Close(fd)
+ifr, err := unix.NewIfreq("lo")
+if err != nil {
+return err
+}
+ifr.SetUint16(unix.IFF_UP | unix.IFF_LOOPBACK | unix.IFF_RUNNING)
+if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil {
+return fmt.Errorf("failed to bring up the loopback interface: %w", err)
+}
+return nil
+}
+
+// setLimits applies l to this process and everything it starts.
+func setLimits(l Limits) error {
+cpu := int64(-1)
+if l.CPUTime >= 0 {
+cpu = int64(l.CPUTime.Seconds())
+}
+limits := []struct {
+resource int
+value    int64
+}{
+{unix.RLIMIT_CPU, cpu},
+{unix.RLIMIT_AS, l.Memory},
+{unix.RLIMIT_NPROC, int64(l.Processes)},
+{unix.RLIMIT_FSIZE, l.FileSize},
+{unix.RLIMIT_NOFILE, int64(l.OpenFiles)},
+}
+for _, lim := range limits {
+if lim.value < 0 {
+continue
+}
+v := uint64(lim.value)
+if err := unix.Setrlimit(lim.resource, &unix.Rlimit{Cur: v, Max: v}); err != nil {
+return fmt.Errorf("failed to set resource limit %d: %w", lim.resource, err)
+}
+}
+return nil
+}
+
+// run drops all privileges and replaces the process with the program.
+func run(spec Spec) error {
+if err := os.Chdir(spec.Dir); err != nil {
+return err
+}
+if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+return fmt.Errorf("failed to set no_new_privs: %w", err)
+}
+if err := dropCapabilities(); err != nil {
+return err
+}
+path, err := exec.LookPath(spec.Argv[0])
+if err != nil {
+return err
+}
+return unix.Exec(path, spec.Argv, os.Environ())
+}
+
+// dropCapabilities empties the bounding set so that the program has no
+// capabilities even when it runs as root in the user namespace.
+func dropCapabilities() error {
+data, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+if err != nil {
+return err
+}
+last, err := strconv.Atoi(strings.TrimSpace(string(data)))
+if err != nil {
+return err
+}
+for c := 0; c <= last; c++ {
+if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+return fmt.Errorf("failed to drop capability %d: %w", c, err)
+}
+}
+return unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0)
+}
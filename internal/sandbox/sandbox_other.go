@@ -0,0 +1,16 @@
+//go:build !linux
+
+package sandbox
+
+import (
+"context"
+"os/exec"
+)
+
+// Command returns ErrUnsupported: sandboxes need Linux namespaces.
+func (c Config) Command(ctx context.Context, env []string, name string, args ...string) (*exec.Cmd, error) {
+return nil, ErrUnsupported
+}
+
+// Init does nothing outside of Linux.
+func Init() {}
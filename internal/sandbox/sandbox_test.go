@@ -0,0 +1,197 @@
+//go:build linux
+
+package sandbox
+
+import (
+"context"
+"os"
+"path/filepath"
+"strings"
+"testing"
+"time"
+
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+Init()
+os.Exit(m.Run())
+}
+
+// runIn runs a bash script in a sandbox and returns its combined output.
+func runIn(t *testing.T, c Config, script string) (string, error) {
+t.Helper()
+cmd, err := c.Command(context.Background(), nil, "bash", "--noprofile", "--norc", "-c", script)
+require.NoError(t, err)
+out, err := cmd.CombinedOutput()
+if strings.Contains(string(out), "sandbox: ") && strings.Contains(string(out), "operation not permitted") {
+t.Skipf("user namespaces are not available: %s", out)
+}
+return string(out), err
+}
+
+func TestSandbox(t *testing.T) {
+work := t.TempDir()
+c := Config{Enabled: true, WritablePaths: []string{work}}
+
+t.Run("writable workspace", func(t *testing.T) {
+out, err := runIn(t, c, "pwd && echo hello > out.txt")
+require.NoError(t, err, out)
+resolved, _ := filepath.EvalSymlinks(work)
+assert.Equal(t, resolved+"\n", out)
+data, err := os.ReadFile(filepath.Join(work, "out.txt"))
+require.NoError(t, err)
+assert.Equal(t, "hello\n", string(data))
+})
+
+t.Run("read-only root", func(t *testing.T) {
+out, err := runIn(t, c, "touch /usr/sandbox-test")
+assert.Error(t, err)
+assert.Contains(t, out, "Read-only file system")
+out, err = runIn(t, c, "touch /sandbox-test")
+assert.Error(t, err)
+assert.Contains(t, out, "Read-only file system")
+_, err = os.Stat("/usr/sandbox-test")
+assert.True(t, os.IsNotExist(err))
+})
+
+t.Run("scratch tmp", func(t *testing.T) {
+out, err := runIn(t, c, "echo x > /tmp/scratch && cat /tmp/scratch && ls /tmp")
+require.NoError(t, err, out)
+assert.Equal(t, "x\n"+filepath.Base(filepath.Dir(work))+"\nscratch\n", out)
+})
+
+t.Run("host paths are hidden", func(t *testing.T) {
+home, err := os.UserHomeDir()
+require.NoError(t, err)
+out, err := runIn(t, c, "ls "+home)
+assert.Error(t, err, out)
+})
+
+t.Run("own processes", func(t *testing.T) {
+out, err := runIn(t, c, "echo $$; cat /proc/1/comm; true")
+require.NoError(t, err, out)
+assert.Equal(t, "1\nbash\n", out)
+})
+
+t.Run("no network", func(t *testing.T) {
+out, err := runIn(t, c, "cat /proc/net/dev | tail -n +3 | cut -d: -f1 | tr -d ' '")
+require.NoError(t, err, out)
+assert.Equal(t, "lo\n", out)
+})
+
+t.Run("network when enabled", func(t *testing.T) {
+n := c
+n.Network = true
+out, err := runIn(t, n, "tail -n +3 /proc/net/dev | wc -l")
+require.NoError(t, err, out)
+assert.NotEqual(t, "1\n", out)
+})
+
+t.Run("devices", func(t *testing.T) {
+out, err := runIn(t, c, "echo gone > /dev/null && head -c 4 /dev/zero | wc -c")
+require.NoError(t, err, out)
+assert.Equal(t, "4\n", out)
+})
+
+t.Run("no privileges", func(t *testing.T) {
+out, err := runIn(t, c, "grep CapEff /proc/self/status; mount -t tmpfs none /tmp")
+assert.Error(t, err)
+assert.Contains(t, out, "CapEff:\t0000000000000000")
+})
+}
+
+func TestSandbox_Limits(t *testing.T) {
+work := t.TempDir()
+c := Config{Enabled: true, WritablePaths: []string{work}, Limits: Limits{
+CPUTime:   10 * 1e9,
+Memory:    1 << 30,
+Processes: 8,
+FileSize:  1 << 20,
+OpenFiles: 64,
+}}
+
+t.Run("reported by ulimit", func(t *testing.T) {
+out, err := runIn(t, c, "ulimit -t -v -u -f -n")
+require.NoError(t, err, out)
+assert.Contains(t, out, "(seconds, -t) 10")
+assert.Contains(t, out, "(kbytes, -v) 1048576")
+assert.Contains(t, out, "(-u) 8")
+assert.Contains(t, out, "(blocks, -f) 1024")
+assert.Contains(t, out, "(-n) 64")
+})
+
+t.Run("processes", func(t *testing.T) {
+if os.Getuid() == 0 {
+t.Skip("the kernel does not enforce RLIMIT_NPROC for root")
+}
+out, _ := runIn(t, c, "for i in $(seq 20); do sleep 1 & done; wait")
+assert.Contains(t, out, "Resource temporarily unavailable")
+})
+
+t.Run("file size", func(t *testing.T) {
+out, err := runIn(t, c, "head -c 2000000 /dev/zero > big")
+assert.Error(t, err, out)
+fi, err := os.Stat(filepath.Join(work, "big"))
+require.NoError(t, err)
+assert.Equal(t, int64(1<<20), fi.Size())
+})
+
+t.Run("unlimited", func(t *testing.T) {
+u := c
+u.Limits = Limits{CPUTime: -1, Memory: -1, Processes: -1, FileSize: -1, OpenFiles: -1}
+out, err := runIn(t, u, "ulimit -v -f")
+require.NoError(t, err, out)
+assert.Equal(t, 2, strings.Count(out, "unlimited"), out)
+})
+}
+
+func TestConfig(t *testing.T) {
+c := Config{}.WithDefaults()
+assert.Equal(t, DefaultReadOnlyPaths, c.ReadOnlyPaths)
+assert.Equal(t, DefaultLimits(), c.Limits)
+// Sandboxed shells do not run forever by default
+assert.Equal(t, 8*time.Hour, c.Limits.WallTime)
+assert.NoError(t, c.Validate())
+assert.Error(t, Config{WritablePaths: []string{"work"}}.Validate())
+assert.Error(t, Config{ReadOnlyPaths: []string{"usr"}}.Validate())
+}
+
+func TestSandbox_Env(t *testing.T) {
+c := Config{Enabled: true, WritablePaths: []string{t.TempDir()}}
+cmd, err := c.Command(context.Background(), []string{"PATH=/usr/bin:/bin", "ONLY=this"}, "env")
+require.NoError(t, err)
+out, err := cmd.CombinedOutput()
+if err != nil && strings.Contains(string(out), "operation not permitted") {
+t.Skipf("user namespaces are not available: %s", out)
+}
+require.NoError(t, err, string(out))
+assert.Equal(t, "PATH=/usr/bin:/bin\nONLY=this\n", string(out))
+}
+
+func TestSandbox_Dir(t *testing.T) {
+work := t.TempDir()
+require.NoError(t, os.Mkdir(filepath.Join(work, "sub"), 0755))
+resolved, err := filepath.EvalSymlinks(work)
+require.NoError(t, err)
+c := Config{Enabled: true, WritablePaths: []string{work}}
+
+cmd, err := c.Command(context.Background(), nil, "pwd")
+require.NoError(t, err)
+cmd.Dir = filepath.Join(resolved, "sub")
+out, err := cmd.CombinedOutput()
+if err != nil && strings.Contains(string(out), "operation not permitted") {
+t.Skipf("user namespaces are not available: %s", out)
+}
+require.NoError(t, err, string(out))
+assert.Equal(t, filepath.Join(resolved, "sub")+"\n", string(out))
+
+// Directories outside the writable paths fall back to the first one
+cmd, err = c.Command(context.Background(), nil, "pwd")
+require.NoError(t, err)
+cmd.Dir = "/usr"
+out, err = cmd.CombinedOutput()
+require.NoError(t, err, string(out))
+assert.Equal(t, resolved+"\n", string(out))
+}
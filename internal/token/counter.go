@@ -0,0 +1,121 @@
+package token
+
+import (
+"context"
+"crypto/sha256"
+"fmt"
+"log/slog"
+"sync"
+
+"github.com/pkoukk/tiktoken-go"
+)
+
+// TokenCounter counts the tokens of a piece of text for a specific model.
+type TokenCounter interface {
+CountTokens(ctx context.Context, text string) (int, error)
+}
+
+// EstimateCounter approximates four characters per token. It never fails and
+// is used when nothing better is available.
+type EstimateCounter struct{}
+
+func (EstimateCounter) CountTokens(ctx context.Context, text string) (int, error) {
+// Roughly four characters per token for English text and code
+return (len(text) + 3) / 4, nil
+}
+
+// TiktokenCounter counts with a local BPE encoding. It matches OpenAI models
+// exactly and approximates others.
+type TiktokenCounter struct {
+encoding *tiktoken.Tiktoken
+}
+
+// NewTiktokenCounter picks the encoding of model, or cl100k_base for models
+// tiktoken does not know, such as Gemini.
+func NewTiktokenCounter(model string) (*TiktokenCounter, error) {
+enc, err := tiktoken.EncodingForModel(model)
+if err != nil {
+enc, err = tiktoken.GetEncoding("cl100k_base")
+}
+if err != nil {
+return nil, fmt.Errorf("failed to get encoding: %w", err)
+}
+return &TiktokenCounter{encoding: enc}, nil
+}
+
+func (c *TiktokenCounter) CountTokens(ctx context.Context, text string) (int, error) {
+return len(c.encoding.Encode(text, nil, nil)), nil
+}
+
+type fallbackCounter struct {
+primary  TokenCounter
+fallback TokenCounter
+}
+
+// WithFallback uses fallback whenever primary fails, e.g. when a counting API
+// is unreachable.
+func WithFallback(primary, fallback TokenCounter) TokenCounter {
+return &fallbackCounter{primary: primary, fallback: fallback}
+}
+
+func (c *fallbackCounter) CountTokens(ctx context.Context, text string) (int, error) {
+n, err := c.primary.CountTokens(ctx, text)
+if err == nil {
+return n, nil
+}
+slog.Debug("Token counter failed, using fallback", "error", err)
+return c.fallback.CountTokens(ctx, text)
+}
+
+// CachingCounter remembers counts by content hash so that history messages
+// are not re-counted on every turn.
+type CachingCounter struct {
+next       TokenCounter
+maxEntries int
+
+mu      sync.Mutex
+entries map[[sha256.Size]byte]int
+}
+
+// NewCachingCounter caches up to maxEntries counts of next.
+func NewCachingCounter(next TokenCounter, maxEntries int) *CachingCounter {
+return &CachingCounter{
+next:       next,
+maxEntries: maxEntries,
+entries:    make(map[[sha256.Size]byte]int),
+}
+}
+
+func (c *CachingCounter) CountTokens(ctx context.Context, text string) (int, error) {
+key := sha256.Sum256([]byte(text))
+c.mu.Lock()
+n, ok := c.entries[key]
+c.mu.Unlock()
+if ok {
+return n, nil
+}
+
+n, err := c.next.CountTokens(ctx, text)
+if err != nil {
+return 0, err
+}
+
+c.mu.Lock()
+defer c.mu.Unlock()
+if len(c.entries) >= c.maxEntries {
+// Evict an arbitrary entry to stay bounded
+for k := range c.entries {
+delete(c.entries, k)
+break
+}
+}
+c.entries[key] = n
+return n, nil
+}
+
+// Len returns the number of cached counts.
+func (c *CachingCounter) Len() int {
+c.mu.Lock()
+defer c.mu.Unlock()
+return len(c.entries)
+}
@@ -0,0 +1,86 @@
+package token
+
+import (
+"context"
+"errors"
+"testing"
+
+"github.com/stretchr/testify/assert"
+)
+
+// stubCounter counts one token per byte and records how often it was asked.
+type stubCounter struct {
+calls int
+err   error
+}
+
+func (s *stubCounter) CountTokens(ctx context.Context, text string) (int, error) {
+s.calls++
+if s.err != nil {
+return 0, s.err
+}
+return len(text), nil
+}
+
+func TestCachingCounter(t *testing.T) {
+ctx := context.Background()
+stub := &stubCounter{}
+c := NewCachingCounter(stub, 2)
+
+n, err := c.CountTokens(ctx, "hello")
+assert.NoError(t, err)
+assert.Equal(t, 5, n)
+n, _ = c.CountTokens(ctx, "hello")
+assert.Equal(t, 5, n)
+assert.Equal(t, 1, stub.calls)
+
+c.CountTokens(ctx, "a")
+c.CountTokens(ctx, "bb")
+assert.Equal(t, 2, c.Len())
+assert.Equal(t, 3, stub.calls)
+
+stub.err = errors.New("offline")
+_, err = c.CountTokens(ctx, "new")
+assert.Error(t, err)
+assert.Equal(t, 2, c.Len())
+}
+
+func TestWithFallback(t *testing.T) {
+ctx := context.Background()
+primary := &stubCounter{err: errors.New("unavailable")}
+n, err := WithFallback(primary, EstimateCounter{}).CountTokens(ctx, "12345678")
+assert.NoError(t, err)
+assert.Equal(t, 2, n)
+assert.Equal(t, 1, primary.calls)
+}
+
+func TestNewTokenManagerWithCounter(t *testing.T) {
+stub := &stubCounter{}
+tm := NewTokenManagerWithCounter(stub)
+assert.Equal(t, 0, tm.CountTokens(""))
+assert.Equal(t, 5, tm.CountTokens("hello"))
+assert.Equal(t, 5, tm.CountTokens("hello"))
+assert.Equal(t, 1, stub.calls)
+
+// Failures fall back to the estimate and are retried next time
+stub.err = errors.New("offline")
+assert.Equal(t, 3, tm.CountTokens("hello world"))
+stub.err = nil
+assert.Equal(t, 11, tm.CountTokens("hello world"))
+}
+
+func TestNewTokenManagerWithFallback(t *testing.T) {
+stub := &stubCounter{err: errors.New("offline")}
+tm := NewTokenManagerWithFallback(stub, EstimateCounter{})
+assert.Equal(t, 3, tm.CountTokens("hello world"))
+
+// The fallback's count was not cached
+stub.err = nil
+assert.Equal(t, 11, tm.CountTokens("hello world"))
+assert.Equal(t, 11, tm.CountTokens("hello world"))
+assert.Equal(t, 2, stub.calls)
+
+// Estimates never ask c
+assert.Equal(t, 3, tm.EstimateTokens("hello world"))
+assert.Equal(t, 2, stub.calls)
+}
@@ -1,30 +1,161 @@
 package token
 
 import (
-"fmt"
-"github.com/pkoukk/tiktoken-go"
+"context"
+"encoding/json"
+"log/slog"
+"strings"
+"time"
+
+"github.com/LeeroyDing/hyperagent/internal/llm"
 )
 
+// messageOverhead approximates the per-message framing tokens added by providers.
+const messageOverhead = 4
+
+// Limits describes the context window of a model.
+type Limits struct {
+// ContextWindow is the total number of tokens the model accepts.
+ContextWindow int `yaml:"context_window"`
+// ReserveOutput is kept free for the model's reply.
+ReserveOutput int `yaml:"reserve_output"`
+}
+
+// DefaultLimits returns conservative limits for a model name.
+func DefaultLimits(model string) Limits {
+if strings.HasPrefix(model, "gemini") {
+return Limits{ContextWindow: 1048576, ReserveOutput: 8192}
+}
+return Limits{ContextWindow: 128000, ReserveOutput: 4096}
+}
+
+// InputBudget is the number of tokens available for the request.
+func (l Limits) InputBudget() int {
+return l.ContextWindow - l.ReserveOutput
+}
+
+// cacheSize bounds the number of counts remembered by a TokenManager.
+const cacheSize = 10000
+
+// countTimeout bounds a single call to a remote TokenCounter.
+const countTimeout = 10 * time.Second
+
 // TokenManager handles token counting and pruning.
+// The zero value counts with a character-based estimate.
 type TokenManager struct {
-encoding *tiktoken.Tiktoken
+counter TokenCounter
+// local counts without calling out, for EstimateTokens
+local TokenCounter
 }
 
-// NewTokenManager creates a new TokenManager.
+// NewTokenManager creates a TokenManager backed by the tiktoken encoding of model.
 func NewTokenManager(model string) (*TokenManager, error) {
-// Gemini models don't have a direct tiktoken encoding, but cl100k_base is a good approximation for many LLMs.
-// For more accuracy, one would use the Gemini API's countTokens method.
-enc, err := tiktoken.GetEncoding("cl100k_base")
+c, err := NewTiktokenCounter(model)
 if err != nil {
-return nil, fmt.Errorf("failed to get encoding: %w", err)
+return nil, err
 }
-return &TokenManager{encoding: enc}, nil
+tm := NewTokenManagerWithCounter(c)
+tm.local = c
+return tm, nil
+}
+
+// NewTokenManagerWithCounter creates a TokenManager that counts with c and
+// caches the results. When c fails the count is estimated and not cached.
+func NewTokenManagerWithCounter(c TokenCounter) *TokenManager {
+return NewTokenManagerWithFallback(c, EstimateCounter{})
+}
+
+// NewTokenManagerWithFallback is like NewTokenManagerWithCounter but counts
+// with fallback when c fails. Only the counts of c are cached, so that c is
+// asked again once it recovers. EstimateTokens counts with fallback alone.
+func NewTokenManagerWithFallback(c, fallback TokenCounter) *TokenManager {
+return &TokenManager{counter: WithFallback(NewCachingCounter(c, cacheSize), fallback), local: fallback}
+}
+
+// NewTokenManagerOrEstimate is like NewTokenManager but falls back to the
+// character-based estimate when the encoding cannot be loaded, e.g. offline.
+func NewTokenManagerOrEstimate(model string) *TokenManager {
+tm, err := NewTokenManager(model)
+if err != nil {
+slog.Warn("Token encoding unavailable, estimating token counts", "error", err)
+return NewTokenManagerWithCounter(EstimateCounter{})
+}
+return tm
 }
 
 // CountTokens returns the number of tokens in a string.
 func (tm *TokenManager) CountTokens(text string) int {
-tokens := tm.encoding.Encode(text, nil, nil)
-return len(tokens)
+if text == "" {
+return 0
+}
+if tm.counter == nil {
+n, _ := EstimateCounter{}.CountTokens(context.Background(), text)
+return n
+}
+ctx, cancel := context.WithTimeout(context.Background(), countTimeout)
+defer cancel()
+n, err := tm.counter.CountTokens(ctx, text)
+if err != nil {
+n, _ = EstimateCounter{}.CountTokens(ctx, text)
+}
+return n
+}
+
+// EstimateTokens counts the tokens in a string without asking a remote
+// counter, for callers that count too often to wait for one.
+func (tm *TokenManager) EstimateTokens(text string) int {
+local := tm.local
+if local == nil {
+local = EstimateCounter{}
+}
+n, err := local.CountTokens(context.Background(), text)
+if err != nil {
+n, _ = EstimateCounter{}.CountTokens(context.Background(), text)
+}
+return n
+}
+
+// CountMessage returns the tokens used by a message, including function
+// calls and results. Its parts are counted in one request, and the count is
+// cached like any other, so that history is not counted again every turn.
+func (tm *TokenManager) CountMessage(m llm.Message) int {
+var texts []string
+for _, p := range m.Parts {
+texts = append(texts, p.Text)
+if p.FunctionCall != nil {
+args, _ := json.Marshal(p.FunctionCall.Arguments)
+texts = append(texts, p.FunctionCall.Name, string(args))
+}
+if p.FunctionResult != nil {
+texts = append(texts, p.FunctionResult.Name, p.FunctionResult.Content)
+}
+}
+return messageOverhead + tm.countAll(texts)
+}
+
+// CountTools returns the tokens used by the tool declarations of a
+// request, counted in one request.
+func (tm *TokenManager) CountTools(tools []llm.Tool) int {
+var texts []string
+for _, t := range tools {
+texts = append(texts, t.Name, t.Description)
+if t.Parameters != nil {
+schema, _ := json.Marshal(t.Parameters.JSON())
+texts = append(texts, string(schema))
+}
+}
+return tm.countAll(texts)
+}
+
+// countAll counts texts together, one per line.
+func (tm *TokenManager) countAll(texts []string) int {
+var nonEmpty []string
+for _, t := range texts {
+if t != "" {
+nonEmpty = append(nonEmpty, t)
+}
+}
+return tm.CountTokens(strings.Join(nonEmpty, "\n"))
 }
 
 // PruneHistory prunes the history messages to fit within the token limit.
@@ -1,7 +1,10 @@
 package token
 
 import (
+"encoding/json"
 "testing"
+
+"github.com/LeeroyDing/hyperagent/internal/llm"
 "github.com/stretchr/testify/assert"
 )
 
@@ -21,3 +24,45 @@ assert.Equal(t, 1, len(pruned))
 assert.Equal(t, "msg3", pruned[0])
 })
 }
+
+func TestTokenManager_Estimate(t *testing.T) {
+tm := &TokenManager{}
+assert.Equal(t, 0, tm.CountTokens(""))
+assert.Equal(t, 3, tm.CountTokens("hello world"))
+
+msg := llm.Message{Role: llm.RoleModel, Parts: []llm.Part{
+{Text: "abcd"},
+{FunctionCall: &llm.FunctionCall{Name: "ls", Arguments: map[string]interface{}{"a": 1}}},
+}}
+// overhead + "abcd\nls\n{\"a\":1}"
+assert.Equal(t, 4+4, tm.CountMessage(msg))
+
+tools := []llm.Tool{{Name: "tool", Description: "desc", Parameters: &llm.Schema{Type: llm.TypeObject}}}
+schema, _ := json.Marshal(tools[0].Parameters.JSON())
+assert.Equal(t, tm.CountTokens("tool\ndesc\n"+string(schema)), tm.CountTools(tools))
+}
+
+func TestTokenManager_CountRequests(t *testing.T) {
+stub := &stubCounter{}
+tm := NewTokenManagerWithCounter(stub)
+
+msg := llm.Message{Role: llm.RoleModel, Parts: []llm.Part{
+{Text: "look"},
+{FunctionCall: &llm.FunctionCall{Name: "ls", Arguments: map[string]interface{}{"a": 1}}},
+{FunctionResult: &llm.FunctionResult{Name: "ls", Content: "x"}},
+}}
+// A message is counted in one request, and only once
+assert.Equal(t, 4+len("look\nls\n{\"a\":1}\nls\nx"), tm.CountMessage(msg))
+assert.Equal(t, 4+len("look\nls\n{\"a\":1}\nls\nx"), tm.CountMessage(msg))
+assert.Equal(t, 1, stub.calls)
+
+tools := []llm.Tool{{Name: "a", Description: "first"}, {Name: "b", Description: "second"}}
+assert.Equal(t, len("a\nfirst\nb\nsecond"), tm.CountTools(tools))
+assert.Equal(t, 2, stub.calls)
+}
+
+func TestLimits(t *testing.T) {
+assert.Equal(t, 1048576, DefaultLimits("gemini-3-flash-preview").ContextWindow)
+assert.Equal(t, 128000, DefaultLimits("llama3").ContextWindow)
+assert.Equal(t, 900, Limits{ContextWindow: 1000, ReserveOutput: 100}.InputBudget())
+}
@@ -3,16 +3,22 @@ package web
 import (
 "context"
 "embed"
+"errors"
+"fmt"
 "io/fs"
 "net/http"
 "os"
+"path/filepath"
 "syscall"
 	"time"
 
 "github.com/LeeroyDing/hyperagent/internal/agent"
 "github.com/LeeroyDing/hyperagent/internal/daemon"
+"github.com/LeeroyDing/hyperagent/internal/editor"
+"github.com/LeeroyDing/hyperagent/internal/executor"
 "github.com/LeeroyDing/hyperagent/internal/history"
 "github.com/LeeroyDing/hyperagent/internal/memory"
+"github.com/LeeroyDing/hyperagent/internal/sandbox"
 "github.com/gin-gonic/gin"
 )
 
@@ -24,8 +30,20 @@ Agent   *agent.Agent
 History history.History
 Memory  memory.Memory
 Daemon  *daemon.Daemon
+// Shells reports the shells of sessions. When nil, the shell
+// endpoints are unavailable.
+Shells *executor.SessionManager
+// Edits is the change journal of file edits. When nil, the edit
+// endpoints are unavailable.
+Edits *editor.Journal
+// Sandboxes are the named sandboxes a new session may choose.
+Sandboxes map[string]sandbox.Config
 router  *gin.Engine
 srv     *http.Server
+// runs is cancelled on Shutdown to stop the streamed runs, which it would
+// otherwise wait for
+runs     context.Context
+stopRuns context.CancelFunc
 }
 
 func NewServer(a *agent.Agent, h history.History, m memory.Memory, d *daemon.Daemon) *Server {
@@ -39,6 +57,7 @@ Memory:  m,
 Daemon:  d,
 router:  r,
 }
+s.runs, s.stopRuns = context.WithCancel(context.Background())
 
 s.setupRoutes()
 return s
@@ -54,8 +73,16 @@ api.POST("/daemon/stop", s.stopDaemon)
 // Agent sessions
 api.GET("/sessions", s.getSessions)
 api.POST("/sessions", s.createSession)
+api.PUT("/sessions/:id/profile", s.setSessionProfile)
+api.GET("/sessions/:id/shell", s.getSessionShell)
+api.DELETE("/sessions/:id/shell", s.killSessionShell)
+api.GET("/shells", s.getShells)
+api.GET("/sessions/:id/edits", s.getSessionEdits)
+api.POST("/sessions/:id/edits/:edit/revert", s.revertSessionEdit)
+api.GET("/profiles", s.getProfiles)
 api.GET("/sessions/:id/messages", s.getMessages)
 api.POST("/sessions/:id/messages", s.sendMessage)
+api.POST("/sessions/:id/stream", s.streamMessage)
 api.GET("/memory", s.searchMemory)
 api.DELETE("/memory/:id", s.deleteMemory)
 }
@@ -79,6 +106,7 @@ return s.srv.ListenAndServe()
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
+s.stopRuns()
 if s.srv == nil {
 return nil
 }
@@ -113,21 +141,167 @@ c.JSON(http.StatusOK, sessions)
 
 func (s *Server) createSession(c *gin.Context) {
 var req struct {
-Name string `json:"name"`
+Name    string `json:"name"`
+Profile string `json:"profile"`
+Sandbox string `json:"sandbox"`
+Workdir string `json:"workdir"`
 }
 if err := c.ShouldBindJSON(&req); err != nil {
 c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 return
 }
+if req.Profile != "" && !s.Agent.Prompts.Has(req.Profile) {
+c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown profile %q", req.Profile)})
+return
+}
+if _, ok := s.Sandboxes[req.Sandbox]; req.Sandbox != "" && !ok {
+c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown sandbox %q", req.Sandbox)})
+return
+}
+if req.Workdir != "" {
+if err := checkWorkdir(req.Workdir); err != nil {
+c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+return
+}
+}
 
 id, err := s.History.CreateSession(req.Name)
 if err != nil {
 c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 return
 }
+if req.Profile != "" {
+if err := s.History.SetSessionProfile(id, req.Profile); err != nil {
+c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+return
+}
+}
+if req.Sandbox != "" {
+if err := s.History.SetSessionSandbox(id, req.Sandbox); err != nil {
+c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+return
+}
+}
+if req.Workdir != "" {
+if err := s.History.SetSessionWorkdir(id, req.Workdir); err != nil {
+c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+return
+}
+}
 c.JSON(http.StatusCreated, gin.H{"id": id})
 }
 
+// checkWorkdir reports why dir cannot be the working directory of a shell.
+func checkWorkdir(dir string) error {
+if !filepath.IsAbs(dir) {
+return fmt.Errorf("workdir %q is not absolute", dir)
+}
+fi, err := os.Stat(dir)
+if err != nil {
+return fmt.Errorf("workdir: %w", err)
+}
+if !fi.IsDir() {
+return fmt.Errorf("workdir %q is not a directory", dir)
+}
+return nil
+}
+
+// getSessionShell reports where the session's shell operates: its current
+// directory and environment, or those it will start with.
+func (s *Server) getSessionShell(c *gin.Context) {
+if s.Shells == nil {
+c.JSON(http.StatusNotFound, gin.H{"error": "shell sessions are not available"})
+return
+}
+c.JSON(http.StatusOK, s.Shells.Info(c.Param("id")))
+}
+
+// killSessionShell closes the session's shell, ending any running command.
+// The session's next command starts a new shell.
+func (s *Server) killSessionShell(c *gin.Context) {
+if s.Shells == nil {
+c.JSON(http.StatusNotFound, gin.H{"error": "shell sessions are not available"})
+return
+}
+if !s.Shells.Kill(c.Param("id")) {
+c.JSON(http.StatusNotFound, gin.H{"error": "no shell is running for this session"})
+return
+}
+c.JSON(http.StatusOK, gin.H{"status": "killed"})
+}
+
+// getShells lists the running shells.
+func (s *Server) getShells(c *gin.Context) {
+if s.Shells == nil {
+c.JSON(http.StatusNotFound, gin.H{"error": "shell sessions are not available"})
+return
+}
+c.JSON(http.StatusOK, s.Shells.List())
+}
+
+// getSessionEdits lists the file edits of the session, oldest first.
+func (s *Server) getSessionEdits(c *gin.Context) {
+if s.Edits == nil {
+c.JSON(http.StatusNotFound, gin.H{"error": "edits are not recorded"})
+return
+}
+edits, err := s.Edits.List(c.Param("id"))
+if err != nil {
+c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+return
+}
+if edits == nil {
+edits = []editor.Edit{}
+}
+c.JSON(http.StatusOK, edits)
+}
+
+// revertSessionEdit undoes a file edit of the session.
+func (s *Server) revertSessionEdit(c *gin.Context) {
+if s.Edits == nil {
+c.JSON(http.StatusNotFound, gin.H{"error": "edits are not recorded"})
+return
+}
+e, err := s.Edits.Revert(c.Param("id"), c.Param("edit"))
+switch {
+case errors.Is(err, editor.ErrEditNotFound):
+c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+case errors.Is(err, editor.ErrEditConflict):
+c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+case err != nil:
+c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+default:
+c.JSON(http.StatusOK, e)
+}
+}
+
+func (s *Server) setSessionProfile(c *gin.Context) {
+id := c.Param("id")
+var req struct {
+Profile string `json:"profile" binding:"required"`
+}
+if err := c.ShouldBindJSON(&req); err != nil {
+c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+return
+}
+if !s.Agent.Prompts.Has(req.Profile) {
+c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown profile %q", req.Profile)})
+return
+}
+if err := s.History.SetSessionProfile(id, req.Profile); err != nil {
+c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+return
+}
+c.JSON(http.StatusOK, gin.H{"profile": req.Profile})
+}
+
+func (s *Server) getProfiles(c *gin.Context) {
+c.JSON(http.StatusOK, gin.H{
+"default":  s.Agent.Prompts.Default(),
+"profiles": s.Agent.Prompts.Profiles(),
+})
+}
+
 func (s *Server) getMessages(c *gin.Context) {
 id := c.Param("id")
 messages, err := s.History.LoadHistory(id)
@@ -148,7 +322,7 @@ c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 return
 }
 
-response, err := s.Agent.Run(context.Background(), id, req.Content)
+response, err := s.Agent.Run(c.Request.Context(), id, req.Content)
 if err != nil {
 c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 return
@@ -157,6 +331,39 @@ return
 c.JSON(http.StatusOK, gin.H{"response": response})
 }
 
+// streamMessage runs the agent like sendMessage but reports its progress as
+// Server-Sent Events. Each agent.Event is sent with its type as the event
+// name; a failed run ends with an "error" event.
+func (s *Server) streamMessage(c *gin.Context) {
+id := c.Param("id")
+var req struct {
+Content string `json:"content"`
+}
+if err := c.ShouldBindJSON(&req); err != nil {
+c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+return
+}
+
+c.Header("Content-Type", "text/event-stream")
+c.Header("Cache-Control", "no-cache")
+c.Header("Connection", "keep-alive")
+c.Status(http.StatusOK)
+
+// The run stops when the client goes away, interrupting the model call or
+// tool in flight, and when the server shuts down.
+ctx, cancel := context.WithCancel(c.Request.Context())
+defer cancel()
+defer context.AfterFunc(s.runs, cancel)()
+_, err := s.Agent.RunStream(ctx, id, req.Content, func(ev agent.Event) {
+c.SSEvent(string(ev.Type), ev)
+c.Writer.Flush()
+})
+if err != nil {
+c.SSEvent("error", gin.H{"error": err.Error()})
+c.Writer.Flush()
+}
+}
+
 func (s *Server) searchMemory(c *gin.Context) {
 query := c.Query("q")
 results, err := s.Memory.Search(context.Background(), query, 10)
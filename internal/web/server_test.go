@@ -7,12 +7,16 @@ import (
 "errors"
 "net/http"
 "net/http/httptest"
+"path/filepath"
 "testing"
+"time"
 
 "github.com/LeeroyDing/hyperagent/internal/agent"
+"github.com/LeeroyDing/hyperagent/internal/editor"
+"github.com/LeeroyDing/hyperagent/internal/executor"
 "github.com/LeeroyDing/hyperagent/internal/history"
-"github.com/LeeroyDing/hyperagent/internal/gemini"
-"github.com/google/generative-ai-go/genai"
+"github.com/LeeroyDing/hyperagent/internal/llm"
+"github.com/LeeroyDing/hyperagent/internal/sandbox"
 "github.com/philippgille/chromem-go"
 "github.com/stretchr/testify/assert"
 "github.com/stretchr/testify/mock"
@@ -33,6 +37,11 @@ args := m.Called(sessionID, role, content)
 return args.Error(0)
 }
 
+func (m *MockHistory) AppendMessage(sessionID string, msg history.Message) error {
+args := m.Called(sessionID, msg)
+return args.Error(0)
+}
+
 func (m *MockHistory) LoadHistory(sessionID string) ([]history.Message, error) {
 args := m.Called(sessionID)
 return args.Get(0).([]history.Message), args.Error(1)
@@ -53,6 +62,36 @@ args := m.Called(sessionID)
 return args.String(0)
 }
 
+func (m *MockHistory) SetSessionProfile(sessionID, profile string) error {
+args := m.Called(sessionID, profile)
+return args.Error(0)
+}
+
+func (m *MockHistory) GetSessionProfile(sessionID string) string {
+args := m.Called(sessionID)
+return args.String(0)
+}
+
+func (m *MockHistory) SetSessionSandbox(sessionID, sandbox string) error {
+args := m.Called(sessionID, sandbox)
+return args.Error(0)
+}
+
+func (m *MockHistory) GetSessionSandbox(sessionID string) string {
+args := m.Called(sessionID)
+return args.String(0)
+}
+
+func (m *MockHistory) SetSessionWorkdir(sessionID, dir string) error {
+args := m.Called(sessionID, dir)
+return args.Error(0)
+}
+
+func (m *MockHistory) GetSessionWorkdir(sessionID string) string {
+args := m.Called(sessionID)
+return args.String(0)
+}
+
 type MockMemory struct {
 mock.Mock
 }
@@ -82,37 +121,47 @@ args := m.Called(ctx)
 return args.Get(0).([]chromem.Document), args.Error(1)
 }
 
-type MockGemini struct {
+type MockLLM struct {
 mock.Mock
 }
 
-func (m *MockGemini) GenerateContent(ctx context.Context, messages []gemini.Message, tools []*genai.Tool) (string, []gemini.ToolCall, error) {
+func (m *MockLLM) GenerateContent(ctx context.Context, messages []llm.Message, tools []llm.Tool) (*llm.Response, error) {
 args := m.Called(ctx, messages, tools)
-return args.String(0), args.Get(1).([]gemini.ToolCall), args.Error(2)
-}
-
-func (m *MockGemini) SendToolResponse(ctx context.Context, messages []gemini.Message, tools []*genai.Tool, toolResponses []gemini.ToolResponse) (string, []gemini.ToolCall, error) {
-args := m.Called(ctx, messages, tools, toolResponses)
-return args.String(0), args.Get(1).([]gemini.ToolCall), args.Error(2)
+resp, _ := args.Get(0).(*llm.Response)
+return resp, args.Error(1)
 }
 
-func (m *MockGemini) EmbedContent(ctx context.Context, text string) ([]float32, error) {
+func (m *MockLLM) EmbedContent(ctx context.Context, text string) ([]float32, error) {
 args := m.Called(ctx, text)
 return args.Get(0).([]float32), args.Error(1)
 }
 
-func (m *MockGemini) Close() error {
+func (m *MockLLM) Close() error {
 args := m.Called()
 return args.Error(0)
 }
 
+// closeShell is a shell that records being closed.
+type closeShell struct {
+closed bool
+}
+
+func (c *closeShell) Execute(ctx context.Context, command string) (executor.Result, error) {
+return executor.Result{}, nil
+}
+
+func (c *closeShell) Close() error {
+c.closed = true
+return nil
+}
+
 func TestWebAPI(t *testing.T) {
 mockHist := new(MockHistory)
 mockMem := new(MockMemory)
-mockGemini := new(MockGemini)
+mockLLM := new(MockLLM)
 
-a := agent.NewAgent(mockGemini, nil, mockMem, nil, mockHist, false)
-s := NewServer(a, mockHist, mockMem)
+a := agent.NewAgent(mockLLM, nil, mockMem, nil, mockHist, false)
+s := NewServer(a, mockHist, mockMem, nil)
 
 t.Run("RedirectRoot", func(t *testing.T) {
 w := httptest.NewRecorder()
@@ -147,6 +196,184 @@ s.router.ServeHTTP(w, req)
 assert.Equal(t, http.StatusCreated, w.Code)
 })
 
+t.Run("CreateSession_WithProfile", func(t *testing.T) {
+mockHist.On("CreateSession", "Ops").Return("uuid-456", nil).Once()
+mockHist.On("SetSessionProfile", "uuid-456", "default").Return(nil).Once()
+body, _ := json.Marshal(map[string]string{"name": "Ops", "profile": "default"})
+w := httptest.NewRecorder()
+req, _ := http.NewRequest("POST", "/api/sessions", bytes.NewBuffer(body))
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusCreated, w.Code)
+})
+
+t.Run("CreateSession_UnknownProfile", func(t *testing.T) {
+body, _ := json.Marshal(map[string]string{"name": "Ops", "profile": "pirate"})
+w := httptest.NewRecorder()
+req, _ := http.NewRequest("POST", "/api/sessions", bytes.NewBuffer(body))
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusBadRequest, w.Code)
+assert.Contains(t, w.Body.String(), "unknown profile")
+})
+
+t.Run("CreateSession_WithSandbox", func(t *testing.T) {
+s.Sandboxes = map[string]sandbox.Config{"offline": {Enabled: true}}
+defer func() { s.Sandboxes = nil }()
+mockHist.On("CreateSession", "Boxed").Return("uuid-789", nil).Once()
+mockHist.On("SetSessionSandbox", "uuid-789", "offline").Return(nil).Once()
+body, _ := json.Marshal(map[string]string{"name": "Boxed", "sandbox": "offline"})
+w := httptest.NewRecorder()
+req, _ := http.NewRequest("POST", "/api/sessions", bytes.NewBuffer(body))
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusCreated, w.Code)
+
+body, _ = json.Marshal(map[string]string{"name": "Boxed", "sandbox": "online"})
+w = httptest.NewRecorder()
+req, _ = http.NewRequest("POST", "/api/sessions", bytes.NewBuffer(body))
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusBadRequest, w.Code)
+assert.Contains(t, w.Body.String(), "unknown sandbox")
+})
+
+t.Run("CreateSession_WithWorkdir", func(t *testing.T) {
+dir := t.TempDir()
+mockHist.On("CreateSession", "Project").Return("uuid-321", nil).Once()
+mockHist.On("SetSessionWorkdir", "uuid-321", dir).Return(nil).Once()
+body, _ := json.Marshal(map[string]string{"name": "Project", "workdir": dir})
+w := httptest.NewRecorder()
+req, _ := http.NewRequest("POST", "/api/sessions", bytes.NewBuffer(body))
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusCreated, w.Code)
+
+for _, bad := range []string{"relative", filepath.Join(dir, "missing")} {
+body, _ = json.Marshal(map[string]string{"name": "Project", "workdir": bad})
+w = httptest.NewRecorder()
+req, _ = http.NewRequest("POST", "/api/sessions", bytes.NewBuffer(body))
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusBadRequest, w.Code)
+assert.Contains(t, w.Body.String(), "workdir")
+}
+})
+
+t.Run("GetSessionShell", func(t *testing.T) {
+w := httptest.NewRecorder()
+req, _ := http.NewRequest("GET", "/api/sessions/123/shell", nil)
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusNotFound, w.Code)
+
+s.Shells = executor.NewSessionManager()
+defer func() { s.Shells = nil }()
+s.Shells.Config = func(id string) executor.SessionConfig {
+return executor.SessionConfig{Dir: "/srv/" + id, Env: []string{"STAGE=dev"}}
+}
+w = httptest.NewRecorder()
+req, _ = http.NewRequest("GET", "/api/sessions/123/shell", nil)
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusOK, w.Code)
+var info executor.SessionInfo
+assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+assert.Equal(t, "/srv/123", info.Dir)
+assert.Equal(t, map[string]string{"STAGE": "dev"}, info.Env)
+assert.False(t, info.Running)
+})
+
+t.Run("KillSessionShell", func(t *testing.T) {
+w := httptest.NewRecorder()
+req, _ := http.NewRequest("GET", "/api/shells", nil)
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusNotFound, w.Code)
+
+s.Shells = executor.NewSessionManager()
+defer func() { s.Shells = nil }()
+s.Shells.Creator = func(id string) (executor.Shell, error) { return &closeShell{}, nil }
+shell, err := s.Shells.GetOrCreate("123")
+assert.NoError(t, err)
+
+w = httptest.NewRecorder()
+req, _ = http.NewRequest("GET", "/api/shells", nil)
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusOK, w.Code)
+var infos []executor.SessionInfo
+assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &infos))
+assert.Len(t, infos, 1)
+assert.Equal(t, "123", infos[0].ID)
+assert.False(t, infos[0].LastUsed.IsZero())
+
+w = httptest.NewRecorder()
+req, _ = http.NewRequest("DELETE", "/api/sessions/123/shell", nil)
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusOK, w.Code)
+assert.True(t, shell.(*closeShell).closed)
+
+w = httptest.NewRecorder()
+req, _ = http.NewRequest("DELETE", "/api/sessions/123/shell", nil)
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusNotFound, w.Code)
+})
+
+t.Run("SessionEdits", func(t *testing.T) {
+w := httptest.NewRecorder()
+req, _ := http.NewRequest("GET", "/api/sessions/123/edits", nil)
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusNotFound, w.Code)
+
+s.Edits = editor.NewJournal(t.TempDir())
+defer func() { s.Edits = nil }()
+path := filepath.Join(t.TempDir(), "a.txt")
+c, err := editor.NewFileEditor().WriteFile(path, "hello\n", editor.WriteCreate, false)
+assert.NoError(t, err)
+_, err = s.Edits.Record("123", "write_file", c)
+assert.NoError(t, err)
+
+w = httptest.NewRecorder()
+req, _ = http.NewRequest("GET", "/api/sessions/123/edits", nil)
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusOK, w.Code)
+var edits []editor.Edit
+assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &edits))
+assert.Len(t, edits, 1)
+assert.Equal(t, path, edits[0].Files[0].Path)
+assert.True(t, edits[0].Files[0].Created)
+
+w = httptest.NewRecorder()
+req, _ = http.NewRequest("POST", "/api/sessions/123/edits/1/revert", nil)
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusOK, w.Code)
+assert.NoFileExists(t, path)
+
+w = httptest.NewRecorder()
+req, _ = http.NewRequest("POST", "/api/sessions/123/edits/1/revert", nil)
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusConflict, w.Code)
+
+w = httptest.NewRecorder()
+req, _ = http.NewRequest("POST", "/api/sessions/123/edits/9/revert", nil)
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusNotFound, w.Code)
+})
+
+t.Run("SetSessionProfile", func(t *testing.T) {
+mockHist.On("SetSessionProfile", "123", "default").Return(nil).Once()
+body, _ := json.Marshal(map[string]string{"profile": "default"})
+w := httptest.NewRecorder()
+req, _ := http.NewRequest("PUT", "/api/sessions/123/profile", bytes.NewBuffer(body))
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusOK, w.Code)
+
+w = httptest.NewRecorder()
+req, _ = http.NewRequest("PUT", "/api/sessions/123/profile", bytes.NewBufferString(`{"profile":"pirate"}`))
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusBadRequest, w.Code)
+})
+
+t.Run("GetProfiles", func(t *testing.T) {
+w := httptest.NewRecorder()
+req, _ := http.NewRequest("GET", "/api/profiles", nil)
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusOK, w.Code)
+assert.Contains(t, w.Body.String(), `"default":"default"`)
+assert.NotContains(t, w.Body.String(), "You are Hyperagent")
+})
+
 t.Run("CreateSession_InvalidJSON", func(t *testing.T) {
 w := httptest.NewRecorder()
 req, _ := http.NewRequest("POST", "/api/sessions", bytes.NewBufferString("invalid"))
@@ -164,11 +391,16 @@ assert.Equal(t, http.StatusInternalServerError, w.Code)
 })
 
 t.Run("GetMessages_Success", func(t *testing.T) {
-mockHist.On("LoadHistory", "123").Return([]history.Message{}, nil).Once()
+mockHist.On("LoadHistory", "123").Return([]history.Message{
+{Role: "model", ToolCalls: []history.ToolCall{{ID: "c1", Name: "execute_command"}}},
+{Role: "tool", ToolResults: []history.ToolResult{{CallID: "c1", Name: "execute_command", Output: "ok"}}},
+}, nil).Once()
 w := httptest.NewRecorder()
 req, _ := http.NewRequest("GET", "/api/sessions/123/messages", nil)
 s.router.ServeHTTP(w, req)
 assert.Equal(t, http.StatusOK, w.Code)
+assert.Contains(t, w.Body.String(), `"tool_calls":[{"id":"c1","name":"execute_command"}]`)
+assert.Contains(t, w.Body.String(), `"output":"ok"`)
 })
 
 t.Run("GetMessages_Error", func(t *testing.T) {
@@ -181,9 +413,11 @@ assert.Equal(t, http.StatusInternalServerError, w.Code)
 
 t.Run("SendMessage_Success", func(t *testing.T) {
 mockHist.On("LoadHistory", "123").Return([]history.Message{}, nil).Once()
+mockHist.On("GetSessionProfile", "123").Return("").Once()
+mockHist.On("SetSessionProfile", "123", "default").Return(nil).Once()
 mockMem.On("Recall", mock.Anything, "hello", 5).Return([]chromem.Result{}, nil).Once()
 mockHist.On("AddMessage", "123", "user", "hello").Return(nil).Once()
-mockGemini.On("GenerateContent", mock.Anything, mock.Anything, mock.Anything).Return("hi", []gemini.ToolCall{}, nil).Once()
+mockLLM.On("GenerateContent", mock.Anything, mock.Anything, mock.Anything).Return(&llm.Response{Message: llm.NewTextMessage(llm.RoleModel, "hi")}, nil).Once()
 mockHist.On("AddMessage", "123", "model", "hi").Return(nil).Once()
 body, _ := json.Marshal(map[string]string{"content": "hello"})
 w := httptest.NewRecorder()
@@ -201,9 +435,11 @@ assert.Equal(t, http.StatusBadRequest, w.Code)
 
 t.Run("SendMessage_AgentError", func(t *testing.T) {
 mockHist.On("LoadHistory", "123").Return([]history.Message{}, nil).Once()
+mockHist.On("GetSessionProfile", "123").Return("").Once()
+mockHist.On("SetSessionProfile", "123", "default").Return(nil).Once()
 mockMem.On("Recall", mock.Anything, "fail", 5).Return([]chromem.Result{}, nil).Once()
 mockHist.On("AddMessage", "123", "user", "fail").Return(nil).Once()
-mockGemini.On("GenerateContent", mock.Anything, mock.Anything, mock.Anything).Return("", []gemini.ToolCall{}, errors.New("agent fail")).Once()
+mockLLM.On("GenerateContent", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("agent fail")).Once()
 body, _ := json.Marshal(map[string]string{"content": "fail"})
 w := httptest.NewRecorder()
 req, _ := http.NewRequest("POST", "/api/sessions/123/messages", bytes.NewBuffer(body))
@@ -211,6 +447,60 @@ s.router.ServeHTTP(w, req)
 assert.Equal(t, http.StatusInternalServerError, w.Code)
 })
 
+t.Run("StreamMessage_Success", func(t *testing.T) {
+mockHist.On("LoadHistory", "123").Return([]history.Message{}, nil).Once()
+mockHist.On("GetSessionProfile", "123").Return("").Once()
+mockHist.On("SetSessionProfile", "123", "default").Return(nil).Once()
+mockMem.On("Recall", mock.Anything, "hello", 5).Return([]chromem.Result{}, nil).Once()
+mockHist.On("AddMessage", "123", "user", "hello").Return(nil).Once()
+mockLLM.On("GenerateContent", mock.Anything, mock.Anything, mock.Anything).Return(&llm.Response{Message: llm.NewTextMessage(llm.RoleModel, "hi")}, nil).Once()
+mockHist.On("AddMessage", "123", "model", "hi").Return(nil).Once()
+body, _ := json.Marshal(map[string]string{"content": "hello"})
+w := httptest.NewRecorder()
+req, _ := http.NewRequest("POST", "/api/sessions/123/stream", bytes.NewBuffer(body))
+s.router.ServeHTTP(w, req)
+assert.Equal(t, http.StatusOK, w.Code)
+assert.Contains(t, w.Header().Get("Content-Type"), "text/event-stream")
+assert.Contains(t, w.Body.String(), "event:text_delta\ndata:{\"type\":\"text_delta\",\"text\":\"hi\"}")
+assert.Contains(t, w.Body.String(), "event:final\n")
+})
+
+t.Run("StreamMessage_AgentError", func(t *testing.T) {
+mockHist.On("LoadHistory", "123").Return([]history.Message{}, nil).Once()
+mockHist.On("GetSessionProfile", "123").Return("").Once()
+mockHist.On("SetSessionProfile", "123", "default").Return(nil).Once()
+mockMem.On("Recall", mock.Anything, "fail", 5).Return([]chromem.Result{}, nil).Once()
+mockHist.On("AddMessage", "123", "user", "fail").Return(nil).Once()
+mockLLM.On("GenerateContent", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("agent fail")).Once()
+body, _ := json.Marshal(map[string]string{"content": "fail"})
+w := httptest.NewRecorder()
+req, _ := http.NewRequest("POST", "/api/sessions/123/stream", bytes.NewBuffer(body))
+s.router.ServeHTTP(w, req)
+assert.Contains(t, w.Body.String(), "event:error\n")
+assert.Contains(t, w.Body.String(), "agent fail")
+})
+
+t.Run("StreamMessage_ClientGone", func(t *testing.T) {
+mockHist.On("LoadHistory", "123").Return([]history.Message{}, nil).Once()
+mockHist.On("GetSessionProfile", "123").Return("").Once()
+mockHist.On("SetSessionProfile", "123", "default").Return(nil).Once()
+mockMem.On("Recall", mock.Anything, "gone", 5).Return([]chromem.Result{}, nil).Once()
+mockHist.On("AddMessage", "123", "user", "gone").Return(nil).Once()
+body, _ := json.Marshal(map[string]string{"content": "gone"})
+ctx, cancel := context.WithCancel(context.Background())
+defer cancel()
+mockLLM.On("GenerateContent", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+// The client disconnects while the model is called
+cancel()
+assert.ErrorIs(t, args.Get(0).(context.Context).Err(), context.Canceled)
+}).Return(nil, context.Canceled).Once()
+w := httptest.NewRecorder()
+req, _ := http.NewRequestWithContext(ctx, "POST", "/api/sessions/123/stream", bytes.NewBuffer(body))
+s.router.ServeHTTP(w, req)
+assert.Contains(t, w.Body.String(), "event:error")
+assert.Contains(t, w.Body.String(), "context canceled")
+})
+
 t.Run("SearchMemory_Success", func(t *testing.T) {
 mockMem.On("Search", mock.Anything, "test", 10).Return([]chromem.Result{}, nil).Once()
 w := httptest.NewRecorder()
@@ -247,13 +537,10 @@ assert.Equal(t, http.StatusInternalServerError, w.Code)
 
 func TestServer_Run(t *testing.T) {
 // This is a bit tricky as Run blocks. We'll run it in a goroutine.
-ctx, cancel := context.WithCancel(context.Background())
-defer cancel()
-
-mockAgent := &mockAgent{}
-mockHistory := &mockHistory{}
-mockMemory := &mockMemory{}
-srv := NewServer(mockAgent, mockHistory, mockMemory)
+mockHist := new(MockHistory)
+mockMem := new(MockMemory)
+a := agent.NewAgent(new(MockLLM), nil, mockMem, nil, mockHist, false)
+srv := NewServer(a, mockHist, mockMem, nil)
 
 go func() {
 // Use a random high port
@@ -262,6 +549,7 @@ _ = srv.Run("127.0.0.1:0")
 
 // Give it a moment to start
 time.Sleep(100 * time.Millisecond)
-// In a real scenario, we'd check if the port is open, but for coverage, 
+// In a real scenario, we'd check if the port is open, but for coverage,
 // just entering the function and starting the listener is often enough.
+assert.NoError(t, srv.Shutdown(context.Background()))
 }
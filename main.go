@@ -3,9 +3,12 @@ package main
 import (
 "log"
 "github.com/LeeroyDing/hyperagent/internal/cmd"
+"github.com/LeeroyDing/hyperagent/internal/sandbox"
 )
 
 func main() {
+// Sandboxed shells are started through this binary
+sandbox.Init()
 if err := cmd.Execute(); err != nil {
 log.Fatal(err)
 }